@@ -2,40 +2,393 @@ package avd
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/desktopvirtualization/armdesktopvirtualization/v2"
 	"github.com/appliedres/cloudy/logging"
+
+	cloudyazure "github.com/appliedres/cloudy-azure"
 )
 
+// remoteAppPrincipalHashLength is the length of the hash replacing a principal ID in a RemoteApp application group name
+const remoteAppPrincipalHashLength = 16
+
+// RemoteApp describes an application published from the session hosts of a host pool
+type RemoteApp struct {
+	Name         string // resource name of the application, unique within its application group
+	FriendlyName string // optional, name shown to users, defaults to Name
+	Description  string // optional
+
+	FilePath string // path of the executable on the session hosts
+
+	CommandLineSetting   string // "DoNotAllow", "Allow" (client arguments) or "Require" (CommandLineArguments), defaults to "DoNotAllow"
+	CommandLineArguments string // arguments passed to the executable, required by "Require"
+
+	IconPath  string // optional, file containing the icon, defaults to FilePath
+	IconIndex int32  // index of the icon within IconPath
+
+	ShowInPortal bool // show the application in the web client
+}
+
+// CreateRDPApplication publishes mstsc.exe as a RemoteApp connecting to targetIP
 func (avd *AzureVirtualDesktopManager) CreateRDPApplication(ctx context.Context, applicationGroupName, appName, targetIP string) (*armdesktopvirtualization.Application, error) {
 	// reference: https://learn.microsoft.com/en-us/windows-server/administration/windows-commands/mstsc
+	return avd.PublishRemoteApp(ctx, applicationGroupName, RemoteApp{
+		Name:                 appName,
+		FriendlyName:         appName, // TODO: make display name UVM name
+		Description:          "an RDP application",
+		FilePath:             "C:\\Windows\\System32\\mstsc.exe",
+		CommandLineSetting:   string(armdesktopvirtualization.CommandLineSettingRequire),
+		CommandLineArguments: "/v:" + targetIP,
+		ShowInPortal:         true,
+	})
+}
+
+// PublishRemoteApp creates or updates a RemoteApp in an application group
+func (avd *AzureVirtualDesktopManager) PublishRemoteApp(ctx context.Context, applicationGroupName string, app RemoteApp) (*armdesktopvirtualization.Application, error) {
+	log := logging.GetLogger(ctx)
+	log.DebugContext(ctx, "Publishing RemoteApp", "Application Group Name", applicationGroupName, "App Name", app.Name)
+
+	err := validateRemoteApp(app)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RemoteApp %s: %w", app.Name, err)
+	}
+
+	appResp, err := avd.applicationsClient.CreateOrUpdate(ctx, avd.Credentials.ResourceGroup, applicationGroupName, app.Name, remoteAppApplication(app), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to publish RemoteApp %s: %w", app.Name, err)
+	}
+
+	log.DebugContext(ctx, "Published RemoteApp", "Application Group Name", applicationGroupName, "App Name", app.Name)
+	return &appResp.Application, nil
+}
+
+// UnpublishRemoteApp removes a RemoteApp from an application group. Missing applications are ignored.
+func (avd *AzureVirtualDesktopManager) UnpublishRemoteApp(ctx context.Context, applicationGroupName, appName string) error {
+	log := logging.GetLogger(ctx)
+
+	_, err := avd.applicationsClient.Delete(ctx, avd.Credentials.ResourceGroup, applicationGroupName, appName, nil)
+	if err != nil && !cloudyazure.Is404(err) {
+		return fmt.Errorf("failed to unpublish RemoteApp %s: %w", appName, err)
+	}
+
+	log.DebugContext(ctx, "Unpublished RemoteApp", "Application Group Name", applicationGroupName, "App Name", appName)
+	return nil
+}
+
+// ListRemoteApps lists the RemoteApps published in an application group
+func (avd *AzureVirtualDesktopManager) ListRemoteApps(ctx context.Context, applicationGroupName string) ([]RemoteApp, error) {
+	pager := avd.applicationsClient.NewListPager(avd.Credentials.ResourceGroup, applicationGroupName, nil)
+
+	var apps []RemoteApp
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list applications of %s: %w", applicationGroupName, err)
+		}
+		for _, application := range page.Value {
+			if application == nil || application.Name == nil {
+				continue
+			}
+			apps = append(apps, remoteAppFromApplication(application))
+		}
+	}
+
+	return apps, nil
+}
+
+// ListStartMenuApps lists the start menu applications installed on the session hosts behind an application group.
+// The results can be passed to PublishRemoteApp as they are.
+func (avd *AzureVirtualDesktopManager) ListStartMenuApps(ctx context.Context, applicationGroupName string) ([]RemoteApp, error) {
+	pager := avd.startMenuItemsClient.NewListPager(avd.Credentials.ResourceGroup, applicationGroupName, nil)
 
+	var apps []RemoteApp
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list start menu items of %s: %w", applicationGroupName, err)
+		}
+		for _, item := range page.Value {
+			if item == nil || item.Name == nil || item.Properties == nil || item.Properties.FilePath == nil {
+				continue
+			}
+			apps = append(apps, remoteAppFromStartMenuItem(item))
+		}
+	}
+
+	return apps, nil
+}
+
+// ListPooledDesktopStartMenuApps lists the start menu applications installed on the pooled desktop session hosts
+func (avd *AzureVirtualDesktopManager) ListPooledDesktopStartMenuApps(ctx context.Context) ([]RemoteApp, error) {
+	if avd.Config.PooledDesktop == nil {
+		return nil, fmt.Errorf("pooled desktop is not configured")
+	}
+
+	return avd.ListStartMenuApps(ctx, avd.Config.PooledDesktopAppGroupNamePrefix+avd.Name)
+}
+
+// PublishRemoteAppToPrincipal publishes a RemoteApp from the pooled desktop session hosts to a single user or group.
+// Each principal gets its own RemoteApp application group, created and linked to the pooled desktop workspace on first use.
+func (avd *AzureVirtualDesktopManager) PublishRemoteAppToPrincipal(ctx context.Context, principalID string, app RemoteApp) (*armdesktopvirtualization.Application, error) {
 	log := logging.GetLogger(ctx)
-	log.DebugContext(ctx, "Creating RDP application", "Application Group Name", applicationGroupName, "App Name", appName)
 
-	rdpExePath := "C:\\Windows\\System32\\mstsc.exe"
-	exeArgs := "/v:" + targetIP
+	if avd.Config.PooledDesktop == nil {
+		return nil, fmt.Errorf("pooled desktop is not configured")
+	}
+	if principalID == "" {
+		return nil, fmt.Errorf("principal ID is required")
+	}
 
-	application := armdesktopvirtualization.Application{
-		Name: to.Ptr(appName),
-		Type: to.Ptr("Microsoft.DesktopVirtualization/applications"),
-		Properties: &armdesktopvirtualization.ApplicationProperties{
-			CommandLineSetting:   to.Ptr(armdesktopvirtualization.CommandLineSettingRequire),
-			CommandLineArguments: to.Ptr(exeArgs),
-			// IconPath: to.Ptr(rdpExePath),  TODO: rdp app icon path
-			FriendlyName: to.Ptr(appName), // TODO: make display name UVM name
-			Description:  to.Ptr("an RDP application"),
-			FilePath:     to.Ptr(rdpExePath),
-			ShowInPortal: to.Ptr(true), // TODO: enable or not?
-		},
+	err := validateRemoteApp(app)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RemoteApp %s: %w", app.Name, err)
 	}
 
-	appResp, err := avd.applicationsClient.CreateOrUpdate(ctx, avd.Credentials.ResourceGroup, applicationGroupName, appName, application, nil)
+	appGroupName, err := avd.remoteAppGroupName(principalID)
 	if err != nil {
 		return nil, err
 	}
+	appGroup, err := avd.GetAppGroupByName(ctx, appGroupName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup RemoteApp application group: %w", err)
+	}
 
-	log.DebugContext(ctx, "Finished creating RDP application", "Application Group Name", applicationGroupName, "App Name", appName)
-	return &appResp.Application, nil
+	if appGroup == nil {
+		log.InfoContext(ctx, "Creating RemoteApp application group", "AppGroupName", appGroupName, "PrincipalID", principalID)
+
+		tags := map[string]*string{
+			"arkloud_created_by": to.Ptr("cloudy-azure"),
+			"principalid":        to.Ptr(principalID),
+		}
+		_, err = avd.CreateApplicationGroup(ctx, appGroupName, avd.PooledDesktopHostPoolName(), tags, armdesktopvirtualization.ApplicationGroupTypeRemoteApp)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the assignment and workspace link are checked on every call, so a previous call that failed half-way is completed
+	assignments, err := avd.ListAppGroupAssignments(ctx, appGroupName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list RemoteApp assignments: %w", err)
+	}
+	for _, missing := range unassignedPrincipals(assignments, []string{principalID}) {
+		err = avd.AssignPrincipalToAppGroup(ctx, appGroupName, missing)
+		if err != nil {
+			return nil, fmt.Errorf("failed to assign principal %s to RemoteApps: %w", missing, err)
+		}
+	}
+
+	_, err = avd.ensurePooledDesktopWorkspace(ctx, appGroupName, map[string]*string{
+		"suffix":             to.Ptr(avd.Name),
+		"arkloud_created_by": to.Ptr("cloudy-azure"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to add RemoteApps to workspace: %w", err)
+	}
+
+	return avd.PublishRemoteApp(ctx, appGroupName, app)
+}
+
+// UnpublishRemoteAppFromPrincipal removes a RemoteApp published to a user or group.
+// The principal's application group is deleted with its last application.
+func (avd *AzureVirtualDesktopManager) UnpublishRemoteAppFromPrincipal(ctx context.Context, principalID, appName string) error {
+	log := logging.GetLogger(ctx)
+
+	appGroupName, err := avd.remoteAppGroupName(principalID)
+	if err != nil {
+		return err
+	}
+	appGroup, err := avd.GetAppGroupByName(ctx, appGroupName)
+	if err != nil {
+		return fmt.Errorf("failed to lookup RemoteApp application group: %w", err)
+	}
+	if appGroup == nil {
+		log.DebugContext(ctx, "No RemoteApps published to principal", "PrincipalID", principalID)
+		return nil
+	}
+
+	err = avd.UnpublishRemoteApp(ctx, appGroupName, appName)
+	if err != nil {
+		return err
+	}
+
+	remaining, err := avd.ListRemoteApps(ctx, appGroupName)
+	if err != nil {
+		return err
+	}
+	if len(remaining) > 0 {
+		return nil
+	}
+
+	log.InfoContext(ctx, "Deleting empty RemoteApp application group", "AppGroupName", appGroupName, "PrincipalID", principalID)
+	err = avd.RemoveApplicationGroupFromWorkspace(ctx, avd.Config.PooledDesktopWorkspaceNamePrefix+avd.Name, appGroupName)
+	if err != nil {
+		log.WarnContext(ctx, "Failed to remove RemoteApps from workspace", "AppGroupName", appGroupName, "Error", err)
+	}
+
+	return avd.DeleteApplicationGroup(ctx, appGroupName)
+}
+
+// ListRemoteAppsForPrincipal lists the RemoteApps published to a user or group
+func (avd *AzureVirtualDesktopManager) ListRemoteAppsForPrincipal(ctx context.Context, principalID string) ([]RemoteApp, error) {
+	appGroupName, err := avd.remoteAppGroupName(principalID)
+	if err != nil {
+		return nil, err
+	}
+	appGroup, err := avd.GetAppGroupByName(ctx, appGroupName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup RemoteApp application group: %w", err)
+	}
+	if appGroup == nil {
+		return nil, nil
+	}
+
+	return avd.ListRemoteApps(ctx, appGroupName)
+}
+
+// remoteAppGroupName returns the application group holding a principal's RemoteApps.
+// A principal ID that would make the name too long for AVD, e.g. a GUID after a long prefix, is replaced by its hash.
+func (avd *AzureVirtualDesktopManager) remoteAppGroupName(principalID string) (string, error) {
+	prefix := avd.Config.RemoteAppGroupNamePrefix + avd.Name + "-"
+	name := prefix + principalID
+	if stackNamePattern.MatchString(name) {
+		return name, nil
+	}
+
+	sum := sha256.Sum256([]byte(principalID))
+	name = prefix + hex.EncodeToString(sum[:])[:remoteAppPrincipalHashLength]
+	if !stackNamePattern.MatchString(name) {
+		return "", fmt.Errorf("RemoteApp application group name %s is not a valid AVD name, at most %d characters are allowed", name, maxStackNameLength)
+	}
+	return name, nil
+}
+
+func validateRemoteApp(app RemoteApp) error {
+	if app.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if app.FilePath == "" {
+		return fmt.Errorf("file path is required")
+	}
+	if app.IconIndex < 0 {
+		return fmt.Errorf("icon index cannot be negative")
+	}
+
+	setting := armdesktopvirtualization.CommandLineSetting(app.CommandLineSetting)
+	switch setting {
+	case "", armdesktopvirtualization.CommandLineSettingDoNotAllow:
+		if app.CommandLineArguments != "" {
+			return fmt.Errorf("command line arguments require the %s or %s command line setting",
+				armdesktopvirtualization.CommandLineSettingAllow, armdesktopvirtualization.CommandLineSettingRequire)
+		}
+	case armdesktopvirtualization.CommandLineSettingAllow:
+	case armdesktopvirtualization.CommandLineSettingRequire:
+		if app.CommandLineArguments == "" {
+			return fmt.Errorf("the %s command line setting requires command line arguments", setting)
+		}
+	default:
+		return fmt.Errorf("unsupported command line setting [%s], must be one of %v", app.CommandLineSetting, armdesktopvirtualization.PossibleCommandLineSettingValues())
+	}
+
+	return nil
+}
+
+// remoteAppApplication converts a validated RemoteApp into its AVD application
+func remoteAppApplication(app RemoteApp) armdesktopvirtualization.Application {
+	setting := armdesktopvirtualization.CommandLineSetting(app.CommandLineSetting)
+	if setting == "" {
+		setting = armdesktopvirtualization.CommandLineSettingDoNotAllow
+	}
+
+	friendlyName := app.FriendlyName
+	if friendlyName == "" {
+		friendlyName = app.Name
+	}
+
+	iconPath := app.IconPath
+	if iconPath == "" {
+		iconPath = app.FilePath
+	}
+
+	properties := &armdesktopvirtualization.ApplicationProperties{
+		ApplicationType:    to.Ptr(armdesktopvirtualization.RemoteApplicationTypeInBuilt),
+		CommandLineSetting: to.Ptr(setting),
+		FriendlyName:       to.Ptr(friendlyName),
+		FilePath:           to.Ptr(app.FilePath),
+		IconPath:           to.Ptr(iconPath),
+		IconIndex:          to.Ptr(app.IconIndex),
+		ShowInPortal:       to.Ptr(app.ShowInPortal),
+	}
+	if app.Description != "" {
+		properties.Description = to.Ptr(app.Description)
+	}
+	if app.CommandLineArguments != "" {
+		properties.CommandLineArguments = to.Ptr(app.CommandLineArguments)
+	}
+
+	return armdesktopvirtualization.Application{
+		Name:       to.Ptr(app.Name),
+		Type:       to.Ptr("Microsoft.DesktopVirtualization/applications"),
+		Properties: properties,
+	}
+}
+
+func remoteAppFromApplication(application *armdesktopvirtualization.Application) RemoteApp {
+	app := RemoteApp{Name: lastNameSegment(*application.Name)}
+
+	properties := application.Properties
+	if properties == nil {
+		return app
+	}
+
+	app.FriendlyName = valueOf(properties.FriendlyName)
+	app.Description = valueOf(properties.Description)
+	app.FilePath = valueOf(properties.FilePath)
+	app.CommandLineSetting = string(valueOf(properties.CommandLineSetting))
+	app.CommandLineArguments = valueOf(properties.CommandLineArguments)
+	app.IconPath = valueOf(properties.IconPath)
+	app.IconIndex = valueOf(properties.IconIndex)
+	app.ShowInPortal = valueOf(properties.ShowInPortal)
+	return app
+}
+
+func remoteAppFromStartMenuItem(item *armdesktopvirtualization.StartMenuItem) RemoteApp {
+	friendlyName := lastNameSegment(*item.Name)
+
+	name := valueOf(item.Properties.AppAlias)
+	if name == "" {
+		name = strings.ToLower(strings.ReplaceAll(friendlyName, " ", "-"))
+	}
+
+	app := RemoteApp{
+		Name:                 name,
+		FriendlyName:         friendlyName,
+		FilePath:             *item.Properties.FilePath,
+		CommandLineArguments: valueOf(item.Properties.CommandLineArguments),
+		IconPath:             valueOf(item.Properties.IconPath),
+		IconIndex:            valueOf(item.Properties.IconIndex),
+		ShowInPortal:         true,
+	}
+	if app.CommandLineArguments != "" {
+		app.CommandLineSetting = string(armdesktopvirtualization.CommandLineSettingRequire)
+	}
+	return app
+}
+
+// lastNameSegment strips the parent names AVD prefixes to child resource names, e.g. "appgroup/app"
+func lastNameSegment(name string) string {
+	return name[strings.LastIndex(name, "/")+1:]
+}
+
+func valueOf[T any](p *T) T {
+	var zero T
+	if p == nil {
+		return zero
+	}
+	return *p
 }
@@ -0,0 +1,108 @@
+package avd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/desktopvirtualization/armdesktopvirtualization/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateRemoteApp(t *testing.T) {
+	valid := RemoteApp{Name: "notepad", FilePath: "C:\\Windows\\System32\\notepad.exe"}
+	assert.NoError(t, validateRemoteApp(valid))
+
+	tests := map[string]func(a *RemoteApp){
+		"no name":                  func(a *RemoteApp) { a.Name = "" },
+		"no file path":             func(a *RemoteApp) { a.FilePath = "" },
+		"negative icon index":      func(a *RemoteApp) { a.IconIndex = -1 },
+		"unknown setting":          func(a *RemoteApp) { a.CommandLineSetting = "Sometimes" },
+		"arguments not allowed":    func(a *RemoteApp) { a.CommandLineArguments = "/A" },
+		"required arguments unset": func(a *RemoteApp) { a.CommandLineSetting = "Require" },
+	}
+	for name, mutate := range tests {
+		t.Run(name, func(t *testing.T) {
+			app := valid
+			mutate(&app)
+			assert.Error(t, validateRemoteApp(app))
+		})
+	}
+
+	valid.CommandLineSetting, valid.CommandLineArguments = "Allow", "C:\\notes.txt"
+	assert.NoError(t, validateRemoteApp(valid))
+}
+
+func TestRemoteAppApplication(t *testing.T) {
+	application := remoteAppApplication(RemoteApp{Name: "notepad", FilePath: "C:\\Windows\\System32\\notepad.exe"})
+	properties := application.Properties
+
+	assert.Equal(t, "notepad", *application.Name)
+	assert.Equal(t, "notepad", *properties.FriendlyName)
+	assert.Equal(t, armdesktopvirtualization.CommandLineSettingDoNotAllow, *properties.CommandLineSetting)
+	assert.Equal(t, "C:\\Windows\\System32\\notepad.exe", *properties.IconPath)
+	assert.Equal(t, int32(0), *properties.IconIndex)
+	assert.Nil(t, properties.CommandLineArguments)
+	assert.False(t, *properties.ShowInPortal)
+
+	app := remoteAppFromApplication(&armdesktopvirtualization.Application{Name: to.Ptr("group/notepad"), Properties: properties})
+	assert.Equal(t, RemoteApp{
+		Name:               "notepad",
+		FriendlyName:       "notepad",
+		FilePath:           "C:\\Windows\\System32\\notepad.exe",
+		CommandLineSetting: "DoNotAllow",
+		IconPath:           "C:\\Windows\\System32\\notepad.exe",
+	}, app)
+}
+
+func TestRemoteAppFromStartMenuItem(t *testing.T) {
+	app := remoteAppFromStartMenuItem(&armdesktopvirtualization.StartMenuItem{
+		Name: to.Ptr("AG-Desktop-team/Remote Desktop Connection"),
+		Properties: &armdesktopvirtualization.StartMenuItemProperties{
+			FilePath:  to.Ptr("C:\\Windows\\system32\\mstsc.exe"),
+			IconPath:  to.Ptr("C:\\Windows\\system32\\mstsc.exe"),
+			IconIndex: to.Ptr(int32(2)),
+		},
+	})
+	assert.Equal(t, "remote-desktop-connection", app.Name)
+	assert.Equal(t, "Remote Desktop Connection", app.FriendlyName)
+	assert.Equal(t, int32(2), app.IconIndex)
+	assert.Empty(t, app.CommandLineSetting)
+	assert.NoError(t, validateRemoteApp(app))
+
+	app = remoteAppFromStartMenuItem(&armdesktopvirtualization.StartMenuItem{
+		Name: to.Ptr("AG-Desktop-team/Word"),
+		Properties: &armdesktopvirtualization.StartMenuItemProperties{
+			AppAlias:             to.Ptr("word"),
+			FilePath:             to.Ptr("C:\\Program Files\\Microsoft Office\\root\\Office16\\WINWORD.EXE"),
+			CommandLineArguments: to.Ptr("/q"),
+		},
+	})
+	assert.Equal(t, "word", app.Name)
+	assert.Equal(t, "Require", app.CommandLineSetting)
+	assert.NoError(t, validateRemoteApp(app))
+}
+
+func TestRemoteAppGroupName(t *testing.T) {
+	avd := &AzureVirtualDesktopManager{Name: "dev", Config: &AzureVirtualDesktopManagerConfig{RemoteAppGroupNamePrefix: "ra-"}}
+
+	name, err := avd.remoteAppGroupName("user-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "ra-dev-user-1", name)
+
+	// a GUID after a long prefix exceeds the AVD name limit, it is replaced by a stable hash
+	avd.Config.RemoteAppGroupNamePrefix = "remoteapps-appgroup-"
+	principalID := "0b1e8f7c-3d5a-4c2b-9e6f-7a8b9c0d1e2f"
+	name, err = avd.remoteAppGroupName(principalID)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(name, "remoteapps-appgroup-dev-"))
+	assert.LessOrEqual(t, len(name), maxStackNameLength)
+	again, _ := avd.remoteAppGroupName(principalID)
+	assert.Equal(t, name, again)
+	other, _ := avd.remoteAppGroupName("1b1e8f7c-3d5a-4c2b-9e6f-7a8b9c0d1e2f")
+	assert.NotEqual(t, name, other)
+
+	avd.Config.RemoteAppGroupNamePrefix = strings.Repeat("x", 60)
+	_, err = avd.remoteAppGroupName(principalID)
+	assert.Error(t, err)
+}
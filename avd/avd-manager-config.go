@@ -1,5 +1,7 @@
 package avd
 
+import "time"
+
 type AzureVirtualDesktopManagerConfig struct {
 	// required
 	AvdUsersGroupId              string
@@ -15,8 +17,106 @@ type AzureVirtualDesktopManagerConfig struct {
 	PooledWorkspaceNamePrefix    string
 	PooledAppGroupNamePrefix     string
 
+	PooledDesktopHostPoolNamePrefix  string
+	PooledDesktopWorkspaceNamePrefix string
+	PooledDesktopAppGroupNamePrefix  string
+	RemoteAppGroupNamePrefix         string
+
 	// optional
 	RDAgentURI        *string
 	BootLoaderURI     *string
 	DesktopNamePrefix *string
+
+	PersonalHostPool *HostPoolConfig      // optional, nil creates personal host pools with the AVD defaults
+	PooledDesktop    *PooledDesktopConfig // optional, nil disables the pooled Windows desktop host pool
+
+	StartVMOnConnect *StartVMOnConnectConfig // optional, nil leaves deallocated personal VMs to be started before connecting
+	GracefulShutdown *GracefulShutdownConfig // optional, nil tears down session hosts without warning their users
+
+	SessionHostHealth *SessionHostHealthConfig // optional, nil classifies session hosts with the default thresholds
+	RegistrationToken *RegistrationTokenConfig // optional, nil keeps 25 day registration tokens, renewed a day before they expire
+	StackNaming       *StackNamingConfig       // optional, nil names personal stacks ALPHA … ZULU-ZULU
+	StackPlacement    *StackPlacementConfig    // optional, nil fills the fullest personal stack first, without a user limit
+}
+
+// StackPlacementConfig selects the personal stack a user's VM is placed in, see PlacementPolicy
+type StackPlacementConfig struct {
+	Policy           string // "fill-first" (default), "spread" or "team-affinity"
+	StrictTeams      bool   // team-affinity only, never place a VM in a stack created for another team
+	MaxUsersPerStack int    // users per personal stack, 0 is unlimited
+	ConsolidateBelow int    // stacks with this many users or fewer are reported as near-empty, defaults to 1
+}
+
+// StackNamingConfig selects how personal AVD stacks are named, see NamingStrategy
+type StackNamingConfig struct {
+	Strategy   string // "phonetic" (default), "numeric", "team" or "hash"
+	MaxWords   int    // phonetic only, words per suffix, defaults to 2
+	Digits     int    // numeric and team only, defaults to 3 for numeric and 2 for team
+	HashLength int    // hash only, hex characters per suffix, defaults to 8
+}
+
+// RegistrationTokenConfig defines the lifetime of host pool registration tokens, and how they reach the session hosts
+type RegistrationTokenConfig struct {
+	Lifetime      time.Duration // how long new tokens are valid, 1 hour to 27 days, defaults to 25 days
+	RefreshBefore time.Duration // tokens expiring within this are renewed, defaults to 24 hours
+
+	// revoke the token of a host pool once no session host is registering with it. Registrations are counted per
+	// process but the token is revoked for the whole host pool: only enable it when a single instance registers
+	// session hosts with each host pool.
+	RevokeAfterRegistration bool
+
+	// optional, stores tokens as secrets in this Key Vault instead of embedding them in setup scripts.
+	// The session host VMs need a managed identity allowed to read the secrets.
+	KeyVaultURL string
+}
+
+// SessionHostHealthConfig defines when an unhealthy session host is repaired, and when it is given up as dead
+type SessionHostHealthConfig struct {
+	TransientPeriod   time.Duration // time a host may be unhealthy, or wait for a repair to take effect, before it needs repair, defaults to 30 minutes
+	DeadAfter         time.Duration // time without heartbeat before a host is dead, defaults to 24 hours
+	MaxRepairAttempts int           // failed repairs before a host is dead, defaults to 3
+}
+
+// GracefulShutdownConfig defines how users are warned and logged off before their session host is stopped or deleted
+type GracefulShutdownConfig struct {
+	MessageTitle string        // optional, defaults to "Your session is ending"
+	Message      string        // optional, defaults to a message stating the grace period
+	GracePeriod  time.Duration // time users get to save their work before they are logged off
+	PollInterval time.Duration // how often sessions are checked during the grace period, defaults to 15 seconds
+}
+
+// StartVMOnConnectConfig lets AVD start deallocated personal VMs when their user connects.
+// Enabling it sets the flag on all personal host pools and grants AVD the power on role on the VMs.
+type StartVMOnConnectConfig struct {
+	AVDServicePrincipalID string // object ID of the Azure Virtual Desktop service principal in the tenant
+	VMResourceGroupID     string // resource ID of the resource group holding the personal VMs
+	RoleDefinitionID      string // optional, defaults to the "Desktop Virtualization Power On Contributor" built-in role
+}
+
+// PooledDesktopConfig defines the pooled Windows desktop host pool, whose multi-session session hosts are shared by its users
+type PooledDesktopConfig struct {
+	HostPool     HostPoolConfig // LoadBalancerType defaults to "BreadthFirst", PreferredAppGroupType must be empty or "Desktop"
+	UserGroupIDs []string       // object IDs of the groups assigned to the desktop, defaults to AvdUsersGroupId
+}
+
+// HostPoolConfig defines the properties of the host pools the manager creates.
+// Unset fields use the AVD defaults. ReconcileHostPools applies changes to existing host pools.
+type HostPoolConfig struct {
+	LoadBalancerType      string // "Persistent" for personal host pools, "BreadthFirst" or "DepthFirst" for pooled
+	AssignmentType        string // personal host pools only, "Automatic" or "Direct"
+	PreferredAppGroupType string // "Desktop" or "RailApplications"
+	MaxSessionLimit       int32  // pooled host pools only, zero uses the AVD default
+	StartVMOnConnect      bool   // start a deallocated session host when its user connects
+	ValidationEnvironment bool   // receive AVD service updates before production host pools
+
+	RDPProperties *RDPPropertiesConfig // optional, nil leaves the RDP properties to the AVD defaults
+}
+
+// RDPPropertiesConfig defines the custom RDP properties of a host pool. Nil fields are not set.
+type RDPPropertiesConfig struct {
+	DriveRedirection     *bool  // redirect all local drives
+	ClipboardRedirection *bool  // redirect the clipboard
+	MultipleMonitors     *bool  // span the session over all local monitors
+	CameraRedirection    *bool  // redirect all local cameras
+	Additional           string // optional, further properties in RDP file syntax, e.g. "audiocapturemode:i:1;"
 }
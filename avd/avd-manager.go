@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"net/http"
-	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -27,6 +26,7 @@ import (
 	"github.com/appliedres/cloudy/models"
 
 	cloudyazure "github.com/appliedres/cloudy-azure"
+	"github.com/appliedres/cloudy-azure/keyvault"
 )
 
 type AzureVirtualDesktopManager struct {
@@ -34,6 +34,8 @@ type AzureVirtualDesktopManager struct {
 
 	Credentials *cloudyazure.AzureCredentials
 	Config      *AzureVirtualDesktopManagerConfig
+	Naming      NamingStrategy  // names the personal stacks, defaults to the strategy of Config.StackNaming
+	Placement   PlacementPolicy // picks the personal stack of a VM, defaults to the policy of Config.StackPlacement
 
 	workspacesClient        *armdesktopvirtualization.WorkspacesClient
 	hostPoolsClient         *armdesktopvirtualization.HostPoolsClient
@@ -42,12 +44,18 @@ type AzureVirtualDesktopManager struct {
 	applicationGroupsClient *armdesktopvirtualization.ApplicationGroupsClient
 	applicationsClient      *armdesktopvirtualization.ApplicationsClient
 	desktopsClient          *armdesktopvirtualization.DesktopsClient
+	startMenuItemsClient    *armdesktopvirtualization.StartMenuItemsClient
 
 	roleAssignmentsClient *armauthorization.RoleAssignmentsClient
 	graphClient           *msgraphsdk.GraphServiceClient
 
+	keyVault           *keyvault.KeyVault // holds registration tokens, nil unless configured
+	registrationTokens registrationTokens
+
 	stackMutex sync.Mutex // blocks concurrent host pool creation/deletion
 	lockMap    sync.Map   // used to block a user from having concurrent registrations in a single host pool
+
+	hostPoolLocks sync.Map // map[string]*sync.Mutex (host pool name→lock), serializes placing users in a stack
 }
 
 func NewAzureVirtualDesktopManager(ctx context.Context, name string, credentials *cloudyazure.AzureCredentials, config *AzureVirtualDesktopManagerConfig) (*AzureVirtualDesktopManager, error) {
@@ -90,6 +98,41 @@ func (avd *AzureVirtualDesktopManager) Configure(ctx context.Context) error {
 	log.DebugContext(ctx, "AVD Manager - Configure started")
 	defer log.DebugContext(ctx, "AVD Manager - Configure complete")
 
+	err := validateHostPoolConfig(avd.Config.PersonalHostPool, armdesktopvirtualization.HostPoolTypePersonal)
+	if err != nil {
+		return fmt.Errorf("invalid personal host pool config: %w", err)
+	}
+
+	err = validatePooledDesktopConfig(avd.Config.PooledDesktop)
+	if err != nil {
+		return fmt.Errorf("invalid pooled desktop config: %w", err)
+	}
+
+	if shutdown := avd.Config.GracefulShutdown; shutdown != nil && (shutdown.GracePeriod < 0 || shutdown.PollInterval < 0) {
+		return fmt.Errorf("invalid graceful shutdown config: durations cannot be negative")
+	}
+
+	if health := avd.Config.SessionHostHealth; health != nil && (health.TransientPeriod < 0 || health.DeadAfter < 0 || health.MaxRepairAttempts < 0) {
+		return fmt.Errorf("invalid session host health config: thresholds cannot be negative")
+	}
+
+	err = validateRegistrationTokenConfig(avd.Config.RegistrationToken)
+	if err != nil {
+		return fmt.Errorf("invalid registration token config: %w", err)
+	}
+
+	err = validateStartVMOnConnectConfig(avd.Config.StartVMOnConnect)
+	if err != nil {
+		return fmt.Errorf("invalid start VM on connect config: %w", err)
+	}
+	if avd.Config.StartVMOnConnect != nil {
+		// personal host pools are created and reconciled with the flag set
+		if avd.Config.PersonalHostPool == nil {
+			avd.Config.PersonalHostPool = &HostPoolConfig{}
+		}
+		avd.Config.PersonalHostPool.StartVMOnConnect = true
+	}
+
 	cred, err := cloudyazure.NewAzureCredentials(avd.Credentials)
 	if err != nil {
 		return err
@@ -116,6 +159,7 @@ func (avd *AzureVirtualDesktopManager) Configure(ctx context.Context) error {
 	avd.applicationGroupsClient = clientFactory.NewApplicationGroupsClient()
 	avd.applicationsClient = clientFactory.NewApplicationsClient()
 	avd.desktopsClient = clientFactory.NewDesktopsClient()
+	avd.startMenuItemsClient = clientFactory.NewStartMenuItemsClient()
 
 	roleassignmentsclient, err := armauthorization.NewRoleAssignmentsClient(avd.Credentials.SubscriptionID, cred, &baseOptions)
 	if err != nil {
@@ -123,6 +167,13 @@ func (avd *AzureVirtualDesktopManager) Configure(ctx context.Context) error {
 	}
 	avd.roleAssignmentsClient = roleassignmentsclient
 
+	if tokens := avd.Config.RegistrationToken; tokens != nil && tokens.KeyVaultURL != "" {
+		avd.keyVault, err = keyvault.NewKeyVault(ctx, tokens.KeyVaultURL, *avd.Credentials)
+		if err != nil {
+			return fmt.Errorf("failed to create registration token key vault client: %w", err)
+		}
+	}
+
 	// Setup MS Graph client
 	credGraph, err := azidentity.NewClientSecretCredential(avd.Credentials.TenantID, avd.Credentials.ClientID, avd.Credentials.ClientSecret, nil)
 	if err != nil {
@@ -153,6 +204,28 @@ func (avd *AzureVirtualDesktopManager) Configure(ctx context.Context) error {
 	avd.Config.PooledWorkspaceNamePrefix = avd.Config.PrefixBase + "-WS-Pooled-"
 	avd.Config.PooledAppGroupNamePrefix = avd.Config.PrefixBase + "-AG-Pooled-"
 
+	avd.Config.PooledDesktopHostPoolNamePrefix = avd.Config.PrefixBase + "-HP-Desktop-"
+	avd.Config.PooledDesktopWorkspaceNamePrefix = avd.Config.PrefixBase + "-WS-Desktop-"
+	avd.Config.PooledDesktopAppGroupNamePrefix = avd.Config.PrefixBase + "-AG-Desktop-"
+	avd.Config.RemoteAppGroupNamePrefix = avd.Config.PrefixBase + "-AG-Apps-"
+
+	if avd.Naming == nil {
+		avd.Naming, err = newNamingStrategy(avd.Config.StackNaming)
+		if err != nil {
+			return fmt.Errorf("invalid stack naming config: %w", err)
+		}
+	}
+	err = avd.validateStackNaming()
+	if err != nil {
+		return fmt.Errorf("invalid stack naming: %w", err)
+	}
+	if avd.Placement == nil {
+		avd.Placement, err = newPlacementPolicy(avd.Config.StackPlacement)
+		if err != nil {
+			return fmt.Errorf("invalid stack placement config: %w", err)
+		}
+	}
+
 	// TODO: ensure all AVD resources with this PrefixBase fit into these naming conventions, cleanup those that do not
 
 	return nil
@@ -172,6 +245,21 @@ func (avd *AzureVirtualDesktopManager) Initialize(ctx context.Context) error {
 		return logging.LogAndWrapErr(ctx, log, err, "Failed to ensure AVD pooled stack")
 	}
 
+	if avd.Config.PooledDesktop != nil {
+		_, err = avd.EnsurePooledDesktopStack(ctx)
+		if err != nil {
+			return logging.LogAndWrapErr(ctx, log, err, "Failed to ensure AVD pooled desktop stack")
+		}
+	}
+
+	if avd.Config.StartVMOnConnect != nil {
+		// a misconfiguration is reported, but does not stop the manager. VMs are started by the orchestrator instead.
+		_, err = avd.EnsureStartVMOnConnect(ctx)
+		if err != nil {
+			log.ErrorContext(ctx, "Start VM on Connect is misconfigured", "Error", err)
+		}
+	}
+
 	return nil
 }
 
@@ -203,7 +291,25 @@ func (avd *AzureVirtualDesktopManager) releaseHostPoolLockForUser(ctx context.Co
 // Prior to VM registration, this process generates a token for a given host pool.
 // This token will later be used in the registration process to join the VM to the host pool.
 // The user is also assigned to the related desktop application group.
+//
+// Deprecated: use PreRegisterSessionHost, whose registration is counted so the token is not revoked while it is in use.
 func (avd *AzureVirtualDesktopManager) PreRegister(ctx context.Context, vm *models.VirtualMachine) (hostPoolName, token *string, err error) {
+	hostPoolName, registrationToken, err := avd.preRegister(ctx, vm, avd.GetRegistrationToken)
+	if err != nil {
+		return nil, nil, err
+	}
+	return hostPoolName, to.Ptr(registrationToken.Token), nil
+}
+
+// PreRegisterSessionHost picks or creates the personal host pool of a VM and begins its registration, see PreRegister.
+// The registration must be ended with EndRegistration once the VM registered, or failed to.
+func (avd *AzureVirtualDesktopManager) PreRegisterSessionHost(ctx context.Context, vm *models.VirtualMachine) (hostPoolName *string, token *RegistrationToken, err error) {
+	return avd.preRegister(ctx, vm, avd.BeginRegistration)
+}
+
+func (avd *AzureVirtualDesktopManager) preRegister(ctx context.Context, vm *models.VirtualMachine,
+	registrationToken func(ctx context.Context, hostPoolName string) (*RegistrationToken, error),
+) (hostPoolName *string, token *RegistrationToken, err error) {
 	log := logging.GetLogger(ctx)
 	log.InfoContext(ctx, "Starting AVD PreRegister", "VM", vm.ID)
 
@@ -228,20 +334,24 @@ func (avd *AzureVirtualDesktopManager) PreRegister(ctx context.Context, vm *mode
 	}
 	log.DebugContext(ctx, "Retrieved host pools", "Count", len(hostPools))
 
-	avd.sortHostPoolsByPhoneticSuffix(hostPools)
-	log.DebugContext(ctx, "Sorted host pools by phonetic suffix", "Count", len(hostPools))
+	stacks := avd.listStacks(hostPools)
+	if avd.Placement.NeedsUsage() {
+		err = avd.loadStackUsage(ctx, stacks)
+		if err != nil {
+			log.ErrorContext(ctx, "Failed to retrieve host pool usage", "Error", err)
+			return nil, nil, fmt.Errorf("failed to retrieve host pool usage: %w", err)
+		}
+	}
+
+	request := StackNamingRequest{UserID: vm.UserID, TeamID: vm.TeamID}
+	candidates := avd.placementCandidates(stacks, request)
+	log.DebugContext(ctx, "Ranked host pools by placement policy", "Count", len(candidates), "Policy", fmt.Sprintf("%T", avd.Placement))
 
-	// Check if the user can be assigned to any existing host pool
+	// Check if the user can be assigned to any existing host pool, in the order of the placement policy
 	var targetHostPool *armdesktopvirtualization.HostPool
-	for _, pool := range hostPools {
-		log := log.With("HostPool", *pool.Name, "UserID", vm.UserID) // shrink repetitive fields
-
-		// determine AVD stack suffix
-		suffix := strings.TrimPrefix(*pool.Name, avd.Config.PersonalHostPoolNamePrefix)
-		if suffix == "" {
-			log.WarnContext(ctx, "host pool name is empty after trimming prefix, skipping…")
-			continue
-		}
+	for _, stack := range candidates {
+		log := log.With("HostPool", stack.HostPoolName, "UserID", vm.UserID) // shrink repetitive fields
+		suffix := stack.Suffix
 
 		// valid desktop app group
 		appGroupName := avd.Config.PersonalAppGroupNamePrefix + suffix
@@ -265,27 +375,20 @@ func (avd *AzureVirtualDesktopManager) PreRegister(ctx context.Context, vm *mode
 		}
 		log.DebugContext(ctx, "workspace OK", "WorkspaceName", *workspace.Name)
 
-		// user can be assigned
-		canAssign, err := avd.CanAssignUserToHostPool(ctx, *pool.Name, vm.UserID)
+		// acquire lock on host pool for this user, if the user is not in it yet and it has room
+		claimed, err := avd.claimStack(ctx, stack.HostPoolName, vm.UserID)
 		if err != nil {
 			log.WarnContext(ctx, "error checking assignment, skipping…", "Error", err)
 			continue
 		}
-		if !canAssign {
-			log.DebugContext(ctx, "user cannot be assigned, skipping…")
-			continue
-		}
-		log.DebugContext(ctx, "user can be assigned")
-
-		// acquire lock on host pool for this user
-		if !avd.acquireHostPoolLockForUser(ctx, vm.UserID, *pool.Name) {
-			log.DebugContext(ctx, "unable to acquire lock, skipping…")
+		if !claimed {
+			log.DebugContext(ctx, "user cannot be assigned, or unable to acquire lock, skipping…")
 			continue
 		}
 
 		// successfully found existing host pool
 		log.DebugContext(ctx, "successfully found existing host pool for session host assignment")
-		targetHostPool = pool
+		targetHostPool = hostPoolByName(hostPools, stack.HostPoolName)
 		break
 	}
 
@@ -293,23 +396,15 @@ func (avd *AzureVirtualDesktopManager) PreRegister(ctx context.Context, vm *mode
 	if targetHostPool == nil {
 		log.InfoContext(ctx, "No suitable host pool found; creating new host pool")
 
-		var nameSuffix string
-		if len(hostPools) == 0 {
-			nameSuffix = "ALPHA"
-		} else {
-			highestHostPoolName := hostPools[len(hostPools)-1].Name
-			highestSuffix := strings.TrimPrefix(*highestHostPoolName, avd.Config.PersonalHostPoolNamePrefix)
-			var err error
-			nameSuffix, err = GenerateNextName(highestSuffix, 2)
-			if err != nil {
-				log.ErrorContext(ctx, "Failed to generate new host pool name", "Error", err)
-				return nil, nil, fmt.Errorf("failed to generate new host pool name: %w", err)
-			}
+		nameSuffix, err := avd.nextStackSuffix(hostPools, request)
+		if err != nil {
+			log.ErrorContext(ctx, "Failed to generate new host pool name", "Error", err)
+			return nil, nil, fmt.Errorf("failed to generate new host pool name: %w", err)
 		}
 
 		log.InfoContext(ctx, "Creating new AVD stack", "Suffix", nameSuffix)
 
-		targetHostPool, _, _, err = avd.createAvdStack(ctx, nameSuffix)
+		targetHostPool, _, _, err = avd.createAvdStack(ctx, nameSuffix, vm.TeamID)
 		if err != nil {
 			log.ErrorContext(ctx, "Failed to create AVD resource stack", "Suffix", nameSuffix, "Error", err)
 			return nil, nil, fmt.Errorf("failed to create AVD resource stack: %w", err)
@@ -326,7 +421,7 @@ func (avd *AzureVirtualDesktopManager) PreRegister(ctx context.Context, vm *mode
 
 	// Step 3: Retrieve registration token
 	log.DebugContext(ctx, "Retrieving registration token", "HostPool", *targetHostPool.Name)
-	token, err = avd.RetrieveRegistrationToken(ctx, *targetHostPool.Name)
+	token, err = registrationToken(ctx, *targetHostPool.Name)
 	if err != nil {
 		log.ErrorContext(ctx, "Failed to retrieve registration token", "HostPool", *targetHostPool.Name, "Error", err)
 		avd.releaseHostPoolLockForUser(ctx, vm.UserID, *targetHostPool.Name)
@@ -462,6 +557,9 @@ func (avd *AzureVirtualDesktopManager) Cleanup(ctx context.Context, vmID string)
 					sessionHostName := sessionHostParts[1]
 
 					log.InfoContext(ctx, "Found session host associated with VM; deleting", "sessionHost", sessionHostName, "hostPoolName", *hostPool.Name)
+					if err := avd.DrainSessionHost(ctx, *hostPool.Name, sessionHostName); err != nil {
+						log.WarnContext(ctx, "Failed to drain session host before deletion", "sessionHost", sessionHostName, "Error", err)
+					}
 					_, err := avd.sessionHostsClient.Delete(ctx, avd.Credentials.ResourceGroup, *hostPool.Name, sessionHostName, nil)
 					if err != nil {
 						return fmt.Errorf("error deleting session host %s in host pool %s: %w", sessionHostName, *hostPool.Name, err)
@@ -472,13 +570,14 @@ func (avd *AzureVirtualDesktopManager) Cleanup(ctx context.Context, vmID string)
 		}
 	}
 
-	// Step 2: Sort host pools by phonetic suffix
-	log.InfoContext(ctx, "Sorting host pools by name")
-	sort.Slice(hostPools, func(i, j int) bool {
-		return *hostPools[i].Name < *hostPools[j].Name
-	})
+	// Step 2: Sort host pools in the order of the naming strategy
+	log.InfoContext(ctx, "Sorting host pools by suffix")
+	avd.sortHostPoolsBySuffix(hostPools)
+
+	// Step 3: Identify empty host pools. Strategies naming stacks in sequence only delete the empty host pools after the
+	// last non-empty one, other strategies leave empty host pools anywhere, e.g. the stacks of a team that left.
+	sequential := avd.Naming.Sequential()
 
-	// Step 3: Identify empty host pools after the last non-empty host pool
 	var deleteHostPoolNames []string
 	for i := len(hostPools) - 1; i >= 0; i-- {
 		hostPool := hostPools[i]
@@ -493,10 +592,13 @@ func (avd *AzureVirtualDesktopManager) Cleanup(ctx context.Context, vmID string)
 			return fmt.Errorf("error checking if host pool %s is empty: %w", *hostPool.Name, err)
 		}
 
-		if !isEmpty {
+		if !isEmpty && sequential {
 			log.InfoContext(ctx, "Found non-empty host pool; stopping deletion collection", "hostPoolName", *hostPool.Name)
 			break
 		}
+		if !isEmpty {
+			continue
+		}
 
 		log.InfoContext(ctx, "Identified empty host pool for deletion", "hostPoolName", *hostPool.Name)
 		deleteHostPoolNames = append(deleteHostPoolNames, *hostPool.Name)
@@ -526,7 +628,7 @@ func (avd *AzureVirtualDesktopManager) Cleanup(ctx context.Context, vmID string)
 }
 
 // createAvdStack creates a new AVD stack including the host pool, application group, and workspace.
-func (avd *AzureVirtualDesktopManager) createAvdStack(ctx context.Context, suffix string) (
+func (avd *AzureVirtualDesktopManager) createAvdStack(ctx context.Context, suffix, teamID string) (
 	*armdesktopvirtualization.HostPool, *armdesktopvirtualization.ApplicationGroup, *armdesktopvirtualization.Workspace, error) {
 	log := logging.GetLogger(ctx)
 	log.DebugContext(ctx, "Creating AVD stack", "Suffix", suffix)
@@ -538,6 +640,9 @@ func (avd *AzureVirtualDesktopManager) createAvdStack(ctx context.Context, suffi
 		"stack_group_suffix": to.Ptr(suffix),
 		"arkloud_created_by": to.Ptr("cloudy-azure"),
 	}
+	if teamID != "" {
+		tags[stackTeamTagKey] = to.Ptr(teamID)
+	}
 
 	log.DebugContext(ctx, "Creating host pool", "ResourceGroup", resourceGroupName, "Suffix", suffix)
 	// Create host pool
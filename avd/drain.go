@@ -0,0 +1,308 @@
+package avd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/desktopvirtualization/armdesktopvirtualization/v2"
+	"github.com/appliedres/cloudy/logging"
+
+	cloudyazure "github.com/appliedres/cloudy-azure"
+)
+
+const (
+	defaultShutdownMessageTitle = "Your session is ending"
+	defaultDrainPollInterval    = 15 * time.Second
+)
+
+// DrainSessionHost gracefully empties a session host before it is stopped or deleted. New sessions are refused (drain mode),
+// active sessions are sent a warning, and sessions still open after the grace period are logged off.
+// The session host is left in drain mode. Does nothing if graceful shutdown is not configured.
+func (avd *AzureVirtualDesktopManager) DrainSessionHost(ctx context.Context, hostPoolName, sessionHostName string) error {
+	config := avd.Config.GracefulShutdown
+	if config == nil {
+		return nil
+	}
+
+	log := logging.GetLogger(ctx).With("hostPool", hostPoolName, "sessionHost", sessionHostName)
+
+	err := avd.SetSessionHostDrainMode(ctx, hostPoolName, sessionHostName, true)
+	if err != nil {
+		return err
+	}
+
+	messaged, err := avd.MessageUserSessions(ctx, hostPoolName, sessionHostName, shutdownMessageTitle(config), shutdownMessage(config))
+	if err != nil {
+		log.WarnContext(ctx, "Failed to warn user sessions", "Error", err)
+	}
+
+	remaining, err := waitForSessionsToEnd(ctx, config, func(ctx context.Context) (int, error) {
+		sessions, err := avd.listUserSessions(ctx, hostPoolName, sessionHostName)
+		return len(sessions), err
+	})
+	if err != nil {
+		return fmt.Errorf("failed waiting for sessions on %s to end: %w", sessionHostName, err)
+	}
+
+	loggedOff := 0
+	if remaining > 0 {
+		loggedOff, err = avd.LogOffUserSessions(ctx, hostPoolName, sessionHostName)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.InfoContext(ctx, "Drained session host", "messaged", messaged, "loggedOff", loggedOff)
+	return nil
+}
+
+// DrainVirtualMachine drains the personal session host backing a VM, see DrainSessionHost.
+// Does nothing if the VM is not registered as a personal session host.
+func (avd *AzureVirtualDesktopManager) DrainVirtualMachine(ctx context.Context, vmID string) error {
+	if avd.Config.GracefulShutdown == nil {
+		return nil
+	}
+
+	hostPool, sessionHost, err := avd.findPersonalSessionHost(ctx, vmID)
+	if err != nil || sessionHost == nil {
+		return err
+	}
+
+	_, sessionHostName, _, err := avd.ParseSessionHostName(ctx, sessionHost)
+	if err != nil {
+		return err
+	}
+
+	return avd.DrainSessionHost(ctx, *hostPool.Name, sessionHostName)
+}
+
+// ResumeVirtualMachineSessions takes the personal session host backing a VM out of drain mode,
+// so its user can connect once the VM is started again.
+func (avd *AzureVirtualDesktopManager) ResumeVirtualMachineSessions(ctx context.Context, vmID string) error {
+	if avd.Config.GracefulShutdown == nil {
+		return nil
+	}
+
+	hostPool, sessionHost, err := avd.findPersonalSessionHost(ctx, vmID)
+	if err != nil || sessionHost == nil {
+		return err
+	}
+
+	_, sessionHostName, _, err := avd.ParseSessionHostName(ctx, sessionHost)
+	if err != nil {
+		return err
+	}
+
+	return avd.SetSessionHostDrainMode(ctx, *hostPool.Name, sessionHostName, false)
+}
+
+// WarnUserSessions warns the sessions of a user in a host pool and waits out the grace period, without logging them off.
+// Used before removing a RemoteApp from a pooled session host that the user's session shares with other apps.
+func (avd *AzureVirtualDesktopManager) WarnUserSessions(ctx context.Context, hostPoolName, upn string) error {
+	config := avd.Config.GracefulShutdown
+	if config == nil {
+		return nil
+	}
+
+	log := logging.GetLogger(ctx).With("hostPool", hostPoolName, "upn", upn)
+
+	sessions, err := avd.listHostPoolUserSessions(ctx, hostPoolName)
+	if err != nil {
+		return err
+	}
+
+	messaged := 0
+	for _, session := range sessions {
+		if !sessionBelongsTo(session, upn) || sessionState(session) != armdesktopvirtualization.SessionStateActive {
+			continue
+		}
+		if err := avd.messageUserSession(ctx, session, shutdownMessageTitle(config), shutdownMessage(config)); err != nil {
+			log.WarnContext(ctx, "Failed to warn user session", "session", *session.Name, "Error", err)
+		}
+		messaged++
+	}
+	if messaged == 0 {
+		return nil
+	}
+
+	// the sessions are not logged off, so wait for the user to close the app, or the grace period to end
+	_, err = waitForSessionsToEnd(ctx, config, func(ctx context.Context) (int, error) {
+		sessions, err := avd.listHostPoolUserSessions(ctx, hostPoolName)
+		if err != nil {
+			return 0, err
+		}
+		count := 0
+		for _, session := range sessions {
+			if sessionBelongsTo(session, upn) && sessionState(session) == armdesktopvirtualization.SessionStateActive {
+				count++
+			}
+		}
+		return count, nil
+	})
+	return err
+}
+
+// SetSessionHostDrainMode enables or disables drain mode on a session host. Session hosts in drain mode refuse new sessions.
+func (avd *AzureVirtualDesktopManager) SetSessionHostDrainMode(ctx context.Context, hostPoolName, sessionHostName string, drain bool) error {
+	_, err := avd.sessionHostsClient.Update(ctx, avd.Credentials.ResourceGroup, hostPoolName, sessionHostName, &armdesktopvirtualization.SessionHostsClientUpdateOptions{
+		SessionHost: &armdesktopvirtualization.SessionHostPatch{
+			Properties: &armdesktopvirtualization.SessionHostPatchProperties{AllowNewSession: to.Ptr(!drain)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set drain mode on session host %s: %w", sessionHostName, err)
+	}
+
+	logging.GetLogger(ctx).DebugContext(ctx, "Set session host drain mode", "hostPool", hostPoolName, "sessionHost", sessionHostName, "drain", drain)
+	return nil
+}
+
+// MessageUserSessions sends a message to every active session on a session host, returning the number of sessions messaged
+func (avd *AzureVirtualDesktopManager) MessageUserSessions(ctx context.Context, hostPoolName, sessionHostName, title, message string) (int, error) {
+	sessions, err := avd.listUserSessions(ctx, hostPoolName, sessionHostName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list user sessions: %w", err)
+	}
+
+	count := 0
+	for _, session := range sessions {
+		if sessionState(session) != armdesktopvirtualization.SessionStateActive {
+			continue
+		}
+		if err := avd.messageUserSession(ctx, session, title, message); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// LogOffUserSessions forcibly logs off every session on a session host, returning the number of sessions logged off
+func (avd *AzureVirtualDesktopManager) LogOffUserSessions(ctx context.Context, hostPoolName, sessionHostName string) (int, error) {
+	sessions, err := avd.listUserSessions(ctx, hostPoolName, sessionHostName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list user sessions: %w", err)
+	}
+
+	count := 0
+	for _, session := range sessions {
+		if session == nil || session.Name == nil {
+			continue
+		}
+
+		_, err := avd.userSessionsClient.Delete(ctx, avd.Credentials.ResourceGroup, hostPoolName, sessionHostName,
+			lastNameSegment(*session.Name), &armdesktopvirtualization.UserSessionsClientDeleteOptions{Force: to.Ptr(true)})
+		if err != nil && !cloudyazure.Is404(err) {
+			return count, fmt.Errorf("failed to log off session %s: %w", *session.Name, err)
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+func (avd *AzureVirtualDesktopManager) messageUserSession(ctx context.Context, session *armdesktopvirtualization.UserSession, title, message string) error {
+	// session names are "hostPoolName/sessionHostName/sessionID"
+	hostPoolName, sessionHostName, sessionID, err := parseUserSessionName(*session.Name)
+	if err != nil {
+		return err
+	}
+
+	_, err = avd.userSessionsClient.SendMessage(ctx, avd.Credentials.ResourceGroup, hostPoolName, sessionHostName, sessionID,
+		&armdesktopvirtualization.UserSessionsClientSendMessageOptions{
+			SendMessage: &armdesktopvirtualization.SendMessage{
+				MessageTitle: to.Ptr(title),
+				MessageBody:  to.Ptr(message),
+			},
+		})
+	if err != nil {
+		return fmt.Errorf("failed to send message to session %s: %w", *session.Name, err)
+	}
+	return nil
+}
+
+func (avd *AzureVirtualDesktopManager) listHostPoolUserSessions(ctx context.Context, hostPoolName string) ([]*armdesktopvirtualization.UserSession, error) {
+	pager := avd.userSessionsClient.NewListByHostPoolPager(avd.Credentials.ResourceGroup, hostPoolName, nil)
+	var all []*armdesktopvirtualization.UserSession
+	for pager.More() {
+		resp, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list user sessions of host pool %s: %w", hostPoolName, err)
+		}
+		all = append(all, resp.Value...)
+	}
+
+	return all, nil
+}
+
+// waitForSessionsToEnd polls the session count until it reaches zero or the grace period ends, returning the remaining count
+func waitForSessionsToEnd(ctx context.Context, config *GracefulShutdownConfig, count func(ctx context.Context) (int, error)) (int, error) {
+	interval := config.PollInterval
+	if interval <= 0 {
+		interval = defaultDrainPollInterval
+	}
+	deadline := time.Now().Add(config.GracePeriod)
+
+	for {
+		remaining, err := count(ctx)
+		if err != nil || remaining == 0 {
+			return remaining, err
+		}
+
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return remaining, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return remaining, ctx.Err()
+		case <-time.After(min(wait, interval)):
+		}
+	}
+}
+
+func shutdownMessageTitle(config *GracefulShutdownConfig) string {
+	if config.MessageTitle != "" {
+		return config.MessageTitle
+	}
+	return defaultShutdownMessageTitle
+}
+
+func shutdownMessage(config *GracefulShutdownConfig) string {
+	if config.Message != "" {
+		return config.Message
+	}
+	if config.GracePeriod <= 0 {
+		return "This desktop is shutting down now. You will be logged off."
+	}
+	return fmt.Sprintf("This desktop is shutting down in %s. Please save your work, you will be logged off.", config.GracePeriod.Round(time.Second))
+}
+
+func parseUserSessionName(name string) (hostPoolName, sessionHostName, sessionID string, err error) {
+	hostPoolName, rest, ok := strings.Cut(name, "/")
+	if !ok {
+		return "", "", "", fmt.Errorf("invalid user session name %q", name)
+	}
+	sessionHostName, sessionID, ok = strings.Cut(rest, "/")
+	if !ok || sessionID == "" {
+		return "", "", "", fmt.Errorf("invalid user session name %q", name)
+	}
+	return hostPoolName, sessionHostName, sessionID, nil
+}
+
+func sessionState(session *armdesktopvirtualization.UserSession) armdesktopvirtualization.SessionState {
+	if session == nil || session.Name == nil || session.Properties == nil || session.Properties.SessionState == nil {
+		return ""
+	}
+	return *session.Properties.SessionState
+}
+
+func sessionBelongsTo(session *armdesktopvirtualization.UserSession, upn string) bool {
+	return session != nil && session.Properties != nil && session.Properties.UserPrincipalName != nil &&
+		strings.EqualFold(*session.Properties.UserPrincipalName, upn)
+}
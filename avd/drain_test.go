@@ -0,0 +1,73 @@
+package avd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/desktopvirtualization/armdesktopvirtualization/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWaitForSessionsToEnd(t *testing.T) {
+	config := &GracefulShutdownConfig{GracePeriod: time.Second, PollInterval: time.Millisecond}
+
+	counts := []int{2, 1, 0}
+	calls := 0
+	remaining, err := waitForSessionsToEnd(context.Background(), config, func(ctx context.Context) (int, error) {
+		calls++
+		return counts[calls-1], nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, remaining)
+	assert.Equal(t, 3, calls)
+
+	// sessions still open when the grace period ends are returned
+	config.GracePeriod = 5 * time.Millisecond
+	remaining, err = waitForSessionsToEnd(context.Background(), config, func(ctx context.Context) (int, error) { return 1, nil })
+	assert.NoError(t, err)
+	assert.Equal(t, 1, remaining)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	config.GracePeriod = time.Minute
+	_, err = waitForSessionsToEnd(ctx, config, func(ctx context.Context) (int, error) { return 1, nil })
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestShutdownMessage(t *testing.T) {
+	config := &GracefulShutdownConfig{GracePeriod: 10 * time.Minute}
+	assert.Equal(t, "Your session is ending", shutdownMessageTitle(config))
+	assert.Equal(t, "This desktop is shutting down in 10m0s. Please save your work, you will be logged off.", shutdownMessage(config))
+	assert.Contains(t, shutdownMessage(&GracefulShutdownConfig{}), "shutting down now")
+
+	config.MessageTitle, config.Message = "Maintenance", "Back at 10:00"
+	assert.Equal(t, "Maintenance", shutdownMessageTitle(config))
+	assert.Equal(t, "Back at 10:00", shutdownMessage(config))
+}
+
+func TestParseUserSessionName(t *testing.T) {
+	hostPool, sessionHost, sessionID, err := parseUserSessionName("HP-Pooled-team/shvm-1.corp.local/3")
+	assert.NoError(t, err)
+	assert.Equal(t, "HP-Pooled-team", hostPool)
+	assert.Equal(t, "shvm-1.corp.local", sessionHost)
+	assert.Equal(t, "3", sessionID)
+
+	_, _, _, err = parseUserSessionName("HP-Pooled-team/shvm-1.corp.local")
+	assert.Error(t, err)
+}
+
+func TestSessionBelongsTo(t *testing.T) {
+	session := &armdesktopvirtualization.UserSession{
+		Name: to.Ptr("hp/sh/1"),
+		Properties: &armdesktopvirtualization.UserSessionProperties{
+			UserPrincipalName: to.Ptr("Jane.Doe@example.com"),
+			SessionState:      to.Ptr(armdesktopvirtualization.SessionStateActive),
+		},
+	}
+	assert.True(t, sessionBelongsTo(session, "jane.doe@example.com"))
+	assert.False(t, sessionBelongsTo(session, "john@example.com"))
+	assert.Equal(t, armdesktopvirtualization.SessionStateActive, sessionState(session))
+	assert.Equal(t, armdesktopvirtualization.SessionState(""), sessionState(&armdesktopvirtualization.UserSession{}))
+}
@@ -0,0 +1,244 @@
+package avd
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/desktopvirtualization/armdesktopvirtualization/v2"
+	"github.com/appliedres/cloudy/logging"
+)
+
+// HostPoolReconcileReport summarizes a host pool reconciliation, keyed by host pool name
+type HostPoolReconcileReport struct {
+	Checked int
+	Updated []string
+	Failed  map[string]error
+}
+
+// ReconcileHostPools updates the properties of existing personal host pools that no longer match the config.
+// Host pools that cannot be updated are reported in Failed, and the others are still reconciled.
+func (avd *AzureVirtualDesktopManager) ReconcileHostPools(ctx context.Context) (*HostPoolReconcileReport, error) {
+	log := logging.GetLogger(ctx)
+
+	report := &HostPoolReconcileReport{Failed: map[string]error{}}
+
+	config := avd.Config.PersonalHostPool
+	if config == nil {
+		log.DebugContext(ctx, "Personal host pool properties not configured, nothing to reconcile")
+		return report, nil
+	}
+	desired := hostPoolProperties(config, armdesktopvirtualization.HostPoolTypePersonal)
+
+	hostPools, err := avd.listHostPools(ctx, to.Ptr(avd.Config.PersonalHostPoolNamePrefix))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list host pools: %w", err)
+	}
+
+	for _, hostPool := range hostPools {
+		if hostPool.Name == nil {
+			continue
+		}
+		report.Checked++
+
+		patch := hostPoolPatch(desired, hostPool.Properties)
+		if patch == nil {
+			continue
+		}
+
+		log.InfoContext(ctx, "Updating host pool properties", "hostPool", *hostPool.Name)
+		_, err := avd.hostPoolsClient.Update(ctx, avd.Credentials.ResourceGroup, *hostPool.Name, &armdesktopvirtualization.HostPoolsClientUpdateOptions{
+			HostPool: &armdesktopvirtualization.HostPoolPatch{Properties: patch},
+		})
+		if err != nil {
+			log.WarnContext(ctx, "Failed to update host pool properties", "hostPool", *hostPool.Name, "Error", err)
+			report.Failed[*hostPool.Name] = err
+			continue
+		}
+		report.Updated = append(report.Updated, *hostPool.Name)
+	}
+
+	log.InfoContext(ctx, "Host pool reconciliation complete", "checked", report.Checked, "updated", len(report.Updated), "failed", len(report.Failed))
+	if len(report.Failed) > 0 {
+		return report, fmt.Errorf("failed to update %d of %d host pools", len(report.Failed), report.Checked)
+	}
+
+	return report, nil
+}
+
+// validateHostPoolConfig checks the configured properties are valid for a host pool type
+func validateHostPoolConfig(config *HostPoolConfig, hostPoolType armdesktopvirtualization.HostPoolType) error {
+	if config == nil {
+		return nil
+	}
+
+	if config.LoadBalancerType != "" {
+		loadBalancerType := armdesktopvirtualization.LoadBalancerType(config.LoadBalancerType)
+		if !slices.Contains(armdesktopvirtualization.PossibleLoadBalancerTypeValues(), loadBalancerType) {
+			return fmt.Errorf("unsupported load balancer type [%s], must be one of %v", config.LoadBalancerType, armdesktopvirtualization.PossibleLoadBalancerTypeValues())
+		}
+
+		personal := loadBalancerType == armdesktopvirtualization.LoadBalancerTypePersistent
+		if personal != (hostPoolType == armdesktopvirtualization.HostPoolTypePersonal) {
+			return fmt.Errorf("load balancer type %s cannot be used by %s host pools", loadBalancerType, hostPoolType)
+		}
+	}
+
+	if config.AssignmentType != "" {
+		if hostPoolType != armdesktopvirtualization.HostPoolTypePersonal {
+			return fmt.Errorf("an assignment type can only be set on personal host pools")
+		}
+		if !slices.Contains(armdesktopvirtualization.PossiblePersonalDesktopAssignmentTypeValues(), armdesktopvirtualization.PersonalDesktopAssignmentType(config.AssignmentType)) {
+			return fmt.Errorf("unsupported assignment type [%s], must be one of %v", config.AssignmentType, armdesktopvirtualization.PossiblePersonalDesktopAssignmentTypeValues())
+		}
+	}
+
+	if config.PreferredAppGroupType != "" {
+		preferred := armdesktopvirtualization.PreferredAppGroupType(config.PreferredAppGroupType)
+		if preferred == armdesktopvirtualization.PreferredAppGroupTypeNone ||
+			!slices.Contains(armdesktopvirtualization.PossiblePreferredAppGroupTypeValues(), preferred) {
+			return fmt.Errorf("unsupported preferred app group type [%s], must be %s or %s", config.PreferredAppGroupType,
+				armdesktopvirtualization.PreferredAppGroupTypeDesktop, armdesktopvirtualization.PreferredAppGroupTypeRailApplications)
+		}
+	}
+
+	if config.MaxSessionLimit < 0 {
+		return fmt.Errorf("max session limit must not be negative")
+	}
+	if config.MaxSessionLimit > 0 && hostPoolType == armdesktopvirtualization.HostPoolTypePersonal {
+		return fmt.Errorf("a max session limit can only be set on pooled host pools")
+	}
+
+	return nil
+}
+
+// hostPoolProperties returns the properties of a new host pool of the given type. The config must already be validated.
+func hostPoolProperties(config *HostPoolConfig, hostPoolType armdesktopvirtualization.HostPoolType) *armdesktopvirtualization.HostPoolProperties {
+	properties := &armdesktopvirtualization.HostPoolProperties{
+		HostPoolType: to.Ptr(hostPoolType),
+	}
+
+	if config == nil {
+		return properties
+	}
+
+	if config.LoadBalancerType != "" {
+		properties.LoadBalancerType = to.Ptr(armdesktopvirtualization.LoadBalancerType(config.LoadBalancerType))
+	} else if hostPoolType == armdesktopvirtualization.HostPoolTypePersonal {
+		properties.LoadBalancerType = to.Ptr(armdesktopvirtualization.LoadBalancerTypePersistent)
+	}
+
+	if config.AssignmentType != "" {
+		properties.PersonalDesktopAssignmentType = to.Ptr(armdesktopvirtualization.PersonalDesktopAssignmentType(config.AssignmentType))
+	}
+
+	if config.PreferredAppGroupType != "" {
+		properties.PreferredAppGroupType = to.Ptr(armdesktopvirtualization.PreferredAppGroupType(config.PreferredAppGroupType))
+	} else {
+		properties.PreferredAppGroupType = to.Ptr(armdesktopvirtualization.PreferredAppGroupTypeDesktop)
+	}
+
+	if config.MaxSessionLimit > 0 {
+		properties.MaxSessionLimit = to.Ptr(config.MaxSessionLimit)
+	}
+
+	properties.StartVMOnConnect = to.Ptr(config.StartVMOnConnect)
+	properties.ValidationEnvironment = to.Ptr(config.ValidationEnvironment)
+
+	if config.RDPProperties != nil {
+		properties.CustomRdpProperty = to.Ptr(rdpProperties(config.RDPProperties))
+	}
+
+	return properties
+}
+
+// hostPoolPatch returns a patch setting the desired properties that differ on an existing host pool, or nil if none differ
+func hostPoolPatch(desired, actual *armdesktopvirtualization.HostPoolProperties) *armdesktopvirtualization.HostPoolPatchProperties {
+	if actual == nil {
+		actual = &armdesktopvirtualization.HostPoolProperties{}
+	}
+
+	patch := &armdesktopvirtualization.HostPoolPatchProperties{}
+	changed := false
+
+	if desired.LoadBalancerType != nil && !equalPtr(desired.LoadBalancerType, actual.LoadBalancerType) {
+		patch.LoadBalancerType, changed = desired.LoadBalancerType, true
+	}
+	if desired.PersonalDesktopAssignmentType != nil && !equalPtr(desired.PersonalDesktopAssignmentType, actual.PersonalDesktopAssignmentType) {
+		patch.PersonalDesktopAssignmentType, changed = desired.PersonalDesktopAssignmentType, true
+	}
+	if desired.PreferredAppGroupType != nil && !equalPtr(desired.PreferredAppGroupType, actual.PreferredAppGroupType) {
+		patch.PreferredAppGroupType, changed = desired.PreferredAppGroupType, true
+	}
+	if desired.MaxSessionLimit != nil && !equalPtr(desired.MaxSessionLimit, actual.MaxSessionLimit) {
+		patch.MaxSessionLimit, changed = desired.MaxSessionLimit, true
+	}
+	if desired.StartVMOnConnect != nil && !equalPtr(desired.StartVMOnConnect, actual.StartVMOnConnect) {
+		patch.StartVMOnConnect, changed = desired.StartVMOnConnect, true
+	}
+	if desired.ValidationEnvironment != nil && !equalPtr(desired.ValidationEnvironment, actual.ValidationEnvironment) {
+		patch.ValidationEnvironment, changed = desired.ValidationEnvironment, true
+	}
+	if desired.CustomRdpProperty != nil && !equalPtr(desired.CustomRdpProperty, actual.CustomRdpProperty) {
+		patch.CustomRdpProperty, changed = desired.CustomRdpProperty, true
+	}
+
+	if !changed {
+		return nil
+	}
+
+	return patch
+}
+
+// rdpProperties builds the custom RDP property string of a host pool, in RDP file syntax
+func rdpProperties(config *RDPPropertiesConfig) string {
+	properties := []string{}
+
+	if config.DriveRedirection != nil {
+		properties = append(properties, "drivestoredirect:s:"+redirectAll(*config.DriveRedirection))
+	}
+	if config.ClipboardRedirection != nil {
+		properties = append(properties, "redirectclipboard:i:"+boolInt(*config.ClipboardRedirection))
+	}
+	if config.MultipleMonitors != nil {
+		properties = append(properties, "use multimon:i:"+boolInt(*config.MultipleMonitors))
+	}
+	if config.CameraRedirection != nil {
+		properties = append(properties, "camerastoredirect:s:"+redirectAll(*config.CameraRedirection))
+	}
+
+	for _, property := range strings.Split(config.Additional, ";") {
+		if property = strings.TrimSpace(property); property != "" {
+			properties = append(properties, property)
+		}
+	}
+
+	if len(properties) == 0 {
+		return ""
+	}
+
+	return strings.Join(properties, ";") + ";"
+}
+
+func redirectAll(enabled bool) string {
+	if enabled {
+		return "*"
+	}
+	return ""
+}
+
+func boolInt(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+func equalPtr[T comparable](a, b *T) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
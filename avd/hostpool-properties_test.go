@@ -0,0 +1,76 @@
+package avd
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/desktopvirtualization/armdesktopvirtualization/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateHostPoolConfig(t *testing.T) {
+	personal := armdesktopvirtualization.HostPoolTypePersonal
+	pooled := armdesktopvirtualization.HostPoolTypePooled
+
+	assert.NoError(t, validateHostPoolConfig(nil, personal))
+	assert.NoError(t, validateHostPoolConfig(&HostPoolConfig{LoadBalancerType: "Persistent", AssignmentType: "Direct", PreferredAppGroupType: "Desktop"}, personal))
+	assert.NoError(t, validateHostPoolConfig(&HostPoolConfig{LoadBalancerType: "DepthFirst", MaxSessionLimit: 10}, pooled))
+
+	assert.Error(t, validateHostPoolConfig(&HostPoolConfig{LoadBalancerType: "BreadthFirst"}, personal))
+	assert.Error(t, validateHostPoolConfig(&HostPoolConfig{LoadBalancerType: "Persistent"}, pooled))
+	assert.Error(t, validateHostPoolConfig(&HostPoolConfig{LoadBalancerType: "RoundRobin"}, pooled))
+	assert.Error(t, validateHostPoolConfig(&HostPoolConfig{AssignmentType: "Manual"}, personal))
+	assert.Error(t, validateHostPoolConfig(&HostPoolConfig{AssignmentType: "Direct"}, pooled))
+	assert.Error(t, validateHostPoolConfig(&HostPoolConfig{PreferredAppGroupType: "None"}, personal))
+	assert.Error(t, validateHostPoolConfig(&HostPoolConfig{MaxSessionLimit: 2}, personal))
+}
+
+func TestRDPProperties(t *testing.T) {
+	config := &RDPPropertiesConfig{
+		DriveRedirection:     to.Ptr(false),
+		ClipboardRedirection: to.Ptr(true),
+		MultipleMonitors:     to.Ptr(true),
+		CameraRedirection:    to.Ptr(true),
+		Additional:           "audiocapturemode:i:1; ;",
+	}
+
+	assert.Equal(t, "drivestoredirect:s:;redirectclipboard:i:1;use multimon:i:1;camerastoredirect:s:*;audiocapturemode:i:1;", rdpProperties(config))
+	assert.Equal(t, "", rdpProperties(&RDPPropertiesConfig{}))
+}
+
+func TestHostPoolPatch(t *testing.T) {
+	config := &HostPoolConfig{
+		AssignmentType:   "Direct",
+		StartVMOnConnect: true,
+		RDPProperties:    &RDPPropertiesConfig{ClipboardRedirection: to.Ptr(false)},
+	}
+	desired := hostPoolProperties(config, armdesktopvirtualization.HostPoolTypePersonal)
+	assert.Equal(t, armdesktopvirtualization.LoadBalancerTypePersistent, *desired.LoadBalancerType)
+	assert.Equal(t, armdesktopvirtualization.PreferredAppGroupTypeDesktop, *desired.PreferredAppGroupType)
+	assert.Nil(t, desired.MaxSessionLimit)
+
+	actual := &armdesktopvirtualization.HostPoolProperties{
+		HostPoolType:                  to.Ptr(armdesktopvirtualization.HostPoolTypePersonal),
+		LoadBalancerType:              to.Ptr(armdesktopvirtualization.LoadBalancerTypePersistent),
+		PersonalDesktopAssignmentType: to.Ptr(armdesktopvirtualization.PersonalDesktopAssignmentTypeAutomatic),
+		PreferredAppGroupType:         to.Ptr(armdesktopvirtualization.PreferredAppGroupTypeDesktop),
+		StartVMOnConnect:              to.Ptr(false),
+		ValidationEnvironment:         to.Ptr(false),
+		CustomRdpProperty:             to.Ptr("redirectclipboard:i:0;"),
+	}
+
+	patch := hostPoolPatch(desired, actual)
+	assert.NotNil(t, patch)
+	assert.Equal(t, armdesktopvirtualization.PersonalDesktopAssignmentTypeDirect, *patch.PersonalDesktopAssignmentType)
+	assert.True(t, *patch.StartVMOnConnect)
+	assert.Nil(t, patch.LoadBalancerType)
+	assert.Nil(t, patch.CustomRdpProperty)
+	assert.Nil(t, patch.ValidationEnvironment)
+
+	actual.PersonalDesktopAssignmentType = patch.PersonalDesktopAssignmentType
+	actual.StartVMOnConnect = patch.StartVMOnConnect
+	assert.Nil(t, hostPoolPatch(desired, actual))
+
+	// without config, new host pools keep the AVD defaults and nothing is reconciled
+	assert.Nil(t, hostPoolPatch(hostPoolProperties(nil, armdesktopvirtualization.HostPoolTypePersonal), actual))
+}
@@ -4,11 +4,9 @@ import (
 	"context"
 	"fmt"
 	"strings"
-	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/desktopvirtualization/armdesktopvirtualization/v2"
-	"github.com/appliedres/cloudy/logging"
 )
 
 func (avd *AzureVirtualDesktopManager) FindFirstAvailableHostPool(ctx context.Context, upn string) (*armdesktopvirtualization.HostPool, error) {
@@ -42,80 +40,6 @@ func (avd *AzureVirtualDesktopManager) FindFirstAvailableHostPool(ctx context.Co
 	return nil, nil
 }
 
-func (avd *AzureVirtualDesktopManager) RetrieveRegistrationToken(ctx context.Context, hpName string) (*string, error) {
-	log := logging.GetLogger(ctx)
-	log.DebugContext(ctx, "Beginning host pool token retrieval")
-
-	const maxRetries = 3
-	var lastErr error
-
-	for attempt := 1; attempt <= maxRetries; attempt++ {
-		// Try to retrieve the token
-		tokenResponse, err := avd.hostPoolsClient.RetrieveRegistrationToken(ctx, avd.Credentials.ResourceGroup, hpName, nil)
-		if err != nil || tokenResponse.Token == nil {
-			log.DebugContext(ctx, fmt.Sprintf("Attempt %d/%d: No valid token found or error retrieving token. Creating/renewing now.",
-				attempt, maxRetries,
-			))
-
-			// Attempt to create/renew
-			hp, updateErr := avd.UpdateHostPoolRegToken(ctx, hpName)
-			if updateErr != nil || hp == nil {
-				lastErr = logging.LogAndWrapErr(ctx, log, updateErr, "Failure while creating/renewing host pool token")
-				// Let the loop continue and try again
-				time.Sleep(3 * time.Second)
-				continue
-			}
-
-			// Wait briefly, then retrieve again
-			time.Sleep(3 * time.Second)
-			tokenResponse, err = avd.hostPoolsClient.RetrieveRegistrationToken(ctx, avd.Credentials.ResourceGroup, hpName, nil)
-			if err != nil || tokenResponse.Token == nil {
-				lastErr = logging.LogAndWrapErr(ctx, log, err, "RetrieveRegistrationToken failure after creating/renewing token")
-				time.Sleep(3 * time.Second)
-				continue
-			}
-
-			log.DebugContext(ctx, "Host pool token has been created/renewed successfully.")
-		}
-
-		// Now we have a valid token; check if it expires within 24 hours
-		if tokenResponse.ExpirationTime.Before(time.Now().Add(24 * time.Hour)) {
-			log.DebugContext(ctx, fmt.Sprintf(
-				"Attempt %d/%d: Host pool token will expire within 24 hours. Renewing...",
-				attempt, maxRetries,
-			))
-
-			hp, updateErr := avd.UpdateHostPoolRegToken(ctx, hpName)
-			if updateErr != nil || hp == nil {
-				lastErr = logging.LogAndWrapErr(ctx, log, updateErr, "Failure while renewing host pool token")
-				time.Sleep(3 * time.Second)
-				continue
-			}
-
-			// Wait briefly, then retrieve again
-			time.Sleep(3 * time.Second)
-			tokenResponse, err = avd.hostPoolsClient.RetrieveRegistrationToken(ctx, avd.Credentials.ResourceGroup, hpName, nil)
-			if err != nil || tokenResponse.Token == nil {
-				lastErr = logging.LogAndWrapErr(ctx, log, err, "RetrieveRegistrationToken failure after renewing token")
-				time.Sleep(3 * time.Second)
-				continue
-			}
-
-			log.DebugContext(ctx, "Host pool token has been renewed successfully and is valid for at least 24 more hours.")
-		}
-
-		// If we reach here, we've successfully retrieved a token that doesn't expire within 24 hours
-		log.DebugContext(ctx, "Successfully retrieved host pool token")
-		return tokenResponse.Token, nil
-	}
-
-	// If we exit the loop, we've used up all retries without success
-	if lastErr == nil {
-		lastErr = fmt.Errorf("unable to retrieve a valid token after %d attempts", maxRetries)
-	}
-	return nil, lastErr
-}
-
 // Helper to check if a host pool is empty
 func (avd *AzureVirtualDesktopManager) isHostPoolEmpty(ctx context.Context, hostPoolName string) (bool, error) {
 	sessionHostsPager := avd.sessionHostsClient.NewListPager(avd.Credentials.ResourceGroup, hostPoolName, nil)
@@ -131,25 +55,19 @@ func (avd *AzureVirtualDesktopManager) isHostPoolEmpty(ctx context.Context, host
 	return true, nil
 }
 
-// CreateHostPool creates a new host pool.
+// CreateHostPool creates a new personal host pool with the configured properties.
 func (avd *AzureVirtualDesktopManager) CreateHostPool(ctx context.Context, suffix string, tags map[string]*string) (*armdesktopvirtualization.HostPool, error) {
 	hostPoolName := avd.Config.PersonalHostPoolNamePrefix + suffix
 
-	// Expiration time can be 1 hour to 27 days. We'll use 25 days.
-	expirationTime := time.Now().AddDate(0, 0, 25) // 25 days from now
+	properties := hostPoolProperties(avd.Config.PersonalHostPool, armdesktopvirtualization.HostPoolTypePersonal)
+	properties.FriendlyName = to.Ptr("Host Pool for AVD stack '" + suffix + "'")
+	properties.Description = to.Ptr("Generated via cloudy-azure")
+	properties.RegistrationInfo = avd.newRegistrationInfo()
 
 	newHostPool := armdesktopvirtualization.HostPool{
-		Location: to.Ptr(string(avd.Credentials.Region)),
-		Tags:     tags,
-		Properties: &armdesktopvirtualization.HostPoolProperties{
-			FriendlyName: to.Ptr("Host Pool for AVD stack '" + suffix + "'"),
-			Description:  to.Ptr("Generated via cloudy-azure"),
-			HostPoolType: to.Ptr(armdesktopvirtualization.HostPoolTypePersonal),
-			RegistrationInfo: &armdesktopvirtualization.RegistrationInfo{
-				ExpirationTime:             &expirationTime,
-				RegistrationTokenOperation: to.Ptr(armdesktopvirtualization.RegistrationTokenOperationUpdate),
-			},
-		},
+		Location:   to.Ptr(string(avd.Credentials.Region)),
+		Tags:       tags,
+		Properties: properties,
 	}
 
 	resp, err := avd.hostPoolsClient.CreateOrUpdate(ctx, avd.Credentials.ResourceGroup, hostPoolName, newHostPool, nil)
@@ -182,28 +100,6 @@ func (avd *AzureVirtualDesktopManager) CanAssignUserToHostPool(ctx context.Conte
 	return true, nil // User is not assigned to any session host in the host pool
 }
 
-func (avd *AzureVirtualDesktopManager) UpdateHostPoolRegToken(ctx context.Context, hpName string) (*armdesktopvirtualization.HostPool, error) {
-	// Expiration time can be 1 hour to 27 days. We'll use 25 days.
-	expirationTime := time.Now().AddDate(0, 0, 25) // 25 days from now
-
-	patch := armdesktopvirtualization.HostPoolPatch{
-		Properties: &armdesktopvirtualization.HostPoolPatchProperties{
-			RegistrationInfo: &armdesktopvirtualization.RegistrationInfoPatch{
-				ExpirationTime:             &expirationTime,
-				RegistrationTokenOperation: to.Ptr(armdesktopvirtualization.RegistrationTokenOperationUpdate),
-			},
-		}}
-
-	resp, err := avd.hostPoolsClient.Update(ctx, avd.Credentials.ResourceGroup, hpName, &armdesktopvirtualization.HostPoolsClientUpdateOptions{
-		HostPool: &patch,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to update host pool reg token: %w", err)
-	}
-
-	return &resp.HostPool, nil
-}
-
 func (avd *AzureVirtualDesktopManager) DeleteHostPool(ctx context.Context, hpName string) error {
 	_, err := avd.hostPoolsClient.Delete(ctx, avd.Credentials.ResourceGroup, hpName, nil)
 	if err != nil {
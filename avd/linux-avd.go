@@ -3,7 +3,6 @@ package avd
 import (
 	"context"
 	"fmt"
-	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/desktopvirtualization/armdesktopvirtualization/v2"
@@ -77,8 +76,6 @@ func (avd *AzureVirtualDesktopManager) EnsurePooledHostPoolForRemoteApps(
 	if foundHP == nil {
 		log.InfoContext(ctx, "Creating new pooled host pool (RemoteApps)", "Name", wantedHPName)
 
-		expirationTime := time.Now().AddDate(0, 0, 25) // 25 days from now
-
 		newHP := armdesktopvirtualization.HostPool{
 			Location: to.Ptr(string(avd.Credentials.Region)),
 			Tags:     tags,
@@ -88,10 +85,7 @@ func (avd *AzureVirtualDesktopManager) EnsurePooledHostPoolForRemoteApps(
 				LoadBalancerType:      to.Ptr(loadBalancerType),
 				MaxSessionLimit:       to.Ptr(maxSessionLimit),
 				Description:           to.Ptr("Pooled Host Pool for Remote Apps. Managed by cloudy-azure"),
-				RegistrationInfo: &armdesktopvirtualization.RegistrationInfo{
-					ExpirationTime:             &expirationTime,
-					RegistrationTokenOperation: to.Ptr(armdesktopvirtualization.RegistrationTokenOperationUpdate),
-				},
+				RegistrationInfo:      avd.newRegistrationInfo(),
 			},
 		}
 
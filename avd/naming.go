@@ -0,0 +1,399 @@
+package avd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/desktopvirtualization/armdesktopvirtualization/v2"
+)
+
+// ErrStackCapacityReached is returned when the naming strategy has no name left for a new personal stack
+var ErrStackCapacityReached = errors.New("personal AVD stack capacity reached")
+
+// AVD host pool, workspace and app group names are 3 to 64 letters, digits, periods, underscores or hyphens
+const maxStackNameLength = 64
+
+var stackNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{3,64}$`)
+
+// StackNamingRequest describes the VM a new personal stack is needed for
+type StackNamingRequest struct {
+	UserID string
+	TeamID string
+}
+
+// NamingStrategy names the personal AVD stacks. A stack is named by a suffix appended to the
+// host pool, workspace and app group name prefixes.
+type NamingStrategy interface {
+	// NextSuffix returns the suffix of a new stack, given the suffixes of the existing stacks.
+	// Returns ErrStackCapacityReached when no suffix is left.
+	NextSuffix(existing []string, request StackNamingRequest) (string, error)
+
+	// Eligible returns whether a VM can be placed in the existing stack with this suffix
+	Eligible(suffix string, request StackNamingRequest) bool
+
+	// Less orders the existing stacks, earlier stacks are filled first
+	Less(a, b string) bool
+
+	// Capacity returns the number of stacks the strategy can name, 0 when it is unbounded
+	Capacity() int
+
+	// MaxSuffixLength returns the length of the longest suffix, 0 when it depends on the request
+	MaxSuffixLength() int
+
+	// Sequential returns whether stacks are named in sequence, so stacks emptied in between are reused
+	// and only the empty stacks after the last one in use are removed
+	Sequential() bool
+}
+
+// StackCapacityReport is the number of personal stacks in use, against the capacity of the naming strategy
+type StackCapacityReport struct {
+	Strategy string
+	Used     int
+	Capacity int // 0 when unbounded
+}
+
+// StackCapacity reports how many personal stacks exist, and how many the naming strategy allows
+func (avd *AzureVirtualDesktopManager) StackCapacity(ctx context.Context) (*StackCapacityReport, error) {
+	hostPools, err := avd.listHostPools(ctx, &avd.Config.PersonalHostPoolNamePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list host pools: %w", err)
+	}
+
+	return &StackCapacityReport{
+		Strategy: fmt.Sprintf("%T", avd.Naming),
+		Used:     len(hostPools),
+		Capacity: avd.Naming.Capacity(),
+	}, nil
+}
+
+// nextStackSuffix names a new personal stack, checking the resulting names against the AVD naming rules
+func (avd *AzureVirtualDesktopManager) nextStackSuffix(hostPools []*armdesktopvirtualization.HostPool, request StackNamingRequest) (string, error) {
+	var existing []string
+	for _, hostPool := range hostPools {
+		if suffix, err := avd.extractSuffixFromHostPoolName(*hostPool.Name); err == nil {
+			existing = append(existing, suffix)
+		}
+	}
+
+	suffix, err := avd.Naming.NextSuffix(existing, request)
+	if err != nil {
+		return "", err
+	}
+
+	for _, name := range avd.stackNames(suffix) {
+		if !stackNamePattern.MatchString(name) {
+			return "", fmt.Errorf("stack name %q breaks the AVD naming rules: 3 to %d letters, digits, periods, underscores or hyphens", name, maxStackNameLength)
+		}
+	}
+	return suffix, nil
+}
+
+// sortHostPoolsBySuffix sorts the personal host pools in the order of the naming strategy, so earlier stacks are filled first
+func (avd *AzureVirtualDesktopManager) sortHostPoolsBySuffix(hostPools []*armdesktopvirtualization.HostPool) {
+	sort.SliceStable(hostPools, func(i, j int) bool {
+		si, errI := avd.extractSuffixFromHostPoolName(*hostPools[i].Name)
+		sj, errJ := avd.extractSuffixFromHostPoolName(*hostPools[j].Name)
+
+		// If either suffix is invalid, keep original order deterministically
+		if errI != nil && errJ != nil {
+			return i < j
+		}
+		if errI != nil {
+			return false
+		}
+		if errJ != nil {
+			return true
+		}
+		return avd.Naming.Less(si, sj)
+	})
+}
+
+// stackNames returns the host pool, workspace and app group names of a personal stack
+func (avd *AzureVirtualDesktopManager) stackNames(suffix string) []string {
+	return []string{
+		avd.Config.PersonalHostPoolNamePrefix + suffix,
+		avd.Config.PersonalWorkspaceNamePrefix + suffix,
+		avd.Config.PersonalAppGroupNamePrefix + suffix,
+	}
+}
+
+// validateStackNaming checks up front that the longest suffix of the strategy fits the AVD name length
+func (avd *AzureVirtualDesktopManager) validateStackNaming() error {
+	maxSuffix := avd.Naming.MaxSuffixLength()
+	if maxSuffix == 0 {
+		return nil
+	}
+
+	for _, name := range avd.stackNames("") {
+		if len(name)+maxSuffix > maxStackNameLength {
+			return fmt.Errorf("stack names starting %q can exceed %d characters with suffixes of up to %d characters",
+				name, maxStackNameLength, maxSuffix)
+		}
+	}
+	return nil
+}
+
+// newNamingStrategy returns the naming strategy of a config, the phonetic strategy when nil
+func newNamingStrategy(config *StackNamingConfig) (NamingStrategy, error) {
+	if config == nil {
+		config = &StackNamingConfig{}
+	}
+	if config.MaxWords < 0 || config.Digits < 0 || config.HashLength < 0 {
+		return nil, fmt.Errorf("naming lengths cannot be negative")
+	}
+
+	switch strings.ToLower(config.Strategy) {
+	case "", "phonetic":
+		return &PhoneticNaming{MaxWords: defaultInt(config.MaxWords, 2)}, nil
+	case "numeric":
+		return &NumericNaming{Digits: defaultInt(config.Digits, 3)}, nil
+	case "team":
+		return &TeamNaming{Digits: defaultInt(config.Digits, 2)}, nil
+	case "hash":
+		return &HashNaming{Length: defaultInt(config.HashLength, 8)}, nil
+	default:
+		return nil, fmt.Errorf("unknown naming strategy %q, expected phonetic, numeric, team or hash", config.Strategy)
+	}
+}
+
+// PhoneticNaming names stacks ALPHA … ZULU, then ALPHA-ALPHA … ZULU-ZULU, up to MaxWords words
+type PhoneticNaming struct {
+	MaxWords int
+}
+
+func (n *PhoneticNaming) NextSuffix(existing []string, _ StackNamingRequest) (string, error) {
+	highest := ""
+	for _, suffix := range existing {
+		if isPhonetic(suffix) && (highest == "" || phoneticLess(highest, suffix)) {
+			highest = suffix
+		}
+	}
+
+	next, err := GenerateNextName(highest, n.MaxWords)
+	if err != nil {
+		return "", fmt.Errorf("%w: %d phonetic stacks: %v", ErrStackCapacityReached, n.Capacity(), err)
+	}
+	return next, nil
+}
+
+func (n *PhoneticNaming) Eligible(string, StackNamingRequest) bool { return true }
+
+func (n *PhoneticNaming) Less(a, b string) bool { return phoneticLess(a, b) }
+
+func (n *PhoneticNaming) Capacity() int {
+	capacity, words := 0, 1
+	for i := 0; i < n.MaxWords; i++ {
+		words *= len(phoneticAlphabet)
+		capacity += words
+	}
+	return capacity
+}
+
+func (n *PhoneticNaming) MaxSuffixLength() int {
+	longest := 0
+	for _, word := range phoneticAlphabet {
+		longest = max(longest, len(word))
+	}
+	return n.MaxWords*longest + n.MaxWords - 1
+}
+
+func (n *PhoneticNaming) Sequential() bool { return true }
+
+// NumericNaming names stacks with zero-padded numbers, 001 … 999, reusing the lowest free number
+type NumericNaming struct {
+	Digits int
+}
+
+func (n *NumericNaming) NextSuffix(existing []string, _ StackNamingRequest) (string, error) {
+	return nextFreeNumber("", existing, n.Digits)
+}
+
+func (n *NumericNaming) Eligible(string, StackNamingRequest) bool { return true }
+
+func (n *NumericNaming) Less(a, b string) bool { return numericLess(a, b) }
+
+func (n *NumericNaming) Capacity() int { return maxNumber(n.Digits) }
+
+func (n *NumericNaming) MaxSuffixLength() int { return n.Digits }
+
+func (n *NumericNaming) Sequential() bool { return true }
+
+const (
+	sharedTeamSuffix = "SHARED" // stacks of VMs without a team
+	teamHashLength   = 6
+)
+
+// TeamNaming names stacks after the team of the VM, TEAM-01 … TEAM-99, so teams do not share stacks.
+// VMs without a team share the SHARED stacks.
+type TeamNaming struct {
+	Digits int
+}
+
+func (n *TeamNaming) NextSuffix(existing []string, request StackNamingRequest) (string, error) {
+	return nextFreeNumber(teamSuffixPrefix(request.TeamID), existing, n.Digits)
+}
+
+func (n *TeamNaming) Eligible(suffix string, request StackNamingRequest) bool {
+	number, ok := strings.CutPrefix(suffix, teamSuffixPrefix(request.TeamID))
+	_, err := strconv.Atoi(number)
+	return ok && err == nil
+}
+
+func (n *TeamNaming) Less(a, b string) bool {
+	teamA, numberA := splitTeamSuffix(a)
+	teamB, numberB := splitTeamSuffix(b)
+	if teamA != teamB {
+		return teamA < teamB
+	}
+	return numericLess(numberA, numberB)
+}
+
+// Capacity is unbounded over all teams, each team is limited to 10^Digits-1 stacks
+func (n *TeamNaming) Capacity() int { return 0 }
+
+// MaxSuffixLength depends on the team, so names are checked when they are generated
+func (n *TeamNaming) MaxSuffixLength() int { return 0 }
+
+// Sequential is false, the stacks of a team that left are empty wherever they sort
+func (n *TeamNaming) Sequential() bool { return false }
+
+// HashNaming names stacks with a hash of the team, so names carry no order and do not reveal the team.
+// VMs are only placed in the stacks of their team, VMs without a team share the stacks of the empty team.
+type HashNaming struct {
+	Length int
+}
+
+// maxHashAttempts is the number of stacks per team, each attempt hashes to another suffix
+const maxHashAttempts = 100
+
+func (n *HashNaming) NextSuffix(existing []string, request StackNamingRequest) (string, error) {
+	for attempt := 0; attempt < maxHashAttempts; attempt++ {
+		suffix := n.suffix(request.TeamID, attempt)
+		if !slices.ContainsFunc(existing, func(s string) bool { return strings.EqualFold(s, suffix) }) {
+			return suffix, nil
+		}
+	}
+	return "", fmt.Errorf("%w: no free %d character hash", ErrStackCapacityReached, n.Length)
+}
+
+func (n *HashNaming) Eligible(suffix string, request StackNamingRequest) bool {
+	for attempt := 0; attempt < maxHashAttempts; attempt++ {
+		if strings.EqualFold(suffix, n.suffix(request.TeamID, attempt)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *HashNaming) suffix(teamID string, attempt int) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s/%d", strings.ToLower(teamID), attempt)))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))[:min(n.Length, 2*len(sum))]
+}
+
+func (n *HashNaming) Less(a, b string) bool { return a < b }
+
+func (n *HashNaming) Capacity() int { return 0 }
+
+func (n *HashNaming) MaxSuffixLength() int { return n.Length }
+
+func (n *HashNaming) Sequential() bool { return false }
+
+// nextFreeNumber returns prefix followed by the lowest zero-padded number not in existing
+func nextFreeNumber(prefix string, existing []string, digits int) (string, error) {
+	used := map[int]bool{}
+	for _, suffix := range existing {
+		if number, ok := strings.CutPrefix(suffix, prefix); ok && len(number) == digits {
+			if i, err := strconv.Atoi(number); err == nil {
+				used[i] = true
+			}
+		}
+	}
+
+	for i := 1; i <= maxNumber(digits); i++ {
+		if !used[i] {
+			return fmt.Sprintf("%s%0*d", prefix, digits, i), nil
+		}
+	}
+	return "", fmt.Errorf("%w: %d stacks of %d digits", ErrStackCapacityReached, maxNumber(digits), digits)
+}
+
+func maxNumber(digits int) int {
+	capacity := 1
+	for i := 0; i < digits; i++ {
+		capacity *= 10
+	}
+	return capacity - 1
+}
+
+// numericLess orders numbers by value, and after them anything else alphabetically
+func numericLess(a, b string) bool {
+	ia, errA := strconv.Atoi(a)
+	ib, errB := strconv.Atoi(b)
+	switch {
+	case errA == nil && errB == nil:
+		return ia < ib
+	case errA == nil:
+		return true
+	case errB == nil:
+		return false
+	}
+	return a < b
+}
+
+// teamSuffixPrefix returns the start of the suffixes of a team, letters, digits and hyphens only.
+// Team IDs are compared ignoring case. An ID that had to be changed, e.g. "a b" becoming "A-B", or that reads
+// SHARED, gets a hash of the ID appended, so it cannot collide with the ID it was changed into.
+func teamSuffixPrefix(teamID string) string {
+	team := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '-'
+	}, teamID)
+
+	team = strings.Trim(team, "-")
+	if team == "" && teamID == "" {
+		return sharedTeamSuffix + "-"
+	}
+
+	if team != strings.ToUpper(teamID) || team == sharedTeamSuffix {
+		sum := sha256.Sum256([]byte(strings.ToLower(teamID)))
+		team = strings.Trim(team+"-"+strings.ToUpper(hex.EncodeToString(sum[:]))[:teamHashLength], "-")
+	}
+	return team + "-"
+}
+
+func splitTeamSuffix(suffix string) (team, number string) {
+	i := strings.LastIndex(suffix, "-")
+	if i < 0 {
+		return "", suffix
+	}
+	return suffix[:i], suffix[i+1:]
+}
+
+func isPhonetic(suffix string) bool {
+	for _, word := range strings.Split(strings.ToUpper(suffix), "-") {
+		if _, ok := phoneticIndex[word]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func defaultInt(value, defaultValue int) int {
+	if value == 0 {
+		return defaultValue
+	}
+	return value
+}
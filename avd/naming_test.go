@@ -0,0 +1,151 @@
+package avd
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/desktopvirtualization/armdesktopvirtualization/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewNamingStrategy(t *testing.T) {
+	naming, err := newNamingStrategy(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, &PhoneticNaming{MaxWords: 2}, naming)
+
+	naming, err = newNamingStrategy(&StackNamingConfig{Strategy: "Numeric", Digits: 4})
+	assert.NoError(t, err)
+	assert.Equal(t, &NumericNaming{Digits: 4}, naming)
+
+	_, err = newNamingStrategy(&StackNamingConfig{Strategy: "random"})
+	assert.Error(t, err)
+	_, err = newNamingStrategy(&StackNamingConfig{Digits: -1})
+	assert.Error(t, err)
+}
+
+func TestPhoneticNaming(t *testing.T) {
+	naming := &PhoneticNaming{MaxWords: 2}
+	assert.Equal(t, 26+26*26, naming.Capacity())
+	assert.Equal(t, 17, naming.MaxSuffixLength())
+	assert.True(t, naming.Sequential())
+
+	next, err := naming.NextSuffix(nil, StackNamingRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, "ALPHA", next)
+
+	// the highest phonetic suffix is continued, other suffixes are ignored
+	next, err = naming.NextSuffix([]string{"ZULU", "ALPHA-BRAVO", "007"}, StackNamingRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, "ALPHA-CHARLIE", next)
+
+	_, err = naming.NextSuffix([]string{"ZULU-ZULU"}, StackNamingRequest{})
+	assert.ErrorIs(t, err, ErrStackCapacityReached)
+}
+
+func TestNumericNaming(t *testing.T) {
+	naming := &NumericNaming{Digits: 2}
+	assert.Equal(t, 99, naming.Capacity())
+	assert.True(t, naming.Sequential())
+
+	// the lowest free number is reused
+	next, err := naming.NextSuffix([]string{"01", "03", "ALPHA"}, StackNamingRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, "02", next)
+
+	suffixes := []string{"10", "ALPHA", "02"}
+	sort.Slice(suffixes, func(i, j int) bool { return naming.Less(suffixes[i], suffixes[j]) })
+	assert.Equal(t, []string{"02", "10", "ALPHA"}, suffixes)
+
+	naming.Digits = 1
+	_, err = naming.NextSuffix([]string{"1", "2", "3", "4", "5", "6", "7", "8", "9"}, StackNamingRequest{})
+	assert.ErrorIs(t, err, ErrStackCapacityReached)
+}
+
+func TestTeamNaming(t *testing.T) {
+	naming := &TeamNaming{Digits: 2}
+	red := StackNamingRequest{TeamID: "red-team"}
+	assert.False(t, naming.Sequential())
+
+	next, err := naming.NextSuffix([]string{"RED-TEAM-01", "BLUE-01"}, red)
+	assert.NoError(t, err)
+	assert.Equal(t, "RED-TEAM-02", next)
+
+	next, err = naming.NextSuffix(nil, StackNamingRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, "SHARED-01", next)
+
+	assert.True(t, naming.Eligible("RED-TEAM-01", red))
+	assert.True(t, naming.Eligible("RED-TEAM-01", StackNamingRequest{TeamID: "Red-Team"}))
+	assert.False(t, naming.Eligible("BLUE-01", red))
+	assert.False(t, naming.Eligible("RED-TEAM-BLUE-01", red))
+}
+
+func TestTeamSuffixPrefix(t *testing.T) {
+	assert.Equal(t, "RED-TEAM-", teamSuffixPrefix("red-team"))
+	assert.Equal(t, "SHARED-", teamSuffixPrefix(""))
+
+	// IDs that had to be changed cannot collide with the ID they were changed into
+	spaced := teamSuffixPrefix("red team")
+	assert.Regexp(t, `^RED-TEAM-[0-9A-F]{6}-$`, spaced)
+	assert.NotEqual(t, spaced, teamSuffixPrefix("red_team"))
+	assert.NotEqual(t, "SHARED-", teamSuffixPrefix("shared"))
+	assert.Regexp(t, `^[0-9A-F]{6}-$`, teamSuffixPrefix("!!!"))
+
+	naming := &TeamNaming{Digits: 2}
+	assert.False(t, naming.Eligible(teamSuffixPrefix("red-team")+"01", StackNamingRequest{TeamID: "red team"}))
+	assert.False(t, naming.Eligible(spaced+"01", StackNamingRequest{TeamID: "red-team"}))
+}
+
+func TestHashNaming(t *testing.T) {
+	naming := &HashNaming{Length: 8}
+	request := StackNamingRequest{UserID: "user@example.com", TeamID: "red"}
+	assert.False(t, naming.Sequential())
+
+	first, err := naming.NextSuffix(nil, request)
+	assert.NoError(t, err)
+	assert.Len(t, first, 8)
+
+	// collisions are skipped
+	second, err := naming.NextSuffix([]string{first}, request)
+	assert.NoError(t, err)
+	assert.NotEqual(t, first, second)
+
+	// VMs are placed in the stacks of their team only
+	assert.True(t, naming.Eligible(first, StackNamingRequest{UserID: "other@example.com", TeamID: "Red"}))
+	assert.True(t, naming.Eligible(second, request))
+	assert.False(t, naming.Eligible(first, StackNamingRequest{TeamID: "blue"}))
+	assert.False(t, naming.Eligible(first, StackNamingRequest{}))
+}
+
+func TestStackNaming(t *testing.T) {
+	avd := &AzureVirtualDesktopManager{
+		Config: &AzureVirtualDesktopManagerConfig{
+			PersonalHostPoolNamePrefix:  "DEV-HP-Personal-",
+			PersonalWorkspaceNamePrefix: "DEV-WS-Personal-",
+			PersonalAppGroupNamePrefix:  "DEV-AG-Personal-",
+		},
+		Naming: &TeamNaming{Digits: 2},
+	}
+	assert.NoError(t, avd.validateStackNaming())
+
+	hostPools := []*armdesktopvirtualization.HostPool{
+		{Name: to.Ptr("DEV-HP-Personal-BLUE-02")},
+		{Name: to.Ptr("unrelated")},
+		{Name: to.Ptr("DEV-HP-Personal-BLUE-01")},
+	}
+	avd.sortHostPoolsBySuffix(hostPools)
+	assert.Equal(t, "DEV-HP-Personal-BLUE-01", *hostPools[0].Name)
+	assert.Equal(t, "unrelated", *hostPools[2].Name)
+
+	next, err := avd.nextStackSuffix(hostPools, StackNamingRequest{TeamID: "blue"})
+	assert.NoError(t, err)
+	assert.Equal(t, "BLUE-03", next)
+
+	// names longer than AVD allows are refused
+	_, err = avd.nextStackSuffix(nil, StackNamingRequest{TeamID: "a-team-name-that-is-far-too-long-for-avd-names"})
+	assert.Error(t, err)
+
+	avd.Naming = &HashNaming{Length: 64}
+	assert.Error(t, avd.validateStackNaming())
+}
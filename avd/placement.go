@@ -0,0 +1,362 @@
+package avd
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/desktopvirtualization/armdesktopvirtualization/v2"
+	"github.com/appliedres/cloudy/logging"
+)
+
+// stackTeamTagKey tags a personal stack with the team of the VM it was created for
+const stackTeamTagKey = "stack_team"
+
+const defaultConsolidateBelow = 1
+
+// StackUsage is the occupancy of a personal AVD stack
+type StackUsage struct {
+	HostPoolName string
+	Suffix       string
+	TeamID       string   // team the stack was created for, empty for stacks created without a team
+	Users        []string // users assigned to a session host, and users registering a session host
+	SessionHosts int
+}
+
+// PlacementPolicy picks the personal stack a user's VM is registered in
+type PlacementPolicy interface {
+	// Rank returns the stacks the VM can be placed in, most preferred first.
+	// The stacks are passed in the order of the naming strategy, without stacks that are full or already hold the user.
+	Rank(stacks []StackUsage, request StackNamingRequest) []StackUsage
+
+	// NeedsUsage returns whether Rank orders by the users of the stacks, so they are loaded for every stack before
+	// ranking. Otherwise only the stack a VM is placed in is checked, see claimStack.
+	NeedsUsage() bool
+
+	// Accepts returns whether a user in stack from can be moved to stack to when consolidating the stacks.
+	// The team of a user is only known through the stack it was placed in.
+	Accepts(user string, from, to StackUsage) bool
+}
+
+// FillFirstPlacement fills the stacks in the order of the naming strategy, keeping the number of stacks low.
+// It does not need the users of the stacks, so they are not loaded for it.
+type FillFirstPlacement struct{}
+
+func (p *FillFirstPlacement) Rank(stacks []StackUsage, _ StackNamingRequest) []StackUsage {
+	return slices.Clone(stacks)
+}
+
+func (p *FillFirstPlacement) NeedsUsage() bool { return false }
+
+func (p *FillFirstPlacement) Accepts(string, StackUsage, StackUsage) bool { return true }
+
+// SpreadPlacement fills the emptiest stack first, spreading users over the existing stacks
+type SpreadPlacement struct{}
+
+func (p *SpreadPlacement) Rank(stacks []StackUsage, _ StackNamingRequest) []StackUsage {
+	ranked := slices.Clone(stacks)
+	sort.SliceStable(ranked, func(i, j int) bool { return len(ranked[i].Users) < len(ranked[j].Users) })
+	return ranked
+}
+
+func (p *SpreadPlacement) NeedsUsage() bool { return true }
+
+func (p *SpreadPlacement) Accepts(string, StackUsage, StackUsage) bool { return true }
+
+// TeamAffinityPlacement fills the stacks of the user's team first, then stacks without a team, then those of other teams,
+// each in the order of the naming strategy. With Strict, stacks of other teams are never used.
+type TeamAffinityPlacement struct {
+	Strict bool
+}
+
+func (p *TeamAffinityPlacement) Rank(stacks []StackUsage, request StackNamingRequest) []StackUsage {
+	affinity := func(stack StackUsage) int {
+		switch {
+		case stack.TeamID != "" && strings.EqualFold(stack.TeamID, request.TeamID):
+			return 0
+		case stack.TeamID == "":
+			return 1
+		default:
+			return 2
+		}
+	}
+
+	ranked := (&FillFirstPlacement{}).Rank(stacks, request)
+	sort.SliceStable(ranked, func(i, j int) bool { return affinity(ranked[i]) < affinity(ranked[j]) })
+	if p.Strict {
+		ranked = slices.DeleteFunc(ranked, func(stack StackUsage) bool { return affinity(stack) == 2 })
+	}
+	return ranked
+}
+
+// NeedsUsage is false, the stacks are ranked by their team only
+func (p *TeamAffinityPlacement) NeedsUsage() bool { return false }
+
+// Accepts only moves users between stacks of the same team with Strict
+func (p *TeamAffinityPlacement) Accepts(_ string, from, to StackUsage) bool {
+	return !p.Strict || strings.EqualFold(from.TeamID, to.TeamID)
+}
+
+// newPlacementPolicy returns the placement policy of a config, fill-first when nil
+func newPlacementPolicy(config *StackPlacementConfig) (PlacementPolicy, error) {
+	if config == nil {
+		config = &StackPlacementConfig{}
+	}
+	if config.MaxUsersPerStack < 0 || config.ConsolidateBelow < 0 {
+		return nil, fmt.Errorf("placement limits cannot be negative")
+	}
+
+	switch strings.ToLower(config.Policy) {
+	case "", "fill-first":
+		return &FillFirstPlacement{}, nil
+	case "spread":
+		return &SpreadPlacement{}, nil
+	case "team-affinity":
+		return &TeamAffinityPlacement{Strict: config.StrictTeams}, nil
+	default:
+		return nil, fmt.Errorf("unknown placement policy %q, expected fill-first, spread or team-affinity", config.Policy)
+	}
+}
+
+// placementCandidates returns the stacks a user can be placed in, in the order of the placement policy.
+// Stacks the naming strategy excludes, that already hold the user, or that reached MaxUsersPerStack are left out.
+func (avd *AzureVirtualDesktopManager) placementCandidates(stacks []StackUsage, request StackNamingRequest) []StackUsage {
+	maxUsers := 0
+	if avd.Config.StackPlacement != nil {
+		maxUsers = avd.Config.StackPlacement.MaxUsersPerStack
+	}
+
+	candidates := slices.DeleteFunc(slices.Clone(stacks), func(stack StackUsage) bool {
+		if !avd.Naming.Eligible(stack.Suffix, request) {
+			return true
+		}
+		if slices.ContainsFunc(stack.Users, func(user string) bool { return strings.EqualFold(user, request.UserID) }) {
+			return true
+		}
+		return maxUsers > 0 && len(stack.Users) >= maxUsers
+	})
+	return avd.Placement.Rank(candidates, request)
+}
+
+// listStackUsage returns the occupancy of the personal stacks, in the order of the naming strategy.
+// Host pools without a valid suffix are left out.
+func (avd *AzureVirtualDesktopManager) listStackUsage(ctx context.Context, hostPools []*armdesktopvirtualization.HostPool) ([]StackUsage, error) {
+	stacks := avd.listStacks(hostPools)
+	err := avd.loadStackUsage(ctx, stacks)
+	if err != nil {
+		return nil, err
+	}
+	return stacks, nil
+}
+
+// listStacks returns the personal stacks in the order of the naming strategy, without their users.
+// Host pools without a valid suffix are left out.
+func (avd *AzureVirtualDesktopManager) listStacks(hostPools []*armdesktopvirtualization.HostPool) []StackUsage {
+	avd.sortHostPoolsBySuffix(hostPools)
+
+	var stacks []StackUsage
+	for _, hostPool := range hostPools {
+		if hostPool.Name == nil {
+			continue
+		}
+		suffix, err := avd.extractSuffixFromHostPoolName(*hostPool.Name)
+		if err != nil || suffix == "" {
+			continue
+		}
+
+		stack := StackUsage{HostPoolName: *hostPool.Name, Suffix: suffix}
+		if team, ok := hostPool.Tags[stackTeamTagKey]; ok && team != nil {
+			stack.TeamID = *team
+		}
+		stacks = append(stacks, stack)
+	}
+	return stacks
+}
+
+// loadStackUsage lists the session hosts of every stack, filling in their users
+func (avd *AzureVirtualDesktopManager) loadStackUsage(ctx context.Context, stacks []StackUsage) error {
+	for i := range stacks {
+		users, sessionHosts, err := avd.stackUsers(ctx, stacks[i].HostPoolName)
+		if err != nil {
+			return err
+		}
+		stacks[i].Users, stacks[i].SessionHosts = users, sessionHosts
+	}
+	return nil
+}
+
+// stackUsers returns the users of a stack, and its number of session hosts
+func (avd *AzureVirtualDesktopManager) stackUsers(ctx context.Context, hostPoolName string) ([]string, int, error) {
+	hosts, err := avd.ListSessionHosts(ctx, hostPoolName)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list session hosts of host pool %s: %w", hostPoolName, err)
+	}
+
+	var users []string
+	for _, host := range hosts {
+		if host.Properties != nil && host.Properties.AssignedUser != nil {
+			users = appendUser(users, *host.Properties.AssignedUser)
+		}
+	}
+	for _, user := range avd.registeringUsers(hostPoolName) {
+		users = appendUser(users, user)
+	}
+	return users, len(hosts), nil
+}
+
+// claimStack takes the host pool lock of a user registering in a stack, if the user is not in the stack yet and it has room.
+// The users are counted again under a lock of the host pool, so concurrent registrations cannot overfill the stack.
+func (avd *AzureVirtualDesktopManager) claimStack(ctx context.Context, hostPoolName, userID string) (bool, error) {
+	lock, _ := avd.hostPoolLocks.LoadOrStore(hostPoolName, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	users, _, err := avd.stackUsers(ctx, hostPoolName)
+	if err != nil {
+		return false, err
+	}
+	if slices.ContainsFunc(users, func(user string) bool { return strings.EqualFold(user, userID) }) {
+		return false, nil
+	}
+	if config := avd.Config.StackPlacement; config != nil && config.MaxUsersPerStack > 0 && len(users) >= config.MaxUsersPerStack {
+		return false, nil
+	}
+
+	return avd.acquireHostPoolLockForUser(ctx, userID, hostPoolName), nil
+}
+
+// registeringUsers returns the users holding a host pool lock, their session hosts are not assigned yet
+func (avd *AzureVirtualDesktopManager) registeringUsers(hostPoolName string) []string {
+	var users []string
+	avd.lockMap.Range(func(key, _ any) bool {
+		if user, ok := strings.CutSuffix(key.(string), "-"+hostPoolName); ok {
+			users = append(users, user)
+		}
+		return true
+	})
+	return users
+}
+
+func hostPoolByName(hostPools []*armdesktopvirtualization.HostPool, name string) *armdesktopvirtualization.HostPool {
+	for _, hostPool := range hostPools {
+		if hostPool.Name != nil && *hostPool.Name == name {
+			return hostPool
+		}
+	}
+	return nil
+}
+
+func appendUser(users []string, user string) []string {
+	if slices.ContainsFunc(users, func(u string) bool { return strings.EqualFold(u, user) }) {
+		return users
+	}
+	return append(users, user)
+}
+
+// StackMove is a user that could be moved to another stack to consolidate the stacks
+type StackMove struct {
+	UserID string
+	From   string // host pool name
+	To     string // host pool name
+}
+
+// StackRebalanceReport shows the occupancy of the personal stacks, and how near-empty stacks could be consolidated
+type StackRebalanceReport struct {
+	GeneratedAt      time.Time
+	MaxUsersPerStack int // 0 when unlimited
+
+	Stacks    []StackUsage
+	NearEmpty []string    // host pools with ConsolidateBelow users or fewer
+	Removable []string    // near-empty host pools whose users all fit in other stacks
+	Moves     []StackMove // moves emptying the removable host pools
+}
+
+// GetStackRebalanceReport reports the occupancy of the personal stacks, and which near-empty stacks could be consolidated.
+// Nothing is moved, moving a user means recreating their VM in the target stack.
+func (avd *AzureVirtualDesktopManager) GetStackRebalanceReport(ctx context.Context) (*StackRebalanceReport, error) {
+	log := logging.GetLogger(ctx)
+
+	hostPools, err := avd.listHostPools(ctx, &avd.Config.PersonalHostPoolNamePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list host pools: %w", err)
+	}
+
+	stacks, err := avd.listStackUsage(ctx, hostPools)
+	if err != nil {
+		return nil, err
+	}
+
+	config := avd.Config.StackPlacement
+	if config == nil {
+		config = &StackPlacementConfig{}
+	}
+	report := planConsolidation(stacks, config.MaxUsersPerStack, defaultInt(config.ConsolidateBelow, defaultConsolidateBelow),
+		func(user string, from, to StackUsage) bool {
+			if !avd.Placement.Accepts(user, from, to) {
+				return false
+			}
+			return avd.Naming.Eligible(to.Suffix, StackNamingRequest{UserID: user, TeamID: from.TeamID})
+		})
+	report.GeneratedAt = time.Now()
+
+	log.DebugContext(ctx, "Generated stack rebalance report", "stacks", len(report.Stacks),
+		"nearEmpty", len(report.NearEmpty), "removable", len(report.Removable))
+	return report, nil
+}
+
+// planConsolidation finds the near-empty stacks whose users all fit in other stacks, emptiest first.
+// Users only move into stacks that are not near-empty, that have room, and that accepts returns true for.
+func planConsolidation(stacks []StackUsage, maxUsers, consolidateBelow int, accepts func(user string, from, to StackUsage) bool) *StackRebalanceReport {
+	report := &StackRebalanceReport{MaxUsersPerStack: maxUsers, Stacks: stacks}
+
+	var sources []StackUsage
+	members := map[string][]string{}
+	for _, stack := range stacks {
+		members[stack.HostPoolName] = stack.Users
+		if len(stack.Users) <= consolidateBelow {
+			report.NearEmpty = append(report.NearEmpty, stack.HostPoolName)
+			sources = append(sources, stack)
+		}
+	}
+	sort.SliceStable(sources, func(i, j int) bool { return len(sources[i].Users) < len(sources[j].Users) })
+
+	for _, source := range sources {
+		var moves []StackMove
+		planned := map[string][]string{}
+		for _, user := range source.Users {
+			for _, target := range stacks {
+				if slices.Contains(report.NearEmpty, target.HostPoolName) {
+					continue
+				}
+				targetUsers := append(slices.Clone(members[target.HostPoolName]), planned[target.HostPoolName]...)
+				if maxUsers > 0 && len(targetUsers) >= maxUsers {
+					continue
+				}
+				if slices.ContainsFunc(targetUsers, func(u string) bool { return strings.EqualFold(u, user) }) {
+					continue
+				}
+				if !accepts(user, source, target) {
+					continue
+				}
+				moves = append(moves, StackMove{UserID: user, From: source.HostPoolName, To: target.HostPoolName})
+				planned[target.HostPoolName] = append(planned[target.HostPoolName], user)
+				break
+			}
+		}
+
+		// a stack is only worth consolidating when all of its users can move
+		if len(moves) != len(source.Users) {
+			continue
+		}
+		for target, users := range planned {
+			members[target] = append(slices.Clone(members[target]), users...)
+		}
+		report.Removable = append(report.Removable, source.HostPoolName)
+		report.Moves = append(report.Moves, moves...)
+	}
+	return report
+}
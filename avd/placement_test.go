@@ -0,0 +1,120 @@
+package avd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testStacks() []StackUsage {
+	return []StackUsage{
+		{HostPoolName: "HP-ALPHA", Suffix: "ALPHA", Users: []string{"a", "b"}},
+		{HostPoolName: "HP-BRAVO", Suffix: "BRAVO", TeamID: "red", Users: []string{"c", "d", "e"}},
+		{HostPoolName: "HP-CHARLIE", Suffix: "CHARLIE", TeamID: "blue", Users: []string{"f"}},
+		{HostPoolName: "HP-DELTA", Suffix: "DELTA"},
+	}
+}
+
+func hostPoolNames(stacks []StackUsage) []string {
+	var names []string
+	for _, stack := range stacks {
+		names = append(names, stack.HostPoolName)
+	}
+	return names
+}
+
+func TestPlacementPolicies(t *testing.T) {
+	request := StackNamingRequest{UserID: "x", TeamID: "blue"}
+
+	assert.Equal(t, []string{"HP-ALPHA", "HP-BRAVO", "HP-CHARLIE", "HP-DELTA"},
+		hostPoolNames((&FillFirstPlacement{}).Rank(testStacks(), request)))
+	assert.Equal(t, []string{"HP-DELTA", "HP-CHARLIE", "HP-ALPHA", "HP-BRAVO"},
+		hostPoolNames((&SpreadPlacement{}).Rank(testStacks(), request)))
+	assert.Equal(t, []string{"HP-CHARLIE", "HP-ALPHA", "HP-DELTA", "HP-BRAVO"},
+		hostPoolNames((&TeamAffinityPlacement{}).Rank(testStacks(), request)))
+	assert.Equal(t, []string{"HP-CHARLIE", "HP-ALPHA", "HP-DELTA"},
+		hostPoolNames((&TeamAffinityPlacement{Strict: true}).Rank(testStacks(), request)))
+}
+
+func TestNewPlacementPolicy(t *testing.T) {
+	policy, err := newPlacementPolicy(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, &FillFirstPlacement{}, policy)
+
+	policy, err = newPlacementPolicy(&StackPlacementConfig{Policy: "Team-Affinity", StrictTeams: true})
+	assert.NoError(t, err)
+	assert.Equal(t, &TeamAffinityPlacement{Strict: true}, policy)
+
+	_, err = newPlacementPolicy(&StackPlacementConfig{Policy: "random"})
+	assert.Error(t, err)
+	_, err = newPlacementPolicy(&StackPlacementConfig{MaxUsersPerStack: -1})
+	assert.Error(t, err)
+}
+
+func TestPlacementCandidates(t *testing.T) {
+	avd := &AzureVirtualDesktopManager{
+		Config:    &AzureVirtualDesktopManagerConfig{StackPlacement: &StackPlacementConfig{MaxUsersPerStack: 3}},
+		Naming:    &PhoneticNaming{MaxWords: 2},
+		Placement: &FillFirstPlacement{},
+	}
+
+	// full stacks and stacks already holding the user are left out
+	assert.Equal(t, []string{"HP-CHARLIE", "HP-DELTA"},
+		hostPoolNames(avd.placementCandidates(testStacks(), StackNamingRequest{UserID: "A"})))
+}
+
+func TestPlacementNeedsUsage(t *testing.T) {
+	assert.False(t, (&FillFirstPlacement{}).NeedsUsage())
+	assert.False(t, (&TeamAffinityPlacement{Strict: true}).NeedsUsage())
+	assert.True(t, (&SpreadPlacement{}).NeedsUsage())
+}
+
+func TestPlacementAccepts(t *testing.T) {
+	stacks := testStacks()
+	unteamed, red, blue := stacks[0], stacks[1], stacks[2]
+
+	assert.True(t, (&FillFirstPlacement{}).Accepts("f", blue, red))
+	assert.True(t, (&SpreadPlacement{}).Accepts("f", blue, red))
+	assert.True(t, (&TeamAffinityPlacement{}).Accepts("f", blue, red))
+
+	strict := &TeamAffinityPlacement{Strict: true}
+	assert.False(t, strict.Accepts("f", blue, red))
+	assert.False(t, strict.Accepts("a", unteamed, red))
+	assert.True(t, strict.Accepts("a", unteamed, stacks[3]))
+}
+
+func TestRegisteringUsers(t *testing.T) {
+	avd := &AzureVirtualDesktopManager{}
+	avd.lockMap.Store("user-1-HP-ALPHA", struct{}{})
+	avd.lockMap.Store("user-2-HP-BRAVO", struct{}{})
+
+	assert.Equal(t, []string{"user-1"}, avd.registeringUsers("HP-ALPHA"))
+}
+
+func TestPlanConsolidation(t *testing.T) {
+	anywhere := func(string, StackUsage, StackUsage) bool { return true }
+
+	report := planConsolidation(testStacks(), 0, 1, anywhere)
+	assert.Equal(t, []string{"HP-CHARLIE", "HP-DELTA"}, report.NearEmpty)
+	assert.Equal(t, []string{"HP-DELTA", "HP-CHARLIE"}, report.Removable)
+	assert.Equal(t, []StackMove{{UserID: "f", From: "HP-CHARLIE", To: "HP-ALPHA"}}, report.Moves)
+
+	// full stacks take no more users
+	report = planConsolidation(testStacks(), 2, 1, anywhere)
+	assert.Equal(t, []string{"HP-DELTA"}, report.Removable)
+	assert.Empty(t, report.Moves)
+
+	// the same user is not moved into a stack twice
+	stacks := []StackUsage{
+		{HostPoolName: "HP-ALPHA", Users: []string{"a", "b"}},
+		{HostPoolName: "HP-BRAVO", Users: []string{"c"}},
+		{HostPoolName: "HP-CHARLIE", Users: []string{"c"}},
+	}
+	report = planConsolidation(stacks, 0, 1, anywhere)
+	assert.Equal(t, []string{"HP-BRAVO"}, report.Removable)
+
+	// moves can be refused, e.g. across teams
+	sameTeam := func(_ string, from, to StackUsage) bool { return from.TeamID == to.TeamID }
+	report = planConsolidation(testStacks(), 0, 1, sameTeam)
+	assert.Equal(t, []string{"HP-DELTA"}, report.Removable)
+}
@@ -0,0 +1,229 @@
+package avd
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/authorization/armauthorization/v2"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/desktopvirtualization/armdesktopvirtualization/v2"
+	"github.com/appliedres/cloudy/logging"
+
+	cloudyazure "github.com/appliedres/cloudy-azure"
+)
+
+// PooledDesktopStack holds the AVD resources publishing the pooled Windows desktop
+type PooledDesktopStack struct {
+	HostPool  *armdesktopvirtualization.HostPool
+	AppGroup  *armdesktopvirtualization.ApplicationGroup
+	Workspace *armdesktopvirtualization.Workspace
+}
+
+// PooledDesktopHostPoolName returns the name of the pooled desktop host pool of this manager
+func (avd *AzureVirtualDesktopManager) PooledDesktopHostPoolName() string {
+	return avd.Config.PooledDesktopHostPoolNamePrefix + avd.Name
+}
+
+// EnsurePooledDesktopStack makes sure the pooled desktop host pool, its desktop application group and workspace exist,
+// and that the configured user groups are assigned to the desktop. Existing host pools are updated to match the config.
+// Session hosts are not managed here, the orchestrator scales them with the pool's sessions.
+func (avd *AzureVirtualDesktopManager) EnsurePooledDesktopStack(ctx context.Context) (*PooledDesktopStack, error) {
+	config := avd.Config.PooledDesktop
+	if config == nil {
+		return nil, fmt.Errorf("pooled desktop is not configured")
+	}
+
+	log := logging.GetLogger(ctx)
+	log.InfoContext(ctx, "Ensuring pooled desktop stack exists", "HostPoolName", avd.PooledDesktopHostPoolName())
+
+	tags := map[string]*string{
+		"suffix":             to.Ptr(avd.Name),
+		"arkloud_created_by": to.Ptr("cloudy-azure"),
+	}
+
+	hostPool, err := avd.ensurePooledDesktopHostPool(ctx, config, tags)
+	if err != nil {
+		return nil, fmt.Errorf("failed to ensure pooled desktop host pool: %w", err)
+	}
+
+	appGroupName := avd.Config.PooledDesktopAppGroupNamePrefix + avd.Name
+	appGroup, err := avd.GetAppGroupByName(ctx, appGroupName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup pooled desktop application group: %w", err)
+	}
+	if appGroup == nil {
+		log.InfoContext(ctx, "Creating pooled desktop application group", "AppGroupName", appGroupName)
+		appGroup, err = avd.CreateApplicationGroup(ctx, appGroupName, *hostPool.Name, tags, armdesktopvirtualization.ApplicationGroupTypeDesktop)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create pooled desktop application group: %w", err)
+		}
+	}
+
+	workspace, err := avd.ensurePooledDesktopWorkspace(ctx, appGroupName, tags)
+	if err != nil {
+		return nil, fmt.Errorf("failed to ensure pooled desktop workspace: %w", err)
+	}
+
+	assignments, err := avd.ListAppGroupAssignments(ctx, appGroupName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pooled desktop assignments: %w", err)
+	}
+	for _, groupID := range unassignedPrincipals(assignments, pooledDesktopUserGroups(config, avd.Config.AvdUsersGroupId)) {
+		log.InfoContext(ctx, "Assigning user group to pooled desktop", "AppGroupName", appGroupName, "GroupID", groupID)
+		err = avd.AssignPrincipalToAppGroup(ctx, appGroupName, groupID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to assign group %s to pooled desktop: %w", groupID, err)
+		}
+	}
+
+	log.InfoContext(ctx, "Pooled desktop stack ready", "HostPoolName", *hostPool.Name, "AppGroupName", appGroupName)
+	return &PooledDesktopStack{
+		HostPool:  hostPool,
+		AppGroup:  appGroup,
+		Workspace: workspace,
+	}, nil
+}
+
+// ensurePooledDesktopHostPool creates the pooled desktop host pool, or updates an existing one that differs from the config
+func (avd *AzureVirtualDesktopManager) ensurePooledDesktopHostPool(ctx context.Context, config *PooledDesktopConfig, tags map[string]*string) (*armdesktopvirtualization.HostPool, error) {
+	log := logging.GetLogger(ctx)
+	hostPoolName := avd.PooledDesktopHostPoolName()
+	desired := pooledDesktopHostPoolProperties(config)
+
+	resp, err := avd.hostPoolsClient.Get(ctx, avd.Credentials.ResourceGroup, hostPoolName, nil)
+	if cloudyazure.Is404(err) {
+		log.InfoContext(ctx, "Creating pooled desktop host pool", "HostPoolName", hostPoolName)
+
+		desired.FriendlyName = to.Ptr("Pooled desktops for '" + avd.Name + "'")
+		desired.Description = to.Ptr("Pooled Host Pool for Windows desktops. Managed by cloudy-azure")
+		desired.RegistrationInfo = avd.newRegistrationInfo()
+
+		created, err := avd.hostPoolsClient.CreateOrUpdate(ctx, avd.Credentials.ResourceGroup, hostPoolName, armdesktopvirtualization.HostPool{
+			Location:   to.Ptr(string(avd.Credentials.Region)),
+			Tags:       tags,
+			Properties: desired,
+		}, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create pooled desktop host pool: %w", err)
+		}
+		return &created.HostPool, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get host pool %s: %w", hostPoolName, err)
+	}
+
+	hostPool := &resp.HostPool
+	if hostPool.Properties == nil || hostPool.Properties.HostPoolType == nil ||
+		*hostPool.Properties.HostPoolType != armdesktopvirtualization.HostPoolTypePooled {
+		return nil, fmt.Errorf("existing host pool %q is not a pooled host pool", hostPoolName)
+	}
+
+	patch := hostPoolPatch(desired, hostPool.Properties)
+	if patch == nil {
+		log.InfoContext(ctx, "Verified existing pooled desktop host pool", "HostPoolName", hostPoolName)
+		return hostPool, nil
+	}
+
+	log.InfoContext(ctx, "Updating pooled desktop host pool properties", "HostPoolName", hostPoolName)
+	updated, err := avd.hostPoolsClient.Update(ctx, avd.Credentials.ResourceGroup, hostPoolName, &armdesktopvirtualization.HostPoolsClientUpdateOptions{
+		HostPool: &armdesktopvirtualization.HostPoolPatch{Properties: patch},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update pooled desktop host pool: %w", err)
+	}
+	return &updated.HostPool, nil
+}
+
+// ensurePooledDesktopWorkspace creates the pooled desktop workspace, and links an application group to it if not linked yet
+func (avd *AzureVirtualDesktopManager) ensurePooledDesktopWorkspace(ctx context.Context, appGroupName string, tags map[string]*string) (*armdesktopvirtualization.Workspace, error) {
+	workspaceName := avd.Config.PooledDesktopWorkspaceNamePrefix + avd.Name
+	appGroupPath := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.DesktopVirtualization/applicationgroups/%s",
+		avd.Credentials.SubscriptionID, avd.Credentials.ResourceGroup, appGroupName)
+
+	workspace, err := avd.GetWorkspaceByName(ctx, workspaceName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup workspace: %w", err)
+	}
+
+	if workspace == nil {
+		workspace = &armdesktopvirtualization.Workspace{
+			Location: to.Ptr(string(avd.Credentials.Region)),
+			Tags:     tags,
+			Properties: &armdesktopvirtualization.WorkspaceProperties{
+				FriendlyName: to.Ptr("Desktops for '" + avd.Name + "'"),
+				Description:  to.Ptr("Generated via cloudy-azure"),
+			},
+		}
+	} else if workspace.Properties != nil && slices.ContainsFunc(workspace.Properties.ApplicationGroupReferences, func(ref *string) bool {
+		return ref != nil && strings.EqualFold(*ref, appGroupPath)
+	}) {
+		return workspace, nil
+	}
+
+	if workspace.Properties == nil {
+		workspace.Properties = &armdesktopvirtualization.WorkspaceProperties{}
+	}
+	workspace.Properties.ApplicationGroupReferences = append(workspace.Properties.ApplicationGroupReferences, &appGroupPath)
+
+	resp, err := avd.workspacesClient.CreateOrUpdate(ctx, avd.Credentials.ResourceGroup, workspaceName, *workspace, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create or update workspace: %w", err)
+	}
+	return &resp.Workspace, nil
+}
+
+// validatePooledDesktopConfig checks the pooled desktop host pool properties
+func validatePooledDesktopConfig(config *PooledDesktopConfig) error {
+	if config == nil {
+		return nil
+	}
+
+	err := validateHostPoolConfig(&config.HostPool, armdesktopvirtualization.HostPoolTypePooled)
+	if err != nil {
+		return err
+	}
+
+	preferred := armdesktopvirtualization.PreferredAppGroupType(config.HostPool.PreferredAppGroupType)
+	if preferred != "" && preferred != armdesktopvirtualization.PreferredAppGroupTypeDesktop {
+		return fmt.Errorf("the pooled desktop host pool must prefer %s app groups", armdesktopvirtualization.PreferredAppGroupTypeDesktop)
+	}
+
+	return nil
+}
+
+// pooledDesktopHostPoolProperties returns the properties of the pooled desktop host pool, load balancing breadth-first by default
+func pooledDesktopHostPoolProperties(config *PooledDesktopConfig) *armdesktopvirtualization.HostPoolProperties {
+	properties := hostPoolProperties(&config.HostPool, armdesktopvirtualization.HostPoolTypePooled)
+	if properties.LoadBalancerType == nil {
+		properties.LoadBalancerType = to.Ptr(armdesktopvirtualization.LoadBalancerTypeBreadthFirst)
+	}
+	return properties
+}
+
+// pooledDesktopUserGroups returns the groups assigned to the pooled desktop
+func pooledDesktopUserGroups(config *PooledDesktopConfig, defaultGroupID string) []string {
+	if len(config.UserGroupIDs) > 0 {
+		return config.UserGroupIDs
+	}
+	if defaultGroupID == "" {
+		return nil
+	}
+	return []string{defaultGroupID}
+}
+
+// unassignedPrincipals returns the principals that have no role assignment yet
+func unassignedPrincipals(assignments []*armauthorization.RoleAssignment, principalIDs []string) []string {
+	var missing []string
+	for _, principalID := range principalIDs {
+		assigned := slices.ContainsFunc(assignments, func(assignment *armauthorization.RoleAssignment) bool {
+			return assignment != nil && assignment.Properties != nil && assignment.Properties.PrincipalID != nil &&
+				*assignment.Properties.PrincipalID == principalID
+		})
+		if !assigned && !slices.Contains(missing, principalID) {
+			missing = append(missing, principalID)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,45 @@
+package avd
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/authorization/armauthorization/v2"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/desktopvirtualization/armdesktopvirtualization/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidatePooledDesktopConfig(t *testing.T) {
+	assert.NoError(t, validatePooledDesktopConfig(nil))
+	assert.NoError(t, validatePooledDesktopConfig(&PooledDesktopConfig{HostPool: HostPoolConfig{LoadBalancerType: "DepthFirst", MaxSessionLimit: 8}}))
+	assert.NoError(t, validatePooledDesktopConfig(&PooledDesktopConfig{HostPool: HostPoolConfig{PreferredAppGroupType: "Desktop"}}))
+
+	assert.Error(t, validatePooledDesktopConfig(&PooledDesktopConfig{HostPool: HostPoolConfig{LoadBalancerType: "Persistent"}}))
+	assert.Error(t, validatePooledDesktopConfig(&PooledDesktopConfig{HostPool: HostPoolConfig{AssignmentType: "Automatic"}}))
+	assert.Error(t, validatePooledDesktopConfig(&PooledDesktopConfig{HostPool: HostPoolConfig{PreferredAppGroupType: "RailApplications"}}))
+}
+
+func TestPooledDesktopHostPoolProperties(t *testing.T) {
+	properties := pooledDesktopHostPoolProperties(&PooledDesktopConfig{HostPool: HostPoolConfig{MaxSessionLimit: 8}})
+	assert.Equal(t, armdesktopvirtualization.HostPoolTypePooled, *properties.HostPoolType)
+	assert.Equal(t, armdesktopvirtualization.LoadBalancerTypeBreadthFirst, *properties.LoadBalancerType)
+	assert.Equal(t, armdesktopvirtualization.PreferredAppGroupTypeDesktop, *properties.PreferredAppGroupType)
+	assert.Equal(t, int32(8), *properties.MaxSessionLimit)
+
+	properties = pooledDesktopHostPoolProperties(&PooledDesktopConfig{HostPool: HostPoolConfig{LoadBalancerType: "DepthFirst"}})
+	assert.Equal(t, armdesktopvirtualization.LoadBalancerTypeDepthFirst, *properties.LoadBalancerType)
+}
+
+func TestUnassignedPrincipals(t *testing.T) {
+	assignments := []*armauthorization.RoleAssignment{
+		{Properties: &armauthorization.RoleAssignmentProperties{PrincipalID: to.Ptr("group-a")}},
+		{Properties: &armauthorization.RoleAssignmentProperties{}},
+	}
+
+	assert.Equal(t, []string{"group-b"}, unassignedPrincipals(assignments, []string{"group-a", "group-b", "group-b"}))
+	assert.Nil(t, unassignedPrincipals(assignments, []string{"group-a"}))
+
+	assert.Equal(t, []string{"users"}, pooledDesktopUserGroups(&PooledDesktopConfig{}, "users"))
+	assert.Equal(t, []string{"group-a"}, pooledDesktopUserGroups(&PooledDesktopConfig{UserGroupIDs: []string{"group-a"}}, "users"))
+	assert.Nil(t, pooledDesktopUserGroups(&PooledDesktopConfig{}, ""))
+}
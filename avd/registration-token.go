@@ -0,0 +1,286 @@
+package avd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/desktopvirtualization/armdesktopvirtualization/v2"
+	"github.com/appliedres/cloudy/logging"
+
+	cloudyazure "github.com/appliedres/cloudy-azure"
+)
+
+const (
+	defaultRegistrationTokenLifetime = 25 * 24 * time.Hour
+	defaultRegistrationTokenRefresh  = 24 * time.Hour
+
+	// AVD accepts registration token lifetimes of one hour up to 27 days
+	minRegistrationTokenLifetime = time.Hour
+	maxRegistrationTokenLifetime = 27 * 24 * time.Hour
+)
+
+// RegistrationToken is the token session hosts use to register with a host pool
+type RegistrationToken struct {
+	HostPoolName string
+	Token        string
+	ExpiresAt    time.Time
+	SecretURL    string // Key Vault secret holding the token, empty unless tokens are stored in Key Vault
+}
+
+// registrationTokens holds the registration state of each host pool
+type registrationTokens struct {
+	hostPools sync.Map // map[string]*hostPoolRegistration (host pool name→registration state)
+}
+
+// hostPoolRegistration caches the token of a host pool, and counts the registrations using it
+type hostPoolRegistration struct {
+	mu      sync.Mutex // held while the token is fetched or revoked, registrations with other host pools are not blocked
+	token   *RegistrationToken
+	pending int
+}
+
+func (tokens *registrationTokens) hostPool(hostPoolName string) *hostPoolRegistration {
+	registration, _ := tokens.hostPools.LoadOrStore(hostPoolName, &hostPoolRegistration{})
+	return registration.(*hostPoolRegistration)
+}
+
+// GetRegistrationToken returns the registration token of a host pool. The token is checked against the host pool,
+// so a token revoked or replaced elsewhere is not handed out, and renewed with the configured lifetime when it is
+// about to expire.
+func (avd *AzureVirtualDesktopManager) GetRegistrationToken(ctx context.Context, hostPoolName string) (*RegistrationToken, error) {
+	registration := avd.registrationTokens.hostPool(hostPoolName)
+	registration.mu.Lock()
+	defer registration.mu.Unlock()
+
+	return avd.registrationToken(ctx, registration, hostPoolName)
+}
+
+// RetrieveRegistrationToken returns the registration token of a host pool, see GetRegistrationToken
+func (avd *AzureVirtualDesktopManager) RetrieveRegistrationToken(ctx context.Context, hpName string) (*string, error) {
+	token, err := avd.GetRegistrationToken(ctx, hpName)
+	if err != nil {
+		return nil, err
+	}
+	return to.Ptr(token.Token), nil
+}
+
+// BeginRegistration returns the registration token for a session host about to register with a host pool.
+// Every call must be followed by EndRegistration once the registration completed or failed.
+func (avd *AzureVirtualDesktopManager) BeginRegistration(ctx context.Context, hostPoolName string) (*RegistrationToken, error) {
+	registration := avd.registrationTokens.hostPool(hostPoolName)
+	registration.mu.Lock()
+	defer registration.mu.Unlock()
+
+	token, err := avd.registrationToken(ctx, registration, hostPoolName)
+	if err != nil {
+		return nil, err
+	}
+
+	registration.pending++
+	return token, nil
+}
+
+// EndRegistration marks a registration started with BeginRegistration as done. When RevokeAfterRegistration is
+// configured and no other registration is pending, the host pool's token is revoked. Pending registrations are
+// only counted within this process, see RevokeAfterRegistration.
+func (avd *AzureVirtualDesktopManager) EndRegistration(ctx context.Context, hostPoolName string) {
+	// the lock is held while revoking, so a registration beginning meanwhile waits and gets a new token
+	registration := avd.registrationTokens.hostPool(hostPoolName)
+	registration.mu.Lock()
+	defer registration.mu.Unlock()
+
+	registration.pending = max(registration.pending-1, 0)
+
+	config := avd.Config.RegistrationToken
+	if registration.pending > 0 || config == nil || !config.RevokeAfterRegistration {
+		return
+	}
+
+	if err := avd.revokeRegistrationToken(ctx, registration, hostPoolName); err != nil {
+		logging.GetLogger(ctx).WarnContext(ctx, "Failed to revoke registration token", "HostPool", hostPoolName, "Error", err)
+	}
+}
+
+// RevokeRegistrationToken deletes the registration token of a host pool, so no further session hosts can register with it
+func (avd *AzureVirtualDesktopManager) RevokeRegistrationToken(ctx context.Context, hostPoolName string) error {
+	registration := avd.registrationTokens.hostPool(hostPoolName)
+	registration.mu.Lock()
+	defer registration.mu.Unlock()
+
+	return avd.revokeRegistrationToken(ctx, registration, hostPoolName)
+}
+
+// revokeRegistrationToken deletes the registration token of a host pool. The caller holds the host pool's registration lock.
+func (avd *AzureVirtualDesktopManager) revokeRegistrationToken(ctx context.Context, registration *hostPoolRegistration, hostPoolName string) error {
+	_, err := avd.hostPoolsClient.Update(ctx, avd.Credentials.ResourceGroup, hostPoolName, &armdesktopvirtualization.HostPoolsClientUpdateOptions{
+		HostPool: &armdesktopvirtualization.HostPoolPatch{
+			Properties: &armdesktopvirtualization.HostPoolPatchProperties{
+				RegistrationInfo: &armdesktopvirtualization.RegistrationInfoPatch{
+					RegistrationTokenOperation: to.Ptr(armdesktopvirtualization.RegistrationTokenOperationDelete),
+				},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to revoke registration token of host pool %s: %w", hostPoolName, err)
+	}
+	registration.token = nil
+
+	if avd.keyVault != nil {
+		if err := avd.keyVault.DeleteSecret(ctx, registrationTokenSecretName(hostPoolName)); err != nil {
+			return fmt.Errorf("failed to delete registration token secret of host pool %s: %w", hostPoolName, err)
+		}
+	}
+
+	logging.GetLogger(ctx).InfoContext(ctx, "Revoked registration token", "HostPool", hostPoolName)
+	return nil
+}
+
+// UpdateHostPoolRegToken generates a new registration token for a host pool, valid for the configured lifetime.
+// Use GetRegistrationToken to get a cached token.
+func (avd *AzureVirtualDesktopManager) UpdateHostPoolRegToken(ctx context.Context, hpName string) (*armdesktopvirtualization.HostPool, error) {
+	expirationTime := time.Now().Add(avd.registrationTokenLifetime())
+
+	patch := armdesktopvirtualization.HostPoolPatch{
+		Properties: &armdesktopvirtualization.HostPoolPatchProperties{
+			RegistrationInfo: &armdesktopvirtualization.RegistrationInfoPatch{
+				ExpirationTime:             &expirationTime,
+				RegistrationTokenOperation: to.Ptr(armdesktopvirtualization.RegistrationTokenOperationUpdate),
+			},
+		}}
+
+	resp, err := avd.hostPoolsClient.Update(ctx, avd.Credentials.ResourceGroup, hpName, &armdesktopvirtualization.HostPoolsClientUpdateOptions{
+		HostPool: &patch,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update host pool reg token: %w", err)
+	}
+
+	return &resp.HostPool, nil
+}
+
+// registrationToken returns the current token of a host pool, renewing it when it is about to expire. The token is
+// always retrieved from the host pool, since other instances may have revoked or renewed it, and the cached token
+// is reused while it is still the host pool's token. The caller holds the host pool's registration lock.
+func (avd *AzureVirtualDesktopManager) registrationToken(ctx context.Context, registration *hostPoolRegistration, hostPoolName string) (*RegistrationToken, error) {
+	log := logging.GetLogger(ctx)
+
+	info, err := avd.fetchRegistrationToken(ctx, hostPoolName)
+	if err != nil {
+		return nil, err
+	}
+
+	if cached := registration.token; cached != nil && cached.Token == *info.Token {
+		log.DebugContext(ctx, "Using cached registration token", "HostPool", hostPoolName, "ExpiresAt", cached.ExpiresAt)
+		return cached, nil
+	}
+
+	token := &RegistrationToken{HostPoolName: hostPoolName, Token: *info.Token, ExpiresAt: *info.ExpirationTime}
+	if avd.keyVault != nil {
+		secretName := registrationTokenSecretName(hostPoolName)
+		if err := avd.keyVault.SaveSecret(ctx, secretName, token.Token); err != nil {
+			return nil, fmt.Errorf("failed to store registration token of host pool %s in key vault: %w", hostPoolName, err)
+		}
+		token.SecretURL = strings.TrimSuffix(avd.keyVault.VaultURL, "/") + "/secrets/" + cloudyazure.SanitizeName(secretName)
+	}
+
+	registration.token = token
+
+	log.DebugContext(ctx, "Cached registration token", "HostPool", hostPoolName, "ExpiresAt", token.ExpiresAt)
+	return token, nil
+}
+
+// fetchRegistrationToken retrieves the current token of a host pool, generating a new one if it is missing or about to expire
+func (avd *AzureVirtualDesktopManager) fetchRegistrationToken(ctx context.Context, hostPoolName string) (*armdesktopvirtualization.RegistrationInfo, error) {
+	log := logging.GetLogger(ctx)
+	log.DebugContext(ctx, "Beginning host pool token retrieval", "HostPool", hostPoolName)
+
+	const maxRetries = 3
+	var lastErr error
+
+	for attempt := 1; attempt <= maxRetries; attempt++ {
+		if attempt > 1 {
+			time.Sleep(3 * time.Second)
+		}
+
+		resp, err := avd.hostPoolsClient.RetrieveRegistrationToken(ctx, avd.Credentials.ResourceGroup, hostPoolName, nil)
+		if err == nil && resp.Token != nil && resp.ExpirationTime != nil && avd.registrationTokenFresh(*resp.ExpirationTime, time.Now()) {
+			log.DebugContext(ctx, "Successfully retrieved host pool token", "HostPool", hostPoolName)
+			return &resp.RegistrationInfo, nil
+		}
+
+		log.DebugContext(ctx, fmt.Sprintf("Attempt %d/%d: No valid token found or token about to expire. Creating/renewing now.", attempt, maxRetries))
+		hp, err := avd.UpdateHostPoolRegToken(ctx, hostPoolName)
+		if err != nil || hp == nil {
+			lastErr = logging.LogAndWrapErr(ctx, log, err, "Failure while creating/renewing host pool token")
+			continue
+		}
+
+		// Wait briefly, then retrieve again
+		time.Sleep(3 * time.Second)
+		resp, err = avd.hostPoolsClient.RetrieveRegistrationToken(ctx, avd.Credentials.ResourceGroup, hostPoolName, nil)
+		if err != nil || resp.Token == nil || resp.ExpirationTime == nil {
+			lastErr = logging.LogAndWrapErr(ctx, log, err, "RetrieveRegistrationToken failure after creating/renewing token")
+			continue
+		}
+
+		log.DebugContext(ctx, "Host pool token has been created/renewed successfully", "HostPool", hostPoolName, "ExpiresAt", *resp.ExpirationTime)
+		return &resp.RegistrationInfo, nil
+	}
+
+	// If we exit the loop, we've used up all retries without success
+	if lastErr == nil {
+		lastErr = fmt.Errorf("unable to retrieve a valid token after %d attempts", maxRetries)
+	}
+	return nil, lastErr
+}
+
+// registrationTokenFresh returns whether a token expiring at expiresAt can still be handed out
+func (avd *AzureVirtualDesktopManager) registrationTokenFresh(expiresAt, now time.Time) bool {
+	refreshBefore := defaultRegistrationTokenRefresh
+	if config := avd.Config.RegistrationToken; config != nil && config.RefreshBefore > 0 {
+		refreshBefore = config.RefreshBefore
+	}
+	// a token renewed for less than refreshBefore would be renewed again on every call
+	refreshBefore = min(refreshBefore, avd.registrationTokenLifetime()/2)
+
+	return expiresAt.After(now.Add(refreshBefore))
+}
+
+func (avd *AzureVirtualDesktopManager) registrationTokenLifetime() time.Duration {
+	if config := avd.Config.RegistrationToken; config != nil && config.Lifetime > 0 {
+		return config.Lifetime
+	}
+	return defaultRegistrationTokenLifetime
+}
+
+// newRegistrationInfo is the registration info of a new host pool, with a token valid for the configured lifetime
+func (avd *AzureVirtualDesktopManager) newRegistrationInfo() *armdesktopvirtualization.RegistrationInfo {
+	return &armdesktopvirtualization.RegistrationInfo{
+		ExpirationTime:             to.Ptr(time.Now().Add(avd.registrationTokenLifetime())),
+		RegistrationTokenOperation: to.Ptr(armdesktopvirtualization.RegistrationTokenOperationUpdate),
+	}
+}
+
+func validateRegistrationTokenConfig(config *RegistrationTokenConfig) error {
+	if config == nil {
+		return nil
+	}
+
+	if config.Lifetime != 0 && (config.Lifetime < minRegistrationTokenLifetime || config.Lifetime > maxRegistrationTokenLifetime) {
+		return fmt.Errorf("lifetime must be between %s and %s", minRegistrationTokenLifetime, maxRegistrationTokenLifetime)
+	}
+	if config.RefreshBefore < 0 {
+		return fmt.Errorf("refresh before cannot be negative")
+	}
+
+	return nil
+}
+
+func registrationTokenSecretName(hostPoolName string) string {
+	return "avd-registration-token-" + hostPoolName
+}
@@ -0,0 +1,50 @@
+package avd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistrationTokenFresh(t *testing.T) {
+	avd := &AzureVirtualDesktopManager{Config: &AzureVirtualDesktopManagerConfig{}}
+	now := time.Now()
+
+	assert.True(t, avd.registrationTokenFresh(now.Add(48*time.Hour), now))
+	assert.False(t, avd.registrationTokenFresh(now.Add(12*time.Hour), now))
+	assert.False(t, avd.registrationTokenFresh(now.Add(-time.Hour), now))
+
+	avd.Config.RegistrationToken = &RegistrationTokenConfig{RefreshBefore: time.Hour}
+	assert.True(t, avd.registrationTokenFresh(now.Add(2*time.Hour), now))
+
+	// short lived tokens are renewed halfway through their lifetime
+	avd.Config.RegistrationToken = &RegistrationTokenConfig{Lifetime: 2 * time.Hour}
+	assert.True(t, avd.registrationTokenFresh(now.Add(90*time.Minute), now))
+	assert.False(t, avd.registrationTokenFresh(now.Add(30*time.Minute), now))
+}
+
+func TestValidateRegistrationTokenConfig(t *testing.T) {
+	assert.NoError(t, validateRegistrationTokenConfig(nil))
+	assert.NoError(t, validateRegistrationTokenConfig(&RegistrationTokenConfig{}))
+	assert.NoError(t, validateRegistrationTokenConfig(&RegistrationTokenConfig{Lifetime: 7 * 24 * time.Hour}))
+	assert.Error(t, validateRegistrationTokenConfig(&RegistrationTokenConfig{Lifetime: time.Minute}))
+	assert.Error(t, validateRegistrationTokenConfig(&RegistrationTokenConfig{Lifetime: 30 * 24 * time.Hour}))
+	assert.Error(t, validateRegistrationTokenConfig(&RegistrationTokenConfig{RefreshBefore: -time.Hour}))
+}
+
+func TestEndRegistration(t *testing.T) {
+	avd := &AzureVirtualDesktopManager{Config: &AzureVirtualDesktopManagerConfig{}}
+	avd.registrationTokens.hostPool("hp").pending = 2
+
+	avd.EndRegistration(context.Background(), "hp")
+	assert.Equal(t, 1, avd.registrationTokens.hostPool("hp").pending)
+
+	avd.EndRegistration(context.Background(), "hp")
+	assert.Equal(t, 0, avd.registrationTokens.hostPool("hp").pending)
+
+	// an unmatched end does not go negative
+	avd.EndRegistration(context.Background(), "hp")
+	assert.Equal(t, 0, avd.registrationTokens.hostPool("hp").pending)
+}
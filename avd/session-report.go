@@ -0,0 +1,238 @@
+package avd
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/desktopvirtualization/armdesktopvirtualization/v2"
+	"github.com/appliedres/cloudy/logging"
+)
+
+// UserSessionInfo describes an active or disconnected user session, with the state of the session host it runs on
+type UserSessionInfo struct {
+	HostPoolName    string
+	SessionHostName string
+	SessionID       string
+
+	UserPrincipalName       string
+	ActiveDirectoryUserName string
+
+	State           armdesktopvirtualization.SessionState
+	ApplicationType armdesktopvirtualization.ApplicationType // "Desktop" or "RemoteApp"
+	CreateTime      *time.Time
+
+	HostStatus        armdesktopvirtualization.Status
+	HostAgentVersion  string
+	HostLastHeartBeat *time.Time
+	HostHealthChecks  []SessionHostHealthCheck
+}
+
+// SessionHostHealthCheck is the result of one of the health checks the AVD agent runs on a session host
+type SessionHostHealthCheck struct {
+	Name    armdesktopvirtualization.HealthCheckName
+	Result  armdesktopvirtualization.HealthCheckResult
+	Message string // details of a failed check
+}
+
+// HostPoolSessionSummary aggregates the sessions of a host pool
+type HostPoolSessionSummary struct {
+	HostPoolName string
+
+	SessionHosts          int
+	AvailableSessionHosts int
+
+	ActiveSessions       int
+	DisconnectedSessions int
+	Users                int // distinct users with a session
+
+	SessionsByApplicationType map[armdesktopvirtualization.ApplicationType]int
+}
+
+// SessionReport lists the sessions over all host pools of this manager, with a summary per host pool
+type SessionReport struct {
+	GeneratedAt time.Time
+	Sessions    []UserSessionInfo
+	HostPools   []HostPoolSessionSummary
+}
+
+// GetSessionReport reports the active and disconnected sessions over all host pools of this manager
+func (avd *AzureVirtualDesktopManager) GetSessionReport(ctx context.Context) (*SessionReport, error) {
+	log := logging.GetLogger(ctx)
+
+	hostPools, err := avd.listHostPools(ctx, &avd.Config.PrefixBase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list host pools: %w", err)
+	}
+
+	report := &SessionReport{GeneratedAt: time.Now()}
+	for _, hostPool := range hostPools {
+		if hostPool.Name == nil {
+			continue
+		}
+
+		hosts, sessions, err := avd.listHostPoolSessionInfo(ctx, *hostPool.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		report.Sessions = append(report.Sessions, sessions...)
+		report.HostPools = append(report.HostPools, summarizeSessions(*hostPool.Name, hosts, sessions))
+	}
+
+	log.DebugContext(ctx, "Generated session report", "hostPools", len(report.HostPools), "sessions", len(report.Sessions))
+	return report, nil
+}
+
+// ListHostPoolSessions lists the active and disconnected sessions of a host pool
+func (avd *AzureVirtualDesktopManager) ListHostPoolSessions(ctx context.Context, hostPoolName string) ([]UserSessionInfo, error) {
+	_, sessions, err := avd.listHostPoolSessionInfo(ctx, hostPoolName)
+	return sessions, err
+}
+
+// GetHostPoolSessionSummary aggregates the sessions of a host pool
+func (avd *AzureVirtualDesktopManager) GetHostPoolSessionSummary(ctx context.Context, hostPoolName string) (*HostPoolSessionSummary, error) {
+	hosts, sessions, err := avd.listHostPoolSessionInfo(ctx, hostPoolName)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := summarizeSessions(hostPoolName, hosts, sessions)
+	return &summary, nil
+}
+
+// ListSessionHostSessions lists the active and disconnected sessions on a single session host
+func (avd *AzureVirtualDesktopManager) ListSessionHostSessions(ctx context.Context, hostPoolName, sessionHostName string) ([]UserSessionInfo, error) {
+	_, sessions, err := avd.listHostPoolSessionInfo(ctx, hostPoolName)
+	if err != nil {
+		return nil, err
+	}
+
+	return slices.DeleteFunc(sessions, func(session UserSessionInfo) bool {
+		return !strings.EqualFold(session.SessionHostName, sessionHostName)
+	}), nil
+}
+
+// ListSessionsForUser lists the active and disconnected sessions of a user over all host pools of this manager
+func (avd *AzureVirtualDesktopManager) ListSessionsForUser(ctx context.Context, upn string) ([]UserSessionInfo, error) {
+	report, err := avd.GetSessionReport(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return slices.DeleteFunc(report.Sessions, func(session UserSessionInfo) bool {
+		return !strings.EqualFold(session.UserPrincipalName, upn)
+	}), nil
+}
+
+// listHostPoolSessionInfo returns the session hosts of a host pool, and its active and disconnected sessions
+func (avd *AzureVirtualDesktopManager) listHostPoolSessionInfo(ctx context.Context, hostPoolName string) ([]*armdesktopvirtualization.SessionHost, []UserSessionInfo, error) {
+	hosts, err := avd.ListSessionHosts(ctx, hostPoolName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list session hosts of host pool %s: %w", hostPoolName, err)
+	}
+
+	sessions, err := avd.listHostPoolUserSessions(ctx, hostPoolName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return hosts, userSessionInfo(hosts, sessions), nil
+}
+
+// userSessionInfo joins the active and disconnected sessions with the session hosts they run on
+func userSessionInfo(hosts []*armdesktopvirtualization.SessionHost, sessions []*armdesktopvirtualization.UserSession) []UserSessionInfo {
+	hostsByName := map[string]*armdesktopvirtualization.SessionHost{}
+	for _, host := range hosts {
+		if host != nil && host.Name != nil {
+			hostsByName[strings.ToLower(*host.Name)] = host
+		}
+	}
+
+	var infos []UserSessionInfo
+	for _, session := range sessions {
+		state := sessionState(session)
+		if state != armdesktopvirtualization.SessionStateActive && state != armdesktopvirtualization.SessionStateDisconnected {
+			continue
+		}
+
+		hostPoolName, sessionHostName, sessionID, err := parseUserSessionName(*session.Name)
+		if err != nil {
+			continue
+		}
+
+		info := UserSessionInfo{
+			HostPoolName:            hostPoolName,
+			SessionHostName:         sessionHostName,
+			SessionID:               sessionID,
+			UserPrincipalName:       valueOf(session.Properties.UserPrincipalName),
+			ActiveDirectoryUserName: valueOf(session.Properties.ActiveDirectoryUserName),
+			State:                   state,
+			ApplicationType:         valueOf(session.Properties.ApplicationType),
+			CreateTime:              session.Properties.CreateTime,
+		}
+
+		if host := hostsByName[strings.ToLower(hostPoolName+"/"+sessionHostName)]; host != nil && host.Properties != nil {
+			info.HostStatus = valueOf(host.Properties.Status)
+			info.HostAgentVersion = valueOf(host.Properties.AgentVersion)
+			info.HostLastHeartBeat = host.Properties.LastHeartBeat
+			info.HostHealthChecks = healthChecks(host)
+		}
+
+		infos = append(infos, info)
+	}
+
+	return infos
+}
+
+func summarizeSessions(hostPoolName string, hosts []*armdesktopvirtualization.SessionHost, sessions []UserSessionInfo) HostPoolSessionSummary {
+	summary := HostPoolSessionSummary{
+		HostPoolName:              hostPoolName,
+		SessionHosts:              len(hosts),
+		SessionsByApplicationType: map[armdesktopvirtualization.ApplicationType]int{},
+	}
+
+	for _, host := range hosts {
+		if host != nil && host.Properties != nil && valueOf(host.Properties.Status) == armdesktopvirtualization.StatusAvailable {
+			summary.AvailableSessionHosts++
+		}
+	}
+
+	users := map[string]struct{}{}
+	for _, session := range sessions {
+		switch session.State {
+		case armdesktopvirtualization.SessionStateActive:
+			summary.ActiveSessions++
+		case armdesktopvirtualization.SessionStateDisconnected:
+			summary.DisconnectedSessions++
+		}
+		if session.ApplicationType != "" {
+			summary.SessionsByApplicationType[session.ApplicationType]++
+		}
+		users[strings.ToLower(session.UserPrincipalName)] = struct{}{}
+	}
+	summary.Users = len(users)
+
+	return summary
+}
+
+func healthChecks(host *armdesktopvirtualization.SessionHost) []SessionHostHealthCheck {
+	var checks []SessionHostHealthCheck
+	for _, report := range host.Properties.SessionHostHealthCheckResults {
+		if report == nil {
+			continue
+		}
+
+		check := SessionHostHealthCheck{
+			Name:   valueOf(report.HealthCheckName),
+			Result: valueOf(report.HealthCheckResult),
+		}
+		if report.AdditionalFailureDetails != nil {
+			check.Message = valueOf(report.AdditionalFailureDetails.Message)
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
@@ -0,0 +1,88 @@
+package avd
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/desktopvirtualization/armdesktopvirtualization/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func testUserSession(name, upn string, state armdesktopvirtualization.SessionState, appType armdesktopvirtualization.ApplicationType) *armdesktopvirtualization.UserSession {
+	return &armdesktopvirtualization.UserSession{
+		Name: to.Ptr(name),
+		Properties: &armdesktopvirtualization.UserSessionProperties{
+			UserPrincipalName: to.Ptr(upn),
+			SessionState:      to.Ptr(state),
+			ApplicationType:   to.Ptr(appType),
+		},
+	}
+}
+
+func TestUserSessionInfo(t *testing.T) {
+	hosts := []*armdesktopvirtualization.SessionHost{
+		{
+			Name: to.Ptr("hp/host-1.example.com"),
+			Properties: &armdesktopvirtualization.SessionHostProperties{
+				Status:       to.Ptr(armdesktopvirtualization.StatusAvailable),
+				AgentVersion: to.Ptr("1.0.1"),
+				SessionHostHealthCheckResults: []*armdesktopvirtualization.SessionHostHealthCheckReport{
+					{
+						HealthCheckName:   to.Ptr(armdesktopvirtualization.HealthCheckNameDomainJoinedCheck),
+						HealthCheckResult: to.Ptr(armdesktopvirtualization.HealthCheckResultHealthCheckFailed),
+						AdditionalFailureDetails: &armdesktopvirtualization.SessionHostHealthCheckFailureDetails{
+							Message: to.Ptr("not joined"),
+						},
+					},
+				},
+			},
+		},
+	}
+	sessions := []*armdesktopvirtualization.UserSession{
+		testUserSession("hp/host-1.example.com/1", "a@example.com", armdesktopvirtualization.SessionStateActive, armdesktopvirtualization.ApplicationTypeDesktop),
+		testUserSession("hp/host-1.example.com/2", "b@example.com", armdesktopvirtualization.SessionStateLogOff, armdesktopvirtualization.ApplicationTypeDesktop),
+		testUserSession("hp/host-2.example.com/3", "b@example.com", armdesktopvirtualization.SessionStateDisconnected, armdesktopvirtualization.ApplicationTypeRemoteApp),
+	}
+
+	infos := userSessionInfo(hosts, sessions)
+	assert.Len(t, infos, 2)
+
+	assert.Equal(t, "host-1.example.com", infos[0].SessionHostName)
+	assert.Equal(t, "1", infos[0].SessionID)
+	assert.Equal(t, armdesktopvirtualization.StatusAvailable, infos[0].HostStatus)
+	assert.Equal(t, "1.0.1", infos[0].HostAgentVersion)
+	assert.Equal(t, []SessionHostHealthCheck{{
+		Name:    armdesktopvirtualization.HealthCheckNameDomainJoinedCheck,
+		Result:  armdesktopvirtualization.HealthCheckResultHealthCheckFailed,
+		Message: "not joined",
+	}}, infos[0].HostHealthChecks)
+
+	// the session host is unknown, so has no host details
+	assert.Equal(t, armdesktopvirtualization.SessionStateDisconnected, infos[1].State)
+	assert.Empty(t, infos[1].HostStatus)
+	assert.Nil(t, infos[1].HostHealthChecks)
+}
+
+func TestSummarizeSessions(t *testing.T) {
+	hosts := []*armdesktopvirtualization.SessionHost{
+		{Properties: &armdesktopvirtualization.SessionHostProperties{Status: to.Ptr(armdesktopvirtualization.StatusAvailable)}},
+		{Properties: &armdesktopvirtualization.SessionHostProperties{Status: to.Ptr(armdesktopvirtualization.StatusUnavailable)}},
+	}
+	sessions := []UserSessionInfo{
+		{UserPrincipalName: "a@example.com", State: armdesktopvirtualization.SessionStateActive, ApplicationType: armdesktopvirtualization.ApplicationTypeDesktop},
+		{UserPrincipalName: "A@example.com", State: armdesktopvirtualization.SessionStateDisconnected, ApplicationType: armdesktopvirtualization.ApplicationTypeRemoteApp},
+		{UserPrincipalName: "b@example.com", State: armdesktopvirtualization.SessionStateActive, ApplicationType: armdesktopvirtualization.ApplicationTypeRemoteApp},
+	}
+
+	summary := summarizeSessions("hp", hosts, sessions)
+	assert.Equal(t, "hp", summary.HostPoolName)
+	assert.Equal(t, 2, summary.SessionHosts)
+	assert.Equal(t, 1, summary.AvailableSessionHosts)
+	assert.Equal(t, 2, summary.ActiveSessions)
+	assert.Equal(t, 1, summary.DisconnectedSessions)
+	assert.Equal(t, 2, summary.Users)
+	assert.Equal(t, map[armdesktopvirtualization.ApplicationType]int{
+		armdesktopvirtualization.ApplicationTypeDesktop:   1,
+		armdesktopvirtualization.ApplicationTypeRemoteApp: 2,
+	}, summary.SessionsByApplicationType)
+}
@@ -0,0 +1,258 @@
+package avd
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/desktopvirtualization/armdesktopvirtualization/v2"
+	"github.com/appliedres/cloudy/logging"
+)
+
+// SessionHostHealth classifies a session host by what has to be done about it
+type SessionHostHealth string
+
+const (
+	SessionHostHealthy     SessionHostHealth = "Healthy"     // serving sessions, or shut down
+	SessionHostTransient   SessionHostHealth = "Transient"   // expected to recover on its own, e.g. while the agent upgrades
+	SessionHostNeedsRepair SessionHostHealth = "NeedsRepair" // can be repaired, see SessionHostRepairAction
+	SessionHostDead        SessionHostHealth = "Dead"        // cannot be repaired and should be deleted
+)
+
+// SessionHostRepairAction is a repair for a session host, from least to most disruptive
+type SessionHostRepairAction string
+
+const (
+	RepairNone           SessionHostRepairAction = ""
+	RepairRestartVM      SessionHostRepairAction = "RestartVM"      // restart the session host VM
+	RepairReregister     SessionHostRepairAction = "Reregister"     // register the agent again with a fresh token
+	RepairReinstallAgent SessionHostRepairAction = "ReinstallAgent" // run the session host setup again, reinstalling the agent
+)
+
+// repairEscalation is the order repairs are tried in when the previous one did not help
+var repairEscalation = []SessionHostRepairAction{RepairRestartVM, RepairReregister, RepairReinstallAgent}
+
+const (
+	defaultTransientPeriod   = 30 * time.Minute
+	defaultDeadAfter         = 24 * time.Hour
+	defaultMaxRepairAttempts = 3
+)
+
+// SessionHostHealthReport is the health of a session host, with the repair it needs
+type SessionHostHealthReport struct {
+	HostPoolName    string
+	SessionHostName string
+	VMID            string
+
+	Status        armdesktopvirtualization.Status
+	UpdateState   armdesktopvirtualization.UpdateState
+	AgentVersion  string
+	LastHeartBeat *time.Time
+	FailedChecks  []SessionHostHealthCheck
+
+	Health SessionHostHealth
+	Repair SessionHostRepairAction // set when Health is NeedsRepair
+	Reason string
+}
+
+// EvaluateSessionHostHealth classifies every session host of a host pool, as if the unhealthy ones were first seen now
+func (avd *AzureVirtualDesktopManager) EvaluateSessionHostHealth(ctx context.Context, hostPoolName string) ([]SessionHostHealthReport, error) {
+	hosts, err := avd.ListSessionHosts(ctx, hostPoolName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list session hosts of host pool %s: %w", hostPoolName, err)
+	}
+
+	reports := make([]SessionHostHealthReport, 0, len(hosts))
+	for _, host := range hosts {
+		reports = append(reports, avd.ClassifySessionHost(ctx, host, time.Time{}))
+	}
+	return reports, nil
+}
+
+// ClassifySessionHost classifies a session host by its status, agent update state, heartbeat and health checks.
+// unhealthySince is when the host was first seen unhealthy, zero if it was not seen unhealthy before. A host
+// unhealthy for less than TransientPeriod is transient, hosts keep sending heartbeats while unavailable.
+func (avd *AzureVirtualDesktopManager) ClassifySessionHost(ctx context.Context, host *armdesktopvirtualization.SessionHost, unhealthySince time.Time) SessionHostHealthReport {
+	report := classifySessionHost(host, avd.Config.SessionHostHealth, unhealthySince, time.Now())
+
+	if host != nil && host.Name != nil {
+		report.HostPoolName, report.SessionHostName, report.VMID, _ = avd.ParseSessionHostName(ctx, host)
+	}
+
+	logging.GetLogger(ctx).DebugContext(ctx, "Classified session host", "host", report.SessionHostName,
+		"status", report.Status, "health", report.Health, "repair", report.Repair, "reason", report.Reason)
+	return report
+}
+
+// EscalateRepair returns the report with the repair to try after a number of failed repair attempts.
+// A host that still needs repair after the most disruptive repair, or after MaxRepairAttempts, is dead.
+func (avd *AzureVirtualDesktopManager) EscalateRepair(report SessionHostHealthReport, failedAttempts int) SessionHostHealthReport {
+	if report.Health != SessionHostNeedsRepair || failedAttempts <= 0 {
+		return report
+	}
+
+	maxAttempts := defaultMaxRepairAttempts
+	if config := avd.Config.SessionHostHealth; config != nil && config.MaxRepairAttempts > 0 {
+		maxAttempts = config.MaxRepairAttempts
+	}
+
+	step := slices.Index(repairEscalation, report.Repair) + failedAttempts
+	if failedAttempts >= maxAttempts || step >= len(repairEscalation) {
+		report.Health = SessionHostDead
+		report.Reason = fmt.Sprintf("%s, still unhealthy after %d repair attempts", report.Reason, failedAttempts)
+		report.Repair = RepairNone
+		return report
+	}
+
+	report.Repair = repairEscalation[step]
+	return report
+}
+
+// AwaitRepair returns the report as transient while the last repair of a host may still take effect, i.e. for
+// TransientPeriod after it ran, so repairs are neither repeated nor escalated before the host had time to recover.
+func (avd *AzureVirtualDesktopManager) AwaitRepair(report SessionHostHealthReport, lastAttempt, now time.Time) SessionHostHealthReport {
+	if report.Health != SessionHostNeedsRepair || lastAttempt.IsZero() {
+		return report
+	}
+
+	transientPeriod := defaultTransientPeriod
+	if config := avd.Config.SessionHostHealth; config != nil && config.TransientPeriod > 0 {
+		transientPeriod = config.TransientPeriod
+	}
+
+	if now.Sub(lastAttempt) >= transientPeriod {
+		return report
+	}
+
+	report.Health = SessionHostTransient
+	report.Reason = fmt.Sprintf("%s, repaired %s ago", report.Reason, now.Sub(lastAttempt).Round(time.Second))
+	report.Repair = RepairNone
+	return report
+}
+
+// PrepareSessionHostRegistration removes a session host from its host pool so its agent can register again,
+// and returns a registration token for the agent. The registration must be ended with EndRegistration.
+func (avd *AzureVirtualDesktopManager) PrepareSessionHostRegistration(ctx context.Context, host *armdesktopvirtualization.SessionHost) (*RegistrationToken, error) {
+	hostPoolName, _, _, err := avd.ParseSessionHostName(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	err = avd.DeleteSessionHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	return avd.BeginRegistration(ctx, hostPoolName)
+}
+
+func classifySessionHost(host *armdesktopvirtualization.SessionHost, config *SessionHostHealthConfig, unhealthySince, now time.Time) SessionHostHealthReport {
+	report := SessionHostHealthReport{}
+	if host == nil || host.Properties == nil || host.Properties.Status == nil {
+		report.Health = SessionHostDead
+		report.Reason = "session host has no status"
+		return report
+	}
+
+	props := host.Properties
+	report.Status = *props.Status
+	report.UpdateState = valueOf(props.UpdateState)
+	report.AgentVersion = valueOf(props.AgentVersion)
+	report.LastHeartBeat = props.LastHeartBeat
+	for _, check := range healthChecks(host) {
+		if check.Result == armdesktopvirtualization.HealthCheckResultHealthCheckFailed {
+			report.FailedChecks = append(report.FailedChecks, check)
+		}
+	}
+
+	transientPeriod, deadAfter := defaultTransientPeriod, defaultDeadAfter
+	if config != nil {
+		if config.TransientPeriod > 0 {
+			transientPeriod = config.TransientPeriod
+		}
+		if config.DeadAfter > 0 {
+			deadAfter = config.DeadAfter
+		}
+	}
+
+	// a shut down host stops sending heartbeats, that is expected
+	if report.Status == armdesktopvirtualization.StatusShutdown {
+		report.Health = SessionHostHealthy
+		report.Reason = "shut down"
+		return report
+	}
+
+	silence := time.Duration(-1)
+	if props.LastHeartBeat != nil {
+		silence = now.Sub(*props.LastHeartBeat)
+	}
+	if silence > deadAfter {
+		report.Health = SessionHostDead
+		report.Reason = fmt.Sprintf("no heartbeat for %s", silence.Round(time.Minute))
+		return report
+	}
+
+	unhealthyFor := time.Duration(0)
+	if !unhealthySince.IsZero() {
+		unhealthyFor = now.Sub(unhealthySince)
+	}
+	recovering := unhealthyFor < transientPeriod
+
+	needsRepair := func(repair SessionHostRepairAction, reason string) SessionHostHealthReport {
+		report.Health, report.Repair, report.Reason = SessionHostNeedsRepair, repair, reason
+		return report
+	}
+
+	switch report.Status {
+	case armdesktopvirtualization.StatusAvailable:
+		report.Health = SessionHostHealthy
+		return report
+	case armdesktopvirtualization.StatusUpgrading:
+		if !recovering {
+			return needsRepair(RepairReinstallAgent, fmt.Sprintf("agent upgrading for %s", unhealthyFor.Round(time.Minute)))
+		}
+		report.Health = SessionHostTransient
+		report.Reason = "agent is upgrading"
+		return report
+	case armdesktopvirtualization.StatusDomainTrustRelationshipLost:
+		// the machine account has to be recreated, replacing the host is simpler
+		report.Health = SessionHostDead
+		report.Reason = "domain trust relationship lost"
+		return report
+	case armdesktopvirtualization.StatusUpgradeFailed:
+		return needsRepair(RepairReinstallAgent, "agent upgrade failed")
+	case armdesktopvirtualization.StatusSxSStackListenerNotReady:
+		return needsRepair(RepairReinstallAgent, "side by side stack listener is not ready")
+	case armdesktopvirtualization.StatusNotJoinedToDomain:
+		return needsRepair(RepairReinstallAgent, "not joined to the domain")
+	}
+
+	// Unavailable, Disconnected, NoHeartbeat, FSLogixNotHealthy and NeedsAssistance
+	switch report.UpdateState {
+	case armdesktopvirtualization.UpdateStatePending, armdesktopvirtualization.UpdateStateStarted:
+		if !recovering {
+			return needsRepair(RepairReinstallAgent, fmt.Sprintf("%s, agent update in progress for %s", report.Status, unhealthyFor.Round(time.Minute)))
+		}
+		report.Health = SessionHostTransient
+		report.Reason = "agent update in progress"
+		return report
+	case armdesktopvirtualization.UpdateStateFailed:
+		return needsRepair(RepairReinstallAgent, "agent update failed")
+	}
+
+	if recovering {
+		report.Health = SessionHostTransient
+		report.Reason = fmt.Sprintf("%s for less than %s", report.Status, transientPeriod)
+		return report
+	}
+
+	for _, check := range report.FailedChecks {
+		switch check.Name {
+		case armdesktopvirtualization.HealthCheckNameDomainJoinedCheck, armdesktopvirtualization.HealthCheckNameSxSStackListenerCheck:
+			return needsRepair(RepairReinstallAgent, fmt.Sprintf("%s, %s failed", report.Status, check.Name))
+		}
+	}
+
+	return needsRepair(RepairRestartVM, fmt.Sprintf("%s for %s", report.Status, unhealthyFor.Round(time.Minute)))
+}
@@ -0,0 +1,114 @@
+package avd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/desktopvirtualization/armdesktopvirtualization/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func testSessionHost(status armdesktopvirtualization.Status, silence time.Duration, now time.Time) *armdesktopvirtualization.SessionHost {
+	return &armdesktopvirtualization.SessionHost{
+		Name: to.Ptr("hp/shvm-1.example.com"),
+		Properties: &armdesktopvirtualization.SessionHostProperties{
+			Status:        to.Ptr(status),
+			LastHeartBeat: to.Ptr(now.Add(-silence)),
+		},
+	}
+}
+
+func TestClassifySessionHost(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name         string
+		host         *armdesktopvirtualization.SessionHost
+		unhealthyFor time.Duration
+		health       SessionHostHealth
+		repair       SessionHostRepairAction
+	}{
+		{"no status", &armdesktopvirtualization.SessionHost{}, 0, SessionHostDead, RepairNone},
+		{"available", testSessionHost(armdesktopvirtualization.StatusAvailable, time.Minute, now), 0, SessionHostHealthy, RepairNone},
+		{"shut down for days", testSessionHost(armdesktopvirtualization.StatusShutdown, 72*time.Hour, now), 72 * time.Hour, SessionHostHealthy, RepairNone},
+		{"upgrading", testSessionHost(armdesktopvirtualization.StatusUpgrading, time.Minute, now), 5 * time.Minute, SessionHostTransient, RepairNone},
+		{"upgrading for an hour", testSessionHost(armdesktopvirtualization.StatusUpgrading, time.Minute, now), time.Hour, SessionHostNeedsRepair, RepairReinstallAgent},
+		{"upgrade failed", testSessionHost(armdesktopvirtualization.StatusUpgradeFailed, time.Minute, now), 0, SessionHostNeedsRepair, RepairReinstallAgent},
+		{"recently unavailable", testSessionHost(armdesktopvirtualization.StatusUnavailable, time.Minute, now), 5 * time.Minute, SessionHostTransient, RepairNone},
+		{"first seen unavailable", testSessionHost(armdesktopvirtualization.StatusUnavailable, time.Hour, now), 0, SessionHostTransient, RepairNone},
+		{"unavailable with heartbeats", testSessionHost(armdesktopvirtualization.StatusUnavailable, time.Minute, now), time.Hour, SessionHostNeedsRepair, RepairRestartVM},
+		{"needs assistance", testSessionHost(armdesktopvirtualization.StatusNeedsAssistance, time.Minute, now), time.Hour, SessionHostNeedsRepair, RepairRestartVM},
+		{"no heartbeat for days", testSessionHost(armdesktopvirtualization.StatusNoHeartbeat, 48*time.Hour, now), 0, SessionHostDead, RepairNone},
+		{"domain trust lost", testSessionHost(armdesktopvirtualization.StatusDomainTrustRelationshipLost, time.Minute, now), 0, SessionHostDead, RepairNone},
+	}
+
+	for _, test := range tests {
+		report := classifySessionHost(test.host, nil, now.Add(-test.unhealthyFor), now)
+		assert.Equal(t, test.health, report.Health, test.name)
+		assert.Equal(t, test.repair, report.Repair, test.name)
+	}
+
+	// an agent update in progress is transient, a failed one needs the agent reinstalled
+	host := testSessionHost(armdesktopvirtualization.StatusUnavailable, time.Minute, now)
+	host.Properties.UpdateState = to.Ptr(armdesktopvirtualization.UpdateStateStarted)
+	assert.Equal(t, SessionHostTransient, classifySessionHost(host, nil, now.Add(-10*time.Minute), now).Health)
+	assert.Equal(t, RepairReinstallAgent, classifySessionHost(host, nil, now.Add(-time.Hour), now).Repair)
+	host.Properties.UpdateState = to.Ptr(armdesktopvirtualization.UpdateStateFailed)
+	assert.Equal(t, RepairReinstallAgent, classifySessionHost(host, nil, now, now).Repair)
+
+	// failed health checks pick the repair
+	host = testSessionHost(armdesktopvirtualization.StatusUnavailable, time.Minute, now)
+	host.Properties.SessionHostHealthCheckResults = []*armdesktopvirtualization.SessionHostHealthCheckReport{
+		{
+			HealthCheckName:   to.Ptr(armdesktopvirtualization.HealthCheckNameDomainJoinedCheck),
+			HealthCheckResult: to.Ptr(armdesktopvirtualization.HealthCheckResultHealthCheckFailed),
+		},
+	}
+	report := classifySessionHost(host, nil, now.Add(-time.Hour), now)
+	assert.Equal(t, RepairReinstallAgent, report.Repair)
+	assert.Len(t, report.FailedChecks, 1)
+
+	// thresholds are configurable
+	host = testSessionHost(armdesktopvirtualization.StatusUnavailable, time.Hour, now)
+	assert.Equal(t, SessionHostTransient, classifySessionHost(host, &SessionHostHealthConfig{TransientPeriod: 2 * time.Hour}, now.Add(-time.Hour), now).Health)
+	assert.Equal(t, SessionHostDead, classifySessionHost(host, &SessionHostHealthConfig{DeadAfter: 30 * time.Minute}, now.Add(-time.Hour), now).Health)
+}
+
+func TestEscalateRepair(t *testing.T) {
+	avd := &AzureVirtualDesktopManager{Config: &AzureVirtualDesktopManagerConfig{}}
+	report := SessionHostHealthReport{Health: SessionHostNeedsRepair, Repair: RepairRestartVM}
+
+	assert.Equal(t, RepairRestartVM, avd.EscalateRepair(report, 0).Repair)
+	assert.Equal(t, RepairReregister, avd.EscalateRepair(report, 1).Repair)
+	assert.Equal(t, RepairReinstallAgent, avd.EscalateRepair(report, 2).Repair)
+	assert.Equal(t, SessionHostDead, avd.EscalateRepair(report, 3).Health)
+
+	// the most disruptive repair is not escalated further
+	report.Repair = RepairReinstallAgent
+	assert.Equal(t, SessionHostDead, avd.EscalateRepair(report, 1).Health)
+
+	avd.Config.SessionHostHealth = &SessionHostHealthConfig{MaxRepairAttempts: 1}
+	report.Repair = RepairRestartVM
+	assert.Equal(t, SessionHostDead, avd.EscalateRepair(report, 1).Health)
+
+	// healthy hosts are never escalated
+	healthy := SessionHostHealthReport{Health: SessionHostHealthy}
+	assert.Equal(t, healthy, avd.EscalateRepair(healthy, 5))
+}
+
+func TestAwaitRepair(t *testing.T) {
+	avd := &AzureVirtualDesktopManager{Config: &AzureVirtualDesktopManagerConfig{}}
+	now := time.Now()
+	report := SessionHostHealthReport{Health: SessionHostNeedsRepair, Repair: RepairReregister, Reason: "no heartbeat"}
+
+	waiting := avd.AwaitRepair(report, now.Add(-10*time.Minute), now)
+	assert.Equal(t, SessionHostTransient, waiting.Health)
+	assert.Equal(t, RepairNone, waiting.Repair)
+
+	assert.Equal(t, report, avd.AwaitRepair(report, now.Add(-31*time.Minute), now))
+	assert.Equal(t, report, avd.AwaitRepair(report, time.Time{}, now))
+
+	avd.Config.SessionHostHealth = &SessionHostHealthConfig{TransientPeriod: 5 * time.Minute}
+	assert.Equal(t, report, avd.AwaitRepair(report, now.Add(-10*time.Minute), now))
+}
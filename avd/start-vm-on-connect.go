@@ -0,0 +1,206 @@
+package avd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/authorization/armauthorization/v2"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/desktopvirtualization/armdesktopvirtualization/v2"
+	"github.com/appliedres/cloudy/logging"
+	"github.com/google/uuid"
+)
+
+// "Desktop Virtualization Power On Contributor" built-in role
+const powerOnContributorRoleID = "489581de-a3bd-480d-9518-53dea7416b33"
+
+// StartVMOnConnectReport is the result of verifying the Start VM on Connect setup
+type StartVMOnConnectReport struct {
+	RoleAssigned         bool     // AVD holds the power on role on the VM resource group
+	HostPoolsWithoutFlag []string // personal host pools that do not have Start VM on Connect enabled
+}
+
+// Ready returns whether AVD can start the VMs of all personal host pools
+func (r *StartVMOnConnectReport) Ready() bool {
+	return r.RoleAssigned && len(r.HostPoolsWithoutFlag) == 0
+}
+
+// EnsureStartVMOnConnect grants AVD the power on role on the VM resource group, enables the flag on all personal
+// host pools, and verifies the result. Returns an error describing any remaining misconfiguration.
+func (avd *AzureVirtualDesktopManager) EnsureStartVMOnConnect(ctx context.Context) (*StartVMOnConnectReport, error) {
+	log := logging.GetLogger(ctx)
+
+	config := avd.Config.StartVMOnConnect
+	if config == nil {
+		return nil, fmt.Errorf("start VM on connect is not configured")
+	}
+
+	assigned, err := avd.hasPowerOnRole(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	if !assigned {
+		log.InfoContext(ctx, "Granting AVD the power on role", "Scope", config.VMResourceGroupID, "PrincipalID", config.AVDServicePrincipalID)
+		_, err = avd.roleAssignmentsClient.Create(ctx, config.VMResourceGroupID, uuid.New().String(),
+			armauthorization.RoleAssignmentCreateParameters{
+				Properties: &armauthorization.RoleAssignmentProperties{
+					RoleDefinitionID: to.Ptr(powerOnRoleDefinitionID(config)),
+					PrincipalID:      to.Ptr(config.AVDServicePrincipalID),
+					PrincipalType:    to.Ptr(armauthorization.PrincipalTypeServicePrincipal),
+				},
+			}, nil)
+		if err != nil {
+			log.WarnContext(ctx, "Failed to grant AVD the power on role", "Error", err)
+		}
+	}
+
+	_, err = avd.ReconcileHostPools(ctx)
+	if err != nil {
+		log.WarnContext(ctx, "Failed to enable start VM on connect on all host pools", "Error", err)
+	}
+
+	report, err := avd.VerifyStartVMOnConnect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !report.Ready() {
+		return report, startVMOnConnectError(report, config)
+	}
+
+	log.InfoContext(ctx, "Start VM on Connect is ready")
+	return report, nil
+}
+
+// VerifyStartVMOnConnect checks that AVD holds the power on role and that every personal host pool has the flag set
+func (avd *AzureVirtualDesktopManager) VerifyStartVMOnConnect(ctx context.Context) (*StartVMOnConnectReport, error) {
+	config := avd.Config.StartVMOnConnect
+	if config == nil {
+		return nil, fmt.Errorf("start VM on connect is not configured")
+	}
+
+	report := &StartVMOnConnectReport{}
+
+	assigned, err := avd.hasPowerOnRole(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	report.RoleAssigned = assigned
+
+	hostPools, err := avd.listHostPools(ctx, to.Ptr(avd.Config.PersonalHostPoolNamePrefix))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list host pools: %w", err)
+	}
+	for _, hostPool := range hostPools {
+		if hostPool.Name != nil && !startsVMOnConnect(hostPool) {
+			report.HostPoolsWithoutFlag = append(report.HostPoolsWithoutFlag, *hostPool.Name)
+		}
+	}
+
+	return report, nil
+}
+
+// StartsOnConnect returns whether AVD starts a VM when its user connects, so the VM does not have to be started first.
+// This requires the VM to be a session host of a personal host pool with the flag set, and AVD to hold the power on role.
+func (avd *AzureVirtualDesktopManager) StartsOnConnect(ctx context.Context, vmID string) (bool, error) {
+	config := avd.Config.StartVMOnConnect
+	if config == nil {
+		return false, nil
+	}
+
+	hostPool, sessionHost, err := avd.findPersonalSessionHost(ctx, vmID)
+	if err != nil {
+		return false, err
+	}
+	if sessionHost == nil || !startsVMOnConnect(hostPool) {
+		return false, nil
+	}
+
+	return avd.hasPowerOnRole(ctx, config)
+}
+
+// hasPowerOnRole returns whether the AVD service principal holds the power on role on the VM resource group
+func (avd *AzureVirtualDesktopManager) hasPowerOnRole(ctx context.Context, config *StartVMOnConnectConfig) (bool, error) {
+	pager := avd.roleAssignmentsClient.NewListForScopePager(config.VMResourceGroupID, &armauthorization.RoleAssignmentsClientListForScopeOptions{
+		Filter: to.Ptr(fmt.Sprintf("principalId eq '%s'", config.AVDServicePrincipalID)),
+	})
+
+	var assignments []*armauthorization.RoleAssignment
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return false, fmt.Errorf("failed to list role assignments on %s: %w", config.VMResourceGroupID, err)
+		}
+		assignments = append(assignments, page.Value...)
+	}
+
+	return hasRoleAssignment(assignments, config.AVDServicePrincipalID, powerOnRoleID(config)), nil
+}
+
+func validateStartVMOnConnectConfig(config *StartVMOnConnectConfig) error {
+	if config == nil {
+		return nil
+	}
+
+	if config.AVDServicePrincipalID == "" {
+		return fmt.Errorf("the AVD service principal ID is required")
+	}
+
+	resourceID, err := arm.ParseResourceID(config.VMResourceGroupID)
+	if err != nil || !strings.EqualFold(resourceID.ResourceType.String(), arm.ResourceGroupResourceType.String()) {
+		return fmt.Errorf("invalid VM resource group ID [%s]", config.VMResourceGroupID)
+	}
+
+	return nil
+}
+
+func powerOnRoleID(config *StartVMOnConnectConfig) string {
+	if config.RoleDefinitionID != "" {
+		return config.RoleDefinitionID
+	}
+	return powerOnContributorRoleID
+}
+
+// powerOnRoleDefinitionID returns the role definition resource ID in the subscription of the VM resource group
+func powerOnRoleDefinitionID(config *StartVMOnConnectConfig) string {
+	subscriptionID := ""
+	if resourceID, err := arm.ParseResourceID(config.VMResourceGroupID); err == nil {
+		subscriptionID = resourceID.SubscriptionID
+	}
+
+	return fmt.Sprintf("/subscriptions/%s/providers/Microsoft.Authorization/roleDefinitions/%s", subscriptionID, powerOnRoleID(config))
+}
+
+// hasRoleAssignment returns whether the principal is assigned the role, given by its role definition GUID
+func hasRoleAssignment(assignments []*armauthorization.RoleAssignment, principalID, roleID string) bool {
+	for _, assignment := range assignments {
+		if assignment == nil || assignment.Properties == nil ||
+			assignment.Properties.PrincipalID == nil || assignment.Properties.RoleDefinitionID == nil {
+			continue
+		}
+		if *assignment.Properties.PrincipalID == principalID &&
+			strings.EqualFold(lastNameSegment(*assignment.Properties.RoleDefinitionID), roleID) {
+			return true
+		}
+	}
+	return false
+}
+
+func startsVMOnConnect(hostPool *armdesktopvirtualization.HostPool) bool {
+	return hostPool != nil && hostPool.Properties != nil &&
+		hostPool.Properties.StartVMOnConnect != nil && *hostPool.Properties.StartVMOnConnect
+}
+
+func startVMOnConnectError(report *StartVMOnConnectReport, config *StartVMOnConnectConfig) error {
+	var problems []string
+	if !report.RoleAssigned {
+		problems = append(problems, fmt.Sprintf("the AVD service principal %s is missing role %s on %s",
+			config.AVDServicePrincipalID, powerOnRoleID(config), config.VMResourceGroupID))
+	}
+	if len(report.HostPoolsWithoutFlag) > 0 {
+		problems = append(problems, fmt.Sprintf("start VM on connect is disabled on host pools %s", strings.Join(report.HostPoolsWithoutFlag, ", ")))
+	}
+
+	return fmt.Errorf("start VM on connect is not ready: %s", strings.Join(problems, "; "))
+}
@@ -0,0 +1,52 @@
+package avd
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/authorization/armauthorization/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateStartVMOnConnectConfig(t *testing.T) {
+	rgID := "/subscriptions/00000000-0000-0000-0000-000000000001/resourceGroups/vms"
+
+	assert.NoError(t, validateStartVMOnConnectConfig(nil))
+	assert.NoError(t, validateStartVMOnConnectConfig(&StartVMOnConnectConfig{AVDServicePrincipalID: "avd", VMResourceGroupID: rgID}))
+
+	assert.Error(t, validateStartVMOnConnectConfig(&StartVMOnConnectConfig{VMResourceGroupID: rgID}))
+	assert.Error(t, validateStartVMOnConnectConfig(&StartVMOnConnectConfig{AVDServicePrincipalID: "avd", VMResourceGroupID: "vms"}))
+	assert.Error(t, validateStartVMOnConnectConfig(&StartVMOnConnectConfig{AVDServicePrincipalID: "avd",
+		VMResourceGroupID: rgID + "/providers/Microsoft.Compute/virtualMachines/uvm-1"}))
+}
+
+func TestPowerOnRole(t *testing.T) {
+	config := &StartVMOnConnectConfig{AVDServicePrincipalID: "avd", VMResourceGroupID: "/subscriptions/sub/resourceGroups/vms"}
+	assert.Equal(t, "/subscriptions/sub/providers/Microsoft.Authorization/roleDefinitions/"+powerOnContributorRoleID, powerOnRoleDefinitionID(config))
+
+	assignments := []*armauthorization.RoleAssignment{
+		{Properties: &armauthorization.RoleAssignmentProperties{PrincipalID: to.Ptr("avd"), RoleDefinitionID: to.Ptr("/subscriptions/sub/providers/Microsoft.Authorization/roleDefinitions/reader")}},
+		{Properties: &armauthorization.RoleAssignmentProperties{PrincipalID: to.Ptr("other"), RoleDefinitionID: to.Ptr(powerOnRoleDefinitionID(config))}},
+		{Properties: &armauthorization.RoleAssignmentProperties{}},
+	}
+	assert.False(t, hasRoleAssignment(assignments, "avd", powerOnContributorRoleID))
+
+	assignments = append(assignments, &armauthorization.RoleAssignment{Properties: &armauthorization.RoleAssignmentProperties{
+		PrincipalID: to.Ptr("avd"), RoleDefinitionID: to.Ptr("/subscriptions/sub/providers/Microsoft.Authorization/roleDefinitions/489581DE-A3BD-480D-9518-53DEA7416B33"),
+	}})
+	assert.True(t, hasRoleAssignment(assignments, "avd", powerOnContributorRoleID))
+}
+
+func TestStartVMOnConnectReport(t *testing.T) {
+	config := &StartVMOnConnectConfig{AVDServicePrincipalID: "avd", VMResourceGroupID: "/subscriptions/sub/resourceGroups/vms"}
+
+	report := &StartVMOnConnectReport{RoleAssigned: true}
+	assert.True(t, report.Ready())
+
+	report = &StartVMOnConnectReport{HostPoolsWithoutFlag: []string{"HP-Personal-alpha"}}
+	assert.False(t, report.Ready())
+
+	err := startVMOnConnectError(report, config)
+	assert.ErrorContains(t, err, "missing role "+powerOnContributorRoleID)
+	assert.ErrorContains(t, err, "disabled on host pools HP-Personal-alpha")
+}
@@ -2,12 +2,22 @@ package avd
 
 import (
 	"context"
+	"fmt"
 	"strings"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/desktopvirtualization/armdesktopvirtualization/v2"
 	"github.com/appliedres/cloudy"
+	"github.com/appliedres/cloudy/logging"
 )
 
+// SessionHostActivity summarizes the user sessions on the personal session host backing a VM
+type SessionHostActivity struct {
+	HostPoolName         string
+	SessionHostName      string
+	ActiveSessions       int
+	DisconnectedSessions int
+}
+
 func (avd *AzureVirtualDesktopManager) getUserSessionId(ctx context.Context, hostPoolName string, sessionHost string, upn string) (*string, error) {
 	pager := avd.userSessionsClient.NewListPager(avd.Credentials.ResourceGroup, hostPoolName, sessionHost, nil)
 	var all []*armdesktopvirtualization.UserSession
@@ -63,3 +73,105 @@ func (avd *AzureVirtualDesktopManager) DisconnectUserSession(ctx context.Context
 
 	return nil
 }
+
+// GetSessionHostActivity finds the personal session host backing a VM and counts its user sessions by state.
+// Returns nil if the VM is not registered as a session host in any personal host pool.
+func (avd *AzureVirtualDesktopManager) GetSessionHostActivity(ctx context.Context, vmID string) (*SessionHostActivity, error) {
+	log := logging.GetLogger(ctx).With("vmID", vmID)
+
+	hostPool, sessionHost, err := avd.findPersonalSessionHost(ctx, vmID)
+	if err != nil {
+		return nil, err
+	}
+	if sessionHost == nil {
+		log.DebugContext(ctx, "VM is not registered as a personal session host")
+		return nil, nil
+	}
+
+	_, sessionHostName, _, err := avd.ParseSessionHostName(ctx, sessionHost)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := avd.listUserSessions(ctx, *hostPool.Name, sessionHostName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list user sessions: %w", err)
+	}
+
+	activity := countSessionStates(sessions)
+	activity.HostPoolName = *hostPool.Name
+	activity.SessionHostName = sessionHostName
+
+	log.DebugContext(ctx, "Retrieved session host activity", "HostPool", activity.HostPoolName,
+		"active", activity.ActiveSessions, "disconnected", activity.DisconnectedSessions)
+	return activity, nil
+}
+
+// findPersonalSessionHost finds the personal host pool and session host backing a VM.
+// Returns nils if the VM is not registered as a session host in any personal host pool.
+func (avd *AzureVirtualDesktopManager) findPersonalSessionHost(ctx context.Context, vmID string) (*armdesktopvirtualization.HostPool, *armdesktopvirtualization.SessionHost, error) {
+	hpFilter := avd.Config.PersonalHostPoolNamePrefix
+	hostPools, err := avd.listHostPools(ctx, &hpFilter)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to retrieve host pools: %w", err)
+	}
+
+	for _, hostPool := range hostPools {
+		if hostPool.Name == nil {
+			continue
+		}
+
+		sessionHost, err := avd.FindSessionHostByVMNameInHostPool(ctx, *hostPool.Name, vmID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if sessionHost != nil {
+			return hostPool, sessionHost, nil
+		}
+	}
+
+	return nil, nil, nil
+}
+
+func (avd *AzureVirtualDesktopManager) listUserSessions(ctx context.Context, hostPoolName string, sessionHost string) ([]*armdesktopvirtualization.UserSession, error) {
+	pager := avd.userSessionsClient.NewListPager(avd.Credentials.ResourceGroup, hostPoolName, sessionHost, nil)
+	var all []*armdesktopvirtualization.UserSession
+	for pager.More() {
+		resp, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, resp.Value...)
+	}
+
+	return all, nil
+}
+
+func countSessionStates(sessions []*armdesktopvirtualization.UserSession) *SessionHostActivity {
+	activity := &SessionHostActivity{}
+	for _, session := range sessions {
+		if session == nil || session.Properties == nil || session.Properties.SessionState == nil {
+			continue
+		}
+
+		switch *session.Properties.SessionState {
+		case armdesktopvirtualization.SessionStateActive:
+			activity.ActiveSessions++
+		case armdesktopvirtualization.SessionStateDisconnected:
+			activity.DisconnectedSessions++
+		}
+	}
+
+	return activity
+}
+
+// CountHostPoolSessions counts the active and disconnected user sessions over all session hosts of a host pool
+func (avd *AzureVirtualDesktopManager) CountHostPoolSessions(ctx context.Context, hostPoolName string) (int, error) {
+	all, err := avd.listHostPoolUserSessions(ctx, hostPoolName)
+	if err != nil {
+		return 0, err
+	}
+
+	activity := countSessionStates(all)
+	return activity.ActiveSessions + activity.DisconnectedSessions, nil
+}
@@ -3,12 +3,10 @@ package avd
 import (
 	"context"
 	"fmt"
-	"sort"
 	"strings"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/authorization/armauthorization/v2"
-	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/desktopvirtualization/armdesktopvirtualization/v2"
 	"github.com/appliedres/cloudy"
 	"github.com/google/uuid"
 )
@@ -136,32 +134,6 @@ var phoneticIndex = func() map[string]int {
 	return m
 }()
 
-// sortHostPoolsByPhoneticSuffix sorts the slice in place so that the pools
-// are in phonetic order of the suffix:
-//
-//	ALPHA … ZULU           ← all single‑word names first
-//	ALPHA‑ALPHA … ZULU‑ZULU
-func (avd *AzureVirtualDesktopManager) sortHostPoolsByPhoneticSuffix(
-	hostPools []*armdesktopvirtualization.HostPool,
-) {
-	sort.SliceStable(hostPools, func(i, j int) bool {
-		si, errI := avd.extractSuffixFromHostPoolName(*hostPools[i].Name)
-		sj, errJ := avd.extractSuffixFromHostPoolName(*hostPools[j].Name)
-
-		// If either suffix is invalid, keep original order deterministically
-		if errI != nil && errJ != nil {
-			return i < j
-		}
-		if errI != nil {
-			return false
-		}
-		if errJ != nil {
-			return true
-		}
-		return phoneticLess(si, sj)
-	})
-}
-
 // phoneticLess returns true if a < b according to the required ordering.
 //
 //   - All single‑word suffixes come before ANY multi‑word suffix.
@@ -64,11 +64,11 @@ func (vdo *VirtualDesktopOrchestrator) startLinuxAVD(ctx context.Context, vm *cm
 	return err
 }
 
-func (vdo VirtualDesktopOrchestrator) stopLinuxAVD(ctx context.Context, vm *cm.VirtualMachine) error {
+func (vdo *VirtualDesktopOrchestrator) stopLinuxAVD(ctx context.Context, vm *cm.VirtualMachine) error {
 	return vdo.cleanupLinuxAVD(ctx, vm)
 }
 
-func (vdo VirtualDesktopOrchestrator) deleteLinuxAVD(ctx context.Context, vm *cm.VirtualMachine) error {
+func (vdo *VirtualDesktopOrchestrator) deleteLinuxAVD(ctx context.Context, vm *cm.VirtualMachine) error {
 	return vdo.cleanupLinuxAVD(ctx, vm)
 }
 
@@ -81,6 +81,12 @@ func (vdo *VirtualDesktopOrchestrator) cleanupLinuxAVD(ctx context.Context, vm *
 	ag := vdo.avdManager.Config.PooledAppGroupNamePrefix + suffix
 	ws := vdo.avdManager.Config.PooledWorkspaceNamePrefix + vdo.avdManager.Name
 
+	// the RemoteApp runs in the user's session on the pooled session hosts, which is shared with their other apps
+	pool := vdo.avdManager.Config.PooledHostPoolNamePrefix + vdo.avdManager.Name
+	if err := vdo.avdManager.WarnUserSessions(ctx, pool, vm.UserID); err != nil {
+		log.WarnContext(ctx, "Failed to warn user sessions", "vmID", vm.ID, "Error", err)
+	}
+
 	_ = vdo.avdManager.RemoveApplicationGroupFromWorkspace(ctx, ws, ag)
 	_ = vdo.avdManager.DeleteApplicationGroup(ctx, ag)
 
@@ -0,0 +1,67 @@
+package vdo
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/appliedres/cloudy-azure/vm"
+	"github.com/appliedres/cloudy/logging"
+	"github.com/appliedres/cloudy/models"
+)
+
+// StartMany starts the selected VMs, including their AVD setup. An error is only returned if the VMs can't be selected,
+// failures on individual VMs are reported in the BulkReport.
+func (vdo *VirtualDesktopOrchestrator) StartMany(ctx context.Context, selector vm.VirtualMachineSelector, options vm.BulkOptions) (*vm.BulkReport, error) {
+	return vdo.runMany(ctx, "StartMany", selector, options, vdo.StartVirtualMachine)
+}
+
+// StopMany deallocates the selected VMs and cleans up their AVD resources, reporting failures per VM
+func (vdo *VirtualDesktopOrchestrator) StopMany(ctx context.Context, selector vm.VirtualMachineSelector, options vm.BulkOptions) (*vm.BulkReport, error) {
+	return vdo.runMany(ctx, "StopMany", selector, options, vdo.StopVirtualMachine)
+}
+
+// DeleteMany deletes the selected VMs and cleans up their AVD resources, reporting failures per VM
+func (vdo *VirtualDesktopOrchestrator) DeleteMany(ctx context.Context, selector vm.VirtualMachineSelector, options vm.BulkOptions) (*vm.BulkReport, error) {
+	return vdo.runMany(ctx, "DeleteMany", selector, options, vdo.DeleteVirtualMachine)
+}
+
+// RunCommandMany runs a script on the selected VMs, using windowsScript on Windows VMs and linuxScript on Linux VMs
+func (vdo *VirtualDesktopOrchestrator) RunCommandMany(ctx context.Context, selector vm.VirtualMachineSelector, windowsScript, linuxScript *string, timeout time.Duration, options vm.BulkOptions) (*vm.BulkReport, error) {
+	log := logging.GetLogger(ctx)
+
+	report, err := vdo.vmManager.RunCommandMany(ctx, selector, windowsScript, linuxScript, timeout, options)
+	if err != nil {
+		return nil, logging.LogAndWrapErr(ctx, log, err, "RunCommandMany failed to select VMs")
+	}
+
+	return report, nil
+}
+
+// runMany runs a VM lifecycle action on each selected VM. The VM is looked up first, as the actions depend on its OS.
+func (vdo *VirtualDesktopOrchestrator) runMany(ctx context.Context, name string, selector vm.VirtualMachineSelector, options vm.BulkOptions,
+	action func(ctx context.Context, vm *models.VirtualMachine) error) (*vm.BulkReport, error) {
+	log := logging.GetLogger(ctx).With("operation", name)
+	log.InfoContext(ctx, "VM Orchestrator - bulk operation starting")
+
+	ids, err := vdo.vmManager.SelectVirtualMachines(ctx, selector)
+	if err != nil {
+		return nil, logging.LogAndWrapErr(ctx, log, err, fmt.Sprintf("%s failed to select VMs", name))
+	}
+
+	report := vm.RunBulk(ctx, ids, options, func(ctx context.Context, vmID string) (string, error) {
+		virtualMachine, err := vdo.vmManager.GetVirtualMachine(ctx, vmID, false)
+		if err != nil {
+			return "", err
+		}
+		if virtualMachine == nil {
+			return "", fmt.Errorf("VM [%s] not found", vmID)
+		}
+		normalizeOperatingSystem(virtualMachine)
+
+		return "", action(ctx, virtualMachine)
+	})
+
+	log.InfoContext(ctx, "VM Orchestrator - bulk operation complete", "succeeded", len(report.Succeeded()), "failed", len(report.Failed()))
+	return report, nil
+}
@@ -1,8 +1,12 @@
 package vdo
 
 import (
+	"context"
+	"time"
+
 	"github.com/appliedres/cloudy-azure/avd"
 	"github.com/appliedres/cloudy-azure/vm"
+	"github.com/appliedres/cloudy/models"
 )
 
 type VirtualDesktopOrchestratorConfig struct {
@@ -12,6 +16,48 @@ type VirtualDesktopOrchestratorConfig struct {
 	SaltMinionInstall               *SaltMinionInstallConfig      // optional, nil disables Salt Minion install
 	BinaryStorage                   *InstallerBinaryStorageConfig // optional, nil disables all software installation (AVD / Salt Minion)
 	RestartVirtualMachineAfterSetup bool                          // Whether to restart the VM after setup
+	IdleShutdown                    *IdleShutdownConfig           // optional, nil disables idle VM detection and deallocation
+	SessionHostPlacement            *SessionHostPlacementConfig   // optional, nil creates regional session hosts
+	StopPolicy                      StopPolicy                    // how VMs are stopped, defaults to StopPolicyDeallocate
+	SessionHostEphemeralOSDisk      string                        // optional, ephemeral OS disk placement for pooled session hosts ("CacheDisk", "ResourceDisk" or "NvmeDisk"), empty uses a managed OS disk
+	PooledDesktop                   *PooledDesktopScalingConfig   // optional, nil leaves the session hosts of the pooled desktop host pool unmanaged
+}
+
+// PooledDesktopScalingConfig defines the session hosts of the pooled Windows desktop host pool and how many are kept running.
+// Each host serves up to the MaxSessionLimit of the AVD pooled desktop config.
+type PooledDesktopScalingConfig struct {
+	ImageID       string        // multi-session image of the session hosts, e.g. "marketplace::microsoftwindowsdesktop::windows-11::win11-24h2-avd::latest"
+	SizeID        string        // optional, VM size of the session hosts, defaults to the RemoteApp session host size
+	MinHosts      int           // session hosts kept running without any sessions
+	MaxHosts      int           // upper bound of session hosts, sessions beyond their capacity are refused by AVD
+	CheckInterval time.Duration // how often capacity is checked, defaults to 5 minutes
+}
+
+// StopPolicy selects how StopVirtualMachine stops a VM
+type StopPolicy string
+
+const (
+	StopPolicyDeallocate StopPolicy = "deallocate" // deallocate the VM, losing any open work
+	StopPolicyHibernate  StopPolicy = "hibernate"  // hibernate VMs that have hibernation enabled, deallocate the others
+)
+
+// SessionHostPlacementConfig defines where pooled session host VMs are placed
+type SessionHostPlacementConfig struct {
+	Zone                      string // availability zone to pin session hosts to, or vm.ZoneAuto to spread them across the zones of the size
+	ProximityPlacementGroupID string // optional, resource ID of a proximity placement group for all session hosts
+}
+
+// IdleShutdownConfig defines the policy for detecting idle user VMs and deallocating them
+type IdleShutdownConfig struct {
+	IdleThreshold time.Duration // how long a VM must be idle before it is deallocated
+	GracePeriod   time.Duration // time between the idle warning and deallocation, zero deallocates without warning
+	CheckInterval time.Duration // how often VMs are checked, defaults to 5 minutes
+
+	CPUThresholdPercent *float64 // optional, nil disables the CPU check. VMs averaging at or above this over IdleThreshold are not idle
+	ExemptTeamIDs       []string // VMs belonging to these teams are never deallocated
+
+	// optional, called once when a VM enters its grace period
+	OnIdleWarning func(ctx context.Context, vm models.VirtualMachine, deallocateAt time.Time)
 }
 
 // ADJoinConfig defines the settings required for Active Directory Join
@@ -20,6 +66,7 @@ type ADJoinConfig struct {
 	DomainUsername         string
 	DomainPassword         string
 	OrganizationalUnitPath *string // optional, nil is not specified
+	UseDomainJoinExtension bool    // join Windows VMs with the JsonADDomainExtension instead of the setup script
 }
 
 // SaltMinionInstallConfig defines the settings required for Salt Minion installation
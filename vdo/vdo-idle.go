@@ -0,0 +1,319 @@
+package vdo
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/appliedres/cloudy/logging"
+	"github.com/appliedres/cloudy/models"
+)
+
+const defaultIdleCheckInterval = 5 * time.Minute
+
+// idleState tracks when a VM was first seen idle and when its user was warned
+type idleState struct {
+	idleSince time.Time
+	warnedAt  time.Time // zero if no warning has been sent
+}
+
+type idleAction int
+
+const (
+	idleActionNone idleAction = iota
+	idleActionWarn
+	idleActionDeallocate
+)
+
+// IdleReport summarizes the outcome of a single idle check, keyed by VM ID
+type IdleReport struct {
+	Checked     int
+	Active      []string
+	Idle        []string // idle, but not yet past the threshold or grace period
+	Warned      []string
+	Deallocated []string
+	Exempt      []string
+	Errors      map[string]error
+}
+
+func validateIdleShutdownConfig(cfg *IdleShutdownConfig) error {
+	if cfg == nil {
+		return nil
+	}
+	if cfg.IdleThreshold <= 0 {
+		return fmt.Errorf("idle shutdown: IdleThreshold must be greater than zero")
+	}
+	if cfg.GracePeriod < 0 {
+		return fmt.Errorf("idle shutdown: GracePeriod cannot be negative")
+	}
+	if cfg.CPUThresholdPercent != nil && (*cfg.CPUThresholdPercent <= 0 || *cfg.CPUThresholdPercent > 100) {
+		return fmt.Errorf("idle shutdown: CPUThresholdPercent must be between 0 and 100")
+	}
+
+	return nil
+}
+
+// StartIdleReaper checks for idle VMs every CheckInterval until ctx is cancelled.
+// Does nothing if idle shutdown is not configured.
+func (vdo *VirtualDesktopOrchestrator) StartIdleReaper(ctx context.Context) {
+	log := logging.GetLogger(ctx)
+
+	policy := vdo.config.IdleShutdown
+	if policy == nil {
+		log.DebugContext(ctx, "Idle shutdown not configured, idle reaper disabled")
+		return
+	}
+
+	interval := policy.CheckInterval
+	if interval <= 0 {
+		interval = defaultIdleCheckInterval
+	}
+
+	log.InfoContext(ctx, "Starting idle reaper", "interval", interval, "threshold", policy.IdleThreshold, "gracePeriod", policy.GracePeriod)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				log.InfoContext(ctx, "Idle reaper stopped")
+				return
+			case <-ticker.C:
+				if _, err := vdo.ReapIdleVirtualMachines(ctx); err != nil {
+					log.WarnContext(ctx, "Idle check failed", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// ReapIdleVirtualMachines checks every running user VM for activity, warns the owners of VMs that have been idle
+// past the threshold, and deallocates VMs whose grace period has expired.
+func (vdo *VirtualDesktopOrchestrator) ReapIdleVirtualMachines(ctx context.Context) (*IdleReport, error) {
+	log := logging.GetLogger(ctx)
+	log.DebugContext(ctx, "ReapIdleVirtualMachines starting")
+	defer log.DebugContext(ctx, "ReapIdleVirtualMachines complete")
+
+	policy := vdo.config.IdleShutdown
+	if policy == nil {
+		return nil, fmt.Errorf("idle shutdown is not configured")
+	}
+
+	vdo.idleLock.Lock()
+	defer vdo.idleLock.Unlock()
+
+	vms, err := vdo.getUserVirtualMachinesWithState(ctx)
+	if err != nil {
+		return nil, logging.LogAndWrapErr(ctx, log, err, "ReapIdleVirtualMachines failed to list VMs")
+	}
+
+	report := &IdleReport{
+		Errors: map[string]error{},
+	}
+	seen := map[string]bool{}
+	now := time.Now()
+
+	for _, vm := range vms {
+		if vm.CloudState == nil || *vm.CloudState != models.VirtualMachineCloudStateRunning {
+			vdo.idleTracker.Delete(vm.ID)
+			continue
+		}
+		seen[vm.ID] = true
+		report.Checked++
+
+		if vm.TeamID != "" && slices.Contains(policy.ExemptTeamIDs, vm.TeamID) {
+			vdo.idleTracker.Delete(vm.ID)
+			report.Exempt = append(report.Exempt, vm.ID)
+			continue
+		}
+
+		vmLog := log.With("vmID", vm.ID)
+
+		active, err := vdo.isVirtualMachineActive(ctx, vm, policy)
+		if err != nil {
+			// never deallocate a VM whose activity could not be determined
+			vmLog.WarnContext(ctx, "Unable to determine VM activity", "error", err)
+			report.Errors[vm.ID] = err
+			continue
+		}
+
+		var current *idleState
+		if v, ok := vdo.idleTracker.Load(vm.ID); ok {
+			current = v.(*idleState)
+		}
+
+		next, action := evaluateIdle(now, current, active, policy.IdleThreshold, policy.GracePeriod)
+		if next == nil {
+			vdo.idleTracker.Delete(vm.ID)
+		} else {
+			vdo.idleTracker.Store(vm.ID, next)
+		}
+
+		switch action {
+		case idleActionWarn:
+			deallocateAt := next.warnedAt.Add(policy.GracePeriod)
+			vmLog.InfoContext(ctx, "VM is idle, deallocation scheduled", "idleSince", next.idleSince, "deallocateAt", deallocateAt)
+			if policy.OnIdleWarning != nil {
+				policy.OnIdleWarning(ctx, vm, deallocateAt)
+			}
+			report.Warned = append(report.Warned, vm.ID)
+
+		case idleActionDeallocate:
+			vmLog.InfoContext(ctx, "Deallocating idle VM", "idleSince", next.idleSince)
+			if err := vdo.StopVirtualMachine(ctx, &vm); err != nil {
+				vmLog.WarnContext(ctx, "Failed to deallocate idle VM", "error", err)
+				report.Errors[vm.ID] = err
+				continue
+			}
+			vdo.idleTracker.Delete(vm.ID)
+			report.Deallocated = append(report.Deallocated, vm.ID)
+
+		default:
+			if active {
+				report.Active = append(report.Active, vm.ID)
+			} else {
+				report.Idle = append(report.Idle, vm.ID)
+			}
+		}
+	}
+
+	// forget VMs that were stopped or deleted since the last check
+	vdo.idleTracker.Range(func(key, _ any) bool {
+		if !seen[key.(string)] {
+			vdo.idleTracker.Delete(key)
+		}
+		return true
+	})
+
+	log.InfoContext(ctx, "Idle check complete", "checked", report.Checked, "active", len(report.Active), "idle", len(report.Idle),
+		"warned", len(report.Warned), "deallocated", len(report.Deallocated), "exempt", len(report.Exempt), "errors", len(report.Errors))
+
+	return report, nil
+}
+
+// evaluateIdle determines the next idle state of a VM and the action to take.
+// A nil state means the VM is no longer tracked as idle.
+func evaluateIdle(now time.Time, current *idleState, active bool, threshold, grace time.Duration) (*idleState, idleAction) {
+	if active {
+		return nil, idleActionNone
+	}
+
+	if current == nil {
+		return &idleState{idleSince: now}, idleActionNone
+	}
+
+	next := *current
+	if now.Sub(next.idleSince) < threshold {
+		return &next, idleActionNone
+	}
+
+	if grace <= 0 {
+		return &next, idleActionDeallocate
+	}
+
+	if next.warnedAt.IsZero() {
+		next.warnedAt = now
+		return &next, idleActionWarn
+	}
+
+	if now.Sub(next.warnedAt) >= grace {
+		return &next, idleActionDeallocate
+	}
+
+	return &next, idleActionNone
+}
+
+// isVirtualMachineActive reports whether a user is working on the VM.
+// Windows VMs registered in AVD use the AVD session state; all other VMs are queried with RunCommand.
+// If a CPU threshold is configured, a busy VM is considered active even without a logged on user.
+func (vdo *VirtualDesktopOrchestrator) isVirtualMachineActive(ctx context.Context, vm models.VirtualMachine, policy *IdleShutdownConfig) (bool, error) {
+	log := logging.GetLogger(ctx).With("vmID", vm.ID)
+
+	windows := strings.EqualFold(vm.Template.OperatingSystem, models.VirtualMachineTemplateOperatingSystemWindows)
+
+	sessionChecked := false
+	if windows && vdo.avdManager != nil {
+		activity, err := vdo.avdManager.GetSessionHostActivity(ctx, vm.ID)
+		if err != nil {
+			return false, err
+		}
+		if activity != nil {
+			sessionChecked = true
+			if activity.ActiveSessions > 0 {
+				log.DebugContext(ctx, "VM has active AVD sessions", "count", activity.ActiveSessions)
+				return true, nil
+			}
+		}
+	}
+
+	if !sessionChecked {
+		count, err := vdo.vmManager.GetActiveSessionCount(ctx, vm.ID, windows)
+		if err != nil {
+			return false, err
+		}
+		if count > 0 {
+			log.DebugContext(ctx, "VM has logged on users", "count", count)
+			return true, nil
+		}
+	}
+
+	if policy.CPUThresholdPercent != nil {
+		average, err := vdo.vmManager.GetAverageCPUPercent(ctx, vm.ID, policy.IdleThreshold)
+		if err != nil {
+			return false, err
+		}
+		if average != nil && *average >= *policy.CPUThresholdPercent {
+			log.DebugContext(ctx, "VM CPU is above idle threshold", "average", *average, "threshold", *policy.CPUThresholdPercent)
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// getUserVirtualMachinesWithState lists all user VMs with both their full model and their power state.
+// The Azure list API only returns one or the other, so both are requested and merged.
+func (vdo *VirtualDesktopOrchestrator) getUserVirtualMachinesWithState(ctx context.Context) ([]models.VirtualMachine, error) {
+	vms, err := vdo.vmManager.GetAllUserVirtualMachines(ctx, nil, false)
+	if err != nil {
+		return nil, err
+	}
+
+	states, err := vdo.vmManager.GetAllUserVirtualMachines(ctx, nil, true)
+	if err != nil {
+		return nil, err
+	}
+
+	stateByID := map[string]*models.VirtualMachineCloudState{}
+	for _, s := range *states {
+		stateByID[s.ID] = s.CloudState
+	}
+
+	var rtn []models.VirtualMachine
+	for _, vm := range *vms {
+		vm.CloudState = stateByID[vm.ID]
+		normalizeOperatingSystem(&vm)
+		rtn = append(rtn, vm)
+	}
+
+	return rtn, nil
+}
+
+// Azure reports the OS type as "Windows" or "Linux". Map it back to the template values so the
+// stop flow runs the correct cleanup. Debian and RHEL are handled identically when stopping.
+func normalizeOperatingSystem(vm *models.VirtualMachine) {
+	if vm.Template == nil {
+		vm.Template = &models.VirtualMachineTemplate{}
+	}
+
+	switch {
+	case strings.EqualFold(vm.Template.OperatingSystem, "windows"):
+		vm.Template.OperatingSystem = models.VirtualMachineTemplateOperatingSystemWindows
+	case strings.EqualFold(vm.Template.OperatingSystem, "linux"):
+		vm.Template.OperatingSystem = models.VirtualMachineTemplateOperatingSystemLinuxDeb
+	}
+}
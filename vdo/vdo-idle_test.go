@@ -0,0 +1,82 @@
+package vdo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvaluateIdle(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	threshold := 30 * time.Minute
+	grace := 10 * time.Minute
+
+	tests := []struct {
+		name          string
+		current       *idleState
+		active        bool
+		grace         time.Duration
+		expectedState *idleState
+		expectedAct   idleAction
+	}{
+		{
+			name:          "active VM is not tracked",
+			current:       &idleState{idleSince: now.Add(-time.Hour)},
+			active:        true,
+			grace:         grace,
+			expectedState: nil,
+			expectedAct:   idleActionNone,
+		},
+		{
+			name:          "first idle observation starts tracking",
+			current:       nil,
+			grace:         grace,
+			expectedState: &idleState{idleSince: now},
+			expectedAct:   idleActionNone,
+		},
+		{
+			name:          "idle below threshold",
+			current:       &idleState{idleSince: now.Add(-10 * time.Minute)},
+			grace:         grace,
+			expectedState: &idleState{idleSince: now.Add(-10 * time.Minute)},
+			expectedAct:   idleActionNone,
+		},
+		{
+			name:          "idle past threshold is warned",
+			current:       &idleState{idleSince: now.Add(-threshold)},
+			grace:         grace,
+			expectedState: &idleState{idleSince: now.Add(-threshold), warnedAt: now},
+			expectedAct:   idleActionWarn,
+		},
+		{
+			name:          "within grace period",
+			current:       &idleState{idleSince: now.Add(-time.Hour), warnedAt: now.Add(-5 * time.Minute)},
+			grace:         grace,
+			expectedState: &idleState{idleSince: now.Add(-time.Hour), warnedAt: now.Add(-5 * time.Minute)},
+			expectedAct:   idleActionNone,
+		},
+		{
+			name:          "grace period expired",
+			current:       &idleState{idleSince: now.Add(-time.Hour), warnedAt: now.Add(-grace)},
+			grace:         grace,
+			expectedState: &idleState{idleSince: now.Add(-time.Hour), warnedAt: now.Add(-grace)},
+			expectedAct:   idleActionDeallocate,
+		},
+		{
+			name:          "no grace period deallocates immediately",
+			current:       &idleState{idleSince: now.Add(-threshold)},
+			grace:         0,
+			expectedState: &idleState{idleSince: now.Add(-threshold)},
+			expectedAct:   idleActionDeallocate,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state, action := evaluateIdle(now, test.current, test.active, threshold, test.grace)
+			assert.Equal(t, test.expectedState, state)
+			assert.Equal(t, test.expectedAct, action)
+		})
+	}
+}
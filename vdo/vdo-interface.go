@@ -17,18 +17,34 @@ func (vdo *VirtualDesktopOrchestrator) CreateVirtualMachine(ctx context.Context,
 	log.InfoContext(ctx, "VM Orchestrator - CreateVirtualMachine starting")
 	defer log.InfoContext(ctx, "VM Orchestrator - CreateVirtualMachine complete")
 
+	var created *models.VirtualMachine
+	var err error
 	switch vm.Template.OperatingSystem {
 	case models.VirtualMachineTemplateOperatingSystemWindows:
-		return vdo.createWindowsVM(ctx, vm)
+		created, err = vdo.createWindowsVM(ctx, vm)
 	case models.VirtualMachineTemplateOperatingSystemLinuxDeb, models.VirtualMachineTemplateOperatingSystemLinuxRhel:
 		if linuxAVDEnabled {
-			return vdo.createLinuxVMWithAVD(ctx, vm)
+			created, err = vdo.createLinuxVMWithAVD(ctx, vm)
 		} else {
-			return vdo.createBasicLinuxVM(ctx, vm)
+			created, err = vdo.createBasicLinuxVM(ctx, vm)
 		}
 	default:
 		return nil, logging.LogAndWrapErr(ctx, log, nil, "CreateVirtualMachine failed: unsupported OS type")
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	// VMs not connected through AVD are connected with the VM manager's connection mode
+	if created.Connect == nil {
+		err = vdo.vmManager.ConnectVirtualMachine(ctx, created)
+		if err != nil {
+			// the VM exists, return it so the caller can retry connecting or delete it
+			return created, logging.LogAndWrapErr(ctx, log, err, "CreateVirtualMachine failed to connect VM")
+		}
+	}
+
+	return created, nil
 }
 
 func (vdo *VirtualDesktopOrchestrator) StartVirtualMachine(ctx context.Context, vm *models.VirtualMachine) error {
@@ -36,6 +52,11 @@ func (vdo *VirtualDesktopOrchestrator) StartVirtualMachine(ctx context.Context,
 	log.InfoContext(ctx, "StartVirtualMachine starting")
 	defer log.InfoContext(ctx, "StartVirtualMachine complete")
 
+	if vdo.startsOnConnect(ctx, vm) {
+		log.InfoContext(ctx, "StartVirtualMachine skipped, AVD starts the VM when its user connects", "vmID", vm.ID)
+		return nil
+	}
+
 	// Physically start the VM first
 	err := vdo.vmManager.StartVirtualMachine(ctx, vm.ID)
 	if err != nil {
@@ -52,13 +73,39 @@ func (vdo *VirtualDesktopOrchestrator) StartVirtualMachine(ctx context.Context,
 	return nil
 }
 
+// startsOnConnect returns whether AVD starts a personal Windows VM when its user connects.
+// Any doubt falls back to starting the VM here.
+func (vdo *VirtualDesktopOrchestrator) startsOnConnect(ctx context.Context, vm *models.VirtualMachine) bool {
+	if vdo.avdManager == nil || vdo.avdManager.Config.StartVMOnConnect == nil ||
+		vm.Template == nil || vm.Template.OperatingSystem != models.VirtualMachineTemplateOperatingSystemWindows {
+		return false
+	}
+
+	starts, err := vdo.avdManager.StartsOnConnect(ctx, vm.ID)
+	if err != nil {
+		logging.GetLogger(ctx).WarnContext(ctx, "Could not check start VM on connect, starting the VM", "vmID", vm.ID, "Error", err)
+		return false
+	}
+	return starts
+}
+
 func (vdo *VirtualDesktopOrchestrator) StopVirtualMachine(ctx context.Context, vm *models.VirtualMachine) error {
 	log := logging.GetLogger(ctx)
 	log.InfoContext(ctx, "StopVirtualMachine starting")
 	defer log.InfoContext(ctx, "StopVirtualMachine complete")
 
+	drained := vdo.drainPersonalSessionHost(ctx, vm)
+
 	// First stop the VM
-	err := vdo.vmManager.StopVirtualMachine(ctx, vm.ID)
+	err := vdo.stopOrHibernate(ctx, vm.ID)
+
+	if drained {
+		// the stopped session host accepts sessions again, so its user can connect once it is started
+		if resumeErr := vdo.avdManager.ResumeVirtualMachineSessions(ctx, vm.ID); resumeErr != nil {
+			log.WarnContext(ctx, "Failed to take session host out of drain mode", "vmID", vm.ID, "Error", resumeErr)
+		}
+	}
+
 	if err != nil {
 		return logging.LogAndWrapErr(ctx, log, err, "StopVirtualMachine failed to stop VM")
 	}
@@ -74,6 +121,42 @@ func (vdo *VirtualDesktopOrchestrator) StopVirtualMachine(ctx context.Context, v
 	return nil
 }
 
+// drainPersonalSessionHost warns and logs off the users of a personal Windows VM before it is stopped or deleted,
+// returning whether its session host was drained. Failures are logged, and do not prevent the VM from stopping.
+func (vdo *VirtualDesktopOrchestrator) drainPersonalSessionHost(ctx context.Context, vm *models.VirtualMachine) bool {
+	if vdo.avdManager == nil || vdo.avdManager.Config.GracefulShutdown == nil ||
+		vm.Template == nil || vm.Template.OperatingSystem != models.VirtualMachineTemplateOperatingSystemWindows {
+		return false
+	}
+
+	err := vdo.avdManager.DrainVirtualMachine(ctx, vm.ID)
+	if err != nil {
+		logging.GetLogger(ctx).WarnContext(ctx, "Failed to drain session host", "vmID", vm.ID, "Error", err)
+	}
+	return true
+}
+
+// stopOrHibernate stops a VM according to the stop policy
+func (vdo *VirtualDesktopOrchestrator) stopOrHibernate(ctx context.Context, vmID string) error {
+	log := logging.GetLogger(ctx)
+
+	if vdo.config.StopPolicy == StopPolicyHibernate {
+		enabled, err := vdo.vmManager.IsHibernationEnabled(ctx, vmID)
+		if err != nil {
+			return err
+		}
+
+		if enabled {
+			log.InfoContext(ctx, "Hibernating VM", "vmID", vmID)
+			return vdo.vmManager.HibernateVirtualMachine(ctx, vmID)
+		}
+
+		log.InfoContext(ctx, "VM does not have hibernation enabled, deallocating", "vmID", vmID)
+	}
+
+	return vdo.vmManager.StopVirtualMachine(ctx, vmID)
+}
+
 func (vdo *VirtualDesktopOrchestrator) DeleteVirtualMachine(ctx context.Context, vm *models.VirtualMachine) error {
 	log := logging.GetLogger(ctx)
 	log.InfoContext(ctx, "DeleteVirtualMachine starting")
@@ -85,6 +168,8 @@ func (vdo *VirtualDesktopOrchestrator) DeleteVirtualMachine(ctx context.Context,
 		_ = vdo.cleanupLinuxAVD(ctx, vm)
 	}
 
+	vdo.drainPersonalSessionHost(ctx, vm)
+
 	err := vdo.vmManager.DeleteVirtualMachine(ctx, vm.ID)
 	if err != nil {
 		return logging.LogAndWrapErr(ctx, log, err, "DeleteVirtualMachine failed during deletion")
@@ -0,0 +1,98 @@
+package vdo
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	logging "github.com/appliedres/cloudy/logging"
+)
+
+const defaultPooledDesktopCheckInterval = 5 * time.Minute
+
+func validatePooledDesktopScalingConfig(cfg *PooledDesktopScalingConfig, avdConfig *AVDConfig) error {
+	if cfg == nil {
+		return nil
+	}
+
+	if avdConfig == nil || avdConfig.AVDManagerConfig.PooledDesktop == nil {
+		return fmt.Errorf("pooled desktop: scaling requires the AVD pooled desktop config")
+	}
+	if avdConfig.AVDManagerConfig.PooledDesktop.HostPool.MaxSessionLimit <= 0 {
+		return fmt.Errorf("pooled desktop: scaling requires a MaxSessionLimit on the host pool")
+	}
+	if cfg.ImageID == "" {
+		return fmt.Errorf("pooled desktop: ImageID is required")
+	}
+	if cfg.MinHosts < 0 {
+		return fmt.Errorf("pooled desktop: MinHosts cannot be negative")
+	}
+	if cfg.MaxHosts <= 0 || cfg.MaxHosts < cfg.MinHosts {
+		return fmt.Errorf("pooled desktop: MaxHosts must be positive and at least MinHosts")
+	}
+
+	return nil
+}
+
+// desktopPool is the pooled Windows desktop host pool, whose demand is its user sessions
+func (vdo *VirtualDesktopOrchestrator) desktopPool() pooledHostPool {
+	pool := vdo.avdManager.PooledDesktopHostPoolName()
+	return pooledHostPool{
+		name: pool,
+		scale: scaleCfg{
+			MaxSessionsPerHost: vdo.avdManager.Config.PooledDesktop.HostPool.MaxSessionLimit,
+			MinHosts:           vdo.config.PooledDesktop.MinHosts,
+			MaxHosts:           vdo.config.PooledDesktop.MaxHosts,
+			DeleteOnScaleDown:  true,
+			ScaleDownIdle:      true,
+		},
+		demand: func(ctx context.Context) (int, error) {
+			return vdo.avdManager.CountHostPoolSessions(ctx, pool)
+		},
+	}
+}
+
+// StartPooledDesktopScaler scales the pooled desktop session hosts every CheckInterval until ctx is cancelled.
+// Does nothing if pooled desktop scaling is not configured.
+func (vdo *VirtualDesktopOrchestrator) StartPooledDesktopScaler(ctx context.Context) {
+	log := logging.GetLogger(ctx)
+
+	scaling := vdo.config.PooledDesktop
+	if scaling == nil || vdo.avdManager == nil {
+		log.DebugContext(ctx, "Pooled desktop scaling not configured, scaler disabled")
+		return
+	}
+
+	interval := scaling.CheckInterval
+	if interval <= 0 {
+		interval = defaultPooledDesktopCheckInterval
+	}
+
+	log.InfoContext(ctx, "Starting pooled desktop scaler", "interval", interval, "minHosts", scaling.MinHosts, "maxHosts", scaling.MaxHosts)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				log.InfoContext(ctx, "Pooled desktop scaler stopped")
+				return
+			case <-ticker.C:
+				if err := vdo.ScalePooledDesktops(ctx); err != nil {
+					log.WarnContext(ctx, "Pooled desktop scaling failed", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// ScalePooledDesktops scales the session hosts of the pooled desktop host pool to its current user sessions
+func (vdo *VirtualDesktopOrchestrator) ScalePooledDesktops(ctx context.Context) error {
+	if vdo.config.PooledDesktop == nil || vdo.avdManager == nil {
+		return fmt.Errorf("pooled desktop scaling is not configured")
+	}
+
+	return vdo.ensurePoolCapacity(ctx, vdo.desktopPool())
+}
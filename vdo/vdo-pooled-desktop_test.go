@@ -0,0 +1,69 @@
+package vdo
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/desktopvirtualization/armdesktopvirtualization/v2"
+	"github.com/appliedres/cloudy-azure/avd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequiredHosts(t *testing.T) {
+	scale := scaleCfg{MaxSessionsPerHost: 4, MinHosts: 1, MaxHosts: 3}
+
+	assert.Equal(t, 1, requiredHosts(0, scale))
+	assert.Equal(t, 1, requiredHosts(3, scale))
+	assert.Equal(t, 2, requiredHosts(4, scale))
+	assert.Equal(t, 3, requiredHosts(50, scale))
+	assert.Equal(t, 2, requiredHosts(0, scaleCfg{MaxSessionsPerHost: 4, MinHosts: 2, MaxHosts: 3}))
+}
+
+func TestIdleHostsAboveCapacity(t *testing.T) {
+	host := func(name string, sessions int32) *armdesktopvirtualization.SessionHost {
+		return &armdesktopvirtualization.SessionHost{
+			Name:       to.Ptr("pool/" + name),
+			Properties: &armdesktopvirtualization.SessionHostProperties{Sessions: to.Ptr(sessions)},
+		}
+	}
+	busy, idle1, idle2 := host("shvm-1", 2), host("shvm-2", 0), host("shvm-3", 0)
+	up := []*armdesktopvirtualization.SessionHost{busy, idle1, idle2}
+	scale := scaleCfg{MaxSessionsPerHost: 4, MinHosts: 1, MaxHosts: 5}
+
+	assert.Equal(t, []*armdesktopvirtualization.SessionHost{idle2, idle1}, idleHostsAboveCapacity(up, 0, 1, scale))
+	assert.Equal(t, []*armdesktopvirtualization.SessionHost{idle2}, idleHostsAboveCapacity(up, 1, 3, scale))
+	assert.Empty(t, idleHostsAboveCapacity(up, 0, 3, scale))
+
+	// MinHosts running hosts are kept, even when pending hosts cover the demand
+	scale.MinHosts = 3
+	assert.Empty(t, idleHostsAboveCapacity(up, 2, 1, scale))
+}
+
+func TestValidatePooledDesktopScalingConfig(t *testing.T) {
+	avdConfig := &AVDConfig{AVDManagerConfig: avd.AzureVirtualDesktopManagerConfig{
+		PooledDesktop: &avd.PooledDesktopConfig{HostPool: avd.HostPoolConfig{MaxSessionLimit: 8}},
+	}}
+	valid := PooledDesktopScalingConfig{ImageID: "marketplace::microsoftwindowsdesktop::windows-11::win11-24h2-avd::latest", MinHosts: 1, MaxHosts: 4}
+
+	assert.NoError(t, validatePooledDesktopScalingConfig(nil, nil))
+	assert.NoError(t, validatePooledDesktopScalingConfig(&valid, avdConfig))
+	assert.Error(t, validatePooledDesktopScalingConfig(&valid, nil))
+	assert.Error(t, validatePooledDesktopScalingConfig(&valid, &AVDConfig{}))
+
+	tests := map[string]func(c *PooledDesktopScalingConfig){
+		"no image":         func(c *PooledDesktopScalingConfig) { c.ImageID = "" },
+		"negative minimum": func(c *PooledDesktopScalingConfig) { c.MinHosts = -1 },
+		"no maximum":       func(c *PooledDesktopScalingConfig) { c.MaxHosts = 0 },
+		"maximum too low":  func(c *PooledDesktopScalingConfig) { c.MinHosts, c.MaxHosts = 3, 2 },
+	}
+	for name, mutate := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := valid
+			mutate(&cfg)
+			assert.Error(t, validatePooledDesktopScalingConfig(&cfg, avdConfig))
+		})
+	}
+
+	avdConfig.AVDManagerConfig.PooledDesktop.HostPool.MaxSessionLimit = 0
+	assert.Error(t, validatePooledDesktopScalingConfig(&valid, avdConfig))
+}
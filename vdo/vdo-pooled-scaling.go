@@ -4,11 +4,13 @@ import (
 	"context"
 	"fmt"
 	"math"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/desktopvirtualization/armdesktopvirtualization/v2"
+	"github.com/appliedres/cloudy-azure/avd"
 	logging "github.com/appliedres/cloudy/logging"
 	"github.com/appliedres/cloudy/models"
 	"golang.org/x/sync/errgroup"
@@ -22,7 +24,8 @@ var (
 type scaleCfg struct {
 	MaxSessionsPerHost int32
 	MinHosts, MaxHosts int
-	DeleteOnScaleDown  bool
+	DeleteOnScaleDown  bool // hosts removed by scale-down are deleted instead of deallocated
+	ScaleDownIdle      bool // running hosts without sessions above the required capacity are removed, down to MinHosts
 }
 
 // TODO: move to vdo config
@@ -66,6 +69,14 @@ func (vdo *VirtualDesktopOrchestrator) purgeStaleHost(ctx context.Context, host
 		log.DebugContext(ctx, "Normalized host name", "from", original, "to", hostName)
 	}
 
+	// users still signed in are warned and logged off first
+	if host.Properties != nil && host.Properties.Sessions != nil && *host.Properties.Sessions > 0 {
+		log.DebugContext(ctx, "Draining session host with open sessions", "pool", poolName, "host", hostName)
+		if err := vdo.avdManager.DrainSessionHost(ctx, poolName, hostName); err != nil {
+			log.WarnContext(ctx, "Failed to drain session host", "pool", poolName, "host", hostName, "err", err)
+		}
+	}
+
 	// remove session host from AVD
 	log.DebugContext(ctx, "Deleting session host from AVD", "pool", poolName, "host", hostName)
 	if err := vdo.avdManager.DeleteSessionHost(ctx, host); err != nil {
@@ -199,12 +210,41 @@ func (vdo *VirtualDesktopOrchestrator) RebuildReservationsFromAppGroups(ctx cont
 // ‼ single global lock for this orchestrator’s Pooled Host Pool operations
 var PooledPoolLock sync.Mutex
 
+// pooledHostPool is a pooled host pool whose session hosts are scaled by ensurePoolCapacity
+type pooledHostPool struct {
+	name  string
+	scale scaleCfg
+
+	// demand returns the number of sessions the pool currently has to serve
+	demand func(ctx context.Context) (int, error)
+}
+
+// remoteAppPool is the pooled host pool publishing the Linux VMs as RemoteApps, with one reservation per VM
+func (vdo *VirtualDesktopOrchestrator) remoteAppPool() pooledHostPool {
+	pool := vdo.avdManager.Config.PooledHostPoolNamePrefix + vdo.avdManager.Name
+	return pooledHostPool{
+		name:  pool,
+		scale: tempConfig,
+		demand: func(ctx context.Context) (int, error) {
+			if err := vdo.RebuildReservationsFromAppGroups(ctx); err != nil {
+				return 0, err
+			}
+			return vdo.reservationCount(pool), nil
+		},
+	}
+}
+
 // ensureCapacity scales session hosts to match the number of VM reservations
 func (vdo *VirtualDesktopOrchestrator) ensureCapacity(ctx context.Context) error {
+	return vdo.ensurePoolCapacity(ctx, vdo.remoteAppPool())
+}
+
+// ensurePoolCapacity scales the session hosts of a pooled host pool to match its demand
+func (vdo *VirtualDesktopOrchestrator) ensurePoolCapacity(ctx context.Context, hostPool pooledHostPool) error {
 	log := logging.GetLogger(ctx)
 	log.DebugContext(ctx, "ensureCapacity triggered")
 
-	pool := vdo.avdManager.Config.PooledHostPoolNamePrefix + vdo.avdManager.Name
+	pool := hostPool.name
 
 	// Prevent concurrent rebuilds
 	start := time.Now()
@@ -217,12 +257,13 @@ func (vdo *VirtualDesktopOrchestrator) ensureCapacity(ctx context.Context) error
 
 	log.DebugContext(ctx, "ensureCapacity start", "pool", pool)
 
-	// 1) Rebuild reservation state
-	if err := vdo.RebuildReservationsFromAppGroups(ctx); err != nil {
-		log.ErrorContext(ctx, "RebuildReservationsFromAppGroups failed", "err", err)
+	// 1) Determine the sessions the pool has to serve
+	currentReservations, err := hostPool.demand(ctx)
+	if err != nil {
+		log.ErrorContext(ctx, "ensureCapacity demand calculation failed", "pool", pool, "err", err)
 		return err
 	}
-	log.DebugContext(ctx, "ensureCapacity RebuildReservationsFromAppGroups complete")
+	log.DebugContext(ctx, "ensureCapacity demand calculation complete", "demand", currentReservations)
 
 	// 2) List all session hosts
 	hosts, err := vdo.avdManager.ListSessionHosts(ctx, pool)
@@ -235,23 +276,26 @@ func (vdo *VirtualDesktopOrchestrator) ensureCapacity(ctx context.Context) error
 	var (
 		upHosts       []*armdesktopvirtualization.SessionHost
 		shutdownHosts []*armdesktopvirtualization.SessionHost
+		pendingHosts  []*armdesktopvirtualization.SessionHost // expected to become available, not replaced
+		repairedHosts []*armdesktopvirtualization.SessionHost // replaced until the repair made them available
+		hostsToRepair []*armdesktopvirtualization.SessionHost
+		repairs       []avd.SessionHostRepairAction
 		hostsToDelete []*armdesktopvirtualization.SessionHost
 	)
 
-	// 3) Categorize hosts by status
+	// 3) Categorize hosts by health
 	for _, h := range hosts {
-		if h.Properties == nil || h.Properties.Status == nil {
-			log.DebugContext(ctx, "ensureCapacity Found session host with empty properties or status. Marking it for deletion", "host", *h.Name)
-			hostsToDelete = append(hostsToDelete, h)
-			continue
-		}
-		status := *h.Properties.Status
-		log.DebugContext(ctx, "ensureCapacity Host status check", "host", *h.Name, "status", status)
-		switch status {
-		case armdesktopvirtualization.StatusAvailable:
-			log.DebugContext(ctx, "ensureCapacity Host is available. Marking it as 'up'", "host", *h.Name)
-			upHosts = append(upHosts, h)
-		case armdesktopvirtualization.StatusShutdown:
+		report, repairing := vdo.sessionHostHealth(ctx, h)
+		log.DebugContext(ctx, "ensureCapacity Host health check", "host", *h.Name, "status", report.Status, "health", report.Health, "reason", report.Reason)
+
+		switch report.Health {
+		case avd.SessionHostHealthy:
+			if report.Status != armdesktopvirtualization.StatusShutdown {
+				log.DebugContext(ctx, "ensureCapacity Host is available. Marking it as 'up'", "host", *h.Name)
+				upHosts = append(upHosts, h)
+				continue
+			}
+
 			// sometimes session hosts report shutdown even if the VM does not exist. We'll verify that it exists.
 			_, _, vmName, err := vdo.avdManager.ParseSessionHostName(ctx, h)
 			if err != nil {
@@ -270,27 +314,38 @@ func (vdo *VirtualDesktopOrchestrator) ensureCapacity(ctx context.Context) error
 			// session host reports stale and VM exists, mark as shutdown
 			log.DebugContext(ctx, "ensureCapacity Session host is shutdown amd VM exists. Marking it as shutdown", "host", *h.Name, "vmName", vmName)
 			shutdownHosts = append(shutdownHosts, h)
+		case avd.SessionHostTransient:
+			if repairing {
+				log.DebugContext(ctx, "ensureCapacity Host is being repaired. Not counting it until it is available", "host", *h.Name, "reason", report.Reason)
+				repairedHosts = append(repairedHosts, h)
+				continue
+			}
+			log.DebugContext(ctx, "ensureCapacity Host is expected to recover. Marking it as pending", "host", *h.Name, "reason", report.Reason)
+			pendingHosts = append(pendingHosts, h)
+		case avd.SessionHostNeedsRepair:
+			log.DebugContext(ctx, "ensureCapacity Host needs repair. Marking it for repair", "host", *h.Name, "repair", report.Repair, "reason", report.Reason)
+			hostsToRepair = append(hostsToRepair, h)
+			repairs = append(repairs, report.Repair)
 		default:
-			log.DebugContext(ctx, "ensureCapacity Host is not available or shutdown. Marking it for deletion", "host", *h.Name, "status", status)
+			log.DebugContext(ctx, "ensureCapacity Host is dead. Marking it for deletion", "host", *h.Name, "reason", report.Reason)
 			hostsToDelete = append(hostsToDelete, h)
 		}
 	}
 	log.DebugContext(ctx, "ensureCapacity Host categorization complete",
 		"up hosts", len(upHosts),
 		"shutdown hosts", len(shutdownHosts),
+		"pending hosts", len(pendingHosts),
+		"repaired hosts", len(repairedHosts),
+		"hosts to repair", len(hostsToRepair),
 		"hosts to delete", len(hostsToDelete),
 	)
 
 	// 4) Capacity calculation
-	currentReservations := vdo.reservationCount(pool)
-	neededReservations := currentReservations + 1
-	needHosts := int(math.Ceil(float64(neededReservations) / float64(tempConfig.MaxSessionsPerHost)))
-	needHosts = clamp(needHosts, tempConfig.MinHosts, tempConfig.MaxHosts)
+	needHosts := requiredHosts(currentReservations, hostPool.scale)
 
 	log.DebugContext(ctx, "ensureCapacity Capacity calculation",
 		"currentReservations", currentReservations,
-		"neededReservations", neededReservations,
-		"MaxSessionsPerHost", tempConfig.MaxSessionsPerHost,
+		"MaxSessionsPerHost", hostPool.scale.MaxSessionsPerHost,
 		"needHosts", needHosts,
 	)
 
@@ -307,9 +362,15 @@ func (vdo *VirtualDesktopOrchestrator) ensureCapacity(ctx context.Context) error
 	wg.Wait()
 	log.DebugContext(ctx, "ensureCapacity Completed deletion of hosts marked for deletion", "deletedCount", len(hostsToDelete))
 
+	// 5b) Repair hosts in the background, they are replaced until a later pass finds them available
+	log.DebugContext(ctx, "ensureCapacity Repairing hosts marked for repair asynchronously", "count", len(hostsToRepair))
+	for i, host := range hostsToRepair {
+		vdo.repairSessionHostAsync(ctx, host, repairs[i])
+	}
+
 	// 6) Start shutdown hosts if needed
 	var toStart int
-	diff := needHosts - len(upHosts)
+	diff := needHosts - len(upHosts) - len(pendingHosts)
 	if diff > 0 {
 		toStart = min(len(shutdownHosts), diff)
 	} else {
@@ -347,8 +408,8 @@ func (vdo *VirtualDesktopOrchestrator) ensureCapacity(ctx context.Context) error
 	}
 
 	// 7) Provision new hosts if still under capacity
-	if len(upHosts) < needHosts {
-		toCreate := needHosts - len(upHosts)
+	if len(upHosts)+len(pendingHosts) < needHosts {
+		toCreate := needHosts - len(upHosts) - len(pendingHosts)
 		log.DebugContext(ctx, "ensureCapacity Provisioning new hosts", "toCreate", toCreate)
 		for i := 0; i < toCreate; i++ {
 			newHostID := len(upHosts) + 1
@@ -379,6 +440,26 @@ func (vdo *VirtualDesktopOrchestrator) ensureCapacity(ctx context.Context) error
 	}
 	log.DebugContext(ctx, "ensureCapacity shutdown session host VM sweep complete")
 
+	// 8b) Remove running hosts without sessions once demand has dropped
+	if hostPool.scale.ScaleDownIdle {
+		idleHosts := idleHostsAboveCapacity(upHosts, len(pendingHosts), needHosts, hostPool.scale)
+		log.DebugContext(ctx, "ensureCapacity Scaling down idle session hosts", "count", len(idleHosts))
+		removed := make([]bool, len(idleHosts))
+		for i, host := range idleHosts {
+			wg.Add(1)
+			go func(i int, h *armdesktopvirtualization.SessionHost) {
+				defer wg.Done()
+				removed[i] = vdo.scaleDownSessionHost(ctx, pool, h, hostPool.scale.DeleteOnScaleDown)
+			}(i, host)
+		}
+		wg.Wait()
+		for i, host := range idleHosts {
+			if removed[i] {
+				upHosts = slices.DeleteFunc(upHosts, func(up *armdesktopvirtualization.SessionHost) bool { return up == host })
+			}
+		}
+	}
+
 	// FIXME: disabled for now. this may have been deleting session host VMs that were part of different API deployments.
 	// We meed tp make sure the SHVMs we do retrieve are associated to this VDO / Pooled Host Pool.
 
@@ -433,6 +514,62 @@ func (vdo *VirtualDesktopOrchestrator) ensureCapacity(ctx context.Context) error
 	return nil
 }
 
+// idleHostsAboveCapacity returns the running hosts without sessions that exceed the required capacity.
+// Pending hosts count towards the capacity, and at least MinHosts running hosts are kept.
+func idleHostsAboveCapacity(upHosts []*armdesktopvirtualization.SessionHost, pending, needHosts int, scale scaleCfg) []*armdesktopvirtualization.SessionHost {
+	excess := min(len(upHosts)+pending-needHosts, len(upHosts)-scale.MinHosts)
+
+	var idle []*armdesktopvirtualization.SessionHost
+	// the newest hosts are removed first, they are at the end of the list
+	for i := len(upHosts) - 1; i >= 0 && len(idle) < excess; i-- {
+		h := upHosts[i]
+		if h.Properties != nil && h.Properties.Sessions != nil && *h.Properties.Sessions > 0 {
+			continue
+		}
+		idle = append(idle, h)
+	}
+	return idle
+}
+
+// scaleDownSessionHost refuses new sessions on an idle host, then deletes or deallocates it. Returns whether it was removed.
+func (vdo *VirtualDesktopOrchestrator) scaleDownSessionHost(ctx context.Context, pool string, host *armdesktopvirtualization.SessionHost, deleteHost bool) bool {
+	log := logging.GetLogger(ctx)
+
+	_, hostName, vmName, err := vdo.avdManager.ParseSessionHostName(ctx, host)
+	if err != nil {
+		log.WarnContext(ctx, "ensureCapacity Failed to parse session host name", "host name", *host.Name, "err", err)
+		return false
+	}
+
+	if err := vdo.avdManager.SetSessionHostDrainMode(ctx, pool, hostName, true); err != nil {
+		log.WarnContext(ctx, "ensureCapacity Failed to drain idle session host", "host", hostName, "err", err)
+		return false
+	}
+
+	if deleteHost {
+		log.DebugContext(ctx, "ensureCapacity Deleting idle session host", "host", hostName)
+		vdo.purgeStaleHost(ctx, host)
+		return true
+	}
+
+	log.DebugContext(ctx, "ensureCapacity Deallocating idle session host", "host", hostName, "vmName", vmName)
+	if err := vdo.vmManager.StopVirtualMachine(ctx, vmName); err != nil {
+		log.WarnContext(ctx, "ensureCapacity StopVirtualMachine failed for idle session host", "host", hostName, "vmName", vmName, "err", err)
+		return false
+	}
+	// started hosts accept new sessions again
+	if err := vdo.avdManager.SetSessionHostDrainMode(ctx, pool, hostName, false); err != nil {
+		log.WarnContext(ctx, "ensureCapacity Failed to end drain mode of deallocated session host", "host", hostName, "err", err)
+	}
+	return true
+}
+
+// requiredHosts returns the number of session hosts needed to serve the demand plus one more session
+func requiredHosts(demand int, scale scaleCfg) int {
+	needHosts := int(math.Ceil(float64(demand+1) / float64(scale.MaxSessionsPerHost)))
+	return clamp(needHosts, scale.MinHosts, scale.MaxHosts)
+}
+
 func clamp(x, minVal, maxVal int) int {
 	if x < minVal {
 		return minVal
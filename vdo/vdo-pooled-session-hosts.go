@@ -8,6 +8,7 @@ import (
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/desktopvirtualization/armdesktopvirtualization/v2"
 	"github.com/appliedres/cloudy"
+	"github.com/appliedres/cloudy-azure/vm"
 	"github.com/appliedres/cloudy/logging"
 	cm "github.com/appliedres/cloudy/models"
 )
@@ -20,17 +21,17 @@ func (vdo *VirtualDesktopOrchestrator) CreateSessionHost(ctx context.Context, ho
 	sessionHostID := fmt.Sprintf("shvm-%s", timestampedID)
 	sessionHostName := fmt.Sprintf("Session Host #%d Pool: %s", idInPool, hostPoolName)
 
-	// TODO: make session host template configurable
+	imageID, sizeID := vdo.sessionHostImage(hostPoolName)
 	sessionHostVM := &cm.VirtualMachine{
 		ID:          sessionHostID,
 		Name:        sessionHostName,
 		Description: "a session host VM for pooled AVD'",
 		Template: &cm.VirtualMachineTemplate{
 			OperatingSystem:      "windows",
-			OsBaseImageID:        "marketplace::microsoftwindowsdesktop::windows-11::win11-22h2-avd::latest",
+			OsBaseImageID:        imageID,
 			LocalAdministratorID: "salt",
 			Size: &cm.VirtualMachineSize{
-				ID: "Standard_D2s_v4",
+				ID: sizeID,
 			},
 			SecurityProfile: &cm.VirtualMachineSecurityProfileConfiguration{
 				SecurityTypes: cm.VirtualMachineSecurityTypesTrustedLaunch,
@@ -42,6 +43,22 @@ func (vdo *VirtualDesktopOrchestrator) CreateSessionHost(ctx context.Context, ho
 		UserID: "system", // TODO: what to use for UserID? Does this need to be a valid entra UPN?
 	}
 
+	sessionHostVM.Template.Tags = map[string]*string{}
+	if vdo.config.SessionHostEphemeralOSDisk != "" {
+		// session hosts hold no user data, so their OS disk can be lost on reimage or redeploy
+		sessionHostVM.Template.Tags[vm.EphemeralOSDiskTagKey] = to.Ptr(vdo.config.SessionHostEphemeralOSDisk)
+	}
+	if placement := vdo.config.SessionHostPlacement; placement != nil {
+		if placement.Zone != "" {
+			// session hosts of a pool are spread across zones together
+			sessionHostVM.Template.Tags[vm.ZoneTagKey] = to.Ptr(placement.Zone)
+			sessionHostVM.Template.Tags[vm.ZoneSpreadGroupTagKey] = to.Ptr(hostPoolName)
+		}
+		if placement.ProximityPlacementGroupID != "" {
+			sessionHostVM.Template.Tags[vm.ProximityPlacementGroupTagKey] = to.Ptr(placement.ProximityPlacementGroupID)
+		}
+	}
+
 	// TODO: do we need a separate CreateSessionHostVirtualMachine function? vs CreateUserVirtualMachine?
 
 	// Create the session host VM
@@ -51,10 +68,11 @@ func (vdo *VirtualDesktopOrchestrator) CreateSessionHost(ctx context.Context, ho
 	}
 
 	// retrieve host pool token
-	hostPoolToken, err := vdo.avdManager.RetrieveRegistrationToken(ctx, hostPoolName)
+	hostPoolToken, err := vdo.avdManager.BeginRegistration(ctx, hostPoolName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get host pool token: %w", err)
 	}
+	defer vdo.avdManager.EndRegistration(ctx, hostPoolName)
 
 	// build setup script
 	vdoConfig := vdo.config
@@ -80,3 +98,25 @@ func (vdo *VirtualDesktopOrchestrator) CreateSessionHost(ctx context.Context, ho
 
 	return sessionHost, nil
 }
+
+const (
+	defaultSessionHostImageID = "marketplace::microsoftwindowsdesktop::windows-11::win11-22h2-avd::latest"
+	defaultSessionHostSizeID  = "Standard_D2s_v4"
+)
+
+// sessionHostImage returns the image and size of new session hosts in a host pool.
+// The pooled desktop host pool uses its configured multi-session image, other pools the RemoteApp defaults.
+func (vdo *VirtualDesktopOrchestrator) sessionHostImage(hostPoolName string) (imageID, sizeID string) {
+	imageID, sizeID = defaultSessionHostImageID, defaultSessionHostSizeID
+
+	scaling := vdo.config.PooledDesktop
+	if scaling == nil || vdo.avdManager == nil || hostPoolName != vdo.avdManager.PooledDesktopHostPoolName() {
+		return imageID, sizeID
+	}
+
+	imageID = scaling.ImageID
+	if scaling.SizeID != "" {
+		sizeID = scaling.SizeID
+	}
+	return imageID, sizeID
+}
@@ -4,18 +4,20 @@ import (
 	"context"
 	_ "embed"
 	"fmt"
+	"net/url"
 	"reflect"
 	"strings"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
 	cloudyazure "github.com/appliedres/cloudy-azure"
+	"github.com/appliedres/cloudy-azure/avd"
 	"github.com/appliedres/cloudy-azure/storage"
 	"github.com/appliedres/cloudy/logging"
 )
 
 // BuildVirtualMachineSetupScript dynamically constructs the PowerShell script
-func (vdo VirtualDesktopOrchestrator) buildSetupScriptWindows(ctx context.Context, config VirtualDesktopOrchestratorConfig, hostPoolRegistrationToken *string) (*string, error) {
+func (vdo *VirtualDesktopOrchestrator) buildSetupScriptWindows(ctx context.Context, config VirtualDesktopOrchestratorConfig, hostPoolRegistrationToken *avd.RegistrationToken) (*string, error) {
 	log := logging.GetLogger(ctx)
 
 	// TODO: validate VDO config
@@ -28,15 +30,21 @@ func (vdo VirtualDesktopOrchestrator) buildSetupScriptWindows(ctx context.Contex
 	// Start script
 	scriptBuilder.WriteString(GenerateScriptStart() + "\n")
 
-	// Active Directory Join section
-	if config.AD != nil {
+	// Active Directory Join section, skipped when the domain join extension is used
+	if config.AD != nil && !config.AD.UseDomainJoinExtension {
 		scriptBuilder.WriteString(GenerateJoinDomainScript(config.AD) + "\n")
 	}
 
 	// AVD Installation section
 	if config.AVD != nil {
+		fetchScript, token, err := GenerateRegistrationTokenScript(hostPoolRegistrationToken)
+		if err != nil {
+			return nil, logging.LogAndWrapErr(ctx, log, err, "Generating AVD registration token script component")
+		}
+		scriptBuilder.WriteString(fetchScript)
+
 		avdScript, err := GenerateInstallAvdScript(ctx, vdo.vmManager.Credentials, config.BinaryStorage.BlobStorageAccount, config.BinaryStorage.BlobContainer,
-			&config.AVD.InstallerConfig, *hostPoolRegistrationToken)
+			&config.AVD.InstallerConfig, token)
 		if err != nil {
 			return nil, logging.LogAndWrapErr(ctx, log, err, "Generating AVD Install script component")
 		}
@@ -173,6 +181,70 @@ func GenerateInstallAvdScript(ctx context.Context, creds *cloudyazure.AzureCrede
 	return script, nil
 }
 
+//go:embed vm-setup-powershell/6_reregisterAVD.ps1
+var reregisterAvdTemplate string
+
+// GenerateReregisterAvdScript builds a script that registers an installed AVD agent again with a fresh token
+//
+// Deprecated: use GenerateReregisterAvdTokenScript, which also supports tokens stored in Key Vault.
+func GenerateReregisterAvdScript(hostPoolToken string) string {
+	script, _ := GenerateReregisterAvdTokenScript(&avd.RegistrationToken{Token: hostPoolToken})
+	return script
+}
+
+// GenerateReregisterAvdTokenScript builds a script that registers an installed AVD agent again with a fresh token
+func GenerateReregisterAvdTokenScript(hostPoolToken *avd.RegistrationToken) (string, error) {
+	fetchScript, token, err := GenerateRegistrationTokenScript(hostPoolToken)
+	if err != nil {
+		return "", err
+	}
+
+	var scriptBuilder strings.Builder
+	scriptBuilder.WriteString(GenerateScriptStart() + "\n")
+	scriptBuilder.WriteString(fetchScript)
+	scriptBuilder.WriteString(strings.ReplaceAll(reregisterAvdTemplate, "$REGISTRATION_TOKEN", token) + "\n")
+	scriptBuilder.WriteString(GenerateScriptEnd() + "\n")
+	return scriptBuilder.String(), nil
+}
+
+//go:embed vm-setup-powershell/7_fetchRegistrationToken.ps1
+var fetchRegistrationTokenTemplate string
+
+// GenerateRegistrationTokenScript returns the script component making the registration token available, and the
+// value to use for the token in the following components. Tokens stored in Key Vault are read by the VM's managed
+// identity into $registrationToken, so they never appear in the script. Other tokens are used as is.
+func GenerateRegistrationTokenScript(hostPoolToken *avd.RegistrationToken) (script, token string, err error) {
+	if hostPoolToken == nil {
+		return "", "", fmt.Errorf("a registration token is required to register with AVD")
+	}
+	if hostPoolToken.SecretURL == "" {
+		return "", hostPoolToken.Token, nil
+	}
+
+	secretURL, err := url.Parse(hostPoolToken.SecretURL)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid registration token secret URL: %w", err)
+	}
+
+	// managed identity tokens for Key Vault are requested for the vault's DNS suffix, e.g. "https://vault.usgovcloudapi.net"
+	_, vaultDomain, ok := strings.Cut(secretURL.Hostname(), ".")
+	if !ok {
+		return "", "", fmt.Errorf("invalid registration token secret URL [%s]", hostPoolToken.SecretURL)
+	}
+
+	replacements := map[string]string{
+		"$KEY_VAULT_RESOURCE": url.QueryEscape("https://" + vaultDomain),
+		"$SECRET_URL":         hostPoolToken.SecretURL,
+	}
+
+	script = fetchRegistrationTokenTemplate
+	for key, value := range replacements {
+		script = strings.ReplaceAll(script, key, value)
+	}
+
+	return script + "\n", "$registrationToken", nil
+}
+
 //go:embed vm-setup-powershell/3_installSaltMinion.ps1
 var installSaltMinionTemplate string
 
@@ -0,0 +1,40 @@
+package vdo
+
+import (
+	"testing"
+
+	"github.com/appliedres/cloudy-azure/avd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateRegistrationTokenScript(t *testing.T) {
+	_, _, err := GenerateRegistrationTokenScript(nil)
+	assert.Error(t, err)
+
+	// tokens outside Key Vault are embedded
+	script, token, err := GenerateRegistrationTokenScript(&avd.RegistrationToken{Token: "secret-token"})
+	assert.NoError(t, err)
+	assert.Empty(t, script)
+	assert.Equal(t, "secret-token", token)
+
+	// tokens in Key Vault are read by the VM
+	script, token, err = GenerateRegistrationTokenScript(&avd.RegistrationToken{
+		Token:     "secret-token",
+		SecretURL: "https://myvault.vault.usgovcloudapi.net/secrets/avd-registration-token-hp",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "$registrationToken", token)
+	assert.NotContains(t, script, "secret-token")
+	assert.Contains(t, script, "resource=https%3A%2F%2Fvault.usgovcloudapi.net")
+	assert.Contains(t, script, "https://myvault.vault.usgovcloudapi.net/secrets/avd-registration-token-hp")
+}
+
+func TestGenerateReregisterAvdScript(t *testing.T) {
+	_, err := GenerateReregisterAvdTokenScript(nil)
+	assert.Error(t, err)
+
+	script, err := GenerateReregisterAvdTokenScript(&avd.RegistrationToken{Token: "secret-token"})
+	assert.NoError(t, err)
+	assert.Contains(t, script, "secret-token")
+	assert.Equal(t, script, GenerateReregisterAvdScript("secret-token"))
+}
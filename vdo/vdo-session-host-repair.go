@@ -0,0 +1,161 @@
+package vdo
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/desktopvirtualization/armdesktopvirtualization/v2"
+	"github.com/appliedres/cloudy-azure/avd"
+	logging "github.com/appliedres/cloudy/logging"
+)
+
+// sessionHostRepairs are the repairs run on a session host since it was last healthy
+type sessionHostRepairs struct {
+	attempts    int
+	lastAttempt time.Time
+	running     bool // a repair is still running, the host is not repaired again until it is done
+}
+
+// sessionHostHealth classifies a pooled session host by how long it has been unhealthy, escalating the repair
+// when earlier repairs did not help. A host being repaired, or repaired less than TransientPeriod ago, is
+// reported as transient, giving the repair time to take effect. repairing reports whether the host was repaired
+// since it was last healthy.
+func (vdo *VirtualDesktopOrchestrator) sessionHostHealth(ctx context.Context, host *armdesktopvirtualization.SessionHost) (report avd.SessionHostHealthReport, repairing bool) {
+	if host == nil || host.Name == nil {
+		return vdo.avdManager.ClassifySessionHost(ctx, host, time.Time{}), false
+	}
+
+	now := time.Now()
+	since := now
+	if first, ok := vdo.unhealthySince.Load(*host.Name); ok {
+		since = first.(time.Time)
+	}
+	report = vdo.avdManager.ClassifySessionHost(ctx, host, since)
+
+	if report.Health == avd.SessionHostHealthy || report.Health == avd.SessionHostDead {
+		vdo.unhealthySince.Delete(*host.Name)
+		vdo.repairAttempts.Delete(*host.Name)
+		return report, false
+	}
+	vdo.unhealthySince.LoadOrStore(*host.Name, since)
+
+	previous, ok := vdo.repairAttempts.Load(*host.Name)
+	if !ok {
+		return report, false
+	}
+	repairs := previous.(sessionHostRepairs)
+	if repairs.running {
+		report.Health = avd.SessionHostTransient
+		report.Reason = fmt.Sprintf("%s, repair in progress", report.Reason)
+		report.Repair = avd.RepairNone
+		return report, true
+	}
+	report = vdo.avdManager.AwaitRepair(report, repairs.lastAttempt, now)
+	report = vdo.avdManager.EscalateRepair(report, repairs.attempts)
+	return report, true
+}
+
+// beginRepair records a repair of a session host as running, and returns the number of earlier repairs.
+// The repair is recorded before it starts so the next health check does not start it again.
+func (vdo *VirtualDesktopOrchestrator) beginRepair(hostName string) int {
+	attempts := 0
+	if previous, ok := vdo.repairAttempts.Load(hostName); ok {
+		attempts = previous.(sessionHostRepairs).attempts
+	}
+	vdo.repairAttempts.Store(hostName, sessionHostRepairs{attempts: attempts + 1, lastAttempt: time.Now(), running: true})
+	return attempts
+}
+
+// repairSessionHostAsync runs a repair in the background, the result is picked up by the next health check.
+// The repair outlives the context's cancellation, an interrupted repair would leave the host unregistered.
+func (vdo *VirtualDesktopOrchestrator) repairSessionHostAsync(ctx context.Context, host *armdesktopvirtualization.SessionHost, repair avd.SessionHostRepairAction) {
+	ctx = context.WithoutCancel(ctx)
+	attempts := vdo.beginRepair(*host.Name)
+	go func() {
+		if err := vdo.repairSessionHost(ctx, host, repair, attempts); err != nil {
+			logging.GetLogger(ctx).WarnContext(ctx, "Session host repair failed", "host", *host.Name, "repair", repair, "err", err)
+		}
+	}()
+}
+
+// RepairSessionHost runs a repair on a session host. Hosts registering again are waited on,
+// a restarted host is classified again on the next check.
+func (vdo *VirtualDesktopOrchestrator) RepairSessionHost(ctx context.Context, host *armdesktopvirtualization.SessionHost, repair avd.SessionHostRepairAction) error {
+	if host == nil || host.Name == nil {
+		return fmt.Errorf("session host has no name")
+	}
+	return vdo.repairSessionHost(ctx, host, repair, vdo.beginRepair(*host.Name))
+}
+
+func (vdo *VirtualDesktopOrchestrator) repairSessionHost(ctx context.Context, host *armdesktopvirtualization.SessionHost, repair avd.SessionHostRepairAction, attempts int) error {
+	log := logging.GetLogger(ctx)
+
+	// the backoff starts once the repair is done, the registration repairs wait for the host
+	defer func() {
+		vdo.repairAttempts.Store(*host.Name, sessionHostRepairs{attempts: attempts + 1, lastAttempt: time.Now()})
+	}()
+
+	hostPoolName, sessionHostName, vmID, err := vdo.avdManager.ParseSessionHostName(ctx, host)
+	if err != nil {
+		return err
+	}
+
+	log.InfoContext(ctx, "Repairing session host", "host", sessionHostName, "repair", repair, "attempt", attempts+1)
+
+	switch repair {
+	case avd.RepairRestartVM:
+		if err := vdo.vmManager.RestartVirtualMachine(ctx, vmID); err != nil {
+			return fmt.Errorf("failed to restart session host VM %s: %w", vmID, err)
+		}
+
+	case avd.RepairReregister:
+		token, err := vdo.avdManager.PrepareSessionHostRegistration(ctx, host)
+		if err != nil {
+			return fmt.Errorf("failed to prepare registration of session host %s: %w", sessionHostName, err)
+		}
+		defer vdo.avdManager.EndRegistration(ctx, hostPoolName)
+
+		script, err := GenerateReregisterAvdTokenScript(token)
+		if err != nil {
+			return err
+		}
+		if err := vdo.vmManager.ExecuteRemotePowershell(ctx, vmID, &script, 10*time.Minute, 15*time.Second); err != nil {
+			return fmt.Errorf("failed to re-register session host %s: %w", sessionHostName, err)
+		}
+		vdo.waitForRepairedSessionHost(ctx, hostPoolName, vmID)
+
+	case avd.RepairReinstallAgent:
+		token, err := vdo.avdManager.PrepareSessionHostRegistration(ctx, host)
+		if err != nil {
+			return fmt.Errorf("failed to prepare registration of session host %s: %w", sessionHostName, err)
+		}
+		defer vdo.avdManager.EndRegistration(ctx, hostPoolName)
+
+		// the same setup a new session host gets, see CreateSessionHost
+		vdoConfig := vdo.config
+		vdoConfig.SaltMinionInstall = nil
+		script, err := vdo.buildSetupScriptWindows(ctx, vdoConfig, token)
+		if err != nil {
+			return logging.LogAndWrapErr(ctx, log, err, "Could not build powershell script (AVD enabled)")
+		}
+		if err := vdo.vmManager.ExecuteRemotePowershell(ctx, vmID, script, 20*time.Minute, 15*time.Second); err != nil {
+			return fmt.Errorf("failed to reinstall the agent on session host %s: %w", sessionHostName, err)
+		}
+		vdo.waitForRepairedSessionHost(ctx, hostPoolName, vmID)
+
+	default:
+		return fmt.Errorf("unknown session host repair %q", repair)
+	}
+
+	log.InfoContext(ctx, "Repaired session host", "host", sessionHostName, "repair", repair)
+	return nil
+}
+
+// waitForRepairedSessionHost waits for a session host to register again, so its registration token is not revoked early.
+// A host that does not come back is classified again on the next check.
+func (vdo *VirtualDesktopOrchestrator) waitForRepairedSessionHost(ctx context.Context, hostPoolName, vmID string) {
+	if _, err := vdo.avdManager.WaitForSessionHost(ctx, hostPoolName, vmID, 10*time.Minute); err != nil {
+		logging.GetLogger(ctx).WarnContext(ctx, "Repaired session host did not become available", "vmID", vmID, "err", err)
+	}
+}
@@ -0,0 +1,57 @@
+package vdo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/desktopvirtualization/armdesktopvirtualization/v2"
+	"github.com/appliedres/cloudy-azure/avd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionHostHealth(t *testing.T) {
+	ctx := context.Background()
+	vdo := &VirtualDesktopOrchestrator{
+		avdManager: &avd.AzureVirtualDesktopManager{Config: &avd.AzureVirtualDesktopManagerConfig{}},
+	}
+	host := &armdesktopvirtualization.SessionHost{
+		Name: to.Ptr("pool/shvm-1.example.com"),
+		Properties: &armdesktopvirtualization.SessionHostProperties{
+			Status:        to.Ptr(armdesktopvirtualization.StatusUnavailable),
+			LastHeartBeat: to.Ptr(time.Now()),
+		},
+	}
+
+	// a host first seen unhealthy is given time to recover, even though it keeps sending heartbeats
+	report, repairing := vdo.sessionHostHealth(ctx, host)
+	assert.Equal(t, avd.SessionHostTransient, report.Health)
+	assert.False(t, repairing)
+
+	vdo.unhealthySince.Store(*host.Name, time.Now().Add(-time.Hour))
+	report, _ = vdo.sessionHostHealth(ctx, host)
+	assert.Equal(t, avd.SessionHostNeedsRepair, report.Health)
+	assert.Equal(t, avd.RepairRestartVM, report.Repair)
+
+	// a running repair is not started again
+	assert.Equal(t, 0, vdo.beginRepair(*host.Name))
+	report, repairing = vdo.sessionHostHealth(ctx, host)
+	assert.Equal(t, avd.SessionHostTransient, report.Health)
+	assert.True(t, repairing)
+
+	// a finished repair that did not help is escalated once it had time to take effect
+	vdo.repairAttempts.Store(*host.Name, sessionHostRepairs{attempts: 1, lastAttempt: time.Now().Add(-time.Hour)})
+	report, repairing = vdo.sessionHostHealth(ctx, host)
+	assert.Equal(t, avd.RepairReregister, report.Repair)
+	assert.True(t, repairing)
+
+	// a healthy host starts over
+	host.Properties.Status = to.Ptr(armdesktopvirtualization.StatusAvailable)
+	report, _ = vdo.sessionHostHealth(ctx, host)
+	assert.Equal(t, avd.SessionHostHealthy, report.Health)
+	_, tracked := vdo.unhealthySince.Load(*host.Name)
+	assert.False(t, tracked)
+	_, tracked = vdo.repairAttempts.Load(*host.Name)
+	assert.False(t, tracked)
+}
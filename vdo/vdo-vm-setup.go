@@ -10,6 +10,7 @@ import (
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
 	cloudyazure "github.com/appliedres/cloudy-azure"
 	"github.com/appliedres/cloudy-azure/storage"
+	"github.com/appliedres/cloudy-azure/vm"
 	"github.com/appliedres/cloudy/logging"
 	"github.com/appliedres/cloudy/models"
 )
@@ -20,13 +21,22 @@ func (vdo *VirtualDesktopOrchestrator) virtualMachineSetupWindows(ctx context.Co
 	defer log.DebugContext(ctx, "virtualMachineSetupWindows finished")
 
 	vdoConfig := vdo.config
+
+	if vdoConfig.AD != nil && vdoConfig.AD.UseDomainJoinExtension {
+		err := vdo.joinDomainWithExtension(ctx, vm.ID, vdoConfig.AD)
+		if err != nil {
+			return nil, logging.LogAndWrapErr(ctx, log, err, "Could not join domain")
+		}
+	}
+
 	if vdo.avdManager != nil {
 		log.InfoContext(ctx, "Initial VM setup - AVD enabled")
 
-		hostPoolNamePtr, hostPoolToken, err := vdo.avdManager.PreRegister(ctx, vm)
+		hostPoolNamePtr, hostPoolToken, err := vdo.avdManager.PreRegisterSessionHost(ctx, vm)
 		if err != nil {
 			return nil, logging.LogAndWrapErr(ctx, log, err, "AVD Pre-Register failed")
 		}
+		defer vdo.avdManager.EndRegistration(ctx, *hostPoolNamePtr)
 
 		script, err := vdo.buildSetupScriptWindows(ctx, vdoConfig, hostPoolToken)
 		if err != nil {
@@ -38,6 +48,7 @@ func (vdo *VirtualDesktopOrchestrator) virtualMachineSetupWindows(ctx context.Co
 			return nil, logging.LogAndWrapErr(ctx, log, err, "Could not run powershell (AVD enabled)")
 		}
 
+		// PostRegister waits for the agent to register, the deferred EndRegistration only revokes the token after it
 		vm, err = vdo.avdManager.PostRegister(ctx, vm, *hostPoolNamePtr)
 		if err != nil {
 			return nil, logging.LogAndWrapErr(ctx, log, err, "AVD Post-Register VM")
@@ -61,6 +72,24 @@ func (vdo *VirtualDesktopOrchestrator) virtualMachineSetupWindows(ctx context.Co
 	return vm, nil
 }
 
+// joinDomainWithExtension joins a Windows VM to the domain using the native JsonADDomainExtension.
+// The extension restarts the VM once joined; RunCommand setup scripts wait for the VM agent to come back.
+func (vdo *VirtualDesktopOrchestrator) joinDomainWithExtension(ctx context.Context, vmID string, adConfig *ADJoinConfig) error {
+	log := logging.GetLogger(ctx)
+	log.InfoContext(ctx, "Joining domain with extension", "Domain", adConfig.DomainName)
+
+	spec := vm.DomainJoinExtension(adConfig.DomainName, adConfig.DomainUsername, adConfig.DomainPassword,
+		stringPtrOrEmpty(adConfig.OrganizationalUnitPath), true)
+
+	_, err := vdo.vmManager.InstallExtension(ctx, vmID, spec)
+	if err != nil {
+		return err
+	}
+
+	log.InfoContext(ctx, "Domain join extension complete", "Domain", adConfig.DomainName)
+	return nil
+}
+
 func (vdo *VirtualDesktopOrchestrator) virtualMachineSetupLinux(ctx context.Context, vm *models.VirtualMachine) (*models.VirtualMachine, error) {
 	log := logging.GetLogger(ctx)
 	log.DebugContext(ctx, "virtualMachineSetupLinux started")
@@ -2,6 +2,8 @@ package vdo
 
 import (
 	"context"
+	"fmt"
+	"sync"
 
 	cloudyazure "github.com/appliedres/cloudy-azure"
 	cloudyvm "github.com/appliedres/cloudy/vm"
@@ -21,17 +23,43 @@ type VirtualDesktopOrchestrator struct {
 
 	vmManager  vm.AzureVirtualMachineManager
 	avdManager *avd.AzureVirtualDesktopManager // optional
+
+	idleTracker sync.Map   // map[string]*idleState (vmID→state)
+	idleLock    sync.Mutex // prevents overlapping idle checks
+
+	unhealthySince sync.Map // map[string]time.Time (session host name→when it was first seen unhealthy)
+	repairAttempts sync.Map // map[string]sessionHostRepairs (session host name→repairs run since it was last healthy)
 }
 
 // TODO: how much should credentials match? Do we allow different subscription?
 
+var _ cloudyvm.VirtualDesktopOrchestrator = (*VirtualDesktopOrchestrator)(nil)
+
+// NewVirtualDesktopOrchestrator creates an orchestrator. No background work runs until StartBackgroundTasks is called:
+// callers must call it for the idle reaper, JIT access expirer and pooled desktop scaler to run.
 func NewVirtualDesktopOrchestrator(
 	ctx context.Context,
 	name string,
 	vmCredentials *cloudyazure.AzureCredentials,
 	avdCredentials *cloudyazure.AzureCredentials,
 	config *VirtualDesktopOrchestratorConfig,
-) (cloudyvm.VirtualDesktopOrchestrator, error) {
+) (*VirtualDesktopOrchestrator, error) {
+
+	err := validateIdleShutdownConfig(config.IdleShutdown)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validatePooledDesktopScalingConfig(config.PooledDesktop, config.AVD)
+	if err != nil {
+		return nil, err
+	}
+
+	switch config.StopPolicy {
+	case "", StopPolicyDeallocate, StopPolicyHibernate:
+	default:
+		return nil, fmt.Errorf("unsupported stop policy [%s]", config.StopPolicy)
+	}
 
 	vmmConfig := &config.VM
 	vmMgr, err := vm.NewAzureVirtualMachineManager(ctx, name, vmCredentials, vmmConfig)
@@ -58,3 +86,15 @@ func NewVirtualDesktopOrchestrator(
 
 	return vdo, nil
 }
+
+// StartBackgroundTasks starts the configured background work, i.e. the idle reaper, JIT access expirer and
+// pooled desktop scaler, until ctx is cancelled or the returned stop func is called.
+func (vdo *VirtualDesktopOrchestrator) StartBackgroundTasks(ctx context.Context) (stop func()) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	vdo.StartIdleReaper(ctx)
+	vdo.vmManager.StartJITAccessExpirer(ctx)
+	vdo.StartPooledDesktopScaler(ctx)
+
+	return cancel
+}
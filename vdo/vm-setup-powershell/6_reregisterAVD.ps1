@@ -0,0 +1,23 @@
+# --------------------------------------------------------------------------------
+# RE-REGISTER AVD AGENT
+# --------------------------------------------------------------------------------
+$agentRegistryPath = "HKLM:\SOFTWARE\Microsoft\RDInfraAgent"
+if (!(Test-Path $agentRegistryPath)) {
+    Exit-OnFailure "AVD RDAgent is not installed, registry key $agentRegistryPath not found"
+}
+
+Write-Host "Setting new AVD registration token..."
+try {
+    Set-ItemProperty -Path $agentRegistryPath -Name "RegistrationToken" -Value "$REGISTRATION_TOKEN"
+    Set-ItemProperty -Path $agentRegistryPath -Name "IsRegistered" -Value 0
+} catch {
+    Exit-OnFailure "Failed to set AVD registration token. Error: $_"
+}
+
+Write-Host "Restarting AVD BootLoader..."
+try {
+    Restart-Service -Name "RDAgentBootLoader" -Force
+} catch {
+    Exit-OnFailure "Failed to restart AVD BootLoader. Error: $_"
+}
+Write-Host "AVD agent re-registration triggered successfully."
@@ -0,0 +1,15 @@
+# --------------------------------------------------------------------------------
+# FETCH AVD REGISTRATION TOKEN FROM KEY VAULT
+# --------------------------------------------------------------------------------
+Write-Host "Fetching AVD registration token from Key Vault..."
+try {
+    $identityUri = "http://169.254.169.254/metadata/identity/oauth2/token?api-version=2018-02-01&resource=$KEY_VAULT_RESOURCE"
+    $accessToken = (Invoke-RestMethod -Uri $identityUri -Headers @{ Metadata = "true" } -UseBasicParsing).access_token
+    $registrationToken = (Invoke-RestMethod -Uri "$SECRET_URL?api-version=7.4" -Headers @{ Authorization = "Bearer $accessToken" } -UseBasicParsing).value
+} catch {
+    Exit-OnFailure "Failed to fetch AVD registration token. Error: $_"
+}
+if ([string]::IsNullOrEmpty($registrationToken)) {
+    Exit-OnFailure "AVD registration token secret is empty"
+}
+Write-Host "AVD registration token fetched successfully."
@@ -0,0 +1,233 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork/v5"
+	"github.com/appliedres/cloudy/models"
+	cloudyvm "github.com/appliedres/cloudy/vm"
+	"github.com/pkg/errors"
+)
+
+// The functions below are kept for existing callers of the legacy controller. They call the VM manager.
+
+// FindBestSubnet returns the first subnet with a free IP address, or an empty string if there is none.
+//
+// Deprecated: Create lets the VM manager pick the subnet.
+func (vmc *AzureVMController) FindBestSubnet(ctx context.Context, availableSubnets []string) (string, error) {
+	for _, subnet := range availableSubnets {
+		available, err := vmc.GetAvailableIPS(ctx, subnet)
+		if err != nil {
+			return "", err
+		}
+
+		if available > 0 {
+			return subnet, nil
+		}
+	}
+
+	return "", nil
+}
+
+// GetAvailableIPS returns the number of free IP addresses in a subnet of the configured virtual network.
+//
+// Deprecated: Create lets the VM manager pick the subnet.
+func (vmc *AzureVMController) GetAvailableIPS(ctx context.Context, subnet string) (int, error) {
+	return vmc.Manager.getSubnetAvailableIps(ctx, subnet)
+}
+
+// CreateNSG creates the configured network security group, if it does not exist yet, and returns its ID.
+//
+// Deprecated: NICs are created without a network security group, the subnet's NSG applies.
+func (vmc *AzureVMController) CreateNSG(ctx context.Context, vm *cloudyvm.VirtualMachineConfiguration) (string, error) {
+	poller, err := vmc.Manager.securityGroupsClient.BeginCreateOrUpdate(ctx,
+		vmc.Config.ResourceGroup,
+		vmc.Config.NetworkSecurityGroupName,
+		armnetwork.SecurityGroup{
+			Location: to.Ptr(vmc.Manager.Credentials.Region),
+		},
+		nil)
+	if err != nil {
+		return "", errors.Wrap(err, "CreateNSG")
+	}
+
+	res, err := poller.PollUntilDone(ctx, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "CreateNSG: polling")
+	}
+
+	return *res.SecurityGroup.ID, nil
+}
+
+// GetNSG returns a network security group in the network resource group.
+//
+// Deprecated: NICs are created without a network security group, the subnet's NSG applies.
+func (vmc *AzureVMController) GetNSG(ctx context.Context, name string) (*armnetwork.SecurityGroup, error) {
+	resp, err := vmc.Manager.securityGroupsClient.Get(ctx, vmc.Config.NetworkResourceGroup, name, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp.SecurityGroup, nil
+}
+
+// CreateNIC creates the primary NIC of a VM and sets it as the VM's primary network.
+// The subnet is picked by the VM manager, subnetId is ignored.
+//
+// Deprecated: Create creates the NIC when the VM does not have one yet.
+func (vmc *AzureVMController) CreateNIC(ctx context.Context, vm *cloudyvm.VirtualMachineConfiguration, subnetId string) error {
+	if vm.Size == nil {
+		return fmt.Errorf("[%s] invalid VM size %v", vm.ID, vm.Size)
+	}
+
+	cloudyVM, err := vmc.toCloudyVirtualMachine(vm)
+	if err != nil {
+		return err
+	}
+
+	nic, err := vmc.Manager.CreateNic(ctx, cloudyVM)
+	if err != nil {
+		return err
+	}
+
+	vm.PrimaryNetwork = &cloudyvm.VirtualMachineNetwork{
+		ID:        nic.ID,
+		Name:      nic.Name,
+		PrivateIP: nic.PrivateIP,
+	}
+	return nil
+}
+
+// GetVmOsDisk returns the OS disk of a VM, or nil if it has none.
+//
+// Deprecated: use AzureVirtualMachineManager.GetOsDisk.
+func (vmc *AzureVMController) GetVmOsDisk(ctx context.Context, vm *cloudyvm.VirtualMachineConfiguration) (*cloudyvm.VirtualMachineDisk, error) {
+	disk, err := vmc.Manager.GetOsDisk(ctx, vm.ID)
+	if err != nil || disk == nil {
+		return nil, err
+	}
+
+	return &cloudyvm.VirtualMachineDisk{Name: disk.Name}, nil
+}
+
+// GetVM returns a VM configuration holding the resource ID of the VM.
+//
+// Deprecated: use Status, or AzureVirtualMachineManager.GetVirtualMachine.
+func (vmc *AzureVMController) GetVM(ctx context.Context, vm *cloudyvm.VirtualMachineConfiguration) (*cloudyvm.VirtualMachineConfiguration, error) {
+	resp, err := vmc.Manager.vmClient.Get(ctx, vmc.Config.ResourceGroup, vm.ID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cloudyvm.VirtualMachineConfiguration{
+		ID: *resp.VirtualMachine.ID,
+	}, nil
+}
+
+// GetNIC returns the primary network of a VM, or nil if it has no NIC.
+//
+// Deprecated: use AzureVirtualMachineManager.GetNics.
+func (vmc *AzureVMController) GetNIC(ctx context.Context, vm *cloudyvm.VirtualMachineConfiguration) (*cloudyvm.VirtualMachineNetwork, error) {
+	nics, err := vmc.Manager.GetNics(ctx, vm.ID)
+	if err != nil || len(nics) == 0 {
+		return nil, err
+	}
+
+	return &cloudyvm.VirtualMachineNetwork{
+		ID:        nics[0].ID,
+		Name:      nics[0].Name,
+		PrivateIP: nics[0].PrivateIP,
+	}, nil
+}
+
+// DeleteNIC deletes a NIC from the network resource group.
+//
+// Deprecated: Delete removes the NICs of the VM.
+func (vmc *AzureVMController) DeleteNIC(ctx context.Context, vmId string, nicName string) error {
+	return vmc.Manager.DeleteNic(ctx, &models.VirtualMachineNic{Name: nicName})
+}
+
+// CreateVirtualMachine creates a VM.
+//
+// Deprecated: use Create.
+func (vmc *AzureVMController) CreateVirtualMachine(ctx context.Context, vm *cloudyvm.VirtualMachineConfiguration) error {
+	_, err := vmc.Create(ctx, vm)
+	return err
+}
+
+// DeleteVMOSDisk deletes the OS disk of a VM.
+//
+// Deprecated: Delete removes the OS disk of the VM.
+func (vmc *AzureVMController) DeleteVMOSDisk(ctx context.Context, vm *cloudyvm.VirtualMachineConfiguration) error {
+	if vm.OSDisk == nil {
+		return fmt.Errorf("[%s] VM has no OS disk", vm.ID)
+	}
+
+	return vmc.Manager.DeleteDisk(ctx, vm.OSDisk.Name)
+}
+
+// ConfigureDiskSize returns the OS disk size of a VM in GB, at least 200 GB for Windows VMs.
+//
+// Deprecated: the VM manager sizes the OS disk from the template.
+func (vmc *AzureVMController) ConfigureDiskSize(ctx context.Context, vm *cloudyvm.VirtualMachineConfiguration) (int32, error) {
+	sizeInGB := int32(30)
+	if vm.OSDisk != nil && vm.OSDisk.Size != "" {
+		size, err := strconv.ParseInt(vm.OSDisk.Size, 10, 32)
+		if err == nil {
+			sizeInGB = int32(size)
+		}
+	}
+
+	if strings.EqualFold(vm.OSType, "windows") {
+		sizeInGB = max(sizeInGB, 200)
+	}
+
+	return sizeInGB, nil
+}
+
+// ConfigureVmOsDiskOsTypeType returns the OS type of a VM's OS disk, or nil if the OS type is not supported.
+//
+// Deprecated: the VM manager sets the OS type from the template.
+func (vmc *AzureVMController) ConfigureVmOsDiskOsTypeType(ctx context.Context, vm *cloudyvm.VirtualMachineConfiguration) *armcompute.OperatingSystemTypes {
+	switch legacyOperatingSystem(vm.OSType) {
+	case models.VirtualMachineTemplateOperatingSystemWindows:
+		return to.Ptr(armcompute.OperatingSystemTypesWindows)
+	case models.VirtualMachineTemplateOperatingSystemLinuxDeb:
+		return to.Ptr(armcompute.OperatingSystemTypesLinux)
+	}
+
+	return nil
+}
+
+// ConfigureVmOsProfile returns the OS profile of a VM with its administrator credentials, or nil if the OS type is not supported.
+//
+// Deprecated: Create sets the OS profile.
+func (vmc *AzureVMController) ConfigureVmOsProfile(ctx context.Context, vm *cloudyvm.VirtualMachineConfiguration) *armcompute.OSProfile {
+	osType := vmc.ConfigureVmOsDiskOsTypeType(ctx, vm)
+	if osType == nil {
+		return nil
+	}
+
+	azVM := &armcompute.VirtualMachine{
+		Properties: &armcompute.VirtualMachineProperties{
+			OSProfile: &armcompute.OSProfile{
+				ComputerName:  to.Ptr(vm.ID),
+				AdminUsername: to.Ptr(vm.Credientials.AdminUser),
+			},
+		},
+	}
+	if *osType == armcompute.OperatingSystemTypesWindows {
+		azVM.Properties.OSProfile.WindowsConfiguration = &armcompute.WindowsConfiguration{}
+	} else {
+		azVM.Properties.OSProfile.LinuxConfiguration = &armcompute.LinuxConfiguration{ProvisionVMAgent: to.Ptr(true)}
+		azVM.Properties.OSProfile.AllowExtensionOperations = to.Ptr(true)
+	}
+
+	legacyOSProfile(vm)(azVM)
+	return azVM.Properties.OSProfile
+}
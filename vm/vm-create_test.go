@@ -7,7 +7,10 @@ import (
 	"context"
 	"testing"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
 	"github.com/appliedres/cloudy"
+	"github.com/appliedres/cloudy/models"
 	"github.com/appliedres/cloudy/testutil"
 	cloudyvm "github.com/appliedres/cloudy/vm"
 	"github.com/stretchr/testify/assert"
@@ -281,10 +284,10 @@ func TestVMDelete(t *testing.T) {
 
 	// Create NIC and Delete "VM" with NIC only
 	azc := VMController.(*AzureVMController)
-	subnetId, err := azc.FindBestSubnet(ctx, azc.Config.AvailableSubnets)
+	cloudyVM, err := azc.toCloudyVirtualMachine(LinuxVmTestConfig)
 	assert.Nil(t, err)
 
-	err = azc.CreateNIC(ctx, LinuxVmTestConfig, subnetId)
+	_, err = azc.Manager.CreateNic(ctx, cloudyVM)
 	assert.Nil(t, err)
 
 	_, err = VMController.Delete(ctx, LinuxVmTestConfig)
@@ -298,3 +301,73 @@ func TestVMDelete(t *testing.T) {
 	assert.Nil(t, err)
 
 }
+
+func TestLegacyToCloudyVirtualMachine(t *testing.T) {
+	vmc := &AzureVMController{Config: &AzureVMControllerConfig{
+		SubscriptionID:         "sub",
+		ResourceGroup:          "rg",
+		SourceImageGalleryName: "gallery",
+	}}
+
+	vm, err := vmc.toCloudyVirtualMachine(LinuxVmTestConfig)
+	assert.Nil(t, err)
+	assert.Equal(t, "uvm-gotest", vm.ID)
+	assert.Equal(t, "Standard_DS1_v2", vm.Template.Size.ID)
+	assert.Equal(t, models.VirtualMachineTemplateOperatingSystemLinuxDeb, vm.Template.OperatingSystem)
+	assert.Equal(t, "marketplace::canonical::ubuntuserver::19.04::19.04.202001220", vm.Template.OsBaseImageID)
+	assert.Equal(t, "salt", vm.Template.LocalAdministratorID)
+
+	gallery := &cloudyvm.VirtualMachineConfiguration{
+		ID:           "uvm-win",
+		OSType:       "windows",
+		Image:        "win11",
+		ImageVersion: "1.0.0",
+		Size:         &cloudyvm.VmSize{Name: "Standard_D2s_v3", PremiumIO: true},
+		OSDisk:       &cloudyvm.VirtualMachineDisk{Size: "256"},
+		Tags:         map[string]string{"Application": "VDI"},
+	}
+	vm, err = vmc.toCloudyVirtualMachine(gallery)
+	assert.Nil(t, err)
+	assert.Equal(t, "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/galleries/gallery/images/win11/versions/1.0.0", vm.Template.OsBaseImageID)
+	assert.Equal(t, "uvm-win", vm.Name)
+	assert.Equal(t, "VDI", *vm.Template.Tags["Application"])
+	assert.Equal(t, int64(256), vm.Template.Disks[0].Size)
+	assert.True(t, vm.Template.Disks[0].PremiumIo)
+
+	gallery.OSDisk.Size = "big"
+	_, err = vmc.toCloudyVirtualMachine(gallery)
+	assert.Error(t, err)
+
+	gallery.Image = "a::b"
+	_, err = vmc.imageID(gallery)
+	assert.Error(t, err)
+
+	assert.Error(t, vmc.ValidateConfiguration(context.Background(), &cloudyvm.VirtualMachineConfiguration{OSType: "beos", Size: &cloudyvm.VmSize{Name: "x"}}))
+	assert.Error(t, vmc.ValidateConfiguration(context.Background(), &cloudyvm.VirtualMachineConfiguration{OSType: "linux"}))
+}
+
+func TestLegacyOSProfile(t *testing.T) {
+	azVM := &armcompute.VirtualMachine{Properties: &armcompute.VirtualMachineProperties{
+		OSProfile: &armcompute.OSProfile{
+			AdminUsername:      to.Ptr("salt"),
+			AdminPassword:      to.Ptr("generated"),
+			LinuxConfiguration: &armcompute.LinuxConfiguration{DisablePasswordAuthentication: to.Ptr(false)},
+		},
+	}}
+
+	legacyOSProfile(&cloudyvm.VirtualMachineConfiguration{
+		Credientials: cloudyvm.Credientials{AdminUser: "salt", SSHKey: "ssh-rsa AAAA"},
+	})(azVM)
+
+	osProfile := azVM.Properties.OSProfile
+	assert.Nil(t, osProfile.AdminPassword)
+	assert.True(t, *osProfile.LinuxConfiguration.DisablePasswordAuthentication)
+	assert.Equal(t, "/home/salt/.ssh/authorized_keys", *osProfile.LinuxConfiguration.SSH.PublicKeys[0].Path)
+	assert.Equal(t, "ssh-rsa AAAA", *osProfile.LinuxConfiguration.SSH.PublicKeys[0].KeyData)
+
+	azVM.Properties.OSProfile = &armcompute.OSProfile{AdminPassword: to.Ptr("generated"), WindowsConfiguration: &armcompute.WindowsConfiguration{}}
+	legacyOSProfile(&cloudyvm.VirtualMachineConfiguration{
+		Credientials: cloudyvm.Credientials{AdminUser: "admin", AdminPassword: "Secret12#$"},
+	})(azVM)
+	assert.Equal(t, "Secret12#$", *azVM.Properties.OSProfile.AdminPassword)
+}
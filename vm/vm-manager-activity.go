@@ -0,0 +1,121 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/monitor/armmonitor"
+	"github.com/appliedres/cloudy/logging"
+	"github.com/pkg/errors"
+)
+
+const (
+	activeSessionsMarker = "ACTIVE_SESSIONS="
+
+	// counts interactive sessions that are currently in the Active state (console or RDP)
+	windowsActiveSessionsScript = `$count = 0
+$sessions = quser 2>$null
+if ($sessions) {
+    $count = @($sessions | Select-Object -Skip 1 | Where-Object { $_ -match '\sActive\s' }).Count
+}
+Write-Output "ACTIVE_SESSIONS=$count"`
+
+	// counts logged in users (local console, ssh and xrdp sessions)
+	linuxActiveSessionsScript = `echo "ACTIVE_SESSIONS=$(who | wc -l)"`
+
+	activityCommandTimeout      = 5 * time.Minute
+	activityCommandPollInterval = 10 * time.Second
+)
+
+var activeSessionsRegex = regexp.MustCompile(regexp.QuoteMeta(activeSessionsMarker) + `\s*(\d+)`)
+
+// GetActiveSessionCount uses RunCommand to count the interactive users currently logged on to a VM.
+// This is used for VMs that are not AVD session hosts, where AVD cannot report session state.
+func (vmm *AzureVirtualMachineManager) GetActiveSessionCount(ctx context.Context, vmID string, windows bool) (int, error) {
+	log := logging.GetLogger(ctx).With("vmID", vmID)
+
+	var (
+		output string
+		err    error
+	)
+	if windows {
+		output, err = vmm.ExecuteRemotePowershellWithOutput(ctx, vmID, to.Ptr(windowsActiveSessionsScript), activityCommandTimeout, activityCommandPollInterval)
+	} else {
+		output, err = vmm.ExecuteRemoteShellScriptWithOutput(ctx, vmID, to.Ptr(linuxActiveSessionsScript), activityCommandTimeout, activityCommandPollInterval)
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "Get Active Session Count")
+	}
+
+	count, err := parseActiveSessionCount(output)
+	if err != nil {
+		return 0, errors.Wrap(err, "Get Active Session Count")
+	}
+
+	log.DebugContext(ctx, "Retrieved active session count", "count", count)
+	return count, nil
+}
+
+// parseActiveSessionCount extracts the session count written by the activity scripts.
+func parseActiveSessionCount(output string) (int, error) {
+	match := activeSessionsRegex.FindStringSubmatch(output)
+	if match == nil {
+		return 0, fmt.Errorf("no session count found in command output")
+	}
+
+	return strconv.Atoi(match[1])
+}
+
+// GetAverageCPUPercent returns the average 'Percentage CPU' platform metric of a VM over the given window.
+// Returns nil when Azure Monitor has no data points for the window, e.g. when the VM was recently started.
+func (vmm *AzureVirtualMachineManager) GetAverageCPUPercent(ctx context.Context, vmID string, window time.Duration) (*float64, error) {
+	log := logging.GetLogger(ctx).With("vmID", vmID)
+
+	end := time.Now().UTC()
+	start := end.Add(-window)
+
+	resp, err := vmm.metricsClient.List(ctx, vmm.virtualMachineResourceID(vmID), &armmonitor.MetricsClientListOptions{
+		Metricnames: to.Ptr("Percentage CPU"),
+		Aggregation: to.Ptr("average"),
+		Interval:    to.Ptr("PT5M"),
+		Timespan:    to.Ptr(fmt.Sprintf("%s/%s", start.Format(time.RFC3339), end.Format(time.RFC3339))),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Get Average CPU")
+	}
+
+	var (
+		sum   float64
+		count int
+	)
+	for _, metric := range resp.Value {
+		for _, series := range metric.Timeseries {
+			for _, point := range series.Data {
+				if point.Average == nil {
+					continue
+				}
+				sum += *point.Average
+				count++
+			}
+		}
+	}
+
+	if count == 0 {
+		log.DebugContext(ctx, "No CPU metric data points found", "window", window)
+		return nil, nil
+	}
+
+	average := sum / float64(count)
+	log.DebugContext(ctx, "Retrieved average CPU", "window", window, "average", average)
+	return &average, nil
+}
+
+// virtualMachineResourceID builds the ARM resource ID of a VM in the manager's resource group.
+func (vmm *AzureVirtualMachineManager) virtualMachineResourceID(vmID string) string {
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/virtualMachines/%s",
+		vmm.Credentials.SubscriptionID, vmm.Credentials.ResourceGroup, vmID)
+}
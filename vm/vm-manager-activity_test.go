@@ -0,0 +1,32 @@
+package vm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseActiveSessionCount(t *testing.T) {
+	tests := []struct {
+		name        string
+		output      string
+		expected    int
+		expectError bool
+	}{
+		{"windows", "ACTIVE_SESSIONS=2\n", 2, false},
+		{"linux with run command header", "Enable succeeded: \n[stdout]\nACTIVE_SESSIONS=0\n\n[stderr]\n", 0, false},
+		{"missing marker", "something else", 0, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			count, err := parseActiveSessionCount(test.output)
+			if test.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, count)
+		})
+	}
+}
@@ -0,0 +1,304 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	cloudyazure "github.com/appliedres/cloudy-azure"
+	"github.com/appliedres/cloudy/logging"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultBulkConcurrency = 5
+
+	// the SDK already retries throttled requests, so a 429 reaching the bulk runner means ARM wants a longer break
+	defaultThrottleBackoff = 30 * time.Second
+	maxThrottleRetries     = 3
+
+	bulkRunCommandPollInterval = 15 * time.Second
+)
+
+// VirtualMachineSelector selects VMs in the manager's resource group.
+// If IDs are given they are used as is, otherwise VMs are listed and matched by Prefix and Tags.
+type VirtualMachineSelector struct {
+	IDs    []string
+	Prefix string            // VM name prefix, e.g. "shvm-"
+	Tags   map[string]string // tags the VM must have, keys are case-insensitive
+}
+
+// BulkOptions control how a bulk operation is run
+type BulkOptions struct {
+	Concurrency int // maximum number of VMs operated on at once, defaults to 5
+}
+
+// BulkResult is the outcome of a bulk operation on a single VM
+type BulkResult struct {
+	VMID     string
+	Output   string // command output, RunCommandMany only
+	Err      error
+	Attempts int
+	Duration time.Duration
+}
+
+// BulkReport holds the result of a bulk operation for every selected VM, in selection order
+type BulkReport struct {
+	Results []BulkResult
+}
+
+// Succeeded returns the IDs of the VMs the operation succeeded on
+func (r *BulkReport) Succeeded() []string {
+	ids := []string{}
+	for _, result := range r.Results {
+		if result.Err == nil {
+			ids = append(ids, result.VMID)
+		}
+	}
+
+	return ids
+}
+
+// Failed returns the errors of the VMs the operation failed on, keyed by VM ID
+func (r *BulkReport) Failed() map[string]error {
+	failed := map[string]error{}
+	for _, result := range r.Results {
+		if result.Err != nil {
+			failed[result.VMID] = result.Err
+		}
+	}
+
+	return failed
+}
+
+// Err returns an error summarizing the failures, or nil if the operation succeeded on every VM
+func (r *BulkReport) Err() error {
+	failed := r.Failed()
+	if len(failed) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("bulk operation failed on %d of %d VMs", len(failed), len(r.Results))
+}
+
+// BulkOperation is run once per selected VM, returning optional output
+type BulkOperation func(ctx context.Context, vmID string) (string, error)
+
+// SelectVirtualMachines returns the IDs of the VMs matching a selector.
+// An empty selector is rejected, so a bulk operation can never accidentally target every VM.
+func (vmm *AzureVirtualMachineManager) SelectVirtualMachines(ctx context.Context, selector VirtualMachineSelector) ([]string, error) {
+	if len(selector.IDs) > 0 {
+		return selector.IDs, nil
+	}
+
+	if selector.Prefix == "" && len(selector.Tags) == 0 {
+		return nil, fmt.Errorf("VM selector must specify IDs, a prefix or tags")
+	}
+
+	ids := []string{}
+	pager := vmm.vmClient.NewListPager(vmm.Credentials.ResourceGroup, nil)
+	for pager.More() {
+		resp, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "Select Virtual Machines")
+		}
+
+		for _, azVM := range resp.Value {
+			if azVM.Name != nil && matchesSelector(azVM, selector) {
+				ids = append(ids, *azVM.Name)
+			}
+		}
+	}
+
+	return ids, nil
+}
+
+func matchesSelector(azVM *armcompute.VirtualMachine, selector VirtualMachineSelector) bool {
+	if !strings.HasPrefix(*azVM.Name, selector.Prefix) {
+		return false
+	}
+
+	for key, value := range selector.Tags {
+		if tagValue(azVM.Tags, key) != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// StartMany starts the selected VMs. An error is only returned if the VMs can't be selected,
+// failures on individual VMs are reported in the BulkReport.
+func (vmm *AzureVirtualMachineManager) StartMany(ctx context.Context, selector VirtualMachineSelector, options BulkOptions) (*BulkReport, error) {
+	return vmm.runMany(ctx, "StartMany", selector, options, func(ctx context.Context, vmID string) (string, error) {
+		return "", vmm.StartVirtualMachine(ctx, vmID)
+	})
+}
+
+// StopMany deallocates the selected VMs, reporting failures per VM
+func (vmm *AzureVirtualMachineManager) StopMany(ctx context.Context, selector VirtualMachineSelector, options BulkOptions) (*BulkReport, error) {
+	return vmm.runMany(ctx, "StopMany", selector, options, func(ctx context.Context, vmID string) (string, error) {
+		return "", vmm.StopVirtualMachine(ctx, vmID)
+	})
+}
+
+// DeleteMany deletes the selected VMs and their disks and NICs, reporting failures per VM
+func (vmm *AzureVirtualMachineManager) DeleteMany(ctx context.Context, selector VirtualMachineSelector, options BulkOptions) (*BulkReport, error) {
+	return vmm.runMany(ctx, "DeleteMany", selector, options, func(ctx context.Context, vmID string) (string, error) {
+		return "", vmm.DeleteVirtualMachine(ctx, vmID)
+	})
+}
+
+// RunCommandMany runs a script on the selected VMs, using windowsScript on Windows VMs and linuxScript on Linux VMs.
+// A nil script fails the VMs of that OS. The output of each VM is returned in its BulkResult.
+func (vmm *AzureVirtualMachineManager) RunCommandMany(ctx context.Context, selector VirtualMachineSelector, windowsScript, linuxScript *string, timeout time.Duration, options BulkOptions) (*BulkReport, error) {
+	return vmm.runMany(ctx, "RunCommandMany", selector, options, func(ctx context.Context, vmID string) (string, error) {
+		vm, err := vmm.GetVirtualMachine(ctx, vmID, false)
+		if err != nil {
+			return "", err
+		}
+		if vm == nil {
+			return "", fmt.Errorf("VM [%s] not found", vmID)
+		}
+
+		if strings.EqualFold(vm.Template.OperatingSystem, string(armcompute.OperatingSystemTypesWindows)) {
+			if windowsScript == nil {
+				return "", fmt.Errorf("no script given for Windows VM [%s]", vmID)
+			}
+			return vmm.ExecuteRemotePowershellWithOutput(ctx, vmID, windowsScript, timeout, bulkRunCommandPollInterval)
+		}
+
+		if linuxScript == nil {
+			return "", fmt.Errorf("no script given for Linux VM [%s]", vmID)
+		}
+		return vmm.ExecuteRemoteShellScriptWithOutput(ctx, vmID, linuxScript, timeout, bulkRunCommandPollInterval)
+	})
+}
+
+func (vmm *AzureVirtualMachineManager) runMany(ctx context.Context, name string, selector VirtualMachineSelector, options BulkOptions, op BulkOperation) (*BulkReport, error) {
+	log := logging.GetLogger(ctx).With("operation", name)
+
+	ids, err := vmm.SelectVirtualMachines(ctx, selector)
+	if err != nil {
+		return nil, err
+	}
+
+	log.InfoContext(ctx, "Bulk operation starting", "count", len(ids))
+	report := RunBulk(ctx, ids, options, op)
+	log.InfoContext(ctx, "Bulk operation complete", "succeeded", len(report.Succeeded()), "failed", len(report.Failed()))
+
+	return report, nil
+}
+
+// RunBulk runs an operation on each VM with bounded concurrency and collects a result per VM.
+// When ARM throttles a request, all workers pause for the requested time and the operation is retried.
+func RunBulk(ctx context.Context, vmIDs []string, options BulkOptions, op BulkOperation) *BulkReport {
+	concurrency := options.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultBulkConcurrency
+	}
+
+	report := &BulkReport{Results: make([]BulkResult, len(vmIDs))}
+	gate := &throttleGate{}
+	sem := make(chan struct{}, concurrency)
+
+	var wg sync.WaitGroup
+	for i, vmID := range vmIDs {
+		wg.Add(1)
+		go func(i int, vmID string) {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			report.Results[i] = runBulkItem(ctx, gate, vmID, op)
+		}(i, vmID)
+	}
+	wg.Wait()
+
+	return report
+}
+
+func runBulkItem(ctx context.Context, gate *throttleGate, vmID string, op BulkOperation) BulkResult {
+	log := logging.GetLogger(ctx).With("vmID", vmID)
+	result := BulkResult{VMID: vmID}
+	start := time.Now()
+
+	for {
+		if err := gate.wait(ctx); err != nil {
+			result.Err = err
+			break
+		}
+
+		result.Attempts++
+		result.Output, result.Err = op(ctx, vmID)
+
+		backoff, throttled := throttleBackoff(result.Err)
+		if !throttled || result.Attempts > maxThrottleRetries {
+			break
+		}
+
+		log.WarnContext(ctx, "Bulk operation throttled, pausing", "backoff", backoff, "attempt", result.Attempts)
+		gate.pause(backoff)
+	}
+
+	result.Duration = time.Since(start)
+	if result.Err != nil {
+		log.WarnContext(ctx, "Bulk operation failed for VM", "err", result.Err)
+	}
+
+	return result
+}
+
+// throttleGate pauses every worker of a bulk operation while ARM is throttling
+type throttleGate struct {
+	mu         sync.Mutex
+	pauseUntil time.Time
+}
+
+func (g *throttleGate) pause(d time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if until := time.Now().Add(d); until.After(g.pauseUntil) {
+		g.pauseUntil = until
+	}
+}
+
+func (g *throttleGate) wait(ctx context.Context) error {
+	g.mu.Lock()
+	d := time.Until(g.pauseUntil)
+	g.mu.Unlock()
+
+	if d <= 0 {
+		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
+
+// throttleBackoff reports whether an error is an ARM throttling response, and how long to back off
+func throttleBackoff(err error) (time.Duration, bool) {
+	respErr := cloudyazure.ToResponseError(err)
+	if respErr == nil || respErr.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+
+	if respErr.RawResponse != nil {
+		if seconds, err := strconv.Atoi(respErr.RawResponse.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+
+	return defaultThrottleBackoff, true
+}
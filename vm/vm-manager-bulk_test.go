@@ -0,0 +1,77 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchesSelector(t *testing.T) {
+	azVM := &armcompute.VirtualMachine{
+		Name: to.Ptr("shvm-123"),
+		Tags: map[string]*string{"TeamID": to.Ptr("team-a")},
+	}
+
+	assert.True(t, matchesSelector(azVM, VirtualMachineSelector{Prefix: "shvm-"}))
+	assert.True(t, matchesSelector(azVM, VirtualMachineSelector{Tags: map[string]string{"teamid": "team-a"}}))
+	assert.False(t, matchesSelector(azVM, VirtualMachineSelector{Prefix: "uvm-"}))
+	assert.False(t, matchesSelector(azVM, VirtualMachineSelector{Prefix: "shvm-", Tags: map[string]string{"TeamID": "team-b"}}))
+}
+
+func TestRunBulk(t *testing.T) {
+	var running, maxRunning int32
+	ids := []string{"vm-1", "vm-2", "vm-3", "vm-4", "vm-5", "vm-6"}
+
+	report := RunBulk(context.Background(), ids, BulkOptions{Concurrency: 2}, func(ctx context.Context, vmID string) (string, error) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		if vmID == "vm-3" {
+			return "", fmt.Errorf("boom")
+		}
+		return "ok " + vmID, nil
+	})
+
+	assert.LessOrEqual(t, maxRunning, int32(2))
+	assert.Len(t, report.Results, len(ids))
+	assert.Equal(t, "vm-1", report.Results[0].VMID)
+	assert.Equal(t, "ok vm-1", report.Results[0].Output)
+	assert.Equal(t, []string{"vm-1", "vm-2", "vm-4", "vm-5", "vm-6"}, report.Succeeded())
+	assert.Contains(t, report.Failed(), "vm-3")
+	assert.Error(t, report.Err())
+}
+
+func TestThrottleBackoff(t *testing.T) {
+	throttled := &azcore.ResponseError{
+		StatusCode:  http.StatusTooManyRequests,
+		RawResponse: &http.Response{Header: http.Header{"Retry-After": []string{"12"}}},
+	}
+
+	backoff, ok := throttleBackoff(fmt.Errorf("start: %w", throttled))
+	assert.True(t, ok)
+	assert.Equal(t, 12*time.Second, backoff)
+
+	backoff, ok = throttleBackoff(&azcore.ResponseError{StatusCode: http.StatusTooManyRequests})
+	assert.True(t, ok)
+	assert.Equal(t, defaultThrottleBackoff, backoff)
+
+	_, ok = throttleBackoff(&azcore.ResponseError{StatusCode: http.StatusConflict})
+	assert.False(t, ok)
+	_, ok = throttleBackoff(nil)
+	assert.False(t, ok)
+}
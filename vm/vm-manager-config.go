@@ -1,8 +1,90 @@
 package vm
 
+import (
+	"context"
+	"time"
+)
+
 type VirtualMachineManagerConfig struct {
 	DomainControllers []*string
 	SubnetIds         []string
 	VnetResourceGroup string
 	VnetId            string
+
+	SourceImageGalleryName string // optional, shared image gallery searched by GetLatestImageVersion
+
+	BootDiagnostics *BootDiagnosticsConfig // optional, nil leaves boot diagnostics disabled on create
+
+	// Query VMs with Azure Resource Graph instead of listing them through the compute API.
+	// The compute API is still used if a Resource Graph query fails.
+	UseResourceGraph           bool
+	ResourceGraphSubscriptions []string // optional, subscriptions searched by Resource Graph queries, defaults to the manager's subscription
+
+	// Roles granted to the system-assigned identity of each created VM, and removed when the VM is deleted
+	RoleAssignments []RoleAssignmentConfig
+
+	FileTransfer *FileTransferConfig // optional, nil disables CopyFileToVirtualMachine and CopyFileFromVirtualMachine
+
+	Connection *ConnectionConfig // optional, nil describes RDP / SSH connections to the VM's private IP
+
+	JITAccess *JITAccessConfig // optional, nil disables just-in-time access grants
+}
+
+// ConnectionMode selects how users connect to VMs that are not connected through AVD
+type ConnectionMode string
+
+const (
+	ConnectionModePrivateIP ConnectionMode = "private" // RDP / SSH to the VM's private IP, e.g. over a VPN or ExpressRoute
+	ConnectionModeBastion   ConnectionMode = "bastion" // through Azure Bastion
+	ConnectionModePublicIP  ConnectionMode = "public"  // RDP / SSH to a public IP created for the VM, restricted by NSG rules
+)
+
+// ConnectionConfig defines how VM connections are described, and the resources created to allow them
+type ConnectionConfig struct {
+	Mode     ConnectionMode            // defaults to ConnectionModePrivateIP, overridden per VM by the ConnectionMode template tag
+	Bastion  *BastionConnectionConfig  // required for ConnectionModeBastion
+	PublicIP *PublicIPConnectionConfig // required for ConnectionModePublicIP
+}
+
+// BastionConnectionConfig defines the Azure Bastion host VMs are connected through
+type BastionConnectionConfig struct {
+	BastionHostID  string // resource ID of the Bastion host, the Standard SKU or above is required for both link types
+	ShareableLinks bool   // create a shareable link per VM, otherwise describe the native client tunnel command
+}
+
+// PublicIPConnectionConfig defines the NSG rules allowing connections to VM public IPs
+type PublicIPConnectionConfig struct {
+	NetworkSecurityGroupID string   // resource ID of the NSG on the VM subnet or NICs, connection rules are added to it
+	AllowedSourcePrefixes  []string // addresses or CIDR ranges allowed to connect, empty creates no standing rule so access must be granted per user
+	RulePriorityStart      int32    // optional, lowest priority used for connection rules, defaults to 3000
+}
+
+// FileTransferConfig defines the blob container files are staged in when they are copied to or from a VM.
+// The manager's identity needs Storage Blob Data Contributor on the container, and permission to create user delegation keys.
+type FileTransferConfig struct {
+	StorageAccount string
+	Container      string
+	SASValidity    time.Duration // optional, lifetime of the staging blob SAS URLs, defaults to 15 minutes
+}
+
+// BootDiagnosticsConfig defines the boot diagnostics settings applied to newly created VMs
+type BootDiagnosticsConfig struct {
+	StorageURI *string // optional, nil uses a managed storage account
+}
+
+// RoleAssignmentConfig defines a role granted to a VM's system-assigned identity
+type RoleAssignmentConfig struct {
+	RoleDefinitionID string // role definition GUID, e.g. 2a2b9908-6ea1-4ae2-8e65-a410df84e7d1 for Storage Blob Data Reader, or its full resource ID
+	Scope            string // resource ID the role is granted on, e.g. a storage account or blob container
+}
+
+// JITAccessConfig defines how just-in-time access to VM admin ports is granted
+type JITAccessConfig struct {
+	NetworkSecurityGroupID string        // optional, NSG grants are added to, defaults to the NSG of the VM's NIC, then of its subnet
+	MaxDuration            time.Duration // longest grant allowed, defaults to 3 hours
+	RulePriorityStart      int32         // optional, lowest priority used for grant rules, defaults to 200 so grants precede standing deny rules
+	ExpiryCheckInterval    time.Duration // how often expired grants are removed, defaults to 1 minute
+
+	// optional, called for every grant, revocation and expiry in addition to the audit log entry
+	OnAudit func(ctx context.Context, event JITAccessAuditEvent)
 }
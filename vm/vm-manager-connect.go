@@ -0,0 +1,358 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork/v5"
+	cloudyazure "github.com/appliedres/cloudy-azure"
+	"github.com/appliedres/cloudy/logging"
+	"github.com/appliedres/cloudy/models"
+	"github.com/pkg/errors"
+)
+
+// Remote desktop providers reported in VM connections
+const (
+	ConnectionProviderRDP     = "RDP"
+	ConnectionProviderSSH     = "SSH"
+	ConnectionProviderBastion = "Bastion"
+)
+
+const (
+	rdpPort                       = 3389
+	sshPort                       = 22
+	defaultConnectionRulePriority = 3000
+)
+
+// ConnectVirtualMachine sets vm.Connect for the VM's connection mode, creating the resources the mode needs:
+// a public IP and NSG rule for ConnectionModePublicIP, or a shareable link for ConnectionModeBastion.
+// Windows VMs are connected with RDP and Linux VMs with SSH. It is safe to call again for a connected VM.
+func (vmm *AzureVirtualMachineManager) ConnectVirtualMachine(ctx context.Context, vm *models.VirtualMachine) error {
+	log := logging.GetLogger(ctx).With("vmID", vm.ID)
+
+	var override ConnectionMode
+	if vm.Template != nil {
+		override = ConnectionMode(strings.ToLower(tagValue(vm.Template.Tags, ConnectionModeTagKey)))
+	}
+	mode := connectionMode(vmm.Config.Connection, override)
+	if err := validateConnectionMode(mode, vmm.Config.Connection); err != nil {
+		return err
+	}
+
+	windows := vm.Template != nil && strings.EqualFold(vm.Template.OperatingSystem, models.VirtualMachineTemplateOperatingSystemWindows)
+	log.DebugContext(ctx, "ConnectVirtualMachine", "mode", mode, "windows", windows)
+
+	switch mode {
+	case ConnectionModeBastion:
+		bastion := vmm.Config.Connection.Bastion
+		if !bastion.ShareableLinks {
+			vm.Connect = &models.VirtualMachineConnection{
+				RemoteDesktopProvider: ConnectionProviderBastion,
+				URL:                   bastionTunnelCommand(bastion.BastionHostID, vmm.virtualMachineResourceID(vm.ID), windows),
+			}
+			return nil
+		}
+
+		link, err := vmm.bastionShareableLink(ctx, vm.ID)
+		if err != nil {
+			return err
+		}
+		vm.Connect = &models.VirtualMachineConnection{
+			RemoteDesktopProvider: ConnectionProviderBastion,
+			URL:                   link,
+		}
+
+	case ConnectionModePublicIP:
+		nic, err := vmm.primaryNic(ctx, vm)
+		if err != nil {
+			return err
+		}
+
+		port := sshPort
+		if windows {
+			port = rdpPort
+		}
+		err = vmm.ensureConnectionRule(ctx, vm.ID, nic.PrivateIP, port)
+		if err != nil {
+			return err
+		}
+
+		publicIP, err := vmm.ensurePublicIP(ctx, vm, nic)
+		if err != nil {
+			return err
+		}
+		vm.Connect = directConnection(publicIP, windows)
+
+	default:
+		nic, err := vmm.primaryNic(ctx, vm)
+		if err != nil {
+			return err
+		}
+		vm.Connect = directConnection(nic.PrivateIP, windows)
+	}
+
+	log.InfoContext(ctx, "ConnectVirtualMachine complete", "mode", mode, "provider", vm.Connect.RemoteDesktopProvider)
+	return nil
+}
+
+// connectionMode returns the connection mode of a VM, from its template tag or the configured mode
+func connectionMode(config *ConnectionConfig, override ConnectionMode) ConnectionMode {
+	if override != "" {
+		return override
+	}
+
+	if config != nil && config.Mode != "" {
+		return config.Mode
+	}
+
+	return ConnectionModePrivateIP
+}
+
+// validateConnectionMode checks that a connection mode is known and configured
+func validateConnectionMode(mode ConnectionMode, config *ConnectionConfig) error {
+	switch mode {
+	case ConnectionModePrivateIP:
+		return nil
+	case ConnectionModeBastion:
+		if config == nil || config.Bastion == nil || config.Bastion.BastionHostID == "" {
+			return fmt.Errorf("connection mode %s requires a Bastion host to be configured", mode)
+		}
+		return nil
+	case ConnectionModePublicIP:
+		if config == nil || config.PublicIP == nil || config.PublicIP.NetworkSecurityGroupID == "" {
+			return fmt.Errorf("connection mode %s requires a network security group to be configured", mode)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported connection mode [%s], must be one of %v", mode,
+			[]ConnectionMode{ConnectionModePrivateIP, ConnectionModeBastion, ConnectionModePublicIP})
+	}
+}
+
+// directConnection describes an RDP connection to a Windows VM or an SSH connection to a Linux VM
+func directConnection(address string, windows bool) *models.VirtualMachineConnection {
+	if windows {
+		return &models.VirtualMachineConnection{
+			RemoteDesktopProvider: ConnectionProviderRDP,
+			URL:                   fmt.Sprintf("rdp://full%%20address=s:%s:%d", address, rdpPort),
+		}
+	}
+
+	return &models.VirtualMachineConnection{
+		RemoteDesktopProvider: ConnectionProviderSSH,
+		URL:                   (&url.URL{Scheme: "ssh", Host: fmt.Sprintf("%s:%d", address, sshPort)}).String(),
+	}
+}
+
+// bastionTunnelCommand returns the Azure CLI command connecting to a VM through Bastion with the native client
+func bastionTunnelCommand(bastionHostID, vmResourceID string, windows bool) string {
+	command := "ssh"
+	auth := " --auth-type AAD"
+	if windows {
+		command, auth = "rdp", ""
+	}
+
+	return fmt.Sprintf("az network bastion %s --name %s --resource-group %s --target-resource-id %s%s",
+		command, path.Base(bastionHostID), resourceGroupFromID(bastionHostID), vmResourceID, auth)
+}
+
+// primaryNic returns the VM's first NIC with a private IP, looking its NICs up if the VM has none
+func (vmm *AzureVirtualMachineManager) primaryNic(ctx context.Context, vm *models.VirtualMachine) (*models.VirtualMachineNic, error) {
+	nics := vm.Nics
+	if len(nics) == 0 {
+		var err error
+		nics, err = vmm.GetNics(ctx, vm.ID)
+		if err != nil {
+			return nil, errors.Wrap(err, "ConnectVirtualMachine")
+		}
+	}
+
+	for _, nic := range nics {
+		if nic != nil && nic.PrivateIP != "" {
+			return nic, nil
+		}
+	}
+
+	return nil, fmt.Errorf("VM %s has no NIC with a private IP", vm.ID)
+}
+
+// bastionShareableLink creates, or returns the existing, Bastion shareable link of a VM
+func (vmm *AzureVirtualMachineManager) bastionShareableLink(ctx context.Context, vmID string) (string, error) {
+	hostID := vmm.Config.Connection.Bastion.BastionHostID
+	vmResourceID := vmm.virtualMachineResourceID(vmID)
+
+	poller, err := vmm.bastionClient.BeginPutBastionShareableLink(ctx, resourceGroupFromID(hostID), path.Base(hostID),
+		armnetwork.BastionShareableLinkListRequest{
+			VMs: []*armnetwork.BastionShareableLink{{VM: &armnetwork.VM{ID: to.Ptr(vmResourceID)}}},
+		}, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "Bastion shareable link")
+	}
+
+	pager, err := poller.PollUntilDone(ctx, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "Bastion shareable link: polling")
+	}
+
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return "", errors.Wrap(err, "Bastion shareable link: list")
+		}
+
+		for _, link := range page.Value {
+			if link == nil || link.VM == nil || link.VM.ID == nil || !strings.EqualFold(*link.VM.ID, vmResourceID) {
+				continue
+			}
+			if link.Bsl != nil && *link.Bsl != "" {
+				return *link.Bsl, nil
+			}
+			if link.Message != nil {
+				return "", fmt.Errorf("Bastion shareable link for VM %s not created: %s", vmID, *link.Message)
+			}
+		}
+	}
+
+	return "", fmt.Errorf("Bastion shareable link for VM %s not returned", vmID)
+}
+
+// deleteBastionShareableLink removes the Bastion shareable link of a VM, if links are configured
+func (vmm *AzureVirtualMachineManager) deleteBastionShareableLink(ctx context.Context, vmID string) error {
+	config := vmm.Config.Connection
+	if config == nil || config.Bastion == nil || config.Bastion.BastionHostID == "" || !config.Bastion.ShareableLinks {
+		return nil
+	}
+	hostID := config.Bastion.BastionHostID
+
+	poller, err := vmm.bastionClient.BeginDeleteBastionShareableLink(ctx, resourceGroupFromID(hostID), path.Base(hostID),
+		armnetwork.BastionShareableLinkListRequest{
+			VMs: []*armnetwork.BastionShareableLink{{VM: &armnetwork.VM{ID: to.Ptr(vmm.virtualMachineResourceID(vmID))}}},
+		}, nil)
+	if err != nil {
+		if cloudyazure.Is404(err) {
+			return nil
+		}
+		return errors.Wrap(err, "delete Bastion shareable link")
+	}
+
+	_, err = poller.PollUntilDone(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "delete Bastion shareable link: polling")
+	}
+
+	return nil
+}
+
+// ensurePublicIP creates the VM's public IP if needed, attaches it to the NIC and returns its address
+func (vmm *AzureVirtualMachineManager) ensurePublicIP(ctx context.Context, vm *models.VirtualMachine, nic *models.VirtualMachineNic) (string, error) {
+	log := logging.GetLogger(ctx)
+
+	poller, err := vmm.publicIPClient.BeginCreateOrUpdate(ctx, vmm.Credentials.ResourceGroup, publicIPName(vm.ID), armnetwork.PublicIPAddress{
+		Location: to.Ptr(vmm.Credentials.Region),
+		Tags:     generateAzureTagsForVM(vm),
+		SKU: &armnetwork.PublicIPAddressSKU{
+			Name: to.Ptr(armnetwork.PublicIPAddressSKUNameStandard),
+			Tier: to.Ptr(armnetwork.PublicIPAddressSKUTierRegional),
+		},
+		Properties: &armnetwork.PublicIPAddressPropertiesFormat{
+			PublicIPAllocationMethod: to.Ptr(armnetwork.IPAllocationMethodStatic),
+			PublicIPAddressVersion:   to.Ptr(armnetwork.IPVersionIPv4),
+		},
+	}, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "create public IP")
+	}
+
+	publicIP, err := poller.PollUntilDone(ctx, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "create public IP: polling")
+	}
+	if publicIP.Properties == nil || publicIP.Properties.IPAddress == nil {
+		return "", fmt.Errorf("public IP %s has no address", publicIPName(vm.ID))
+	}
+
+	azNic, err := vmm.nicClient.Get(ctx, vmm.Config.VnetResourceGroup, nic.Name, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "get NIC")
+	}
+	if azNic.Properties == nil || len(azNic.Properties.IPConfigurations) == 0 || azNic.Properties.IPConfigurations[0].Properties == nil {
+		return "", fmt.Errorf("NIC %s has no IP configuration", nic.Name)
+	}
+
+	ipConfig := azNic.Properties.IPConfigurations[0].Properties
+	if ipConfig.PublicIPAddress == nil || ipConfig.PublicIPAddress.ID == nil || !strings.EqualFold(*ipConfig.PublicIPAddress.ID, *publicIP.ID) {
+		log.InfoContext(ctx, "Attaching public IP to NIC", "nic", nic.Name, "publicIP", *publicIP.ID)
+		ipConfig.PublicIPAddress = &armnetwork.PublicIPAddress{ID: publicIP.ID}
+
+		nicPoller, err := vmm.nicClient.BeginCreateOrUpdate(ctx, vmm.Config.VnetResourceGroup, nic.Name, azNic.Interface, nil)
+		if err != nil {
+			return "", errors.Wrap(err, "attach public IP")
+		}
+		_, err = nicPoller.PollUntilDone(ctx, nil)
+		if err != nil {
+			return "", errors.Wrap(err, "attach public IP: polling")
+		}
+	}
+
+	return *publicIP.Properties.IPAddress, nil
+}
+
+// ensureConnectionRule allows the configured source prefixes to connect to the VM's private IP on the given port.
+// Nothing is created when no source prefixes are configured.
+func (vmm *AzureVirtualMachineManager) ensureConnectionRule(ctx context.Context, vmID, privateIP string, port int) error {
+	config := vmm.Config.Connection.PublicIP
+	if len(config.AllowedSourcePrefixes) == 0 {
+		return nil
+	}
+
+	return vmm.ensureSecurityRule(ctx, config.NetworkSecurityGroupID, connectionRuleName(vmID), config.RulePriorityStart, armnetwork.SecurityRulePropertiesFormat{
+		Description:              to.Ptr(fmt.Sprintf("connections to VM %s", vmID)),
+		Access:                   to.Ptr(armnetwork.SecurityRuleAccessAllow),
+		Direction:                to.Ptr(armnetwork.SecurityRuleDirectionInbound),
+		Protocol:                 to.Ptr(armnetwork.SecurityRuleProtocolTCP),
+		SourceAddressPrefixes:    to.SliceOfPtrs(config.AllowedSourcePrefixes...),
+		SourcePortRange:          to.Ptr("*"),
+		DestinationAddressPrefix: to.Ptr(privateIP),
+		DestinationPortRange:     to.Ptr(fmt.Sprint(port)),
+	})
+}
+
+// deletePublicConnection removes the VM's connection rule and public IP. The public IP can only be deleted once the NIC is gone.
+func (vmm *AzureVirtualMachineManager) deletePublicConnection(ctx context.Context, vmID string) error {
+	config := vmm.Config.Connection
+	if config == nil || config.PublicIP == nil || config.PublicIP.NetworkSecurityGroupID == "" {
+		return nil
+	}
+
+	err := vmm.deleteSecurityRule(ctx, config.PublicIP.NetworkSecurityGroupID, connectionRuleName(vmID))
+	if err != nil {
+		return err
+	}
+
+	poller, err := vmm.publicIPClient.BeginDelete(ctx, vmm.Credentials.ResourceGroup, publicIPName(vmID), nil)
+	if err != nil {
+		if cloudyazure.Is404(err) {
+			return nil
+		}
+		return errors.Wrap(err, "delete public IP")
+	}
+
+	_, err = poller.PollUntilDone(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "delete public IP: polling")
+	}
+
+	return nil
+}
+
+func publicIPName(vmID string) string {
+	return vmID + "-pip"
+}
+
+func connectionRuleName(vmID string) string {
+	return vmID + "-connect"
+}
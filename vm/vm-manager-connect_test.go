@@ -0,0 +1,73 @@
+package vm
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork/v5"
+	"github.com/appliedres/cloudy/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnectionMode(t *testing.T) {
+	assert.Equal(t, ConnectionModePrivateIP, connectionMode(nil, ""))
+	assert.Equal(t, ConnectionModeBastion, connectionMode(&ConnectionConfig{Mode: ConnectionModeBastion}, ""))
+	assert.Equal(t, ConnectionModePublicIP, connectionMode(&ConnectionConfig{Mode: ConnectionModeBastion}, ConnectionModePublicIP))
+
+	opts, err := parseTemplateOptions(&models.VirtualMachineTemplate{Tags: map[string]*string{"connectionmode": to.Ptr("Bastion")}})
+	assert.NoError(t, err)
+	assert.Equal(t, ConnectionModeBastion, opts.ConnectionMode)
+}
+
+func TestValidateConnectionMode(t *testing.T) {
+	assert.NoError(t, validateConnectionMode(ConnectionModePrivateIP, nil))
+	assert.Error(t, validateConnectionMode(ConnectionModeBastion, nil))
+	assert.Error(t, validateConnectionMode(ConnectionModePublicIP, &ConnectionConfig{PublicIP: &PublicIPConnectionConfig{}}))
+	assert.Error(t, validateConnectionMode("vpn", &ConnectionConfig{}))
+
+	config := &ConnectionConfig{
+		Bastion:  &BastionConnectionConfig{BastionHostID: "/subscriptions/sub/resourceGroups/net/providers/Microsoft.Network/bastionHosts/bastion"},
+		PublicIP: &PublicIPConnectionConfig{NetworkSecurityGroupID: "/subscriptions/sub/resourceGroups/net/providers/Microsoft.Network/networkSecurityGroups/nsg"},
+	}
+	assert.NoError(t, validateConnectionMode(ConnectionModeBastion, config))
+	assert.NoError(t, validateConnectionMode(ConnectionModePublicIP, config))
+}
+
+func TestDirectConnection(t *testing.T) {
+	rdp := directConnection("10.0.0.4", true)
+	assert.Equal(t, ConnectionProviderRDP, rdp.RemoteDesktopProvider)
+	assert.Equal(t, "rdp://full%20address=s:10.0.0.4:3389", rdp.URL)
+
+	ssh := directConnection("20.1.2.3", false)
+	assert.Equal(t, ConnectionProviderSSH, ssh.RemoteDesktopProvider)
+	assert.Equal(t, "ssh://20.1.2.3:22", ssh.URL)
+}
+
+func TestBastionTunnelCommand(t *testing.T) {
+	hostID := "/subscriptions/sub/resourceGroups/net/providers/Microsoft.Network/bastionHosts/bastion"
+	vmID := "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/uvm-1"
+
+	assert.Equal(t, "az network bastion rdp --name bastion --resource-group net --target-resource-id "+vmID,
+		bastionTunnelCommand(hostID, vmID, true))
+	assert.Equal(t, "az network bastion ssh --name bastion --resource-group net --target-resource-id "+vmID+" --auth-type AAD",
+		bastionTunnelCommand(hostID, vmID, false))
+}
+
+func TestSecurityRulePriority(t *testing.T) {
+	rules := []*armnetwork.SecurityRule{
+		{Name: to.Ptr("uvm-1-connect"), Properties: &armnetwork.SecurityRulePropertiesFormat{Priority: to.Ptr(int32(3000))}},
+		{Name: to.Ptr("uvm-2-connect"), Properties: &armnetwork.SecurityRulePropertiesFormat{Priority: to.Ptr(int32(3001))}},
+		{Name: to.Ptr("deny-all"), Properties: &armnetwork.SecurityRulePropertiesFormat{Priority: to.Ptr(int32(4096))}},
+	}
+
+	priority, err := securityRulePriority(rules, "uvm-2-connect", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3001), priority)
+
+	priority, err = securityRulePriority(rules, "uvm-3-connect", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3002), priority)
+
+	_, err = securityRulePriority(rules, "uvm-3-connect", 4096)
+	assert.Error(t, err)
+}
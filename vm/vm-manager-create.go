@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
 	cloudyazure "github.com/appliedres/cloudy-azure"
 	"github.com/appliedres/cloudy/logging"
 	"github.com/appliedres/cloudy/models"
@@ -59,6 +60,12 @@ func generateAzureTagsForVM(vm *models.VirtualMachine) map[string]*string {
 }
 
 func (vmm *AzureVirtualMachineManager) CreateVirtualMachine(ctx context.Context, vm *models.VirtualMachine) (*models.VirtualMachine, error) {
+	return vmm.createVirtualMachine(ctx, vm, nil)
+}
+
+// createVirtualMachine creates a VM. If customize is set, it is called with the VM create parameters before they are sent,
+// to set anything the cloudy model can't express.
+func (vmm *AzureVirtualMachineManager) createVirtualMachine(ctx context.Context, vm *models.VirtualMachine, customize func(*armcompute.VirtualMachine)) (*models.VirtualMachine, error) {
 	log := logging.GetLogger(ctx)
 
 	if vm.ID == "" {
@@ -74,6 +81,17 @@ func (vmm *AzureVirtualMachineManager) CreateVirtualMachine(ctx context.Context,
 		}
 	}
 
+	// fail before any resources are created if the template can't be satisfied by the size
+	err := vmm.validateTemplateForSize(ctx, vm.Template)
+	if err != nil {
+		return nil, errors.Wrap(err, "VM Create, template validation failed")
+	}
+
+	zone, err := vmm.selectZone(ctx, vm.Template)
+	if err != nil {
+		return nil, errors.Wrap(err, "VM Create, zone selection failed")
+	}
+
 	log.InfoContext(ctx, "VM Create creating nics")
 
 	nics, err := vmm.GetNics(ctx, vm.ID)
@@ -91,11 +109,17 @@ func (vmm *AzureVirtualMachineManager) CreateVirtualMachine(ctx context.Context,
 
 	log.InfoContext(ctx, "VM Create converting from cloudy to azure")
 
-	virtualMachineParameters, err := FromCloudyVirtualMachine(ctx, vm)
+	virtualMachineParameters, err := fromCloudyVirtualMachine(ctx, vm, zone)
 	if err != nil {
 		return nil, errors.Wrap(err, "VM Create, FromCloudyVirtualMachine failed")
 	}
 
+	vmm.applyBootDiagnostics(virtualMachineParameters)
+
+	if customize != nil {
+		customize(virtualMachineParameters)
+	}
+
 	log.InfoContext(ctx, "VM Create BeginCreateOrUpdate starting")
 
 	poller, err := vmm.vmClient.BeginCreateOrUpdate(ctx,
@@ -113,6 +137,64 @@ func (vmm *AzureVirtualMachineManager) CreateVirtualMachine(ctx context.Context,
 	if err != nil {
 		return nil, errors.Wrap(err, "VM Create")
 	}
+	vm.Location.ID = zone
+
+	if vmm.Config != nil && len(vmm.Config.RoleAssignments) > 0 {
+		identity := response.VirtualMachine.Identity
+		if identity == nil || identity.PrincipalID == nil {
+			log.WarnContext(ctx, "VM Create, VM has no system-assigned identity, skipping role assignments")
+		} else {
+			err = vmm.GrantConfiguredRoles(ctx, vm.ID, *identity.PrincipalID)
+			if err != nil {
+				// the VM exists, so it is returned for the caller to retry the assignments or delete it
+				return vm, errors.Wrap(err, "VM Create, role assignments")
+			}
+		}
+	}
 
 	return vm, nil
 }
+
+// validateTemplateForSize checks the template options against the capabilities of the template's VM size
+func (vmm *AzureVirtualMachineManager) validateTemplateForSize(ctx context.Context, template *models.VirtualMachineTemplate) error {
+	if template == nil || template.Size == nil || template.Size.ID == "" {
+		return fmt.Errorf("template must specify a VM size")
+	}
+
+	options, err := parseTemplateOptions(template)
+	if err != nil {
+		return err
+	}
+
+	sku, err := vmm.getResourceSKU(ctx, template.Size.ID)
+	if err != nil {
+		return err
+	}
+	caps := newSKUCapabilities(sku)
+
+	err = validateSecurityOptions(templateSecurityType(template), options, caps)
+	if err != nil {
+		return err
+	}
+
+	err = validateHibernationOptions(options, caps)
+	if err != nil {
+		return err
+	}
+
+	err = validateOSDiskOptions(template, options, caps)
+	if err != nil {
+		return err
+	}
+
+	var connection *ConnectionConfig
+	if vmm.Config != nil {
+		connection = vmm.Config.Connection
+	}
+	err = validateConnectionMode(connectionMode(connection, options.ConnectionMode), connection)
+	if err != nil {
+		return err
+	}
+
+	return validatePlacementOptions(options, skuZones(sku, vmm.Credentials.Region))
+}
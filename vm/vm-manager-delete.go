@@ -25,8 +25,25 @@ func (vmm *AzureVirtualMachineManager) DeleteVirtualMachine(ctx context.Context,
 		return errors.New("vmm.Delete: VM ID not set")
 	}
 
+	if vmm.Config != nil && len(vmm.Config.RoleAssignments) > 0 {
+		// role assignments outlive the identity, so remove them while the principal is still known
+		resp, err := vmm.vmClient.Get(ctx, vmm.Credentials.ResourceGroup, vmId, nil)
+		if err != nil && !cloudyazure.Is404(err) {
+			return errors.Wrap(err, "VM Delete: Get identity")
+		}
+		if err == nil && resp.Identity != nil && resp.Identity.PrincipalID != nil {
+			vmm.RevokeConfiguredRoles(ctx, vmId, *resp.Identity.PrincipalID)
+		}
+	}
+
+	// a shareable link references the VM, so it is removed while the VM still exists
+	err := vmm.deleteBastionShareableLink(ctx, vmId)
+	if err != nil {
+		log.WarnContext(ctx, "DeleteVM could not delete Bastion shareable link", logging.WithError(err))
+	}
+
 	log.InfoContext(ctx, "DeleteVM Starting Deallocate")
-	err := vmm.deallocateVirtualMachine(ctx, vmId)
+	err = vmm.deallocateVirtualMachine(ctx, vmId)
 	if err != nil {
 		return err
 	}
@@ -86,5 +103,15 @@ func (vmm *AzureVirtualMachineManager) DeleteVirtualMachine(ctx context.Context,
 		log.InfoContext(ctx, "No Nics found")
 	}
 
+	err = vmm.deletePublicConnection(ctx, vmId)
+	if err != nil {
+		return errors.Wrap(err, "VM Delete")
+	}
+
+	err = vmm.revokeJITAccessForVM(ctx, vmId)
+	if err != nil {
+		return errors.Wrap(err, "VM Delete")
+	}
+
 	return nil
 }
@@ -0,0 +1,104 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	cloudyazure "github.com/appliedres/cloudy-azure"
+	"github.com/appliedres/cloudy/logging"
+	"github.com/pkg/errors"
+)
+
+const (
+	// lifetime of the SAS URIs returned for boot diagnostics blobs
+	bootDiagnosticsSasExpirationMinutes = 30
+
+	// serial logs are small, but guard against reading an unbounded response
+	maxSerialConsoleLogBytes = 10 * 1024 * 1024
+)
+
+// BootDiagnosticsData holds short-lived SAS URIs for a VM's boot diagnostics blobs
+type BootDiagnosticsData struct {
+	ConsoleScreenshotURI string
+	SerialConsoleLogURI  string
+}
+
+// applyBootDiagnostics enables boot diagnostics on VM create parameters if configured
+func (vmm *AzureVirtualMachineManager) applyBootDiagnostics(azVM *armcompute.VirtualMachine) {
+	if vmm.Config == nil || vmm.Config.BootDiagnostics == nil {
+		return
+	}
+
+	azVM.Properties.DiagnosticsProfile = &armcompute.DiagnosticsProfile{
+		BootDiagnostics: &armcompute.BootDiagnostics{
+			Enabled:    to.Ptr(true),
+			StorageURI: vmm.Config.BootDiagnostics.StorageURI,
+		},
+	}
+}
+
+// GetBootDiagnostics retrieves SAS URIs for the serial console log and screenshot of a VM.
+// Boot diagnostics must be enabled on the VM.
+func (vmm *AzureVirtualMachineManager) GetBootDiagnostics(ctx context.Context, vmName string) (*BootDiagnosticsData, error) {
+	log := logging.GetLogger(ctx).With("vmName", vmName)
+
+	resp, err := vmm.vmClient.RetrieveBootDiagnosticsData(ctx, vmm.Credentials.ResourceGroup, vmName,
+		&armcompute.VirtualMachinesClientRetrieveBootDiagnosticsDataOptions{
+			SasURIExpirationTimeInMinutes: to.Ptr[int32](bootDiagnosticsSasExpirationMinutes),
+		})
+	if err != nil {
+		if cloudyazure.Is404(err) {
+			log.DebugContext(ctx, "Boot diagnostics not found")
+			return nil, nil
+		}
+
+		return nil, errors.Wrap(err, "Get Boot Diagnostics")
+	}
+
+	data := &BootDiagnosticsData{}
+	if resp.ConsoleScreenshotBlobURI != nil {
+		data.ConsoleScreenshotURI = *resp.ConsoleScreenshotBlobURI
+	}
+	if resp.SerialConsoleLogBlobURI != nil {
+		data.SerialConsoleLogURI = *resp.SerialConsoleLogBlobURI
+	}
+
+	return data, nil
+}
+
+// GetSerialConsoleLog downloads the boot diagnostics serial console log of a VM.
+func (vmm *AzureVirtualMachineManager) GetSerialConsoleLog(ctx context.Context, vmName string) (string, error) {
+	data, err := vmm.GetBootDiagnostics(ctx, vmName)
+	if err != nil {
+		return "", err
+	}
+	if data == nil || data.SerialConsoleLogURI == "" {
+		return "", fmt.Errorf("no serial console log available for VM [%s], is boot diagnostics enabled?", vmName)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, data.SerialConsoleLogURI, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "Get Serial Console Log")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "Get Serial Console Log")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Get Serial Console Log: unexpected status %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxSerialConsoleLogBytes))
+	if err != nil {
+		return "", errors.Wrap(err, "Get Serial Console Log")
+	}
+
+	return string(body), nil
+}
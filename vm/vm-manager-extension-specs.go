@@ -0,0 +1,152 @@
+package vm
+
+// Typed specs for commonly used VM extensions. Pass the result to InstallExtension.
+
+const (
+	CustomScriptWindowsExtensionName = "CustomScriptExtension"
+	CustomScriptLinuxExtensionName   = "CustomScript"
+	DomainJoinExtensionName          = "JsonADDomainExtension"
+	AADLoginWindowsExtensionName     = "AADLoginForWindows"
+	AADSSHLoginLinuxExtensionName    = "AADSSHLoginForLinux"
+	AzureMonitorWindowsExtensionName = "AzureMonitorWindowsAgent"
+	AzureMonitorLinuxExtensionName   = "AzureMonitorLinuxAgent"
+	AVDDSCExtensionName              = "Microsoft.PowerShell.DSC"
+
+	// join the domain and create the computer account (NETSETUP_JOIN_DOMAIN | NETSETUP_ACCT_CREATE)
+	domainJoinOptions = "3"
+
+	// entry point of the AVD session host DSC configuration package
+	avdDSCConfigurationFunction = "Configuration.ps1\\AddSessionHost"
+)
+
+// CustomScriptWindowsExtension runs a command on a Windows VM, after downloading fileURIs.
+// The command and URIs are protected, as they commonly contain secrets or SAS tokens.
+func CustomScriptWindowsExtension(commandToExecute string, fileURIs []string) VirtualMachineExtensionSpec {
+	return VirtualMachineExtensionSpec{
+		Name:                    CustomScriptWindowsExtensionName,
+		Publisher:               "Microsoft.Compute",
+		Type:                    "CustomScriptExtension",
+		TypeHandlerVersion:      "1.10",
+		AutoUpgradeMinorVersion: true,
+		ProtectedSettings:       customScriptSettings(commandToExecute, fileURIs),
+	}
+}
+
+// CustomScriptLinuxExtension runs a command on a Linux VM, after downloading fileURIs.
+// The command and URIs are protected, as they commonly contain secrets or SAS tokens.
+func CustomScriptLinuxExtension(commandToExecute string, fileURIs []string) VirtualMachineExtensionSpec {
+	return VirtualMachineExtensionSpec{
+		Name:                    CustomScriptLinuxExtensionName,
+		Publisher:               "Microsoft.Azure.Extensions",
+		Type:                    "CustomScript",
+		TypeHandlerVersion:      "2.1",
+		AutoUpgradeMinorVersion: true,
+		ProtectedSettings:       customScriptSettings(commandToExecute, fileURIs),
+	}
+}
+
+func customScriptSettings(commandToExecute string, fileURIs []string) map[string]any {
+	settings := map[string]any{
+		"commandToExecute": commandToExecute,
+	}
+	if len(fileURIs) > 0 {
+		settings["fileUris"] = fileURIs
+	}
+
+	return settings
+}
+
+// DomainJoinExtension joins a Windows VM to an Active Directory domain.
+// ouPath is optional, an empty string uses the domain's default computers container.
+func DomainJoinExtension(domainName, username, password, ouPath string, restart bool) VirtualMachineExtensionSpec {
+	restartValue := "false"
+	if restart {
+		restartValue = "true"
+	}
+
+	return VirtualMachineExtensionSpec{
+		Name:                    DomainJoinExtensionName,
+		Publisher:               "Microsoft.Compute",
+		Type:                    "JsonADDomainExtension",
+		TypeHandlerVersion:      "1.3",
+		AutoUpgradeMinorVersion: true,
+		Settings: map[string]any{
+			"Name":    domainName,
+			"User":    username,
+			"OUPath":  ouPath,
+			"Restart": restartValue,
+			"Options": domainJoinOptions,
+		},
+		ProtectedSettings: map[string]any{
+			"Password": password,
+		},
+	}
+}
+
+// AADLoginForWindowsExtension enables Entra ID (Azure AD) login on a Windows VM
+func AADLoginForWindowsExtension() VirtualMachineExtensionSpec {
+	return VirtualMachineExtensionSpec{
+		Name:                    AADLoginWindowsExtensionName,
+		Publisher:               "Microsoft.Azure.ActiveDirectory",
+		Type:                    "AADLoginForWindows",
+		TypeHandlerVersion:      "2.0",
+		AutoUpgradeMinorVersion: true,
+	}
+}
+
+// AADSSHLoginForLinuxExtension enables Entra ID (Azure AD) SSH login on a Linux VM
+func AADSSHLoginForLinuxExtension() VirtualMachineExtensionSpec {
+	return VirtualMachineExtensionSpec{
+		Name:                    AADSSHLoginLinuxExtensionName,
+		Publisher:               "Microsoft.Azure.ActiveDirectory",
+		Type:                    "AADSSHLoginForLinux",
+		TypeHandlerVersion:      "1.0",
+		AutoUpgradeMinorVersion: true,
+	}
+}
+
+// AzureMonitorAgentExtension installs the Azure Monitor Agent. Data collection is configured separately with data collection rules.
+func AzureMonitorAgentExtension(windows bool) VirtualMachineExtensionSpec {
+	name := AzureMonitorLinuxExtensionName
+	if windows {
+		name = AzureMonitorWindowsExtensionName
+	}
+
+	return VirtualMachineExtensionSpec{
+		Name:                    name,
+		Publisher:               "Microsoft.Azure.Monitor",
+		Type:                    name,
+		TypeHandlerVersion:      "1.0",
+		AutoUpgradeMinorVersion: true,
+		EnableAutomaticUpgrade:  true,
+	}
+}
+
+// AVDDSCExtension installs the AVD agent and registers the VM as a session host in the given host pool,
+// using the DSC configuration package published by Microsoft (or a mirror of it) at configurationURL.
+func AVDDSCExtension(configurationURL, hostPoolName, registrationToken string, aadJoin bool) VirtualMachineExtensionSpec {
+	return VirtualMachineExtensionSpec{
+		Name:                    AVDDSCExtensionName,
+		Publisher:               "Microsoft.Powershell",
+		Type:                    "DSC",
+		TypeHandlerVersion:      "2.73",
+		AutoUpgradeMinorVersion: true,
+		Settings: map[string]any{
+			"modulesUrl":            configurationURL,
+			"configurationFunction": avdDSCConfigurationFunction,
+			"properties": map[string]any{
+				"hostPoolName": hostPoolName,
+				"registrationInfoTokenCredential": map[string]any{
+					"UserName": "PLACEHOLDER_DO_NOT_USE",
+					"Password": "PrivateSettingsRef:RegistrationInfoToken",
+				},
+				"aadJoin": aadJoin,
+			},
+		},
+		ProtectedSettings: map[string]any{
+			"Items": map[string]any{
+				"RegistrationInfoToken": registrationToken,
+			},
+		},
+	}
+}
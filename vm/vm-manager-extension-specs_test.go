@@ -0,0 +1,30 @@
+package vm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDomainJoinExtension(t *testing.T) {
+	spec := DomainJoinExtension("corp.local", "corp\\joiner", "secret", "", true)
+
+	assert.Equal(t, "Microsoft.Compute", spec.Publisher)
+	assert.Equal(t, "JsonADDomainExtension", spec.Type)
+	assert.Equal(t, "corp.local", spec.Settings["Name"])
+	assert.Equal(t, "true", spec.Settings["Restart"])
+	assert.NotContains(t, spec.Settings, "Password")
+	assert.Equal(t, "secret", spec.ProtectedSettings["Password"])
+}
+
+func TestCustomScriptExtensionProtectsCommand(t *testing.T) {
+	windows := CustomScriptWindowsExtension("powershell -File setup.ps1", []string{"https://example/setup.ps1?sig=abc"})
+	linux := CustomScriptLinuxExtension("bash setup.sh", nil)
+
+	assert.Nil(t, windows.Settings)
+	assert.Equal(t, "powershell -File setup.ps1", windows.ProtectedSettings["commandToExecute"])
+	assert.Equal(t, []string{"https://example/setup.ps1?sig=abc"}, windows.ProtectedSettings["fileUris"])
+
+	assert.Equal(t, "Microsoft.Azure.Extensions", linux.Publisher)
+	assert.NotContains(t, linux.ProtectedSettings, "fileUris")
+}
@@ -0,0 +1,164 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	cloudyazure "github.com/appliedres/cloudy-azure"
+	"github.com/appliedres/cloudy/logging"
+	"github.com/pkg/errors"
+)
+
+const extensionPollInterval = 15 * time.Second
+
+// VirtualMachineExtensionSpec describes an extension to install on a VM.
+// Settings and ProtectedSettings are serialized to JSON; ProtectedSettings are encrypted and never returned by Azure.
+type VirtualMachineExtensionSpec struct {
+	Name               string // name of the extension resource on the VM
+	Publisher          string
+	Type               string
+	TypeHandlerVersion string
+
+	AutoUpgradeMinorVersion bool
+	EnableAutomaticUpgrade  bool
+
+	Settings          map[string]any
+	ProtectedSettings map[string]any
+}
+
+// InstallExtension installs or updates an extension on a VM and waits for it to finish provisioning.
+func (vmm *AzureVirtualMachineManager) InstallExtension(ctx context.Context, vmName string, spec VirtualMachineExtensionSpec) (*armcompute.VirtualMachineExtension, error) {
+	log := logging.GetLogger(ctx).With("vmName", vmName, "extension", spec.Name)
+	log.InfoContext(ctx, "Install Extension starting")
+
+	if spec.Name == "" || spec.Publisher == "" || spec.Type == "" || spec.TypeHandlerVersion == "" {
+		return nil, fmt.Errorf("extension name, publisher, type and version are required")
+	}
+
+	extension := armcompute.VirtualMachineExtension{
+		Location: to.Ptr(vmm.Credentials.Region),
+		Properties: &armcompute.VirtualMachineExtensionProperties{
+			Publisher:               to.Ptr(spec.Publisher),
+			Type:                    to.Ptr(spec.Type),
+			TypeHandlerVersion:      to.Ptr(spec.TypeHandlerVersion),
+			AutoUpgradeMinorVersion: to.Ptr(spec.AutoUpgradeMinorVersion),
+			EnableAutomaticUpgrade:  to.Ptr(spec.EnableAutomaticUpgrade),
+		},
+	}
+	if spec.Settings != nil {
+		extension.Properties.Settings = spec.Settings
+	}
+	if spec.ProtectedSettings != nil {
+		extension.Properties.ProtectedSettings = spec.ProtectedSettings
+	}
+
+	poller, err := vmm.extensionsClient.BeginCreateOrUpdate(ctx, vmm.Credentials.ResourceGroup, vmName, spec.Name, extension, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "Install Extension")
+	}
+
+	resp, err := cloudyazure.PollWrapper(ctx, poller, "Install Extension")
+	if err != nil {
+		return nil, errors.Wrap(err, "Install Extension")
+	}
+
+	log.InfoContext(ctx, "Install Extension complete")
+	return &resp.VirtualMachineExtension, nil
+}
+
+// ListExtensions returns all extensions installed on a VM, including their instance view.
+func (vmm *AzureVirtualMachineManager) ListExtensions(ctx context.Context, vmName string) ([]*armcompute.VirtualMachineExtension, error) {
+	resp, err := vmm.extensionsClient.List(ctx, vmm.Credentials.ResourceGroup, vmName, &armcompute.VirtualMachineExtensionsClientListOptions{
+		Expand: to.Ptr("instanceView"),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "List Extensions")
+	}
+
+	return resp.Value, nil
+}
+
+// RemoveExtension removes an extension from a VM. Removing an extension that is not installed is not an error.
+func (vmm *AzureVirtualMachineManager) RemoveExtension(ctx context.Context, vmName string, extensionName string) error {
+	log := logging.GetLogger(ctx).With("vmName", vmName, "extension", extensionName)
+
+	poller, err := vmm.extensionsClient.BeginDelete(ctx, vmm.Credentials.ResourceGroup, vmName, extensionName, nil)
+	if err != nil {
+		if cloudyazure.Is404(err) {
+			log.InfoContext(ctx, "RemoveExtension - extension not found")
+			return nil
+		}
+
+		return errors.Wrap(err, "Remove Extension")
+	}
+
+	_, err = cloudyazure.PollWrapper(ctx, poller, "Remove Extension")
+	if err != nil {
+		return errors.Wrap(err, "Remove Extension")
+	}
+
+	log.InfoContext(ctx, "Remove Extension complete")
+	return nil
+}
+
+// WaitForExtension waits for an extension on a VM to reach a terminal provisioning state.
+// Useful for extensions installed outside of InstallExtension, e.g. by Azure Policy.
+// Returns an error if the extension fails to provision or the timeout is reached.
+func (vmm *AzureVirtualMachineManager) WaitForExtension(ctx context.Context, vmName string, extensionName string, timeout time.Duration) (*armcompute.VirtualMachineExtension, error) {
+	log := logging.GetLogger(ctx).With("vmName", vmName, "extension", extensionName)
+	log.DebugContext(ctx, "WaitForExtension start", "timeout", timeout)
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(extensionPollInterval)
+	defer ticker.Stop()
+
+	for {
+		resp, err := vmm.extensionsClient.Get(ctx, vmm.Credentials.ResourceGroup, vmName, extensionName, &armcompute.VirtualMachineExtensionsClientGetOptions{
+			Expand: to.Ptr("instanceView"),
+		})
+		if err != nil && !cloudyazure.Is404(err) {
+			return nil, errors.Wrap(err, "Wait For Extension")
+		}
+
+		if err == nil && resp.Properties != nil && resp.Properties.ProvisioningState != nil {
+			state := *resp.Properties.ProvisioningState
+			log.DebugContext(ctx, "Extension provisioning state", "state", state)
+
+			switch strings.ToLower(state) {
+			case "succeeded":
+				return &resp.VirtualMachineExtension, nil
+			case "failed", "canceled":
+				return &resp.VirtualMachineExtension, fmt.Errorf("extension [%s] on VM [%s] finished with state %s: %s",
+					extensionName, vmName, state, extensionStatusMessage(&resp.VirtualMachineExtension))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("timed out waiting for extension [%s] on VM [%s]: %w", extensionName, vmName, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// extensionStatusMessage collects the status messages reported by an extension's instance view
+func extensionStatusMessage(extension *armcompute.VirtualMachineExtension) string {
+	if extension.Properties == nil || extension.Properties.InstanceView == nil {
+		return ""
+	}
+
+	var messages []string
+	for _, status := range toStatusDetails(extension.Properties.InstanceView.Statuses) {
+		if status.Message != "" {
+			messages = append(messages, status.Message)
+		}
+	}
+
+	return strings.Join(messages, "; ")
+}
@@ -0,0 +1,325 @@
+package vm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+	cloudyazure "github.com/appliedres/cloudy-azure"
+	"github.com/appliedres/cloudy-azure/storage"
+	"github.com/appliedres/cloudy/logging"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultTransferSASValidity  = 15 * time.Minute
+	defaultTransferTimeout      = 10 * time.Minute
+	defaultTransferPollInterval = 10 * time.Second
+)
+
+// The transfer scripts print the SHA-256 of the file on the VM on a line of its own, prefixed with this marker
+var transferChecksumPattern = regexp.MustCompile(`(?m)^\s*SHA256:([0-9a-fA-F]{64})\s*$`)
+
+var unsafeBlobNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// FileTransferOptions controls a file copy to or from a VM
+type FileTransferOptions struct {
+	Timeout      time.Duration // optional, limit on the RunCommand that moves the file on the VM, defaults to 10 minutes
+	PollInterval time.Duration // optional, defaults to 10 seconds
+}
+
+// FileTransferResult describes a completed file copy
+type FileTransferResult struct {
+	Path   string // path of the file on the VM
+	Bytes  int64
+	SHA256 string // hex encoded checksum, verified on both ends of the copy
+}
+
+// CopyFileToVirtualMachine copies content to a file on the VM, without the VM needing a public endpoint.
+// The content is staged in the file transfer blob container and downloaded by the VM with a RunCommand,
+// through a short-lived read-only user delegation SAS URL. The checksum of the downloaded file is verified,
+// and the staging blob is always deleted.
+func (vmm *AzureVirtualMachineManager) CopyFileToVirtualMachine(ctx context.Context, vmID string, content io.Reader, destinationPath string, opts *FileTransferOptions) (*FileTransferResult, error) {
+	log := logging.GetLogger(ctx).With("vmID", vmID, "path", destinationPath)
+
+	if destinationPath == "" {
+		return nil, fmt.Errorf("a destination path is required")
+	}
+
+	windows, err := vmm.transferTarget(ctx, vmID)
+	if err != nil {
+		return nil, err
+	}
+
+	blobName := transferBlobName(vmID, destinationPath)
+	defer vmm.deleteTransferBlob(ctx, blobName)
+
+	hash := sha256.New()
+	counter := &countingWriter{}
+	_, err = vmm.transferClient.UploadStream(ctx, vmm.Config.FileTransfer.Container, blobName, io.TeeReader(content, io.MultiWriter(hash, counter)), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "CopyFileToVirtualMachine staging upload")
+	}
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	log.DebugContext(ctx, "CopyFileToVirtualMachine staged file", "bytes", counter.n, "sha256", checksum)
+
+	sasURL, err := vmm.transferSAS(ctx, blobName, sas.BlobPermissions{Read: true})
+	if err != nil {
+		return nil, err
+	}
+
+	var script string
+	if windows {
+		script = powershellDownloadScript(sasURL, destinationPath)
+	} else {
+		script = shellDownloadScript(sasURL, destinationPath)
+	}
+
+	remoteChecksum, err := vmm.runTransferScript(ctx, vmID, windows, script, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "CopyFileToVirtualMachine")
+	}
+
+	if !strings.EqualFold(remoteChecksum, checksum) {
+		return nil, fmt.Errorf("checksum mismatch copying to VM %s, expected %s but the VM has %s", vmID, checksum, remoteChecksum)
+	}
+
+	log.InfoContext(ctx, "CopyFileToVirtualMachine complete", "bytes", counter.n)
+	return &FileTransferResult{
+		Path:   destinationPath,
+		Bytes:  counter.n,
+		SHA256: checksum,
+	}, nil
+}
+
+// CopyFileFromVirtualMachine copies a file on the VM to w, without the VM needing a public endpoint.
+// The VM uploads the file to the file transfer blob container with a RunCommand, through a short-lived
+// write-only user delegation SAS URL. The checksum reported by the VM is verified against the downloaded
+// content, and the staging blob is always deleted.
+func (vmm *AzureVirtualMachineManager) CopyFileFromVirtualMachine(ctx context.Context, vmID string, sourcePath string, w io.Writer, opts *FileTransferOptions) (*FileTransferResult, error) {
+	log := logging.GetLogger(ctx).With("vmID", vmID, "path", sourcePath)
+
+	if sourcePath == "" {
+		return nil, fmt.Errorf("a source path is required")
+	}
+
+	windows, err := vmm.transferTarget(ctx, vmID)
+	if err != nil {
+		return nil, err
+	}
+
+	blobName := transferBlobName(vmID, sourcePath)
+	defer vmm.deleteTransferBlob(ctx, blobName)
+
+	sasURL, err := vmm.transferSAS(ctx, blobName, sas.BlobPermissions{Create: true, Write: true})
+	if err != nil {
+		return nil, err
+	}
+
+	var script string
+	if windows {
+		script = powershellUploadScript(sasURL, sourcePath)
+	} else {
+		script = shellUploadScript(sasURL, sourcePath)
+	}
+
+	remoteChecksum, err := vmm.runTransferScript(ctx, vmID, windows, script, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "CopyFileFromVirtualMachine")
+	}
+
+	resp, err := vmm.transferClient.DownloadStream(ctx, vmm.Config.FileTransfer.Container, blobName, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "CopyFileFromVirtualMachine staging download")
+	}
+	defer resp.Body.Close()
+
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w, hash), resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "CopyFileFromVirtualMachine staging download")
+	}
+	checksum := hex.EncodeToString(hash.Sum(nil))
+
+	if !strings.EqualFold(remoteChecksum, checksum) {
+		return nil, fmt.Errorf("checksum mismatch copying from VM %s, the VM reported %s but %s was received", vmID, remoteChecksum, checksum)
+	}
+
+	log.InfoContext(ctx, "CopyFileFromVirtualMachine complete", "bytes", n)
+	return &FileTransferResult{
+		Path:   sourcePath,
+		Bytes:  n,
+		SHA256: checksum,
+	}, nil
+}
+
+// transferTarget checks that file transfer is configured and returns whether the VM runs Windows
+func (vmm *AzureVirtualMachineManager) transferTarget(ctx context.Context, vmID string) (bool, error) {
+	if vmm.Config.FileTransfer == nil || vmm.transferClient == nil {
+		return false, fmt.Errorf("file transfer is not configured")
+	}
+
+	resp, err := vmm.vmClient.Get(ctx, vmm.Credentials.ResourceGroup, vmID, nil)
+	if err != nil {
+		return false, errors.Wrap(err, "file transfer get VM")
+	}
+
+	props := resp.Properties
+	if props == nil || props.StorageProfile == nil || props.StorageProfile.OSDisk == nil || props.StorageProfile.OSDisk.OSType == nil {
+		return false, fmt.Errorf("could not determine the operating system of VM %s", vmID)
+	}
+
+	return *props.StorageProfile.OSDisk.OSType == armcompute.OperatingSystemTypesWindows, nil
+}
+
+// transferSAS generates a user delegation SAS URL for a staging blob
+func (vmm *AzureVirtualMachineManager) transferSAS(ctx context.Context, blobName string, permissions sas.BlobPermissions) (string, error) {
+	validity := vmm.Config.FileTransfer.SASValidity
+	if validity == 0 {
+		validity = defaultTransferSASValidity
+	}
+
+	sasURL, err := storage.GenerateBlobSAS(ctx, vmm.Credentials, vmm.Config.FileTransfer.StorageAccount, vmm.Config.FileTransfer.Container, blobName, validity, permissions)
+	if err != nil {
+		return "", errors.Wrap(err, "file transfer SAS")
+	}
+
+	return sasURL, nil
+}
+
+// runTransferScript runs a transfer script on the VM and returns the checksum it reports
+func (vmm *AzureVirtualMachineManager) runTransferScript(ctx context.Context, vmID string, windows bool, script string, opts *FileTransferOptions) (string, error) {
+	timeout, pollInterval := defaultTransferTimeout, defaultTransferPollInterval
+	if opts != nil && opts.Timeout > 0 {
+		timeout = opts.Timeout
+	}
+	if opts != nil && opts.PollInterval > 0 {
+		pollInterval = opts.PollInterval
+	}
+
+	var output string
+	var err error
+	if windows {
+		output, err = vmm.ExecuteRemotePowershellWithOutput(ctx, vmID, &script, timeout, pollInterval)
+	} else {
+		output, err = vmm.ExecuteRemoteShellScriptWithOutput(ctx, vmID, &script, timeout, pollInterval)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return parseTransferChecksum(output)
+}
+
+// deleteTransferBlob removes a staging blob. It runs even if ctx was cancelled, so a failed copy does not leave content behind.
+func (vmm *AzureVirtualMachineManager) deleteTransferBlob(ctx context.Context, blobName string) {
+	log := logging.GetLogger(ctx)
+
+	_, err := vmm.transferClient.DeleteBlob(context.WithoutCancel(ctx), vmm.Config.FileTransfer.Container, blobName, nil)
+	if err != nil && !cloudyazure.Is404(err) {
+		log.WarnContext(ctx, "Could not delete file transfer staging blob", "blob", blobName, logging.WithError(err))
+	}
+}
+
+// transferServiceURL returns the blob service URL of the file transfer storage account
+func transferServiceURL(account string) string {
+	return fmt.Sprintf("https://%s.blob.core.usgovcloudapi.net", account)
+}
+
+// transferBlobName returns a unique staging blob name for a file on a VM. Characters that would need escaping
+// in the SAS URL or the transfer scripts are replaced, the name is only used for staging.
+func transferBlobName(vmID, filePath string) string {
+	name := path.Base(strings.ReplaceAll(filePath, "\\", "/"))
+	if name == "." || name == "/" {
+		name = "file"
+	}
+	name = unsafeBlobNameChars.ReplaceAllString(name, "_")
+
+	return fmt.Sprintf("%s/%s/%s", vmID, uuid.NewString(), name)
+}
+
+// parseTransferChecksum returns the checksum printed by a transfer script, or an error with the output if there is none
+func parseTransferChecksum(output string) (string, error) {
+	match := transferChecksumPattern.FindStringSubmatch(output)
+	if match == nil {
+		return "", fmt.Errorf("file transfer did not report a checksum: %s", strings.TrimSpace(output))
+	}
+
+	return strings.ToLower(match[1]), nil
+}
+
+// powershellQuote quotes a value as a single-quoted PowerShell string
+func powershellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// shellQuote quotes a value as a single-quoted POSIX shell string
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// powershellDownloadScript downloads a blob to a file on a Windows VM and prints its checksum
+func powershellDownloadScript(sasURL, destinationPath string) string {
+	return strings.Join([]string{
+		"$ErrorActionPreference = 'Stop'",
+		"$ProgressPreference = 'SilentlyContinue'",
+		"$path = " + powershellQuote(destinationPath),
+		"$dir = Split-Path -Parent $path",
+		"if ($dir -and -not (Test-Path $dir)) { New-Item -ItemType Directory -Path $dir -Force | Out-Null }",
+		"Invoke-WebRequest -UseBasicParsing -Uri " + powershellQuote(sasURL) + " -OutFile $path",
+		`Write-Output ("SHA256:" + (Get-FileHash -Algorithm SHA256 -Path $path).Hash)`,
+	}, "\n")
+}
+
+// powershellUploadScript uploads a file on a Windows VM to a blob and prints its checksum
+func powershellUploadScript(sasURL, sourcePath string) string {
+	return strings.Join([]string{
+		"$ErrorActionPreference = 'Stop'",
+		"$ProgressPreference = 'SilentlyContinue'",
+		"$path = " + powershellQuote(sourcePath),
+		`$hash = (Get-FileHash -Algorithm SHA256 -Path $path).Hash`,
+		"Invoke-RestMethod -Method Put -Headers @{ 'x-ms-blob-type' = 'BlockBlob' } -InFile $path -Uri " + powershellQuote(sasURL) + " | Out-Null",
+		`Write-Output ("SHA256:" + $hash)`,
+	}, "\n")
+}
+
+// shellDownloadScript downloads a blob to a file on a Linux VM and prints its checksum
+func shellDownloadScript(sasURL, destinationPath string) string {
+	return strings.Join([]string{
+		"set -e",
+		"path=" + shellQuote(destinationPath),
+		`mkdir -p "$(dirname "$path")"`,
+		"curl -fsSL --retry 3 -o \"$path\" " + shellQuote(sasURL),
+		`echo "SHA256:$(sha256sum "$path" | cut -d ' ' -f 1)"`,
+	}, "\n")
+}
+
+// shellUploadScript uploads a file on a Linux VM to a blob and prints its checksum
+func shellUploadScript(sasURL, sourcePath string) string {
+	return strings.Join([]string{
+		"set -e",
+		"path=" + shellQuote(sourcePath),
+		`hash="$(sha256sum "$path" | cut -d ' ' -f 1)"`,
+		"curl -fsS --retry 3 -X PUT -H 'x-ms-blob-type: BlockBlob' --upload-file \"$path\" " + shellQuote(sasURL),
+		`echo "SHA256:$hash"`,
+	}, "\n")
+}
+
+// countingWriter counts the bytes written to it
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,47 @@
+package vm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTransferChecksum(t *testing.T) {
+	hash := strings.Repeat("AB12", 16)
+
+	checksum, err := parseTransferChecksum("Enable succeeded: \n[stdout]\nSHA256:" + hash + "\n\n[stderr]\n")
+	assert.NoError(t, err)
+	assert.Equal(t, strings.ToLower(hash), checksum)
+
+	_, err = parseTransferChecksum("[stderr]\ncurl: (22) The requested URL returned error: 403")
+	assert.ErrorContains(t, err, "403")
+
+	_, err = parseTransferChecksum("SHA256:1234")
+	assert.Error(t, err)
+}
+
+func TestTransferScriptQuoting(t *testing.T) {
+	assert.Equal(t, `'C:\it''s\file.txt'`, powershellQuote(`C:\it's\file.txt`))
+	assert.Equal(t, `'/tmp/it'\''s'`, shellQuote(`/tmp/it's`))
+
+	script := shellDownloadScript("https://acct.blob.core.usgovcloudapi.net/c/b?sig=a&sp=r", "/opt/app/config's.json")
+	assert.Contains(t, script, `path='/opt/app/config'\''s.json'`)
+	assert.Contains(t, script, `'https://acct.blob.core.usgovcloudapi.net/c/b?sig=a&sp=r'`)
+
+	script = powershellUploadScript("https://acct/c/b?sig=a", `C:\logs\app.log`)
+	assert.Contains(t, script, `$path = 'C:\logs\app.log'`)
+	assert.Contains(t, script, "x-ms-blob-type")
+}
+
+func TestTransferBlobName(t *testing.T) {
+	name := transferBlobName("shvm-1", `C:\logs\app.log`)
+	assert.True(t, strings.HasPrefix(name, "shvm-1/"))
+	assert.True(t, strings.HasSuffix(name, "/app.log"))
+	assert.NotEqual(t, name, transferBlobName("shvm-1", `C:\logs\app.log`))
+
+	assert.True(t, strings.HasSuffix(transferBlobName("shvm-1", "/"), "/file"))
+
+	// characters that need escaping in URLs or scripts are replaced
+	assert.True(t, strings.HasSuffix(transferBlobName("shvm-1", `C:\logs\my app#1?%.log`), "/my_app_1__.log"))
+}
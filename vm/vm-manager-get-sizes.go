@@ -221,16 +221,16 @@ func scoreVMSize(size *models.VirtualMachineSize, template models.VirtualMachine
 	return totalScore
 }
 
-// This function was unused in v1
-// It would need the config to have the source image gallery name in it
+// GetLatestImageVersion returns the highest version of an image in the configured shared image gallery
 func (vmm *AzureVirtualMachineManager) GetLatestImageVersion(ctx context.Context, imageName string) (string, error) {
 
 	log := logging.GetLogger(ctx)
 
-	// set this for real if we're going to use this function
-	sourceImageGalleryName := ""
+	if vmm.Config.SourceImageGalleryName == "" {
+		return "", errors.New("GetLatestImageVersion: no source image gallery configured")
+	}
 
-	pager := vmm.galleryClient.NewListPager(vmm.Credentials.Region, sourceImageGalleryName, imageName, &armcompute.SharedGalleryImageVersionsClientListOptions{})
+	pager := vmm.galleryClient.NewListPager(vmm.Credentials.Region, vmm.Config.SourceImageGalleryName, imageName, &armcompute.SharedGalleryImageVersionsClientListOptions{})
 
 	var allVersions []*version.Version
 
@@ -249,6 +249,10 @@ func (vmm *AzureVirtualMachineManager) GetLatestImageVersion(ctx context.Context
 		}
 	}
 
+	if len(allVersions) == 0 {
+		return "", fmt.Errorf("GetLatestImageVersion: no versions found for image %s", imageName)
+	}
+
 	sort.Sort(version.Collection(allVersions))
 
 	latest := allVersions[len(allVersions)-1]
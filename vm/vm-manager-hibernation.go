@@ -0,0 +1,92 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	cloudyazure "github.com/appliedres/cloudy-azure"
+	"github.com/appliedres/cloudy/logging"
+	"github.com/appliedres/cloudy/models"
+	"github.com/pkg/errors"
+)
+
+// VirtualMachineCloudStateHibernated is reported for VMs that are deallocated with their memory saved to the OS disk.
+// The cloudy model has no hibernated state, so this extends its enum.
+const VirtualMachineCloudStateHibernated models.VirtualMachineCloudState = "hibernated"
+
+// validateHibernationOptions checks that hibernation is supported by the size, if SKU capabilities are given
+func validateHibernationOptions(opts *templateOptions, caps skuCapabilities) error {
+	if !opts.Hibernation || caps == nil {
+		return nil
+	}
+
+	if !caps.isTrue("HibernationSupported") {
+		return fmt.Errorf("VM size does not support hibernation")
+	}
+
+	return nil
+}
+
+// applyHibernationOptions enables hibernation on VM create parameters. Hibernation can only be enabled when the VM is created,
+// or while it is deallocated.
+func applyHibernationOptions(azVM *armcompute.VirtualMachine, opts *templateOptions) {
+	if !opts.Hibernation {
+		return
+	}
+
+	if azVM.Properties.AdditionalCapabilities == nil {
+		azVM.Properties.AdditionalCapabilities = &armcompute.AdditionalCapabilities{}
+	}
+	azVM.Properties.AdditionalCapabilities.HibernationEnabled = to.Ptr(true)
+}
+
+// readHibernation reports whether hibernation is enabled on an Azure VM in the cloudy VM tags
+func readHibernation(azVM *armcompute.VirtualMachine, tags map[string]*string) {
+	if azVM.Properties == nil || azVM.Properties.AdditionalCapabilities == nil || azVM.Properties.AdditionalCapabilities.HibernationEnabled == nil {
+		return
+	}
+
+	setTag(tags, HibernationTagKey, strconv.FormatBool(*azVM.Properties.AdditionalCapabilities.HibernationEnabled))
+}
+
+// IsHibernationEnabled reports whether a VM was created with hibernation enabled
+func (vmm *AzureVirtualMachineManager) IsHibernationEnabled(ctx context.Context, vmName string) (bool, error) {
+	resp, err := vmm.vmClient.Get(ctx, vmm.Credentials.ResourceGroup, vmName, nil)
+	if err != nil {
+		return false, errors.Wrap(err, "VM Hibernation Enabled")
+	}
+
+	capabilities := resp.Properties.AdditionalCapabilities
+	return capabilities != nil && capabilities.HibernationEnabled != nil && *capabilities.HibernationEnabled, nil
+}
+
+// HibernateVirtualMachine saves the memory of a VM to its OS disk and deallocates it.
+// The VM must have been created with hibernation enabled. Use ResumeVirtualMachine to restore it.
+func (vmm *AzureVirtualMachineManager) HibernateVirtualMachine(ctx context.Context, vmName string) error {
+	log := logging.GetLogger(ctx)
+
+	poller, err := vmm.vmClient.BeginDeallocate(ctx, vmm.Credentials.ResourceGroup, vmName, &armcompute.VirtualMachinesClientBeginDeallocateOptions{
+		Hibernate: to.Ptr(true),
+	})
+	if err != nil {
+		return errors.Wrap(err, "VM Hibernate")
+	}
+
+	_, err = cloudyazure.PollWrapper(ctx, poller, "VM Hibernate")
+	if err != nil {
+		return errors.Wrap(err, "VM Hibernate")
+	}
+
+	log.InfoContext(ctx, "VM Hibernate complete")
+
+	return nil
+}
+
+// ResumeVirtualMachine resumes a hibernated VM, restoring its memory. Starting a hibernated VM resumes it,
+// so this is the same as StartVirtualMachine and also starts VMs that were deallocated without hibernation.
+func (vmm *AzureVirtualMachineManager) ResumeVirtualMachine(ctx context.Context, vmName string) error {
+	return vmm.StartVirtualMachine(ctx, vmName)
+}
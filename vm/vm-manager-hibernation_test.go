@@ -0,0 +1,51 @@
+package vm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/appliedres/cloudy/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateHibernationOptions(t *testing.T) {
+	assert.NoError(t, validateHibernationOptions(&templateOptions{}, skuCapabilities{}))
+	assert.NoError(t, validateHibernationOptions(&templateOptions{Hibernation: true}, nil))
+	assert.NoError(t, validateHibernationOptions(&templateOptions{Hibernation: true}, skuCapabilities{"HibernationSupported": "True"}))
+	assert.Error(t, validateHibernationOptions(&templateOptions{Hibernation: true}, skuCapabilities{"HibernationSupported": "False"}))
+}
+
+func TestHibernationRoundTrip(t *testing.T) {
+	azVM := &armcompute.VirtualMachine{Properties: &armcompute.VirtualMachineProperties{}}
+	applyHibernationOptions(azVM, &templateOptions{Hibernation: true})
+
+	tags := map[string]*string{}
+	readHibernation(azVM, tags)
+	assert.Equal(t, "true", *tags[HibernationTagKey])
+}
+
+func TestHibernatedCloudState(t *testing.T) {
+	statuses := func(codes ...string) *armcompute.VirtualMachine {
+		azVM := &armcompute.VirtualMachine{
+			Name: to.Ptr("uvm-1"),
+			ID:   to.Ptr("uvm-1"),
+			Properties: &armcompute.VirtualMachineProperties{
+				ProvisioningState: to.Ptr("Succeeded"),
+				InstanceView:      &armcompute.VirtualMachineInstanceView{},
+			},
+		}
+		for _, code := range codes {
+			azVM.Properties.InstanceView.Statuses = append(azVM.Properties.InstanceView.Statuses, &armcompute.InstanceViewStatus{Code: to.Ptr(code)})
+		}
+		return azVM
+	}
+
+	ctx := context.Background()
+	assert.Equal(t, VirtualMachineCloudStateHibernated,
+		*mapProvisioningAndPowerState(ctx, statuses("PowerState/deallocated", "HibernationState/Hibernated")))
+	assert.Equal(t, models.VirtualMachineCloudStateStopped,
+		*mapProvisioningAndPowerState(ctx, statuses("PowerState/deallocated")))
+	assert.Equal(t, models.VirtualMachineCloudStateStarting, mapCloudState("updating", "hibernated"))
+}
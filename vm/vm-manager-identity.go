@@ -0,0 +1,184 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/authorization/armauthorization/v2"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	cloudyazure "github.com/appliedres/cloudy-azure"
+	"github.com/appliedres/cloudy/logging"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+const (
+	// a new identity can take a few minutes to replicate before roles can be assigned to it
+	roleAssignmentRetryTimeout  = 3 * time.Minute
+	roleAssignmentRetryInterval = 10 * time.Second
+)
+
+// templateIdentity builds the managed identity of a VM from the template options.
+// Without options the VM gets a system-assigned identity.
+func templateIdentity(opts *templateOptions) (*armcompute.VirtualMachineIdentity, error) {
+	systemAssigned := opts.Identity == ""
+	userAssigned := opts.Identity == "" && len(opts.UserAssignedIdentityIDs) > 0
+	none := false
+
+	for _, part := range strings.Split(opts.Identity, ",") {
+		switch part = strings.TrimSpace(part); {
+		case part == "":
+		case strings.EqualFold(part, string(armcompute.ResourceIdentityTypeSystemAssigned)):
+			systemAssigned = true
+		case strings.EqualFold(part, string(armcompute.ResourceIdentityTypeUserAssigned)):
+			userAssigned = true
+		case strings.EqualFold(part, string(armcompute.ResourceIdentityTypeNone)):
+			none = true
+		default:
+			return nil, fmt.Errorf("unsupported identity type [%s]", part)
+		}
+	}
+
+	if none && (systemAssigned || userAssigned) {
+		return nil, fmt.Errorf("identity type None cannot be combined with other identity types")
+	}
+	if userAssigned && len(opts.UserAssignedIdentityIDs) == 0 {
+		return nil, fmt.Errorf("a user-assigned identity requires the %s template tag", UserAssignedIdentitiesTagKey)
+	}
+	if !userAssigned && len(opts.UserAssignedIdentityIDs) > 0 {
+		return nil, fmt.Errorf("user-assigned identities are set, but the identity type does not include UserAssigned")
+	}
+
+	identity := &armcompute.VirtualMachineIdentity{}
+	switch {
+	case systemAssigned && userAssigned:
+		identity.Type = to.Ptr(armcompute.ResourceIdentityTypeSystemAssignedUserAssigned)
+	case systemAssigned:
+		identity.Type = to.Ptr(armcompute.ResourceIdentityTypeSystemAssigned)
+	case userAssigned:
+		identity.Type = to.Ptr(armcompute.ResourceIdentityTypeUserAssigned)
+	default:
+		identity.Type = to.Ptr(armcompute.ResourceIdentityTypeNone)
+	}
+
+	if userAssigned {
+		identity.UserAssignedIdentities = map[string]*armcompute.UserAssignedIdentitiesValue{}
+		for _, id := range opts.UserAssignedIdentityIDs {
+			identity.UserAssignedIdentities[id] = &armcompute.UserAssignedIdentitiesValue{}
+		}
+	}
+
+	return identity, nil
+}
+
+// readIdentity reports the managed identities of an Azure VM in the cloudy VM tags
+func readIdentity(azVM *armcompute.VirtualMachine, tags map[string]*string) {
+	if azVM.Identity == nil || azVM.Identity.Type == nil {
+		return
+	}
+
+	setTag(tags, IdentityTagKey, string(*azVM.Identity.Type))
+
+	if azVM.Identity.PrincipalID != nil {
+		setTag(tags, IdentityPrincipalIDTagKey, *azVM.Identity.PrincipalID)
+	}
+
+	if len(azVM.Identity.UserAssignedIdentities) > 0 {
+		ids := make([]string, 0, len(azVM.Identity.UserAssignedIdentities))
+		for id := range azVM.Identity.UserAssignedIdentities {
+			ids = append(ids, id)
+		}
+		setTag(tags, UserAssignedIdentitiesTagKey, strings.Join(ids, ","))
+	}
+}
+
+// roleDefinitionResourceID expands a role definition GUID to its resource ID in the manager's subscription
+func (vmm *AzureVirtualMachineManager) roleDefinitionResourceID(roleDefinitionID string) string {
+	if strings.HasPrefix(roleDefinitionID, "/") {
+		return roleDefinitionID
+	}
+
+	return fmt.Sprintf("/subscriptions/%s/providers/Microsoft.Authorization/roleDefinitions/%s",
+		vmm.Credentials.SubscriptionID, roleDefinitionID)
+}
+
+// roleAssignmentName derives a stable role assignment name, so assignments can be re-applied and removed without listing them
+func roleAssignmentName(scope, roleDefinitionID, principalID string) string {
+	key := strings.ToLower(scope + "|" + roleDefinitionID + "|" + principalID)
+	return uuid.NewSHA1(uuid.NameSpaceURL, []byte(key)).String()
+}
+
+// GrantConfiguredRoles assigns the configured roles to the system-assigned identity of a VM.
+// Assignments that already exist are left as is.
+func (vmm *AzureVirtualMachineManager) GrantConfiguredRoles(ctx context.Context, vmName string, principalID string) error {
+	if vmm.Config == nil {
+		return nil
+	}
+	log := logging.GetLogger(ctx).With("vmName", vmName, "principalID", principalID)
+
+	for _, assignment := range vmm.Config.RoleAssignments {
+		roleDefinitionID := vmm.roleDefinitionResourceID(assignment.RoleDefinitionID)
+		name := roleAssignmentName(assignment.Scope, roleDefinitionID, principalID)
+
+		params := armauthorization.RoleAssignmentCreateParameters{
+			Properties: &armauthorization.RoleAssignmentProperties{
+				RoleDefinitionID: to.Ptr(roleDefinitionID),
+				PrincipalID:      to.Ptr(principalID),
+				PrincipalType:    to.Ptr(armauthorization.PrincipalTypeServicePrincipal),
+			},
+		}
+
+		deadline := time.Now().Add(roleAssignmentRetryTimeout)
+		for {
+			_, err := vmm.roleAssignmentsClient.Create(ctx, assignment.Scope, name, params, nil)
+			if err == nil || hasErrorCode(err, "RoleAssignmentExists") {
+				break
+			}
+			if !hasErrorCode(err, "PrincipalNotFound") || time.Now().After(deadline) {
+				return errors.Wrapf(err, "Grant Role %s on %s", assignment.RoleDefinitionID, assignment.Scope)
+			}
+
+			log.DebugContext(ctx, "Identity not replicated yet, retrying role assignment", "scope", assignment.Scope)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(roleAssignmentRetryInterval):
+			}
+		}
+
+		log.InfoContext(ctx, "Granted role to VM identity", "role", assignment.RoleDefinitionID, "scope", assignment.Scope)
+	}
+
+	return nil
+}
+
+// RevokeConfiguredRoles removes the configured role assignments of a VM's system-assigned identity.
+// Failures are logged rather than returned, so they never block deleting the VM.
+func (vmm *AzureVirtualMachineManager) RevokeConfiguredRoles(ctx context.Context, vmName string, principalID string) {
+	if vmm.Config == nil {
+		return
+	}
+	log := logging.GetLogger(ctx).With("vmName", vmName, "principalID", principalID)
+
+	for _, assignment := range vmm.Config.RoleAssignments {
+		roleDefinitionID := vmm.roleDefinitionResourceID(assignment.RoleDefinitionID)
+		name := roleAssignmentName(assignment.Scope, roleDefinitionID, principalID)
+
+		_, err := vmm.roleAssignmentsClient.Delete(ctx, assignment.Scope, name, nil)
+		if err != nil && !cloudyazure.Is404(err) {
+			log.WarnContext(ctx, "Failed to revoke role from VM identity", "role", assignment.RoleDefinitionID, "scope", assignment.Scope, "err", err)
+			continue
+		}
+
+		log.InfoContext(ctx, "Revoked role from VM identity", "role", assignment.RoleDefinitionID, "scope", assignment.Scope)
+	}
+}
+
+// hasErrorCode reports whether an Azure error has the given error code
+func hasErrorCode(err error, code string) bool {
+	respErr := cloudyazure.ToResponseError(err)
+	return respErr != nil && strings.EqualFold(respErr.ErrorCode, code)
+}
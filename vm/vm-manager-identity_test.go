@@ -0,0 +1,69 @@
+package vm
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplateIdentity(t *testing.T) {
+	uami := []string{"/subscriptions/sub/resourceGroups/rg/providers/Microsoft.ManagedIdentity/userAssignedIdentities/installer"}
+
+	tests := []struct {
+		name         string
+		opts         templateOptions
+		expectedType armcompute.ResourceIdentityType
+		expectError  bool
+	}{
+		{"default", templateOptions{}, armcompute.ResourceIdentityTypeSystemAssigned, false},
+		{"user-assigned ids only", templateOptions{UserAssignedIdentityIDs: uami}, armcompute.ResourceIdentityTypeSystemAssignedUserAssigned, false},
+		{"user-assigned", templateOptions{Identity: "userassigned", UserAssignedIdentityIDs: uami}, armcompute.ResourceIdentityTypeUserAssigned, false},
+		{"both", templateOptions{Identity: "SystemAssigned, UserAssigned", UserAssignedIdentityIDs: uami}, armcompute.ResourceIdentityTypeSystemAssignedUserAssigned, false},
+		{"none", templateOptions{Identity: "None"}, armcompute.ResourceIdentityTypeNone, false},
+		{"none with ids", templateOptions{Identity: "None", UserAssignedIdentityIDs: uami}, "", true},
+		{"system-assigned with ids", templateOptions{Identity: "SystemAssigned", UserAssignedIdentityIDs: uami}, "", true},
+		{"user-assigned without ids", templateOptions{Identity: "UserAssigned"}, "", true},
+		{"unknown", templateOptions{Identity: "Everything"}, "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			identity, err := templateIdentity(&test.opts)
+			if test.expectError {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedType, *identity.Type)
+			assert.Len(t, identity.UserAssignedIdentities, len(test.opts.UserAssignedIdentityIDs))
+		})
+	}
+}
+
+func TestRoleAssignmentName(t *testing.T) {
+	name := roleAssignmentName("/scope", "/role", "principal")
+
+	assert.Equal(t, name, roleAssignmentName("/SCOPE", "/role", "principal"))
+	assert.NotEqual(t, name, roleAssignmentName("/scope", "/role", "other"))
+	assert.Len(t, name, 36)
+}
+
+func TestReadIdentity(t *testing.T) {
+	azVM := &armcompute.VirtualMachine{
+		Identity: &armcompute.VirtualMachineIdentity{
+			Type:        to.Ptr(armcompute.ResourceIdentityTypeSystemAssigned),
+			PrincipalID: to.Ptr("principal"),
+		},
+	}
+
+	tags := map[string]*string{"identity": to.Ptr("SystemAssigned")}
+	readIdentity(azVM, tags)
+
+	assert.Equal(t, map[string]*string{
+		IdentityTagKey:            to.Ptr("SystemAssigned"),
+		IdentityPrincipalIDTagKey: to.Ptr("principal"),
+	}, tags)
+}
@@ -0,0 +1,174 @@
+package vm
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/appliedres/cloudy/models"
+)
+
+// VirtualMachineDetailedStatus exposes the instance view details that are collapsed into a single CloudState.
+// Useful when diagnosing VMs that are stuck or failed.
+type VirtualMachineDetailedStatus struct {
+	CloudState        *models.VirtualMachineCloudState
+	ProvisioningState string
+	PowerState        string
+	Statuses          []StatusDetail // every status code reported for the VM
+
+	AgentVersion  string // empty if the VM agent has not reported
+	AgentHealthy  bool
+	AgentStatuses []StatusDetail
+
+	Extensions []ExtensionStatus
+
+	LastProvisioningError *StatusDetail // nil if no provisioning error was reported
+
+	BootDiagnostics *BootDiagnosticsStatus // nil if boot diagnostics are disabled
+}
+
+// StatusDetail is a single instance view status
+type StatusDetail struct {
+	Code          string
+	Level         string
+	DisplayStatus string
+	Message       string
+	Time          *time.Time
+}
+
+// ExtensionStatus is the instance view of a single VM extension
+type ExtensionStatus struct {
+	Name        string
+	Type        string
+	Version     string
+	Statuses    []StatusDetail
+	Substatuses []StatusDetail
+}
+
+// BootDiagnosticsStatus holds the boot diagnostics blob URIs reported by the instance view
+type BootDiagnosticsStatus struct {
+	ConsoleScreenshotBlobURI string
+	SerialConsoleLogBlobURI  string
+	Status                   *StatusDetail
+}
+
+// toDetailedStatus builds the detailed status of a VM that was queried with its instance view.
+// Returns nil if the instance view is missing.
+func toDetailedStatus(ctx context.Context, azVM *armcompute.VirtualMachine) *VirtualMachineDetailedStatus {
+	if azVM.Properties == nil || azVM.Properties.InstanceView == nil {
+		return nil
+	}
+	instanceView := azVM.Properties.InstanceView
+
+	status := &VirtualMachineDetailedStatus{
+		CloudState: mapProvisioningAndPowerState(ctx, azVM),
+		Statuses:   toStatusDetails(instanceView.Statuses),
+	}
+
+	if azVM.Properties.ProvisioningState != nil {
+		status.ProvisioningState = *azVM.Properties.ProvisioningState
+	}
+
+	for _, s := range status.Statuses {
+		switch {
+		case strings.HasPrefix(s.Code, "PowerState/"):
+			status.PowerState = strings.TrimPrefix(s.Code, "PowerState/")
+		case strings.HasPrefix(s.Code, "ProvisioningState/failed"):
+			failed := s
+			status.LastProvisioningError = &failed
+		}
+	}
+
+	if instanceView.VMAgent != nil {
+		if instanceView.VMAgent.VMAgentVersion != nil {
+			status.AgentVersion = *instanceView.VMAgent.VMAgentVersion
+		}
+		status.AgentStatuses = toStatusDetails(instanceView.VMAgent.Statuses)
+		status.AgentHealthy = isAgentHealthy(status.AgentStatuses)
+	}
+
+	for _, ext := range instanceView.Extensions {
+		if ext == nil {
+			continue
+		}
+
+		extStatus := ExtensionStatus{
+			Statuses:    toStatusDetails(ext.Statuses),
+			Substatuses: toStatusDetails(ext.Substatuses),
+		}
+		if ext.Name != nil {
+			extStatus.Name = *ext.Name
+		}
+		if ext.Type != nil {
+			extStatus.Type = *ext.Type
+		}
+		if ext.TypeHandlerVersion != nil {
+			extStatus.Version = *ext.TypeHandlerVersion
+		}
+		status.Extensions = append(status.Extensions, extStatus)
+	}
+
+	if instanceView.BootDiagnostics != nil {
+		bootDiagnostics := &BootDiagnosticsStatus{}
+		if instanceView.BootDiagnostics.ConsoleScreenshotBlobURI != nil {
+			bootDiagnostics.ConsoleScreenshotBlobURI = *instanceView.BootDiagnostics.ConsoleScreenshotBlobURI
+		}
+		if instanceView.BootDiagnostics.SerialConsoleLogBlobURI != nil {
+			bootDiagnostics.SerialConsoleLogBlobURI = *instanceView.BootDiagnostics.SerialConsoleLogBlobURI
+		}
+		if instanceView.BootDiagnostics.Status != nil {
+			s := toStatusDetail(instanceView.BootDiagnostics.Status)
+			bootDiagnostics.Status = &s
+		}
+		status.BootDiagnostics = bootDiagnostics
+	}
+
+	return status
+}
+
+// The agent reports "ProvisioningState/succeeded" with a "Ready" display status when it is healthy
+func isAgentHealthy(statuses []StatusDetail) bool {
+	for _, s := range statuses {
+		if strings.EqualFold(s.Level, string(armcompute.StatusLevelTypesError)) {
+			return false
+		}
+		if strings.EqualFold(s.DisplayStatus, "Ready") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func toStatusDetails(statuses []*armcompute.InstanceViewStatus) []StatusDetail {
+	var details []StatusDetail
+	for _, s := range statuses {
+		if s == nil {
+			continue
+		}
+		details = append(details, toStatusDetail(s))
+	}
+
+	return details
+}
+
+func toStatusDetail(s *armcompute.InstanceViewStatus) StatusDetail {
+	detail := StatusDetail{
+		Time: s.Time,
+	}
+	if s.Code != nil {
+		detail.Code = *s.Code
+	}
+	if s.Level != nil {
+		detail.Level = string(*s.Level)
+	}
+	if s.DisplayStatus != nil {
+		detail.DisplayStatus = *s.DisplayStatus
+	}
+	if s.Message != nil {
+		detail.Message = *s.Message
+	}
+
+	return detail
+}
@@ -0,0 +1,78 @@
+package vm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/appliedres/cloudy/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToDetailedStatus(t *testing.T) {
+	ctx := context.Background()
+
+	azVM := &armcompute.VirtualMachine{
+		ID:   to.Ptr("uvm-test"),
+		Name: to.Ptr("uvm-test"),
+		Properties: &armcompute.VirtualMachineProperties{
+			ProvisioningState: to.Ptr("Failed"),
+			InstanceView: &armcompute.VirtualMachineInstanceView{
+				Statuses: []*armcompute.InstanceViewStatus{
+					{Code: to.Ptr("ProvisioningState/failed/VMStartTimedOut"), Level: to.Ptr(armcompute.StatusLevelTypesError), Message: to.Ptr("timed out")},
+					{Code: to.Ptr("PowerState/running"), Level: to.Ptr(armcompute.StatusLevelTypesInfo)},
+				},
+				VMAgent: &armcompute.VirtualMachineAgentInstanceView{
+					VMAgentVersion: to.Ptr("2.7.41491.1102"),
+					Statuses: []*armcompute.InstanceViewStatus{
+						{Code: to.Ptr("ProvisioningState/succeeded"), DisplayStatus: to.Ptr("Ready"), Level: to.Ptr(armcompute.StatusLevelTypesInfo)},
+					},
+				},
+				Extensions: []*armcompute.VirtualMachineExtensionInstanceView{
+					{
+						Name:               to.Ptr("AADLoginForWindows"),
+						Type:               to.Ptr("Microsoft.Azure.ActiveDirectory.AADLoginForWindows"),
+						TypeHandlerVersion: to.Ptr("2.0"),
+						Statuses: []*armcompute.InstanceViewStatus{
+							{Code: to.Ptr("ProvisioningState/succeeded")},
+						},
+					},
+				},
+				BootDiagnostics: &armcompute.BootDiagnosticsInstanceView{
+					SerialConsoleLogBlobURI: to.Ptr("https://example/serial.log"),
+				},
+			},
+		},
+	}
+
+	status := toDetailedStatus(ctx, azVM)
+	assert.NotNil(t, status)
+
+	assert.Equal(t, models.VirtualMachineCloudStateFailed, *status.CloudState)
+	assert.Equal(t, "Failed", status.ProvisioningState)
+	assert.Equal(t, "running", status.PowerState)
+	assert.Len(t, status.Statuses, 2)
+
+	assert.Equal(t, "2.7.41491.1102", status.AgentVersion)
+	assert.True(t, status.AgentHealthy)
+
+	assert.Len(t, status.Extensions, 1)
+	assert.Equal(t, "AADLoginForWindows", status.Extensions[0].Name)
+	assert.Equal(t, "2.0", status.Extensions[0].Version)
+
+	assert.NotNil(t, status.LastProvisioningError)
+	assert.Equal(t, "timed out", status.LastProvisioningError.Message)
+
+	assert.NotNil(t, status.BootDiagnostics)
+	assert.Equal(t, "https://example/serial.log", status.BootDiagnostics.SerialConsoleLogBlobURI)
+}
+
+func TestToDetailedStatusWithoutInstanceView(t *testing.T) {
+	azVM := &armcompute.VirtualMachine{
+		Name:       to.Ptr("uvm-test"),
+		Properties: &armcompute.VirtualMachineProperties{ProvisioningState: to.Ptr("Succeeded")},
+	}
+
+	assert.Nil(t, toDetailedStatus(context.Background(), azVM))
+}
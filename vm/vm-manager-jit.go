@@ -0,0 +1,611 @@
+package vm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork/v5"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resourcegraph/armresourcegraph"
+	cloudyazure "github.com/appliedres/cloudy-azure"
+	"github.com/appliedres/cloudy/logging"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// JIT grants are stored as NSG rules, so they survive restarts and are visible to network admins.
+// The rule name holds the owning manager, the VM and expiry, the description holds the requester and justification.
+// NSGs holding grants are tagged with the owning manager, so only those NSGs are searched and only its own rules are expired.
+const (
+	jitRulePrefix            = "jit-"
+	jitOwnerTagPrefix        = "jit-grants-"
+	jitOwnerLength           = 8
+	jitRuleDescriptionLength = 140
+	defaultJITMaxDuration    = 3 * time.Hour
+	defaultJITRulePriority   = 200
+	defaultJITExpiryInterval = time.Minute
+	minJITSourcePrefixIPv4   = 24
+	minJITSourcePrefixIPv6   = 64
+	jitRequesterSeparator    = ": "
+	jitRuleNameSuffixLength  = 8
+)
+
+// JITAccessAuditAction is the kind of change recorded in a JIT access audit event
+type JITAccessAuditAction string
+
+const (
+	JITAccessGranted JITAccessAuditAction = "granted"
+	JITAccessRevoked JITAccessAuditAction = "revoked"
+	JITAccessExpired JITAccessAuditAction = "expired"
+)
+
+// JITAccessRequest asks for temporary network access to a VM's admin ports
+type JITAccessRequest struct {
+	VMID          string
+	SourceIP      string        // caller's address, or a CIDR range no wider than /24 (IPv4) or /64 (IPv6)
+	Ports         []int         // optional, defaults to RDP for Windows VMs and SSH for Linux VMs
+	Duration      time.Duration // must not exceed the configured MaxDuration
+	RequestedBy   string        // identity of the caller, recorded on the grant
+	Justification string        // optional, recorded on the grant
+}
+
+// JITAccessGrant is an active grant of network access to a VM
+type JITAccessGrant struct {
+	ID                     string // name of the NSG rule
+	NetworkSecurityGroupID string
+	VMID                   string
+	SourceIP               string
+	Ports                  []int
+	RequestedBy            string
+	Justification          string // may be truncated on listed grants
+	ExpiresAt              time.Time
+}
+
+// JITAccessAuditEvent records a grant, revocation or expiry of JIT access
+type JITAccessAuditEvent struct {
+	Action JITAccessAuditAction
+	Grant  JITAccessGrant
+	Actor  string // who granted or revoked access, empty when access expired or the VM was deleted
+	Time   time.Time
+}
+
+// GrantJITAccess allows a source IP to connect to a VM's admin ports until the requested duration has passed.
+// The allow rule is added to the configured NSG, or the NSG of the VM's NIC or subnet, and removed by the expirer.
+func (vmm *AzureVirtualMachineManager) GrantJITAccess(ctx context.Context, request JITAccessRequest) (*JITAccessGrant, error) {
+	log := logging.GetLogger(ctx).With("vmID", request.VMID)
+
+	config := vmm.Config.JITAccess
+	if config == nil {
+		return nil, fmt.Errorf("JIT access is not configured")
+	}
+
+	err := validateJITAccessRequest(request, config)
+	if err != nil {
+		return nil, err
+	}
+
+	privateIP, nsgID, windows, err := vmm.jitAccessTarget(ctx, request.VMID)
+	if err != nil {
+		return nil, err
+	}
+
+	ports := request.Ports
+	if len(ports) == 0 {
+		ports = []int{sshPort}
+		if windows {
+			ports = []int{rdpPort}
+		}
+	}
+
+	grant := JITAccessGrant{
+		NetworkSecurityGroupID: nsgID,
+		VMID:                   request.VMID,
+		SourceIP:               request.SourceIP,
+		Ports:                  ports,
+		RequestedBy:            request.RequestedBy,
+		Justification:          request.Justification,
+		ExpiresAt:              time.Now().Add(request.Duration).UTC().Truncate(time.Second),
+	}
+	grant.ID = jitRuleName(vmm.jitOwner(), grant.VMID, grant.ExpiresAt)
+
+	portRanges := []string{}
+	for _, port := range ports {
+		portRanges = append(portRanges, strconv.Itoa(port))
+	}
+
+	priorityStart := config.RulePriorityStart
+	if priorityStart <= 0 {
+		priorityStart = defaultJITRulePriority
+	}
+
+	log.InfoContext(ctx, "Granting JIT access", "source", grant.SourceIP, "ports", ports, "expiresAt", grant.ExpiresAt, "nsg", nsgID)
+	err = vmm.tagJITAccessNSG(ctx, nsgID)
+	if err != nil {
+		return nil, errors.Wrap(err, "GrantJITAccess")
+	}
+
+	err = vmm.ensureSecurityRule(ctx, nsgID, grant.ID, priorityStart, armnetwork.SecurityRulePropertiesFormat{
+		Description:              to.Ptr(jitRuleDescription(grant.RequestedBy, grant.Justification)),
+		Access:                   to.Ptr(armnetwork.SecurityRuleAccessAllow),
+		Direction:                to.Ptr(armnetwork.SecurityRuleDirectionInbound),
+		Protocol:                 to.Ptr(armnetwork.SecurityRuleProtocolTCP),
+		SourceAddressPrefix:      to.Ptr(grant.SourceIP),
+		SourcePortRange:          to.Ptr("*"),
+		DestinationAddressPrefix: to.Ptr(privateIP),
+		DestinationPortRanges:    to.SliceOfPtrs(portRanges...),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "GrantJITAccess")
+	}
+
+	vmm.auditJITAccess(ctx, JITAccessGranted, grant, request.RequestedBy)
+	return &grant, nil
+}
+
+// RevokeJITAccess removes a grant before it expires
+func (vmm *AzureVirtualMachineManager) RevokeJITAccess(ctx context.Context, grant JITAccessGrant, revokedBy string) error {
+	if vmm.Config.JITAccess == nil {
+		return fmt.Errorf("JIT access is not configured")
+	}
+
+	return vmm.removeJITAccess(ctx, grant, JITAccessRevoked, revokedBy)
+}
+
+// ListJITAccessGrants returns the grants this manager made for a VM, or for all VMs if vmID is empty, in order of expiry.
+// Only the configured NSG, or the NSGs tagged when granting, are searched.
+// Grants past their expiry that the expirer has not removed yet are included.
+func (vmm *AzureVirtualMachineManager) ListJITAccessGrants(ctx context.Context, vmID string) ([]JITAccessGrant, error) {
+	config := vmm.Config.JITAccess
+	if config == nil {
+		return nil, fmt.Errorf("JIT access is not configured")
+	}
+
+	nsgs := []*armnetwork.SecurityGroup{}
+	if config.NetworkSecurityGroupID != "" {
+		resp, err := vmm.securityGroupsClient.Get(ctx, resourceGroupFromID(config.NetworkSecurityGroupID), path.Base(config.NetworkSecurityGroupID), nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "ListJITAccessGrants")
+		}
+		nsgs = append(nsgs, &resp.SecurityGroup)
+	} else {
+		nsgIDs, err := vmm.listJITAccessNSGs(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "ListJITAccessGrants")
+		}
+		for _, nsgID := range nsgIDs {
+			resp, err := vmm.securityGroupsClient.Get(ctx, resourceGroupFromID(nsgID), path.Base(nsgID), nil)
+			if err != nil {
+				if cloudyazure.Is404(err) {
+					continue
+				}
+				return nil, errors.Wrap(err, "ListJITAccessGrants")
+			}
+			nsgs = append(nsgs, &resp.SecurityGroup)
+		}
+	}
+
+	owner := vmm.jitOwner()
+	grants := []JITAccessGrant{}
+	for _, nsg := range nsgs {
+		if nsg == nil || nsg.ID == nil || nsg.Properties == nil {
+			continue
+		}
+
+		for _, rule := range nsg.Properties.SecurityRules {
+			grant, ok := parseJITRule(owner, *nsg.ID, rule)
+			if !ok || (vmID != "" && !strings.EqualFold(grant.VMID, vmID)) {
+				continue
+			}
+			grants = append(grants, grant)
+		}
+	}
+
+	sort.Slice(grants, func(i, j int) bool {
+		return grants[i].ExpiresAt.Before(grants[j].ExpiresAt)
+	})
+
+	return grants, nil
+}
+
+// ExpireJITAccessGrants removes every grant past its expiry and returns the removed grants
+func (vmm *AzureVirtualMachineManager) ExpireJITAccessGrants(ctx context.Context) ([]JITAccessGrant, error) {
+	log := logging.GetLogger(ctx)
+
+	grants, err := vmm.ListJITAccessGrants(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	expired := []JITAccessGrant{}
+	for _, grant := range grants {
+		if grant.ExpiresAt.After(now) {
+			break
+		}
+
+		err := vmm.removeJITAccess(ctx, grant, JITAccessExpired, "")
+		if err != nil {
+			log.WarnContext(ctx, "Could not remove expired JIT access grant", "grant", grant.ID, logging.WithError(err))
+			continue
+		}
+		expired = append(expired, grant)
+	}
+
+	return expired, nil
+}
+
+// StartJITAccessExpirer removes expired grants every ExpiryCheckInterval until ctx is cancelled.
+// Does nothing if JIT access is not configured.
+func (vmm *AzureVirtualMachineManager) StartJITAccessExpirer(ctx context.Context) {
+	log := logging.GetLogger(ctx)
+
+	config := vmm.Config.JITAccess
+	if config == nil {
+		log.DebugContext(ctx, "JIT access not configured, expirer disabled")
+		return
+	}
+
+	interval := config.ExpiryCheckInterval
+	if interval <= 0 {
+		interval = defaultJITExpiryInterval
+	}
+
+	log.InfoContext(ctx, "Starting JIT access expirer", "interval", interval)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				log.InfoContext(ctx, "JIT access expirer stopped")
+				return
+			case <-ticker.C:
+				if _, err := vmm.ExpireJITAccessGrants(ctx); err != nil {
+					log.WarnContext(ctx, "JIT access expiry check failed", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// revokeJITAccessForVM removes all grants of a deleted VM, so they cannot apply to a VM that reuses its private IP
+func (vmm *AzureVirtualMachineManager) revokeJITAccessForVM(ctx context.Context, vmID string) error {
+	if vmm.Config.JITAccess == nil {
+		return nil
+	}
+
+	grants, err := vmm.ListJITAccessGrants(ctx, vmID)
+	if err != nil {
+		return err
+	}
+
+	for _, grant := range grants {
+		err = vmm.removeJITAccess(ctx, grant, JITAccessRevoked, "")
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (vmm *AzureVirtualMachineManager) removeJITAccess(ctx context.Context, grant JITAccessGrant, action JITAccessAuditAction, actor string) error {
+	err := vmm.deleteSecurityRule(ctx, grant.NetworkSecurityGroupID, grant.ID)
+	if err != nil {
+		return errors.Wrap(err, "remove JIT access")
+	}
+
+	vmm.auditJITAccess(ctx, action, grant, actor)
+	return nil
+}
+
+// auditJITAccess writes the audit log entry for a JIT access change and passes it to the configured audit hook
+func (vmm *AzureVirtualMachineManager) auditJITAccess(ctx context.Context, action JITAccessAuditAction, grant JITAccessGrant, actor string) {
+	log := logging.GetLogger(ctx)
+
+	event := JITAccessAuditEvent{
+		Action: action,
+		Grant:  grant,
+		Actor:  actor,
+		Time:   time.Now().UTC(),
+	}
+
+	log.InfoContext(ctx, "JIT access audit",
+		"action", action,
+		"actor", actor,
+		"grant", grant.ID,
+		"vmID", grant.VMID,
+		"source", grant.SourceIP,
+		"ports", grant.Ports,
+		"requestedBy", grant.RequestedBy,
+		"justification", grant.Justification,
+		"expiresAt", grant.ExpiresAt,
+		"nsg", grant.NetworkSecurityGroupID)
+
+	if vmm.Config.JITAccess != nil && vmm.Config.JITAccess.OnAudit != nil {
+		vmm.Config.JITAccess.OnAudit(ctx, event)
+	}
+}
+
+// jitAccessTarget returns the private IP of a VM's primary NIC, the NSG grants are added to, and whether the VM runs Windows
+func (vmm *AzureVirtualMachineManager) jitAccessTarget(ctx context.Context, vmID string) (string, string, bool, error) {
+	resp, err := vmm.vmClient.Get(ctx, vmm.Credentials.ResourceGroup, vmID, nil)
+	if err != nil {
+		return "", "", false, errors.Wrap(err, "JIT access get VM")
+	}
+
+	props := resp.Properties
+	if props == nil || props.NetworkProfile == nil || len(props.NetworkProfile.NetworkInterfaces) == 0 || props.NetworkProfile.NetworkInterfaces[0].ID == nil {
+		return "", "", false, fmt.Errorf("VM %s has no NIC", vmID)
+	}
+	windows := props.StorageProfile != nil && props.StorageProfile.OSDisk != nil && props.StorageProfile.OSDisk.OSType != nil &&
+		*props.StorageProfile.OSDisk.OSType == armcompute.OperatingSystemTypesWindows
+
+	nicID := *props.NetworkProfile.NetworkInterfaces[0].ID
+	nic, err := vmm.nicClient.Get(ctx, resourceGroupFromID(nicID), path.Base(nicID), nil)
+	if err != nil {
+		return "", "", false, errors.Wrap(err, "JIT access get NIC")
+	}
+	if nic.Properties == nil || len(nic.Properties.IPConfigurations) == 0 || nic.Properties.IPConfigurations[0].Properties == nil ||
+		nic.Properties.IPConfigurations[0].Properties.PrivateIPAddress == nil {
+		return "", "", false, fmt.Errorf("NIC %s has no private IP", path.Base(nicID))
+	}
+	ipConfig := nic.Properties.IPConfigurations[0].Properties
+	privateIP := *ipConfig.PrivateIPAddress
+
+	if vmm.Config.JITAccess.NetworkSecurityGroupID != "" {
+		return privateIP, vmm.Config.JITAccess.NetworkSecurityGroupID, windows, nil
+	}
+
+	if nic.Properties.NetworkSecurityGroup != nil && nic.Properties.NetworkSecurityGroup.ID != nil {
+		return privateIP, *nic.Properties.NetworkSecurityGroup.ID, windows, nil
+	}
+
+	if ipConfig.Subnet != nil && ipConfig.Subnet.ID != nil {
+		subnetID := *ipConfig.Subnet.ID
+		vnet := resourceSegmentFromID(subnetID, "virtualNetworks")
+		subnet, err := vmm.subnetClient.Get(ctx, resourceGroupFromID(subnetID), vnet, path.Base(subnetID), nil)
+		if err != nil {
+			return "", "", false, errors.Wrap(err, "JIT access get subnet")
+		}
+		if subnet.Properties != nil && subnet.Properties.NetworkSecurityGroup != nil && subnet.Properties.NetworkSecurityGroup.ID != nil {
+			return privateIP, *subnet.Properties.NetworkSecurityGroup.ID, windows, nil
+		}
+	}
+
+	return "", "", false, fmt.Errorf("VM %s has no NSG on its NIC or subnet, and no JIT access NSG is configured", vmID)
+}
+
+// jitOwner identifies this manager's grants, so deployments sharing an NSG or subscription never expire each other's rules
+func (vmm *AzureVirtualMachineManager) jitOwner() string {
+	sum := sha256.Sum256([]byte(vmm.Credentials.SubscriptionID + "/" + vmm.Credentials.ResourceGroup + "/" + vmm.name))
+	return hex.EncodeToString(sum[:])[:jitOwnerLength]
+}
+
+// tagJITAccessNSG marks an NSG as holding this manager's grants, keeping its existing tags
+func (vmm *AzureVirtualMachineManager) tagJITAccessNSG(ctx context.Context, nsgID string) error {
+	nsgGroup, nsgName := resourceGroupFromID(nsgID), path.Base(nsgID)
+	resp, err := vmm.securityGroupsClient.Get(ctx, nsgGroup, nsgName, nil)
+	if err != nil {
+		return errors.Wrap(err, "get NSG")
+	}
+
+	key := jitOwnerTagPrefix + vmm.jitOwner()
+	if _, ok := resp.Tags[key]; ok {
+		return nil
+	}
+
+	tags := map[string]*string{}
+	for k, v := range resp.Tags {
+		tags[k] = v
+	}
+	tags[key] = to.Ptr(vmm.name)
+
+	_, err = vmm.securityGroupsClient.UpdateTags(ctx, nsgGroup, nsgName, armnetwork.TagsObject{Tags: tags}, nil)
+	if err != nil {
+		return errors.Wrap(err, "tag NSG")
+	}
+
+	return nil
+}
+
+// listJITAccessNSGs returns the IDs of the NSGs tagged as holding this manager's grants
+func (vmm *AzureVirtualMachineManager) listJITAccessNSGs(ctx context.Context) ([]string, error) {
+	subscriptions := vmm.Config.ResourceGraphSubscriptions
+	if len(subscriptions) == 0 {
+		subscriptions = []string{vmm.Credentials.SubscriptionID}
+	}
+
+	request := armresourcegraph.QueryRequest{
+		Query:         to.Ptr(jitAccessNSGQuery(vmm.jitOwner())),
+		Subscriptions: to.SliceOfPtrs(subscriptions...),
+		Options: &armresourcegraph.QueryRequestOptions{
+			ResultFormat: to.Ptr(armresourcegraph.ResultFormatObjectArray),
+			Top:          to.Ptr(int32(resourceGraphPageSize)),
+		},
+	}
+
+	nsgIDs := []string{}
+	for {
+		resp, err := vmm.resourceGraphClient.Resources(ctx, request, nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "Resource Graph Query")
+		}
+
+		rows, ok := resp.Data.([]any)
+		if !ok {
+			return nil, fmt.Errorf("unexpected Resource Graph result format %T", resp.Data)
+		}
+		for _, row := range rows {
+			if values, ok := row.(map[string]any); ok {
+				if id, ok := values["id"].(string); ok && id != "" {
+					nsgIDs = append(nsgIDs, id)
+				}
+			}
+		}
+
+		if resp.SkipToken == nil || *resp.SkipToken == "" {
+			break
+		}
+		request.Options.SkipToken = resp.SkipToken
+	}
+
+	return nsgIDs, nil
+}
+
+// jitAccessNSGQuery finds the NSGs tagged as holding the grants of an owner
+func jitAccessNSGQuery(owner string) string {
+	return strings.Join([]string{
+		"Resources",
+		"where type =~ 'microsoft.network/networksecuritygroups'",
+		fmt.Sprintf("where isnotnull(tags[%s])", kqlString(jitOwnerTagPrefix+owner)),
+		"project id",
+	}, "\n| ")
+}
+
+// validateJITAccessRequest checks that a request is attributable, time-bound and limited to a narrow source
+func validateJITAccessRequest(request JITAccessRequest, config *JITAccessConfig) error {
+	if request.VMID == "" {
+		return fmt.Errorf("JIT access requires a VM ID")
+	}
+
+	if request.RequestedBy == "" {
+		return fmt.Errorf("JIT access requires the requesting identity")
+	}
+
+	maxDuration := config.MaxDuration
+	if maxDuration <= 0 {
+		maxDuration = defaultJITMaxDuration
+	}
+	if request.Duration <= 0 || request.Duration > maxDuration {
+		return fmt.Errorf("JIT access duration must be between 0 and %v, got %v", maxDuration, request.Duration)
+	}
+
+	for _, port := range request.Ports {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("invalid JIT access port %d", port)
+		}
+	}
+
+	return validateJITSource(request.SourceIP)
+}
+
+// validateJITSource accepts a single address, or a CIDR range no wider than /24 (IPv4) or /64 (IPv6)
+func validateJITSource(source string) error {
+	if net.ParseIP(source) != nil {
+		return nil
+	}
+
+	ip, network, err := net.ParseCIDR(source)
+	if err != nil {
+		return fmt.Errorf("JIT access source must be an IP address or CIDR range, got [%s]", source)
+	}
+
+	ones, _ := network.Mask.Size()
+	minOnes := minJITSourcePrefixIPv6
+	if ip.To4() != nil {
+		minOnes = minJITSourcePrefixIPv4
+	}
+	if ones < minOnes {
+		return fmt.Errorf("JIT access source range [%s] is too wide, must be /%d or narrower", source, minOnes)
+	}
+
+	return nil
+}
+
+// jitRuleName returns a unique NSG rule name recording the owner, VM and expiry of a grant
+func jitRuleName(owner, vmID string, expiresAt time.Time) string {
+	return fmt.Sprintf("%s%s-%s-%d-%s", jitRulePrefix, owner, vmID, expiresAt.Unix(), uuid.NewString()[:jitRuleNameSuffixLength])
+}
+
+// jitRuleDescription records the requester and justification, truncated to the NSG rule description limit
+func jitRuleDescription(requestedBy, justification string) string {
+	description := requestedBy
+	if justification != "" {
+		description += jitRequesterSeparator + justification
+	}
+
+	if len(description) > jitRuleDescriptionLength {
+		description = description[:jitRuleDescriptionLength]
+	}
+
+	return description
+}
+
+// parseJITRule reads a grant from an NSG rule, returning false for rules that are not JIT grants of the owner
+func parseJITRule(owner, nsgID string, rule *armnetwork.SecurityRule) (JITAccessGrant, bool) {
+	prefix := jitRulePrefix + owner + "-"
+	if rule == nil || rule.Name == nil || rule.Properties == nil || !strings.HasPrefix(*rule.Name, prefix) {
+		return JITAccessGrant{}, false
+	}
+
+	// jit-<owner>-<vmID>-<expiry>-<random>, where the VM ID may itself contain dashes
+	rest := strings.TrimPrefix(*rule.Name, prefix)
+	i := strings.LastIndex(rest, "-")
+	if i < 0 {
+		return JITAccessGrant{}, false
+	}
+	rest = rest[:i]
+	i = strings.LastIndex(rest, "-")
+	if i <= 0 {
+		return JITAccessGrant{}, false
+	}
+	expiry, err := strconv.ParseInt(rest[i+1:], 10, 64)
+	if err != nil {
+		return JITAccessGrant{}, false
+	}
+
+	grant := JITAccessGrant{
+		ID:                     *rule.Name,
+		NetworkSecurityGroupID: nsgID,
+		VMID:                   rest[:i],
+		ExpiresAt:              time.Unix(expiry, 0).UTC(),
+	}
+
+	props := rule.Properties
+	if props.SourceAddressPrefix != nil {
+		grant.SourceIP = *props.SourceAddressPrefix
+	}
+
+	ranges := props.DestinationPortRanges
+	if props.DestinationPortRange != nil {
+		ranges = append(ranges, props.DestinationPortRange)
+	}
+	for _, r := range ranges {
+		if r == nil {
+			continue
+		}
+		if port, err := strconv.Atoi(*r); err == nil {
+			grant.Ports = append(grant.Ports, port)
+		}
+	}
+
+	if props.Description != nil {
+		grant.RequestedBy, grant.Justification, _ = strings.Cut(*props.Description, jitRequesterSeparator)
+	}
+
+	return grant, true
+}
+
+// resourceSegmentFromID returns the name following a resource type segment of a resource ID
+func resourceSegmentFromID(id, segment string) string {
+	parts := strings.Split(id, "/")
+	for i := 0; i < len(parts)-1; i++ {
+		if strings.EqualFold(parts[i], segment) {
+			return parts[i+1]
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,85 @@
+package vm
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateJITAccessRequest(t *testing.T) {
+	config := &JITAccessConfig{MaxDuration: time.Hour}
+	valid := JITAccessRequest{VMID: "uvm-1", SourceIP: "203.0.113.7", Duration: 30 * time.Minute, RequestedBy: "admin@example.com"}
+	assert.NoError(t, validateJITAccessRequest(valid, config))
+
+	tests := map[string]func(r *JITAccessRequest){
+		"no requester":    func(r *JITAccessRequest) { r.RequestedBy = "" },
+		"too long":        func(r *JITAccessRequest) { r.Duration = 2 * time.Hour },
+		"no duration":     func(r *JITAccessRequest) { r.Duration = 0 },
+		"any source":      func(r *JITAccessRequest) { r.SourceIP = "*" },
+		"wide range":      func(r *JITAccessRequest) { r.SourceIP = "203.0.0.0/16" },
+		"internet":        func(r *JITAccessRequest) { r.SourceIP = "0.0.0.0/0" },
+		"wide IPv6 range": func(r *JITAccessRequest) { r.SourceIP = "2001:db8::/32" },
+		"bad port":        func(r *JITAccessRequest) { r.Ports = []int{70000} },
+	}
+	for name, mutate := range tests {
+		t.Run(name, func(t *testing.T) {
+			request := valid
+			mutate(&request)
+			assert.Error(t, validateJITAccessRequest(request, config))
+		})
+	}
+
+	request := valid
+	request.SourceIP = "203.0.113.0/28"
+	assert.NoError(t, validateJITAccessRequest(request, config))
+	request.Duration = 3 * time.Hour
+	assert.NoError(t, validateJITAccessRequest(request, &JITAccessConfig{}))
+}
+
+func TestParseJITRule(t *testing.T) {
+	expires := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	name := jitRuleName("0a1b2c3d", "uvm-team-1", expires)
+	assert.True(t, strings.HasPrefix(name, "jit-0a1b2c3d-uvm-team-1-1714564800-"))
+
+	nsgID := "/subscriptions/sub/resourceGroups/net/providers/Microsoft.Network/networkSecurityGroups/nsg"
+	grant, ok := parseJITRule("0a1b2c3d", nsgID, &armnetwork.SecurityRule{
+		Name: to.Ptr(name),
+		Properties: &armnetwork.SecurityRulePropertiesFormat{
+			Description:           to.Ptr(jitRuleDescription("admin@example.com", "patch: KB123")),
+			SourceAddressPrefix:   to.Ptr("203.0.113.7"),
+			DestinationPortRanges: to.SliceOfPtrs("3389", "5986"),
+		},
+	})
+	assert.True(t, ok)
+	assert.Equal(t, name, grant.ID)
+	assert.Equal(t, nsgID, grant.NetworkSecurityGroupID)
+	assert.Equal(t, "uvm-team-1", grant.VMID)
+	assert.Equal(t, expires, grant.ExpiresAt)
+	assert.Equal(t, "203.0.113.7", grant.SourceIP)
+	assert.Equal(t, []int{3389, 5986}, grant.Ports)
+	assert.Equal(t, "admin@example.com", grant.RequestedBy)
+	assert.Equal(t, "patch: KB123", grant.Justification)
+
+	// rules of another manager are never read, so they are never expired by this one
+	_, ok = parseJITRule("ffffffff", nsgID, &armnetwork.SecurityRule{Name: to.Ptr(name), Properties: &armnetwork.SecurityRulePropertiesFormat{}})
+	assert.False(t, ok)
+	_, ok = parseJITRule("0a1b2c3d", nsgID, &armnetwork.SecurityRule{Name: to.Ptr("uvm-1-connect"), Properties: &armnetwork.SecurityRulePropertiesFormat{}})
+	assert.False(t, ok)
+	_, ok = parseJITRule("0a1b2c3d", nsgID, &armnetwork.SecurityRule{Name: to.Ptr("jit-0a1b2c3d-manual"), Properties: &armnetwork.SecurityRulePropertiesFormat{}})
+	assert.False(t, ok)
+}
+
+func TestJITRuleDescription(t *testing.T) {
+	assert.Equal(t, "admin", jitRuleDescription("admin", ""))
+	assert.Len(t, jitRuleDescription("admin", strings.Repeat("x", 200)), jitRuleDescriptionLength)
+}
+
+func TestJITAccessNSGQuery(t *testing.T) {
+	query := jitAccessNSGQuery("0a1b2c3d")
+	assert.Contains(t, query, "microsoft.network/networksecuritygroups")
+	assert.Contains(t, query, "where isnotnull(tags['jit-grants-0a1b2c3d'])")
+}
@@ -0,0 +1,96 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork/v5"
+	cloudyazure "github.com/appliedres/cloudy-azure"
+	"github.com/pkg/errors"
+)
+
+const maxSecurityRulePriority = 4096
+
+// ensureSecurityRule creates or updates a rule in an NSG. A new rule gets the lowest free priority
+// at or above start, an existing rule keeps its priority.
+func (vmm *AzureVirtualMachineManager) ensureSecurityRule(ctx context.Context, nsgID, name string, start int32, properties armnetwork.SecurityRulePropertiesFormat) error {
+	nsgGroup, nsgName := resourceGroupFromID(nsgID), path.Base(nsgID)
+
+	rules := []*armnetwork.SecurityRule{}
+	pager := vmm.securityRulesClient.NewListPager(nsgGroup, nsgName, nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return errors.Wrap(err, "list NSG rules")
+		}
+		rules = append(rules, page.Value...)
+	}
+
+	priority, err := securityRulePriority(rules, name, start)
+	if err != nil {
+		return err
+	}
+	properties.Priority = to.Ptr(priority)
+
+	poller, err := vmm.securityRulesClient.BeginCreateOrUpdate(ctx, nsgGroup, nsgName, name, armnetwork.SecurityRule{
+		Properties: &properties,
+	}, nil)
+	if err != nil {
+		return errors.Wrap(err, "create NSG rule")
+	}
+
+	_, err = poller.PollUntilDone(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "create NSG rule: polling")
+	}
+
+	return nil
+}
+
+// deleteSecurityRule removes a rule from an NSG, if it exists
+func (vmm *AzureVirtualMachineManager) deleteSecurityRule(ctx context.Context, nsgID, name string) error {
+	poller, err := vmm.securityRulesClient.BeginDelete(ctx, resourceGroupFromID(nsgID), path.Base(nsgID), name, nil)
+	if err != nil {
+		if cloudyazure.Is404(err) {
+			return nil
+		}
+		return errors.Wrap(err, "delete NSG rule")
+	}
+
+	_, err = poller.PollUntilDone(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "delete NSG rule: polling")
+	}
+
+	return nil
+}
+
+// securityRulePriority returns the priority of the named rule if it exists, otherwise the lowest free priority at or above start
+func securityRulePriority(rules []*armnetwork.SecurityRule, name string, start int32) (int32, error) {
+	if start <= 0 {
+		start = defaultConnectionRulePriority
+	}
+
+	used := []int32{}
+	for _, rule := range rules {
+		if rule == nil || rule.Properties == nil || rule.Properties.Priority == nil {
+			continue
+		}
+		if rule.Name != nil && strings.EqualFold(*rule.Name, name) {
+			return *rule.Properties.Priority, nil
+		}
+		used = append(used, *rule.Properties.Priority)
+	}
+
+	for priority := start; priority <= maxSecurityRulePriority; priority++ {
+		if !slices.Contains(used, priority) {
+			return priority, nil
+		}
+	}
+
+	return 0, fmt.Errorf("no free NSG rule priority at or above %d", start)
+}
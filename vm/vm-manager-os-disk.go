@@ -0,0 +1,197 @@
+package vm
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/appliedres/cloudy/models"
+)
+
+// OS disk SKUs that can be used for an OS disk. Premium SSD v2 and Ultra disks are data disk only.
+var supportedOSDiskSKUs = []armcompute.StorageAccountTypes{
+	armcompute.StorageAccountTypesStandardLRS,
+	armcompute.StorageAccountTypesStandardSSDLRS,
+	armcompute.StorageAccountTypesStandardSSDZRS,
+	armcompute.StorageAccountTypesPremiumLRS,
+	armcompute.StorageAccountTypesPremiumZRS,
+}
+
+// templateOSDisk returns the OS disk of the template's disks, or nil if the template does not define one
+func templateOSDisk(template *models.VirtualMachineTemplate) *models.VirtualMachineDisk {
+	if template == nil {
+		return nil
+	}
+
+	for _, disk := range template.Disks {
+		if disk != nil && disk.OsDisk {
+			return disk
+		}
+	}
+
+	return nil
+}
+
+// osDiskSizeGB returns the OS disk size to create, or zero to use the size of the image.
+// Windows VMs are raised to MIN_WINDOWS_OS_DISK_SIZE, unless the OS disk is ephemeral and limited by the size of the VM's local storage.
+func osDiskSizeGB(template *models.VirtualMachineTemplate, opts *templateOptions) int64 {
+	size := int64(0)
+	if disk := templateOSDisk(template); disk != nil {
+		size = disk.Size
+	}
+
+	if template != nil && template.OperatingSystem == models.VirtualMachineTemplateOperatingSystemWindows &&
+		opts.EphemeralOSDisk == "" && size < MIN_WINDOWS_OS_DISK_SIZE {
+		size = MIN_WINDOWS_OS_DISK_SIZE
+	}
+
+	return size
+}
+
+// osDiskSKU returns the OS disk SKU, from the OSDiskSKU tag or the template OS disk's PremiumIo flag. Empty uses the Azure default.
+func osDiskSKU(template *models.VirtualMachineTemplate, opts *templateOptions) string {
+	if opts.OSDiskSKU != "" {
+		return opts.OSDiskSKU
+	}
+
+	if disk := templateOSDisk(template); disk != nil && disk.PremiumIo {
+		return string(armcompute.StorageAccountTypesPremiumLRS)
+	}
+
+	return ""
+}
+
+// validateOSDiskOptions checks the OS disk settings, and if SKU capabilities are given, that the size supports them
+func validateOSDiskOptions(template *models.VirtualMachineTemplate, opts *templateOptions, caps skuCapabilities) error {
+	sku := osDiskSKU(template, opts)
+	if sku != "" && !slices.Contains(supportedOSDiskSKUs, armcompute.StorageAccountTypes(sku)) {
+		return fmt.Errorf("unsupported OS disk SKU [%s], must be one of %v", sku, supportedOSDiskSKUs)
+	}
+
+	if opts.OSDiskCaching != "" && !slices.Contains(armcompute.PossibleCachingTypesValues(), armcompute.CachingTypes(opts.OSDiskCaching)) {
+		return fmt.Errorf("unsupported OS disk caching [%s], must be one of %v", opts.OSDiskCaching, armcompute.PossibleCachingTypesValues())
+	}
+
+	if opts.EphemeralOSDisk != "" {
+		placement := armcompute.DiffDiskPlacement(opts.EphemeralOSDisk)
+		if !slices.Contains(armcompute.PossibleDiffDiskPlacementValues(), placement) {
+			return fmt.Errorf("unsupported ephemeral OS disk placement [%s], must be one of %v", opts.EphemeralOSDisk, armcompute.PossibleDiffDiskPlacementValues())
+		}
+		if opts.OSDiskSKU != "" {
+			return fmt.Errorf("an ephemeral OS disk is stored on the VM host and cannot have an OS disk SKU")
+		}
+		if opts.OSDiskCaching != "" && armcompute.CachingTypes(opts.OSDiskCaching) != armcompute.CachingTypesReadOnly {
+			return fmt.Errorf("an ephemeral OS disk requires %s caching", armcompute.CachingTypesReadOnly)
+		}
+		if opts.DiskEncryptionSetID != "" || opts.ConfidentialDiskEncryption != "" {
+			return fmt.Errorf("an ephemeral OS disk cannot use a disk encryption set or confidential disk encryption")
+		}
+		if opts.Hibernation {
+			return fmt.Errorf("hibernation is not supported with an ephemeral OS disk")
+		}
+	}
+
+	if caps == nil {
+		return nil
+	}
+
+	if (sku == string(armcompute.StorageAccountTypesPremiumLRS) || sku == string(armcompute.StorageAccountTypesPremiumZRS)) && !caps.isTrue("PremiumIO") {
+		return fmt.Errorf("VM size does not support premium storage")
+	}
+
+	if opts.EphemeralOSDisk != "" {
+		if !caps.isTrue("EphemeralOSDiskSupported") {
+			return fmt.Errorf("VM size does not support ephemeral OS disks")
+		}
+		if !caps.contains("SupportedEphemeralOSDiskPlacements", opts.EphemeralOSDisk) {
+			return fmt.Errorf("VM size does not support %s placement of ephemeral OS disks", opts.EphemeralOSDisk)
+		}
+
+		if err := validateEphemeralOSDiskSize(armcompute.DiffDiskPlacement(opts.EphemeralOSDisk), osDiskSizeGB(template, opts), caps); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateEphemeralOSDiskSize checks that an ephemeral OS disk of a known size fits in the local storage it is placed on
+func validateEphemeralOSDiskSize(placement armcompute.DiffDiskPlacement, sizeGB int64, caps skuCapabilities) error {
+	if sizeGB == 0 {
+		return nil
+	}
+
+	var available int64
+	var err error
+	switch placement {
+	case armcompute.DiffDiskPlacementCacheDisk:
+		available, err = strconv.ParseInt(caps["CachedDiskBytes"], 10, 64)
+		available = available / (1024 * 1024 * 1024)
+	case armcompute.DiffDiskPlacementResourceDisk:
+		available, err = strconv.ParseInt(caps["MaxResourceVolumeMB"], 10, 64)
+		available = available / 1024
+	default:
+		// NVMe disk sizes are not published as a capability
+		return nil
+	}
+	if err != nil {
+		return nil
+	}
+
+	if sizeGB > available {
+		return fmt.Errorf("ephemeral OS disk of %d GB does not fit the %d GB %s of the VM size", sizeGB, available, placement)
+	}
+
+	return nil
+}
+
+// applyOSDiskOptions sets the OS disk size, SKU, caching and ephemeral placement on VM create parameters.
+// The options must already be validated.
+func applyOSDiskOptions(azVM *armcompute.VirtualMachine, template *models.VirtualMachineTemplate, opts *templateOptions) {
+	osDisk := azVM.Properties.StorageProfile.OSDisk
+
+	if size := osDiskSizeGB(template, opts); size > 0 {
+		osDisk.DiskSizeGB = to.Ptr(int32(size))
+	}
+
+	if opts.OSDiskCaching != "" {
+		osDisk.Caching = to.Ptr(armcompute.CachingTypes(opts.OSDiskCaching))
+	}
+
+	if opts.EphemeralOSDisk != "" {
+		osDisk.Caching = to.Ptr(armcompute.CachingTypesReadOnly)
+		osDisk.DiffDiskSettings = &armcompute.DiffDiskSettings{
+			Option:    to.Ptr(armcompute.DiffDiskOptionsLocal),
+			Placement: to.Ptr(armcompute.DiffDiskPlacement(opts.EphemeralOSDisk)),
+		}
+	}
+
+	if sku := osDiskSKU(template, opts); sku != "" {
+		if osDisk.ManagedDisk == nil {
+			osDisk.ManagedDisk = &armcompute.ManagedDiskParameters{}
+		}
+		osDisk.ManagedDisk.StorageAccountType = to.Ptr(armcompute.StorageAccountTypes(sku))
+	}
+}
+
+// readOSDisk reports the OS disk SKU, caching and ephemeral placement of an Azure VM in the cloudy VM tags
+func readOSDisk(azVM *armcompute.VirtualMachine, tags map[string]*string) {
+	if azVM.Properties == nil || azVM.Properties.StorageProfile == nil || azVM.Properties.StorageProfile.OSDisk == nil {
+		return
+	}
+	osDisk := azVM.Properties.StorageProfile.OSDisk
+
+	if osDisk.Caching != nil {
+		setTag(tags, OSDiskCachingTagKey, string(*osDisk.Caching))
+	}
+
+	if osDisk.DiffDiskSettings != nil && osDisk.DiffDiskSettings.Placement != nil {
+		setTag(tags, EphemeralOSDiskTagKey, string(*osDisk.DiffDiskSettings.Placement))
+	}
+
+	if osDisk.ManagedDisk != nil && osDisk.ManagedDisk.StorageAccountType != nil {
+		setTag(tags, OSDiskSKUTagKey, string(*osDisk.ManagedDisk.StorageAccountType))
+	}
+}
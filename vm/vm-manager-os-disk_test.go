@@ -0,0 +1,91 @@
+package vm
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/appliedres/cloudy/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOSDiskSizeGB(t *testing.T) {
+	windows := &models.VirtualMachineTemplate{OperatingSystem: models.VirtualMachineTemplateOperatingSystemWindows}
+	linux := &models.VirtualMachineTemplate{
+		OperatingSystem: models.VirtualMachineTemplateOperatingSystemLinuxDeb,
+		Disks:           []*models.VirtualMachineDisk{{Size: 500}, {OsDisk: true, Size: 64}},
+	}
+
+	assert.Equal(t, int64(MIN_WINDOWS_OS_DISK_SIZE), osDiskSizeGB(windows, &templateOptions{}))
+	assert.Equal(t, int64(0), osDiskSizeGB(windows, &templateOptions{EphemeralOSDisk: "CacheDisk"}))
+	assert.Equal(t, int64(64), osDiskSizeGB(linux, &templateOptions{}))
+
+	windows.Disks = []*models.VirtualMachineDisk{{OsDisk: true, Size: 512}}
+	assert.Equal(t, int64(512), osDiskSizeGB(windows, &templateOptions{}))
+}
+
+func TestValidateOSDiskOptions(t *testing.T) {
+	linux := &models.VirtualMachineTemplate{OperatingSystem: models.VirtualMachineTemplateOperatingSystemLinuxDeb}
+	ephemeral := skuCapabilities{
+		"EphemeralOSDiskSupported":           "True",
+		"SupportedEphemeralOSDiskPlacements": "ResourceDisk,CacheDisk",
+		"CachedDiskBytes":                    "53687091200", // 50 GB
+		"PremiumIO":                          "True",
+	}
+
+	tests := []struct {
+		name        string
+		template    *models.VirtualMachineTemplate
+		opts        templateOptions
+		caps        skuCapabilities
+		expectError bool
+	}{
+		{"defaults", linux, templateOptions{}, skuCapabilities{}, false},
+		{"premium", linux, templateOptions{OSDiskSKU: "Premium_LRS", OSDiskCaching: "ReadWrite"}, ephemeral, false},
+		{"premium unsupported", linux, templateOptions{OSDiskSKU: "Premium_LRS"}, skuCapabilities{}, true},
+		{"premium io unsupported", &models.VirtualMachineTemplate{Disks: []*models.VirtualMachineDisk{{OsDisk: true, PremiumIo: true}}}, templateOptions{}, skuCapabilities{}, true},
+		{"premium v2", linux, templateOptions{OSDiskSKU: "PremiumV2_LRS"}, nil, true},
+		{"unknown caching", linux, templateOptions{OSDiskCaching: "WriteOnly"}, nil, true},
+		{"ephemeral", linux, templateOptions{EphemeralOSDisk: "CacheDisk"}, ephemeral, false},
+		{"ephemeral unsupported placement", linux, templateOptions{EphemeralOSDisk: "NvmeDisk"}, ephemeral, true},
+		{"ephemeral unsupported size", linux, templateOptions{EphemeralOSDisk: "CacheDisk"}, skuCapabilities{}, true},
+		{"ephemeral too large", &models.VirtualMachineTemplate{Disks: []*models.VirtualMachineDisk{{OsDisk: true, Size: 64}}}, templateOptions{EphemeralOSDisk: "CacheDisk"}, ephemeral, true},
+		{"ephemeral with sku", linux, templateOptions{EphemeralOSDisk: "CacheDisk", OSDiskSKU: "Premium_LRS"}, nil, true},
+		{"ephemeral with read-write caching", linux, templateOptions{EphemeralOSDisk: "CacheDisk", OSDiskCaching: "ReadWrite"}, nil, true},
+		{"ephemeral with hibernation", linux, templateOptions{EphemeralOSDisk: "CacheDisk", Hibernation: true}, nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateOSDiskOptions(test.template, &test.opts, test.caps)
+			if test.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestOSDiskRoundTrip(t *testing.T) {
+	azVM := &armcompute.VirtualMachine{
+		Properties: &armcompute.VirtualMachineProperties{
+			StorageProfile: &armcompute.StorageProfile{OSDisk: &armcompute.OSDisk{}},
+		},
+	}
+	template := &models.VirtualMachineTemplate{OperatingSystem: models.VirtualMachineTemplateOperatingSystemWindows}
+	applyOSDiskOptions(azVM, template, &templateOptions{EphemeralOSDisk: "ResourceDisk"})
+
+	osDisk := azVM.Properties.StorageProfile.OSDisk
+	assert.Nil(t, osDisk.DiskSizeGB)
+	assert.Equal(t, armcompute.DiffDiskOptionsLocal, *osDisk.DiffDiskSettings.Option)
+
+	tags := map[string]*string{}
+	readOSDisk(azVM, tags)
+	assert.Equal(t, "ResourceDisk", *tags[EphemeralOSDiskTagKey])
+	assert.Equal(t, "ReadOnly", *tags[OSDiskCachingTagKey])
+
+	azVM.Properties.StorageProfile.OSDisk = &armcompute.OSDisk{}
+	applyOSDiskOptions(azVM, template, &templateOptions{OSDiskSKU: "StandardSSD_LRS"})
+	assert.Equal(t, int32(MIN_WINDOWS_OS_DISK_SIZE), *azVM.Properties.StorageProfile.OSDisk.DiskSizeGB)
+	assert.Equal(t, armcompute.StorageAccountTypesStandardSSDLRS, *azVM.Properties.StorageProfile.OSDisk.ManagedDisk.StorageAccountType)
+}
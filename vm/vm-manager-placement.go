@@ -0,0 +1,185 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/appliedres/cloudy/logging"
+	"github.com/appliedres/cloudy/models"
+	"github.com/pkg/errors"
+)
+
+// skuZones returns the availability zones a SKU can be deployed to in a region, excluding zones restricted for the subscription
+func skuZones(sku *armcompute.ResourceSKU, region string) []string {
+	if sku == nil {
+		return nil
+	}
+
+	restricted := map[string]bool{}
+	for _, restriction := range sku.Restrictions {
+		if restriction == nil || restriction.Type == nil || *restriction.Type != armcompute.ResourceSKURestrictionsTypeZone ||
+			restriction.RestrictionInfo == nil {
+			continue
+		}
+		for _, zone := range restriction.RestrictionInfo.Zones {
+			if zone != nil {
+				restricted[*zone] = true
+			}
+		}
+	}
+
+	zones := []string{}
+	for _, info := range sku.LocationInfo {
+		if info == nil || info.Location == nil || !strings.EqualFold(*info.Location, region) {
+			continue
+		}
+		for _, zone := range info.Zones {
+			if zone != nil && !restricted[*zone] {
+				zones = append(zones, *zone)
+			}
+		}
+	}
+	slices.Sort(zones)
+
+	return zones
+}
+
+// validatePlacementOptions checks the requested zone against the zones offered for the size.
+// An "auto" zone is always valid, as it falls back to a regional VM when the size has no zones.
+func validatePlacementOptions(opts *templateOptions, zones []string) error {
+	if opts.Zone == "" || opts.Zone == ZoneAuto {
+		return nil
+	}
+
+	if len(zones) == 0 {
+		return fmt.Errorf("VM size is not available in any availability zone, cannot pin to zone %s", opts.Zone)
+	}
+	if !slices.Contains(zones, opts.Zone) {
+		return fmt.Errorf("VM size is not available in zone %s, available zones are [%s]", opts.Zone, strings.Join(zones, ", "))
+	}
+
+	return nil
+}
+
+// leastUsedZone returns the zone with the fewest VMs, preferring the lowest zone on a tie
+func leastUsedZone(zones []string, usage map[string]int) string {
+	selected := ""
+	for _, zone := range zones {
+		if selected == "" || usage[zone] < usage[selected] {
+			selected = zone
+		}
+	}
+
+	return selected
+}
+
+// selectZone returns the zone to create a VM in, or an empty string for a regional VM.
+// Pinned zones are returned as is. For "auto", the VMs of the resource group in the same spread group
+// are counted per zone and the least used zone offered for the size is chosen.
+func (vmm *AzureVirtualMachineManager) selectZone(ctx context.Context, template *models.VirtualMachineTemplate) (string, error) {
+	log := logging.GetLogger(ctx)
+
+	opts, err := parseTemplateOptions(template)
+	if err != nil {
+		return "", err
+	}
+
+	if opts.Zone != ZoneAuto {
+		return opts.Zone, nil
+	}
+
+	sku, err := vmm.getResourceSKU(ctx, template.Size.ID)
+	if err != nil {
+		return "", err
+	}
+
+	zones := skuZones(sku, vmm.Credentials.Region)
+	if len(zones) == 0 {
+		log.InfoContext(ctx, "VM size has no availability zones in region, creating a regional VM", "size", template.Size.ID)
+		return "", nil
+	}
+
+	usage, err := vmm.zoneUsage(ctx, opts.ZoneSpreadGroup)
+	if err != nil {
+		return "", err
+	}
+
+	zone := leastUsedZone(zones, usage)
+	log.DebugContext(ctx, "Selected availability zone", "zone", zone, "spreadGroup", opts.ZoneSpreadGroup, "usage", usage)
+
+	return zone, nil
+}
+
+// zoneUsage counts the zonal VMs of the resource group in a spread group, by zone
+func (vmm *AzureVirtualMachineManager) zoneUsage(ctx context.Context, spreadGroup string) (map[string]int, error) {
+	usage := map[string]int{}
+
+	pager := vmm.vmClient.NewListPager(vmm.Credentials.ResourceGroup, nil)
+	for pager.More() {
+		resp, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "Zone Usage")
+		}
+
+		for _, azVM := range resp.Value {
+			if !strings.EqualFold(tagValue(azVM.Tags, ZoneSpreadGroupTagKey), spreadGroup) {
+				continue
+			}
+			for _, zone := range azVM.Zones {
+				if zone != nil {
+					usage[*zone]++
+				}
+			}
+		}
+	}
+
+	return usage, nil
+}
+
+// applyPlacementOptions sets the zone and proximity placement group on VM create parameters
+func applyPlacementOptions(azVM *armcompute.VirtualMachine, zone string, opts *templateOptions) {
+	if zone != "" {
+		azVM.Zones = []*string{to.Ptr(zone)}
+	}
+
+	if opts.ProximityPlacementGroupID != "" {
+		azVM.Properties.ProximityPlacementGroup = &armcompute.SubResource{
+			ID: to.Ptr(opts.ProximityPlacementGroupID),
+		}
+	}
+}
+
+// readPlacement reports the zone of an Azure VM as the location ID, and the zone and proximity placement group in the tags.
+// A regional VM has no zone, and any "auto" zone tag copied from the template is removed.
+func readPlacement(azVM *armcompute.VirtualMachine, location *models.VirtualMachineLocation, tags map[string]*string) {
+	zone := ""
+	if len(azVM.Zones) > 0 && azVM.Zones[0] != nil {
+		zone = *azVM.Zones[0]
+	}
+
+	location.ID = zone
+	if zone != "" {
+		setTag(tags, ZoneTagKey, zone)
+	} else {
+		deleteTag(tags, ZoneTagKey)
+	}
+
+	if azVM.Properties != nil && azVM.Properties.ProximityPlacementGroup != nil && azVM.Properties.ProximityPlacementGroup.ID != nil {
+		setTag(tags, ProximityPlacementGroupTagKey, *azVM.Properties.ProximityPlacementGroup.ID)
+	}
+}
+
+// tagValue returns the value of a tag, matching the key case-insensitively
+func tagValue(tags map[string]*string, key string) string {
+	for k, v := range tags {
+		if strings.EqualFold(k, key) && v != nil {
+			return *v
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,68 @@
+package vm
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/appliedres/cloudy/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSkuZones(t *testing.T) {
+	sku := &armcompute.ResourceSKU{
+		LocationInfo: []*armcompute.ResourceSKULocationInfo{
+			{Location: to.Ptr("USGovVirginia"), Zones: []*string{to.Ptr("3"), to.Ptr("1"), to.Ptr("2")}},
+			{Location: to.Ptr("usgovtexas"), Zones: []*string{to.Ptr("1")}},
+		},
+		Restrictions: []*armcompute.ResourceSKURestrictions{
+			{
+				Type:            to.Ptr(armcompute.ResourceSKURestrictionsTypeZone),
+				RestrictionInfo: &armcompute.ResourceSKURestrictionInfo{Zones: []*string{to.Ptr("2")}},
+			},
+		},
+	}
+
+	assert.Equal(t, []string{"1", "3"}, skuZones(sku, "usgovvirginia"))
+	assert.Empty(t, skuZones(sku, "usgovarizona"))
+	assert.Empty(t, skuZones(nil, "usgovvirginia"))
+}
+
+func TestValidatePlacementOptions(t *testing.T) {
+	zones := []string{"1", "2", "3"}
+
+	assert.NoError(t, validatePlacementOptions(&templateOptions{}, nil))
+	assert.NoError(t, validatePlacementOptions(&templateOptions{Zone: ZoneAuto}, nil))
+	assert.NoError(t, validatePlacementOptions(&templateOptions{Zone: "2"}, zones))
+	assert.Error(t, validatePlacementOptions(&templateOptions{Zone: "4"}, zones))
+	assert.Error(t, validatePlacementOptions(&templateOptions{Zone: "1"}, nil))
+}
+
+func TestLeastUsedZone(t *testing.T) {
+	zones := []string{"1", "2", "3"}
+
+	assert.Equal(t, "1", leastUsedZone(zones, map[string]int{}))
+	assert.Equal(t, "2", leastUsedZone(zones, map[string]int{"1": 2, "2": 1, "3": 1}))
+	assert.Equal(t, "3", leastUsedZone(zones, map[string]int{"1": 1, "2": 1}))
+	assert.Equal(t, "", leastUsedZone(nil, map[string]int{"1": 1}))
+}
+
+func TestPlacementRoundTrip(t *testing.T) {
+	azVM := &armcompute.VirtualMachine{Properties: &armcompute.VirtualMachineProperties{}}
+	applyPlacementOptions(azVM, "2", &templateOptions{ProximityPlacementGroupID: "ppg"})
+
+	location := &models.VirtualMachineLocation{}
+	tags := map[string]*string{"zone": to.Ptr(ZoneAuto)}
+	readPlacement(azVM, location, tags)
+
+	assert.Equal(t, "2", location.ID)
+	assert.Equal(t, map[string]*string{
+		ZoneTagKey:                    to.Ptr("2"),
+		ProximityPlacementGroupTagKey: to.Ptr("ppg"),
+	}, tags)
+
+	regional := &armcompute.VirtualMachine{}
+	readPlacement(regional, location, tags)
+	assert.Equal(t, "", location.ID)
+	assert.Equal(t, "", tagValue(tags, ZoneTagKey))
+}
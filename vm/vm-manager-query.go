@@ -0,0 +1,314 @@
+package vm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resourcegraph/armresourcegraph"
+	"github.com/appliedres/cloudy/logging"
+	"github.com/appliedres/cloudy/models"
+	"github.com/pkg/errors"
+)
+
+// rows per Resource Graph page, the maximum allowed
+const resourceGraphPageSize = 1000
+
+// VirtualMachineQuery filters VMs. Empty fields match everything, values within a field are OR'ed.
+type VirtualMachineQuery struct {
+	Prefix         string            // VM name prefix, e.g. "uvm-"
+	Tags           map[string]string // tags the VM must have. Tag keys are case-sensitive in Resource Graph
+	PowerStates    []string          // Azure power states without the "PowerState/" prefix, e.g. "running", "deallocated"
+	Sizes          []string          // VM sizes, e.g. "Standard_D2s_v4"
+	ResourceGroups []string
+	Subscriptions  []string // defaults to the configured Resource Graph subscriptions, or the manager's subscription
+
+	IncludeState bool // report the cloud state of each VM
+}
+
+// QueryVirtualMachines returns the VMs matching a query. If Resource Graph is enabled the query runs as a single
+// Resource Graph query across all subscriptions, otherwise, or if the Resource Graph query fails,
+// the VMs of the manager's subscription are listed through the compute API and filtered.
+func (vmm *AzureVirtualMachineManager) QueryVirtualMachines(ctx context.Context, query VirtualMachineQuery) (*[]models.VirtualMachine, error) {
+	log := logging.GetLogger(ctx)
+
+	if vmm.Config.UseResourceGraph {
+		vms, err := vmm.queryResourceGraph(ctx, query)
+		if err == nil {
+			return vms, nil
+		}
+
+		log.WarnContext(ctx, "Resource Graph query failed, falling back to the compute API", "err", err)
+	}
+
+	return vmm.queryComputeAPI(ctx, query)
+}
+
+func (vmm *AzureVirtualMachineManager) queryResourceGraph(ctx context.Context, query VirtualMachineQuery) (*[]models.VirtualMachine, error) {
+	log := logging.GetLogger(ctx)
+
+	subscriptions := query.Subscriptions
+	if len(subscriptions) == 0 {
+		subscriptions = vmm.Config.ResourceGraphSubscriptions
+	}
+	if len(subscriptions) == 0 {
+		subscriptions = []string{vmm.Credentials.SubscriptionID}
+	}
+
+	kql := buildResourceGraphQuery(query)
+	log.DebugContext(ctx, "Resource Graph query", "query", kql, "subscriptions", subscriptions)
+
+	request := armresourcegraph.QueryRequest{
+		Query:         to.Ptr(kql),
+		Subscriptions: to.SliceOfPtrs(subscriptions...),
+		Options: &armresourcegraph.QueryRequestOptions{
+			ResultFormat: to.Ptr(armresourcegraph.ResultFormatObjectArray),
+			Top:          to.Ptr(int32(resourceGraphPageSize)),
+		},
+	}
+
+	vmList := []models.VirtualMachine{}
+	for {
+		resp, err := vmm.resourceGraphClient.Resources(ctx, request, nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "Resource Graph Query")
+		}
+
+		rows, ok := resp.Data.([]any)
+		if !ok {
+			return nil, fmt.Errorf("unexpected Resource Graph result format %T", resp.Data)
+		}
+
+		for _, row := range rows {
+			vm, err := resourceGraphRowToVirtualMachine(ctx, row, query.IncludeState)
+			if err != nil {
+				return nil, err
+			}
+			vmList = append(vmList, *vm)
+		}
+
+		if resp.SkipToken == nil || *resp.SkipToken == "" {
+			break
+		}
+		request.Options.SkipToken = resp.SkipToken
+	}
+
+	log.DebugContext(ctx, "Resource Graph query complete", "count", len(vmList))
+	return &vmList, nil
+}
+
+// buildResourceGraphQuery translates a query to KQL
+func buildResourceGraphQuery(query VirtualMachineQuery) string {
+	clauses := []string{
+		"Resources",
+		"where type =~ 'microsoft.compute/virtualmachines'",
+	}
+
+	if query.Prefix != "" {
+		clauses = append(clauses, fmt.Sprintf("where name startswith %s", kqlString(query.Prefix)))
+	}
+	if len(query.ResourceGroups) > 0 {
+		clauses = append(clauses, fmt.Sprintf("where resourceGroup in~ (%s)", kqlList(query.ResourceGroups)))
+	}
+	if len(query.Sizes) > 0 {
+		clauses = append(clauses, fmt.Sprintf("where tostring(properties.hardwareProfile.vmSize) in~ (%s)", kqlList(query.Sizes)))
+	}
+
+	tagKeys := make([]string, 0, len(query.Tags))
+	for key := range query.Tags {
+		tagKeys = append(tagKeys, key)
+	}
+	slices.Sort(tagKeys)
+	for _, key := range tagKeys {
+		clauses = append(clauses, fmt.Sprintf("where tostring(tags[%s]) == %s", kqlString(key), kqlString(query.Tags[key])))
+	}
+
+	clauses = append(clauses, "extend powerState = tostring(properties.extended.instanceView.powerState.code)")
+	if len(query.PowerStates) > 0 {
+		codes := make([]string, 0, len(query.PowerStates))
+		for _, state := range query.PowerStates {
+			codes = append(codes, "PowerState/"+strings.TrimPrefix(state, "PowerState/"))
+		}
+		clauses = append(clauses, fmt.Sprintf("where powerState in~ (%s)", kqlList(codes)))
+	}
+
+	clauses = append(clauses, "project id, name, location, subscriptionId, tags, zones, properties, powerState")
+
+	return strings.Join(clauses, "\n| ")
+}
+
+// kqlString quotes a string literal for KQL
+func kqlString(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `'`, `\'`)
+	return "'" + value + "'"
+}
+
+func kqlList(values []string) string {
+	quoted := make([]string, 0, len(values))
+	for _, value := range values {
+		quoted = append(quoted, kqlString(value))
+	}
+
+	return strings.Join(quoted, ", ")
+}
+
+// resourceGraphRowToVirtualMachine maps a Resource Graph row to a cloudy VM.
+// The row has the same shape as the compute API's VM resource, so it is decoded as one,
+// with the power state added to the instance view where ToCloudyVirtualMachine expects it.
+func resourceGraphRowToVirtualMachine(ctx context.Context, row any, includeState bool) (*models.VirtualMachine, error) {
+	data, err := json.Marshal(row)
+	if err != nil {
+		return nil, errors.Wrap(err, "Resource Graph row")
+	}
+
+	var azVM armcompute.VirtualMachine
+	if err := json.Unmarshal(data, &azVM); err != nil {
+		return nil, errors.Wrap(err, "Resource Graph row")
+	}
+
+	var extra struct {
+		SubscriptionID string `json:"subscriptionId"`
+		PowerState     string `json:"powerState"`
+	}
+	if err := json.Unmarshal(data, &extra); err != nil {
+		return nil, errors.Wrap(err, "Resource Graph row")
+	}
+
+	if azVM.Name == nil || azVM.Location == nil {
+		return nil, fmt.Errorf("Resource Graph row is missing the VM name or location")
+	}
+
+	if azVM.Properties != nil {
+		azVM.Properties.InstanceView = nil
+		if includeState && extra.PowerState != "" && azVM.Properties.ProvisioningState != nil {
+			azVM.Properties.InstanceView = &armcompute.VirtualMachineInstanceView{
+				Statuses: []*armcompute.InstanceViewStatus{{Code: to.Ptr(extra.PowerState)}},
+			}
+		}
+	}
+
+	vm := ToCloudyVirtualMachine(ctx, &azVM)
+	vm.Location.Subscription = extra.SubscriptionID
+
+	return vm, nil
+}
+
+// queryComputeAPI lists the VMs of the manager's subscription and filters them in memory
+func (vmm *AzureVirtualMachineManager) queryComputeAPI(ctx context.Context, query VirtualMachineQuery) (*[]models.VirtualMachine, error) {
+	log := logging.GetLogger(ctx)
+
+	if len(query.Subscriptions) > 0 && !slices.Contains(query.Subscriptions, vmm.Credentials.SubscriptionID) {
+		log.WarnContext(ctx, "The compute API can only query the manager's subscription", "subscriptions", query.Subscriptions)
+		return &[]models.VirtualMachine{}, nil
+	}
+
+	// the power state is needed to filter on it
+	statusOnly := query.IncludeState || len(query.PowerStates) > 0
+
+	vmList := []models.VirtualMachine{}
+	pager := vmm.vmClient.NewListAllPager(&armcompute.VirtualMachinesClientListAllOptions{
+		StatusOnly: to.Ptr(fmt.Sprint(statusOnly)),
+	})
+	for pager.More() {
+		resp, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "Query Virtual Machines")
+		}
+
+		for _, azVM := range resp.Value {
+			if !matchesQuery(azVM, query) {
+				continue
+			}
+
+			vm := ToCloudyVirtualMachine(ctx, azVM)
+			vm.Location.Subscription = vmm.Credentials.SubscriptionID
+			if !query.IncludeState {
+				vm.CloudState = nil
+			}
+			vmList = append(vmList, *vm)
+		}
+	}
+
+	return &vmList, nil
+}
+
+// matchesQuery applies a query to a VM from the compute API, matching the semantics of the KQL query
+func matchesQuery(azVM *armcompute.VirtualMachine, query VirtualMachineQuery) bool {
+	if azVM.Name == nil || !strings.HasPrefix(*azVM.Name, query.Prefix) {
+		return false
+	}
+
+	if len(query.ResourceGroups) > 0 {
+		rg := ""
+		if azVM.ID != nil {
+			rg = resourceGroupFromID(*azVM.ID)
+		}
+		if !containsFold(query.ResourceGroups, rg) {
+			return false
+		}
+	}
+
+	if len(query.Sizes) > 0 {
+		size := ""
+		if azVM.Properties != nil && azVM.Properties.HardwareProfile != nil && azVM.Properties.HardwareProfile.VMSize != nil {
+			size = string(*azVM.Properties.HardwareProfile.VMSize)
+		}
+		// a VM without a hardware profile can't be ruled out
+		if size != "" && !containsFold(query.Sizes, size) {
+			return false
+		}
+	}
+
+	for key, value := range query.Tags {
+		tag, ok := azVM.Tags[key]
+		if !ok || tag == nil || *tag != value {
+			return false
+		}
+	}
+
+	if len(query.PowerStates) > 0 {
+		powerState := ""
+		if azVM.Properties != nil && azVM.Properties.InstanceView != nil {
+			for _, status := range azVM.Properties.InstanceView.Statuses {
+				if status.Code != nil && strings.HasPrefix(*status.Code, "PowerState/") {
+					powerState = strings.TrimPrefix(*status.Code, "PowerState/")
+				}
+			}
+		}
+
+		matched := false
+		for _, state := range query.PowerStates {
+			if strings.EqualFold(strings.TrimPrefix(state, "PowerState/"), powerState) {
+				matched = true
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+// resourceGroupFromID returns the resource group segment of a resource ID
+func resourceGroupFromID(id string) string {
+	parts := strings.Split(id, "/")
+	for i := 0; i < len(parts)-1; i++ {
+		if strings.EqualFold(parts[i], "resourceGroups") {
+			return parts[i+1]
+		}
+	}
+
+	return ""
+}
+
+func containsFold(values []string, value string) bool {
+	return slices.ContainsFunc(values, func(v string) bool {
+		return strings.EqualFold(v, value)
+	})
+}
@@ -0,0 +1,93 @@
+package vm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/appliedres/cloudy/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildResourceGraphQuery(t *testing.T) {
+	query := buildResourceGraphQuery(VirtualMachineQuery{
+		Prefix:         "uvm-",
+		Tags:           map[string]string{"TeamID": "team's"},
+		PowerStates:    []string{"running", "PowerState/deallocated"},
+		Sizes:          []string{"Standard_D2s_v4"},
+		ResourceGroups: []string{"rg-1", "rg-2"},
+	})
+
+	assert.Equal(t, `Resources
+| where type =~ 'microsoft.compute/virtualmachines'
+| where name startswith 'uvm-'
+| where resourceGroup in~ ('rg-1', 'rg-2')
+| where tostring(properties.hardwareProfile.vmSize) in~ ('Standard_D2s_v4')
+| where tostring(tags['TeamID']) == 'team\'s'
+| extend powerState = tostring(properties.extended.instanceView.powerState.code)
+| where powerState in~ ('PowerState/running', 'PowerState/deallocated')
+| project id, name, location, subscriptionId, tags, zones, properties, powerState`, query)
+}
+
+func TestResourceGraphRowToVirtualMachine(t *testing.T) {
+	row := map[string]any{
+		"id":             "/subscriptions/sub-1/resourceGroups/rg-1/providers/Microsoft.Compute/virtualMachines/uvm-1",
+		"name":           "uvm-1",
+		"location":       "usgovvirginia",
+		"subscriptionId": "sub-1",
+		"zones":          []any{"2"},
+		"tags":           map[string]any{"Name": "My VM", "UserID": "user-1"},
+		"powerState":     "PowerState/running",
+		"properties": map[string]any{
+			"provisioningState": "Succeeded",
+			"hardwareProfile":   map[string]any{"vmSize": "Standard_D2s_v4"},
+			"storageProfile":    map[string]any{"osDisk": map[string]any{"osType": "Windows"}},
+		},
+	}
+
+	vm, err := resourceGraphRowToVirtualMachine(context.Background(), row, true)
+	assert.NoError(t, err)
+	assert.Equal(t, "uvm-1", vm.ID)
+	assert.Equal(t, "My VM", vm.Name)
+	assert.Equal(t, "user-1", vm.UserID)
+	assert.Equal(t, "sub-1", vm.Location.Subscription)
+	assert.Equal(t, "2", vm.Location.ID)
+	assert.Equal(t, "Windows", vm.Template.OperatingSystem)
+	assert.Equal(t, models.VirtualMachineCloudStateRunning, *vm.CloudState)
+
+	vm, err = resourceGraphRowToVirtualMachine(context.Background(), row, false)
+	assert.NoError(t, err)
+	assert.Nil(t, vm.CloudState)
+}
+
+func TestMatchesQuery(t *testing.T) {
+	azVM := &armcompute.VirtualMachine{
+		ID:   to.Ptr("/subscriptions/sub-1/resourceGroups/RG-1/providers/Microsoft.Compute/virtualMachines/uvm-1"),
+		Name: to.Ptr("uvm-1"),
+		Tags: map[string]*string{"TeamID": to.Ptr("team-a")},
+		Properties: &armcompute.VirtualMachineProperties{
+			HardwareProfile: &armcompute.HardwareProfile{VMSize: to.Ptr(armcompute.VirtualMachineSizeTypesStandardD2SV3)},
+			InstanceView: &armcompute.VirtualMachineInstanceView{
+				Statuses: []*armcompute.InstanceViewStatus{
+					{Code: to.Ptr("ProvisioningState/succeeded")},
+					{Code: to.Ptr("PowerState/deallocated")},
+				},
+			},
+		},
+	}
+
+	assert.True(t, matchesQuery(azVM, VirtualMachineQuery{}))
+	assert.True(t, matchesQuery(azVM, VirtualMachineQuery{
+		Prefix:         "uvm-",
+		ResourceGroups: []string{"rg-1"},
+		Sizes:          []string{"standard_d2s_v3"},
+		Tags:           map[string]string{"TeamID": "team-a"},
+		PowerStates:    []string{"deallocated"},
+	}))
+	assert.False(t, matchesQuery(azVM, VirtualMachineQuery{Prefix: "shvm-"}))
+	assert.False(t, matchesQuery(azVM, VirtualMachineQuery{ResourceGroups: []string{"rg-2"}}))
+	assert.False(t, matchesQuery(azVM, VirtualMachineQuery{Sizes: []string{"Standard_D4s_v3"}}))
+	assert.False(t, matchesQuery(azVM, VirtualMachineQuery{Tags: map[string]string{"TeamID": "team-b"}}))
+	assert.False(t, matchesQuery(azVM, VirtualMachineQuery{PowerStates: []string{"running"}}))
+}
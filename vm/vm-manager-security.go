@@ -0,0 +1,194 @@
+package vm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/appliedres/cloudy/models"
+)
+
+// templateSecurityType returns the security type requested by the template, None if not specified
+func templateSecurityType(template *models.VirtualMachineTemplate) models.VirtualMachineSecurityTypes {
+	if template == nil || template.SecurityProfile == nil || template.SecurityProfile.SecurityTypes == "" {
+		return models.VirtualMachineSecurityTypesNone
+	}
+
+	return template.SecurityProfile.SecurityTypes
+}
+
+// validateSecurityOptions checks that the requested security settings can be combined.
+// If SKU capabilities are given, the settings are also checked against what the size supports.
+func validateSecurityOptions(securityType models.VirtualMachineSecurityTypes, opts *templateOptions, caps skuCapabilities) error {
+	trustedLaunch := securityType == models.VirtualMachineSecurityTypesTrustedLaunch
+	confidential := securityType == models.VirtualMachineSecurityTypesConfidentialVM
+
+	if !trustedLaunch && !confidential {
+		if (opts.SecureBoot != nil && *opts.SecureBoot) || (opts.VTpm != nil && *opts.VTpm) {
+			return fmt.Errorf("secure boot and vTPM require the TrustedLaunch or ConfidentialVM security type")
+		}
+	}
+
+	if opts.ConfidentialDiskEncryption != "" {
+		if !confidential {
+			return fmt.Errorf("confidential OS disk encryption requires the ConfidentialVM security type")
+		}
+
+		switch armcompute.SecurityEncryptionTypes(opts.ConfidentialDiskEncryption) {
+		case armcompute.SecurityEncryptionTypesVMGuestStateOnly, armcompute.SecurityEncryptionTypesDiskWithVMGuestState:
+		default:
+			return fmt.Errorf("unsupported confidential OS disk encryption [%s], must be %s or %s", opts.ConfidentialDiskEncryption,
+				armcompute.SecurityEncryptionTypesVMGuestStateOnly, armcompute.SecurityEncryptionTypesDiskWithVMGuestState)
+		}
+	}
+
+	if opts.ConfidentialDiskEncryptionSetID != "" {
+		if armcompute.SecurityEncryptionTypes(opts.ConfidentialDiskEncryption) != armcompute.SecurityEncryptionTypesDiskWithVMGuestState {
+			return fmt.Errorf("a confidential disk encryption set requires %s confidential OS disk encryption", armcompute.SecurityEncryptionTypesDiskWithVMGuestState)
+		}
+		if opts.DiskEncryptionSetID != "" {
+			return fmt.Errorf("a disk encryption set and a confidential disk encryption set cannot both be used on the OS disk")
+		}
+	}
+
+	if confidential {
+		if opts.VTpm != nil && !*opts.VTpm {
+			return fmt.Errorf("confidential VMs require vTPM")
+		}
+		if opts.EncryptionAtHost {
+			return fmt.Errorf("encryption at host is not supported for confidential VMs")
+		}
+	}
+
+	if caps == nil {
+		return nil
+	}
+
+	if trustedLaunch {
+		if caps.isTrue("TrustedLaunchDisabled") {
+			return fmt.Errorf("VM size does not support Trusted Launch")
+		}
+		if !caps.contains("HyperVGenerations", "V2") {
+			return fmt.Errorf("VM size does not support generation 2 VMs, required for Trusted Launch")
+		}
+	}
+
+	if confidential && caps["ConfidentialComputingType"] == "" {
+		return fmt.Errorf("VM size does not support Confidential VMs")
+	}
+
+	if opts.EncryptionAtHost && !caps.isTrue("EncryptionAtHostSupported") {
+		return fmt.Errorf("VM size does not support encryption at host")
+	}
+
+	return nil
+}
+
+// applySecurityOptions configures UEFI settings, encryption at host and OS disk encryption on VM create parameters.
+// The options must already be validated.
+func applySecurityOptions(azVM *armcompute.VirtualMachine, securityType models.VirtualMachineSecurityTypes, opts *templateOptions) {
+	props := azVM.Properties
+
+	if securityType == models.VirtualMachineSecurityTypesTrustedLaunch || securityType == models.VirtualMachineSecurityTypesConfidentialVM {
+		if props.SecurityProfile == nil {
+			props.SecurityProfile = &armcompute.SecurityProfile{}
+		}
+
+		// secure boot and vTPM are on by default, and can be turned off per template
+		props.SecurityProfile.UefiSettings = &armcompute.UefiSettings{
+			SecureBootEnabled: to.Ptr(opts.SecureBoot == nil || *opts.SecureBoot),
+			VTpmEnabled:       to.Ptr(opts.VTpm == nil || *opts.VTpm),
+		}
+	}
+
+	if opts.EncryptionAtHost {
+		if props.SecurityProfile == nil {
+			props.SecurityProfile = &armcompute.SecurityProfile{}
+		}
+		props.SecurityProfile.EncryptionAtHost = to.Ptr(true)
+	}
+
+	if opts.DiskEncryptionSetID == "" && opts.ConfidentialDiskEncryption == "" {
+		return
+	}
+
+	osDisk := props.StorageProfile.OSDisk
+	if osDisk.ManagedDisk == nil {
+		osDisk.ManagedDisk = &armcompute.ManagedDiskParameters{}
+	}
+
+	if opts.DiskEncryptionSetID != "" {
+		osDisk.ManagedDisk.DiskEncryptionSet = &armcompute.DiskEncryptionSetParameters{
+			ID: to.Ptr(opts.DiskEncryptionSetID),
+		}
+	}
+
+	if opts.ConfidentialDiskEncryption != "" {
+		osDisk.ManagedDisk.SecurityProfile = &armcompute.VMDiskSecurityProfile{
+			SecurityEncryptionType: to.Ptr(armcompute.SecurityEncryptionTypes(opts.ConfidentialDiskEncryption)),
+		}
+		if opts.ConfidentialDiskEncryptionSetID != "" {
+			osDisk.ManagedDisk.SecurityProfile.DiskEncryptionSet = &armcompute.DiskEncryptionSetParameters{
+				ID: to.Ptr(opts.ConfidentialDiskEncryptionSetID),
+			}
+		}
+	}
+}
+
+// readSecurityOptions reports the security settings of an Azure VM in the cloudy VM tags, using the template tag keys.
+// The cloudy model only carries the security type, so this is the only place the settings can be surfaced.
+func readSecurityOptions(azVM *armcompute.VirtualMachine, tags map[string]*string) {
+	if azVM.Properties == nil {
+		return
+	}
+
+	if sp := azVM.Properties.SecurityProfile; sp != nil {
+		if sp.UefiSettings != nil {
+			if sp.UefiSettings.SecureBootEnabled != nil {
+				setTag(tags, SecureBootTagKey, strconv.FormatBool(*sp.UefiSettings.SecureBootEnabled))
+			}
+			if sp.UefiSettings.VTpmEnabled != nil {
+				setTag(tags, VTpmTagKey, strconv.FormatBool(*sp.UefiSettings.VTpmEnabled))
+			}
+		}
+		if sp.EncryptionAtHost != nil {
+			setTag(tags, EncryptionAtHostTagKey, strconv.FormatBool(*sp.EncryptionAtHost))
+		}
+	}
+
+	if azVM.Properties.StorageProfile == nil || azVM.Properties.StorageProfile.OSDisk == nil ||
+		azVM.Properties.StorageProfile.OSDisk.ManagedDisk == nil {
+		return
+	}
+	managedDisk := azVM.Properties.StorageProfile.OSDisk.ManagedDisk
+
+	if managedDisk.DiskEncryptionSet != nil && managedDisk.DiskEncryptionSet.ID != nil {
+		setTag(tags, DiskEncryptionSetTagKey, *managedDisk.DiskEncryptionSet.ID)
+	}
+
+	if managedDisk.SecurityProfile != nil {
+		if managedDisk.SecurityProfile.SecurityEncryptionType != nil {
+			setTag(tags, ConfidentialDiskEncryptionTagKey, string(*managedDisk.SecurityProfile.SecurityEncryptionType))
+		}
+		if managedDisk.SecurityProfile.DiskEncryptionSet != nil && managedDisk.SecurityProfile.DiskEncryptionSet.ID != nil {
+			setTag(tags, ConfidentialDiskEncryptionSetTagKey, *managedDisk.SecurityProfile.DiskEncryptionSet.ID)
+		}
+	}
+}
+
+// setTag sets a tag, replacing any existing tag with the same key in a different case
+func setTag(tags map[string]*string, key, value string) {
+	deleteTag(tags, key)
+	tags[key] = to.Ptr(value)
+}
+
+// deleteTag removes a tag, matching the key case-insensitively
+func deleteTag(tags map[string]*string, key string) {
+	for k := range tags {
+		if strings.EqualFold(k, key) {
+			delete(tags, k)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package vm
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/appliedres/cloudy/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateSecurityOptions(t *testing.T) {
+	gen2 := skuCapabilities{"HyperVGenerations": "V1,V2", "EncryptionAtHostSupported": "True"}
+	gen1 := skuCapabilities{"HyperVGenerations": "V1"}
+	cvm := skuCapabilities{"HyperVGenerations": "V2", "ConfidentialComputingType": "SNP"}
+
+	tests := []struct {
+		name         string
+		securityType models.VirtualMachineSecurityTypes
+		opts         templateOptions
+		caps         skuCapabilities
+		expectError  bool
+	}{
+		{"standard VM", models.VirtualMachineSecurityTypesNone, templateOptions{}, gen1, false},
+		{"secure boot without trusted launch", models.VirtualMachineSecurityTypesNone, templateOptions{SecureBoot: to.Ptr(true)}, nil, true},
+		{"trusted launch on gen2 size", models.VirtualMachineSecurityTypesTrustedLaunch, templateOptions{EncryptionAtHost: true}, gen2, false},
+		{"trusted launch on gen1 size", models.VirtualMachineSecurityTypesTrustedLaunch, templateOptions{}, gen1, true},
+		{"trusted launch disabled for size", models.VirtualMachineSecurityTypesTrustedLaunch, templateOptions{}, skuCapabilities{"HyperVGenerations": "V2", "TrustedLaunchDisabled": "True"}, true},
+		{"encryption at host unsupported", models.VirtualMachineSecurityTypesNone, templateOptions{EncryptionAtHost: true}, gen1, true},
+		{"confidential VM", models.VirtualMachineSecurityTypesConfidentialVM, templateOptions{ConfidentialDiskEncryption: "DiskWithVMGuestState"}, cvm, false},
+		{"confidential VM on standard size", models.VirtualMachineSecurityTypesConfidentialVM, templateOptions{}, gen2, true},
+		{"confidential VM without vTPM", models.VirtualMachineSecurityTypesConfidentialVM, templateOptions{VTpm: to.Ptr(false)}, nil, true},
+		{"confidential VM with encryption at host", models.VirtualMachineSecurityTypesConfidentialVM, templateOptions{EncryptionAtHost: true}, nil, true},
+		{"confidential disk encryption on trusted launch", models.VirtualMachineSecurityTypesTrustedLaunch, templateOptions{ConfidentialDiskEncryption: "VMGuestStateOnly"}, nil, true},
+		{"unknown confidential disk encryption", models.VirtualMachineSecurityTypesConfidentialVM, templateOptions{ConfidentialDiskEncryption: "Everything"}, nil, true},
+		{"confidential DES without disk encryption", models.VirtualMachineSecurityTypesConfidentialVM, templateOptions{ConfidentialDiskEncryption: "VMGuestStateOnly", ConfidentialDiskEncryptionSetID: "des"}, nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateSecurityOptions(test.securityType, &test.opts, test.caps)
+			if test.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestSecurityOptionsRoundTrip(t *testing.T) {
+	azVM := &armcompute.VirtualMachine{
+		Properties: &armcompute.VirtualMachineProperties{
+			SecurityProfile: &armcompute.SecurityProfile{SecurityType: to.Ptr(armcompute.SecurityTypesConfidentialVM)},
+			StorageProfile:  &armcompute.StorageProfile{OSDisk: &armcompute.OSDisk{}},
+		},
+	}
+
+	opts := &templateOptions{
+		SecureBoot:                      to.Ptr(false),
+		ConfidentialDiskEncryption:      "DiskWithVMGuestState",
+		ConfidentialDiskEncryptionSetID: "/des/confidential",
+	}
+	applySecurityOptions(azVM, models.VirtualMachineSecurityTypesConfidentialVM, opts)
+
+	assert.False(t, *azVM.Properties.SecurityProfile.UefiSettings.SecureBootEnabled)
+	assert.True(t, *azVM.Properties.SecurityProfile.UefiSettings.VTpmEnabled)
+	assert.Nil(t, azVM.Properties.SecurityProfile.EncryptionAtHost)
+
+	tags := map[string]*string{"secureboot": to.Ptr("true")}
+	readSecurityOptions(azVM, tags)
+
+	assert.NotContains(t, tags, "secureboot")
+	assert.Equal(t, "false", *tags[SecureBootTagKey])
+	assert.Equal(t, "true", *tags[VTpmTagKey])
+	assert.Equal(t, "DiskWithVMGuestState", *tags[ConfidentialDiskEncryptionTagKey])
+	assert.Equal(t, "/des/confidential", *tags[ConfidentialDiskEncryptionSetTagKey])
+}
+
+func TestParseTemplateOptions(t *testing.T) {
+	template := &models.VirtualMachineTemplate{
+		Tags: map[string]*string{
+			"encryptionathost": to.Ptr("true"),
+			"VTpm":             to.Ptr("false"),
+			"Department":       to.Ptr("ops"),
+		},
+	}
+
+	opts, err := parseTemplateOptions(template)
+	assert.NoError(t, err)
+	assert.True(t, opts.EncryptionAtHost)
+	assert.False(t, *opts.VTpm)
+	assert.Nil(t, opts.SecureBoot)
+
+	template.Tags[SecureBootTagKey] = to.Ptr("sometimes")
+	_, err = parseTemplateOptions(template)
+	assert.Error(t, err)
+}
@@ -0,0 +1,103 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/appliedres/cloudy/logging"
+	"github.com/pkg/errors"
+)
+
+// listing SKUs for a region is slow, and the catalog rarely changes
+const skuCacheTTL = 1 * time.Hour
+
+// skuCache holds the VM SKUs of the manager's region, keyed by lower-cased size name
+type skuCache struct {
+	mu       sync.Mutex
+	loadedAt time.Time
+	skus     map[string]*armcompute.ResourceSKU
+}
+
+// getResourceSKU returns the SKU of a VM size in the manager's region, or an error if the size is not offered there.
+func (vmm *AzureVirtualMachineManager) getResourceSKU(ctx context.Context, sizeName string) (*armcompute.ResourceSKU, error) {
+	log := logging.GetLogger(ctx)
+
+	cache := vmm.skuCache
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if cache.skus == nil || time.Since(cache.loadedAt) > skuCacheTTL {
+		log.DebugContext(ctx, "Loading VM SKUs", "region", vmm.Credentials.Region)
+
+		skus := map[string]*armcompute.ResourceSKU{}
+		pager := vmm.sizesClient.NewListPager(&armcompute.ResourceSKUsClientListOptions{
+			Filter: to.Ptr(fmt.Sprintf("location eq '%s'", vmm.Credentials.Region)),
+		})
+		for pager.More() {
+			resp, err := pager.NextPage(ctx)
+			if err != nil {
+				return nil, errors.Wrap(err, "Get Resource SKU")
+			}
+
+			for _, sku := range resp.Value {
+				if sku.ResourceType == nil || sku.Name == nil || !strings.EqualFold(*sku.ResourceType, "virtualMachines") {
+					continue
+				}
+				skus[strings.ToLower(*sku.Name)] = sku
+			}
+		}
+
+		cache.skus = skus
+		cache.loadedAt = time.Now()
+		log.DebugContext(ctx, "Loaded VM SKUs", "region", vmm.Credentials.Region, "count", len(skus))
+	}
+
+	sku, ok := cache.skus[strings.ToLower(sizeName)]
+	if !ok {
+		return nil, fmt.Errorf("VM size [%s] is not available in region [%s]", sizeName, vmm.Credentials.Region)
+	}
+
+	return sku, nil
+}
+
+// skuCapabilities provides typed access to the capabilities of a SKU
+type skuCapabilities map[string]string
+
+func newSKUCapabilities(sku *armcompute.ResourceSKU) skuCapabilities {
+	caps := skuCapabilities{}
+	if sku == nil {
+		return caps
+	}
+
+	for _, capability := range sku.Capabilities {
+		if capability == nil || capability.Name == nil || capability.Value == nil {
+			continue
+		}
+		caps[*capability.Name] = *capability.Value
+	}
+
+	return caps
+}
+
+// isTrue reports whether a boolean capability is present and true
+func (c skuCapabilities) isTrue(name string) bool {
+	v, err := strconv.ParseBool(c[name])
+	return err == nil && v
+}
+
+// contains reports whether a comma separated capability includes the value, e.g. HyperVGenerations "V1,V2"
+func (c skuCapabilities) contains(name, value string) bool {
+	for _, v := range strings.Split(c[name], ",") {
+		if strings.EqualFold(strings.TrimSpace(v), value) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,140 @@
+package vm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/appliedres/cloudy/models"
+)
+
+// The cloudy template model has no fields for many Azure specific VM settings, so they are read from
+// well-known template tags. Template tags are also applied to the VM as Azure tags.
+const (
+	SecureBootTagKey                    = "SecureBoot"                      // "true" / "false", defaults to true for Trusted Launch and Confidential VMs
+	VTpmTagKey                          = "VTpm"                            // "true" / "false", defaults to true for Trusted Launch and Confidential VMs
+	EncryptionAtHostTagKey              = "EncryptionAtHost"                // "true" / "false"
+	DiskEncryptionSetTagKey             = "DiskEncryptionSetID"             // resource ID of a disk encryption set for customer managed keys
+	ConfidentialDiskEncryptionTagKey    = "ConfidentialDiskEncryption"      // "VMGuestStateOnly" or "DiskWithVMGuestState"
+	ConfidentialDiskEncryptionSetTagKey = "ConfidentialDiskEncryptionSetID" // resource ID of a disk encryption set for confidential OS disk encryption
+	ZoneTagKey                          = "Zone"                            // availability zone to pin the VM to, or "auto" to spread VMs across the zones of the size
+	ZoneSpreadGroupTagKey               = "ZoneSpreadGroup"                 // VMs with the same group are spread evenly across zones by "auto"
+	ProximityPlacementGroupTagKey       = "ProximityPlacementGroupID"       // resource ID of a proximity placement group
+	IdentityTagKey                      = "Identity"                        // "SystemAssigned", "UserAssigned", "SystemAssigned,UserAssigned" or "None", defaults to SystemAssigned
+	UserAssignedIdentitiesTagKey        = "UserAssignedIdentityIDs"         // comma separated resource IDs of user-assigned identities
+	IdentityPrincipalIDTagKey           = "IdentityPrincipalID"             // reported only, the principal ID of the system-assigned identity
+	HibernationTagKey                   = "Hibernation"                     // "true" / "false", enables hibernation on sizes that support it
+	OSDiskSKUTagKey                     = "OSDiskSKU"                       // e.g. "StandardSSD_LRS" or "Premium_LRS", overrides the template OS disk's PremiumIo
+	OSDiskCachingTagKey                 = "OSDiskCaching"                   // "None", "ReadOnly" or "ReadWrite"
+	EphemeralOSDiskTagKey               = "EphemeralOSDisk"                 // ephemeral OS disk placement, "CacheDisk", "ResourceDisk" or "NvmeDisk"
+	ConnectionModeTagKey                = "ConnectionMode"                  // "private", "bastion" or "public", overrides the configured connection mode
+)
+
+// ZoneAuto selects the least used zone of the VM's spread group
+const ZoneAuto = "auto"
+
+// templateOptions holds the VM settings parsed from template tags
+type templateOptions struct {
+	SecureBoot *bool
+	VTpm       *bool
+
+	EncryptionAtHost                bool
+	DiskEncryptionSetID             string
+	ConfidentialDiskEncryption      string
+	ConfidentialDiskEncryptionSetID string
+
+	Zone                      string
+	ZoneSpreadGroup           string
+	ProximityPlacementGroupID string
+
+	Identity                string
+	UserAssignedIdentityIDs []string
+
+	Hibernation bool
+
+	OSDiskSKU       string
+	OSDiskCaching   string
+	EphemeralOSDisk string
+
+	ConnectionMode ConnectionMode
+}
+
+// parseTemplateOptions reads the VM settings from the template tags. Tag keys are case-insensitive.
+func parseTemplateOptions(template *models.VirtualMachineTemplate) (*templateOptions, error) {
+	opts := &templateOptions{}
+	if template == nil {
+		return opts, nil
+	}
+
+	for k, v := range template.Tags {
+		if v == nil {
+			continue
+		}
+		value := strings.TrimSpace(*v)
+
+		var err error
+		switch {
+		case strings.EqualFold(k, SecureBootTagKey):
+			opts.SecureBoot, err = parseBoolOption(k, value)
+		case strings.EqualFold(k, VTpmTagKey):
+			opts.VTpm, err = parseBoolOption(k, value)
+		case strings.EqualFold(k, EncryptionAtHostTagKey):
+			var b *bool
+			b, err = parseBoolOption(k, value)
+			opts.EncryptionAtHost = b != nil && *b
+		case strings.EqualFold(k, HibernationTagKey):
+			var b *bool
+			b, err = parseBoolOption(k, value)
+			opts.Hibernation = b != nil && *b
+		case strings.EqualFold(k, DiskEncryptionSetTagKey):
+			opts.DiskEncryptionSetID = value
+		case strings.EqualFold(k, ConfidentialDiskEncryptionTagKey):
+			opts.ConfidentialDiskEncryption = value
+		case strings.EqualFold(k, ConfidentialDiskEncryptionSetTagKey):
+			opts.ConfidentialDiskEncryptionSetID = value
+		case strings.EqualFold(k, ZoneTagKey):
+			opts.Zone = value
+			if strings.EqualFold(value, ZoneAuto) {
+				opts.Zone = ZoneAuto
+			}
+		case strings.EqualFold(k, ZoneSpreadGroupTagKey):
+			opts.ZoneSpreadGroup = value
+		case strings.EqualFold(k, ProximityPlacementGroupTagKey):
+			opts.ProximityPlacementGroupID = value
+		case strings.EqualFold(k, OSDiskSKUTagKey):
+			opts.OSDiskSKU = value
+		case strings.EqualFold(k, OSDiskCachingTagKey):
+			opts.OSDiskCaching = value
+		case strings.EqualFold(k, EphemeralOSDiskTagKey):
+			opts.EphemeralOSDisk = value
+		case strings.EqualFold(k, ConnectionModeTagKey):
+			opts.ConnectionMode = ConnectionMode(strings.ToLower(value))
+		case strings.EqualFold(k, IdentityTagKey):
+			opts.Identity = value
+		case strings.EqualFold(k, UserAssignedIdentitiesTagKey):
+			for _, id := range strings.Split(value, ",") {
+				if id = strings.TrimSpace(id); id != "" {
+					opts.UserAssignedIdentityIDs = append(opts.UserAssignedIdentityIDs, id)
+				}
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return opts, nil
+}
+
+func parseBoolOption(key, value string) (*bool, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, fmt.Errorf("template tag %s must be true or false, got [%s]", key, value)
+	}
+
+	return &b, nil
+}
@@ -15,6 +15,11 @@ import (
 )
 
 func FromCloudyVirtualMachine(ctx context.Context, cloudyVM *models.VirtualMachine) (*armcompute.VirtualMachine, error) {
+	return fromCloudyVirtualMachine(ctx, cloudyVM, "")
+}
+
+// fromCloudyVirtualMachine converts a cloudy VM, placing it in zone. An empty zone uses the zone of the template.
+func fromCloudyVirtualMachine(ctx context.Context, cloudyVM *models.VirtualMachine, zone string) (*armcompute.VirtualMachine, error) {
 	// log := logging.GetLogger(ctx)
 
 	azVM := armcompute.VirtualMachine{
@@ -23,9 +28,6 @@ func FromCloudyVirtualMachine(ctx context.Context, cloudyVM *models.VirtualMachi
 		ID:       &cloudyVM.ID,
 		Name:     &cloudyVM.ID,
 		Location: &cloudyVM.Location.Region,
-		Identity: &armcompute.VirtualMachineIdentity{
-			Type: to.Ptr(armcompute.ResourceIdentityTypeSystemAssigned),
-		},
 	}
 
 	if cloudyVM.Template == nil {
@@ -67,6 +69,38 @@ func FromCloudyVirtualMachine(ctx context.Context, cloudyVM *models.VirtualMachi
 		}
 	}
 
+	options, err := parseTemplateOptions(cloudyVM.Template)
+	if err != nil {
+		return nil, fmt.Errorf("VM template options invalid: %w", err)
+	}
+
+	securityType := templateSecurityType(cloudyVM.Template)
+	if err := validateSecurityOptions(securityType, options, nil); err != nil {
+		return nil, fmt.Errorf("VM security options invalid: %w", err)
+	}
+	applySecurityOptions(&azVM, securityType, options)
+
+	// an "auto" zone depends on the existing VMs, and is selected by CreateVirtualMachine
+	if zone == "" && options.Zone != ZoneAuto {
+		zone = options.Zone
+	}
+	applyPlacementOptions(&azVM, zone, options)
+
+	if err := validateHibernationOptions(options, nil); err != nil {
+		return nil, fmt.Errorf("VM hibernation options invalid: %w", err)
+	}
+	applyHibernationOptions(&azVM, options)
+
+	if err := validateOSDiskOptions(cloudyVM.Template, options, nil); err != nil {
+		return nil, fmt.Errorf("VM OS disk options invalid: %w", err)
+	}
+	applyOSDiskOptions(&azVM, cloudyVM.Template, options)
+
+	azVM.Identity, err = templateIdentity(options)
+	if err != nil {
+		return nil, fmt.Errorf("VM identity options invalid: %w", err)
+	}
+
 	azVM.Properties.OSProfile = &armcompute.OSProfile{
 		ComputerName:  to.Ptr(cloudyVM.ID),
 		AdminUsername: &cloudyVM.Template.LocalAdministratorID,
@@ -186,12 +220,21 @@ func ToCloudyVirtualMachine(ctx context.Context, azVM *armcompute.VirtualMachine
 				cloudyVm.CreatorID = *v
 			} else if strings.EqualFold(k, vmUserTagKey) {
 				cloudyVm.UserID = *v
+			} else if strings.EqualFold(k, vmTeamTagKey) {
+				cloudyVm.TeamID = *v
 			} else {
 				cloudyVm.Tags[k] = v
 			}
 		}
 	}
 
+	// report the actual security settings, overriding any template tags copied to the VM
+	readSecurityOptions(azVM, cloudyVm.Tags)
+	readPlacement(azVM, cloudyVm.Location, cloudyVm.Tags)
+	readIdentity(azVM, cloudyVm.Tags)
+	readHibernation(azVM, cloudyVm.Tags)
+	readOSDisk(azVM, cloudyVm.Tags)
+
 	return &cloudyVm
 }
 
@@ -305,6 +348,7 @@ func ToCloudyVirtualMachineSize(ctx context.Context, resource *armcompute.Resour
 			"UltraSSDAvailable",
 			"MaxWriteAcceleratorDisksAllowed",
 			"TrustedLaunchDisabled",
+			"ConfidentialComputingType",
 			"ParentSize",
 			"DiskControllerTypes",
 			"NvmeDiskSizeInMiB",
@@ -341,21 +385,28 @@ func LongIdToShortId(longId string) string {
 func mapProvisioningAndPowerState(ctx context.Context, azVM *armcompute.VirtualMachine) *models.VirtualMachineCloudState {
 	log := logging.GetLogger(ctx)
 
-	provState := strings.ToLower(*azVM.Properties.ProvisioningState)
-	var powerState string
-
 	if azVM.Properties.InstanceView == nil {
 		return nil // no InstanceView, we likely did not query with IncludeState
 	}
 
+	provState := strings.ToLower(*azVM.Properties.ProvisioningState)
+	var powerState string
+
+	hibernated := false
 	for _, status := range azVM.Properties.InstanceView.Statuses {
 		if strings.Contains(*status.Code, "PowerState") {
 			statusParts := strings.Split(*status.Code, "/")
 			powerState = strings.ToLower(statusParts[1])
-			break
+		} else if strings.EqualFold(*status.Code, "HibernationState/Hibernated") {
+			hibernated = true
 		}
 	}
 
+	// a hibernated VM is deallocated, with an extra hibernation status
+	if hibernated && powerState == "deallocated" {
+		powerState = "hibernated"
+	}
+
 	cloudState := mapCloudState(provState, powerState)
 
 	if cloudState == models.VirtualMachineCloudStateUnknown {
@@ -385,6 +436,8 @@ func mapCloudState(provState, powerState string) models.VirtualMachineCloudState
 		case "deallocated":
 			// we intend to always 'deallocate' VMs for the 'stop' action
 			return models.VirtualMachineCloudStateStopped
+		case string(VirtualMachineCloudStateHibernated):
+			return VirtualMachineCloudStateHibernated
 		default:
 			return models.VirtualMachineCloudStateUnknown
 		}
@@ -404,6 +457,8 @@ func mapCloudState(provState, powerState string) models.VirtualMachineCloudState
 			return models.VirtualMachineCloudStateStopping
 		case string(models.VirtualMachineCloudStateStopped): // 'updating' a 'stopped' VM is 'starting'
 			return models.VirtualMachineCloudStateStarting
+		case string(VirtualMachineCloudStateHibernated): // 'updating' a 'hibernated' VM is 'starting' (resuming)
+			return models.VirtualMachineCloudStateStarting
 		default:
 			return models.VirtualMachineCloudStateUnknown
 		}
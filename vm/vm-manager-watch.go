@@ -0,0 +1,562 @@
+package vm
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/appliedres/cloudy/logging"
+	"github.com/appliedres/cloudy/models"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultWatchInterval = 30 * time.Second
+	defaultWatchBuffer   = 100
+
+	// Event Grid batches at most 1 MB of events per delivery
+	maxEventGridPayload = 1 << 20
+)
+
+// VirtualMachineEventType is the kind of VM state transition reported by a watcher
+type VirtualMachineEventType string
+
+const (
+	VirtualMachineEventCreated           VirtualMachineEventType = "created"
+	VirtualMachineEventStarted           VirtualMachineEventType = "started"
+	VirtualMachineEventStopped           VirtualMachineEventType = "stopped" // powered off, still allocated
+	VirtualMachineEventDeallocated       VirtualMachineEventType = "deallocated"
+	VirtualMachineEventDeleted           VirtualMachineEventType = "deleted"
+	VirtualMachineEventFailed            VirtualMachineEventType = "failed"             // provisioning state changed to failed, or an action failed
+	VirtualMachineEventProvisioningError VirtualMachineEventType = "provisioning_error" // a new provisioning error was reported
+)
+
+const (
+	VirtualMachineEventSourceReconcile = "reconcile"
+	VirtualMachineEventSourceEventGrid = "eventgrid"
+)
+
+// VirtualMachineEvent is a state transition of a watched VM
+type VirtualMachineEvent struct {
+	Type       VirtualMachineEventType
+	VMID       string
+	CloudState *models.VirtualMachineCloudState // state after the transition, nil if not known
+	Message    string                           // error details of failed and provisioning error events
+	Source     string                           // VirtualMachineEventSourceReconcile or VirtualMachineEventSourceEventGrid
+	Time       time.Time
+}
+
+// WatchOptions control a VM watcher
+type WatchOptions struct {
+	Selector VirtualMachineSelector // VMs to watch. IDs, a prefix or tags are required
+	Interval time.Duration          // time between reconciliations, defaults to 30s
+	Buffer   int                    // size of the event channel, defaults to 100. A full channel coalesces events per VM
+
+	// Optional secret Event Grid deliveries must pass as the "code" query parameter of the webhook URL
+	EventGridSecret string
+}
+
+// vmObservation is the last known state of a watched VM
+type vmObservation struct {
+	PowerState        string
+	ProvisioningState string
+	ProvisioningError string // message of the last provisioning error, empty if there is none
+	CloudState        *models.VirtualMachineCloudState
+}
+
+// VirtualMachineWatcher emits events for state transitions of the VMs matching a selector.
+// Transitions are found by periodically reconciling the VMs' instance views, and can be received sooner
+// by delivering Event Grid system topic events for the resource group to the watcher's ServeHTTP.
+type VirtualMachineWatcher struct {
+	vmm     *AzureVirtualMachineManager
+	options WatchOptions
+
+	events chan VirtualMachineEvent
+
+	mu       sync.Mutex
+	known    map[string]vmObservation
+	baseline bool // set once the first reconciliation has recorded the existing VMs
+	closed   bool
+	overflow []VirtualMachineEvent // events that did not fit the channel, at most one per VM
+	dropped  int
+}
+
+// WatchVirtualMachines starts watching the VMs matching the selector until the context is cancelled, when the
+// event channel is closed. The first reconciliation records the existing VMs without emitting events for them.
+func (vmm *AzureVirtualMachineManager) WatchVirtualMachines(ctx context.Context, options WatchOptions) (*VirtualMachineWatcher, error) {
+	selector := options.Selector
+	if len(selector.IDs) == 0 && selector.Prefix == "" && len(selector.Tags) == 0 {
+		return nil, fmt.Errorf("VM selector must specify IDs, a prefix or tags")
+	}
+	if options.Interval <= 0 {
+		options.Interval = defaultWatchInterval
+	}
+	if options.Buffer <= 0 {
+		options.Buffer = defaultWatchBuffer
+	}
+
+	w := &VirtualMachineWatcher{
+		vmm:     vmm,
+		options: options,
+		events:  make(chan VirtualMachineEvent, options.Buffer),
+		known:   map[string]vmObservation{},
+	}
+
+	go w.run(ctx)
+
+	return w, nil
+}
+
+// Events returns the channel VM events are delivered on
+func (w *VirtualMachineWatcher) Events() <-chan VirtualMachineEvent {
+	return w.events
+}
+
+func (w *VirtualMachineWatcher) run(ctx context.Context) {
+	log := logging.GetLogger(ctx)
+
+	defer w.close()
+
+	ticker := time.NewTicker(w.options.Interval)
+	defer ticker.Stop()
+
+	for {
+		err := w.Reconcile(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.WarnContext(ctx, "VM watch reconciliation failed", logging.WithError(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *VirtualMachineWatcher) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	close(w.events)
+}
+
+// Reconcile compares the current state of the watched VMs with the last known state and emits an event for each transition.
+// It is run periodically by the watcher, and can be called to reconcile immediately.
+func (w *VirtualMachineWatcher) Reconcile(ctx context.Context) error {
+	azVMs, err := w.vmm.listVirtualMachinesWithState(ctx)
+	if err != nil {
+		return err
+	}
+
+	current := map[string]vmObservation{}
+	for _, azVM := range azVMs {
+		if azVM.Name == nil || !w.selects(azVM) {
+			continue
+		}
+		current[*azVM.Name] = observeVirtualMachine(ctx, azVM)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var events []VirtualMachineEvent
+	if w.baseline {
+		events = diffObservations(w.known, current, time.Now())
+	}
+	w.known = current
+	w.baseline = true
+
+	w.emit(events)
+	return nil
+}
+
+// selects reports whether a VM listed by the reconciliation is watched
+func (w *VirtualMachineWatcher) selects(azVM *armcompute.VirtualMachine) bool {
+	if len(w.options.Selector.IDs) > 0 {
+		return containsFold(w.options.Selector.IDs, *azVM.Name)
+	}
+
+	return matchesSelector(azVM, w.options.Selector)
+}
+
+// emit delivers events without blocking. Events that do not fit the channel are held until the next emit,
+// keeping only the latest event per VM. Must be called with the lock held.
+func (w *VirtualMachineWatcher) emit(events []VirtualMachineEvent) {
+	if w.closed {
+		return
+	}
+
+	pending := append(w.overflow, events...)
+	w.overflow = nil
+	for i, event := range pending {
+		select {
+		case w.events <- event:
+		default:
+			// keep the order, nothing is sent past an event that did not fit
+			for _, held := range pending[i:] {
+				w.hold(held)
+			}
+			return
+		}
+	}
+}
+
+// hold keeps an event that did not fit the channel, replacing an earlier event of the same VM.
+// The oldest event is dropped once the overflow is as large as the channel. Must be called with the lock held.
+func (w *VirtualMachineWatcher) hold(event VirtualMachineEvent) {
+	if i := slices.IndexFunc(w.overflow, func(held VirtualMachineEvent) bool { return held.VMID == event.VMID }); i >= 0 {
+		w.overflow = slices.Delete(w.overflow, i, i+1)
+		w.dropped++
+	} else if len(w.overflow) >= max(w.options.Buffer, 1) {
+		w.overflow = w.overflow[1:]
+		w.dropped++
+	}
+	w.overflow = append(w.overflow, event)
+}
+
+// Dropped returns the number of events dropped or coalesced because the event channel was full
+func (w *VirtualMachineWatcher) Dropped() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.dropped
+}
+
+// listVirtualMachinesWithState lists the VMs of the manager's resource group with their instance view
+func (vmm *AzureVirtualMachineManager) listVirtualMachinesWithState(ctx context.Context) ([]*armcompute.VirtualMachine, error) {
+	azVMs := []*armcompute.VirtualMachine{}
+
+	// the resource group list only expands the instance view when filtered by scale set, so list the subscription
+	pager := vmm.vmClient.NewListAllPager(&armcompute.VirtualMachinesClientListAllOptions{
+		StatusOnly: to.Ptr("true"),
+	})
+	for pager.More() {
+		resp, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "List VMs with state")
+		}
+
+		for _, azVM := range resp.Value {
+			if azVM.ID != nil && strings.EqualFold(resourceGroupFromID(*azVM.ID), vmm.Credentials.ResourceGroup) {
+				azVMs = append(azVMs, azVM)
+			}
+		}
+	}
+
+	return azVMs, nil
+}
+
+// observeVirtualMachine records the state of a VM listed with its instance view
+func observeVirtualMachine(ctx context.Context, azVM *armcompute.VirtualMachine) vmObservation {
+	observation := vmObservation{}
+	if azVM.Properties != nil && azVM.Properties.ProvisioningState != nil {
+		observation.ProvisioningState = strings.ToLower(*azVM.Properties.ProvisioningState)
+	}
+
+	status := toDetailedStatus(ctx, azVM)
+	if status == nil {
+		return observation
+	}
+
+	observation.PowerState = strings.ToLower(status.PowerState)
+	observation.CloudState = status.CloudState
+	if status.LastProvisioningError != nil {
+		observation.ProvisioningError = status.LastProvisioningError.Message
+		if observation.ProvisioningError == "" {
+			observation.ProvisioningError = status.LastProvisioningError.Code
+		}
+	}
+
+	return observation
+}
+
+// diffObservations returns the events for the transitions between two reconciliations, in VM ID order
+func diffObservations(previous, current map[string]vmObservation, now time.Time) []VirtualMachineEvent {
+	events := []VirtualMachineEvent{}
+
+	for _, id := range sortedKeys(current) {
+		curr := current[id]
+		event := func(eventType VirtualMachineEventType, message string) VirtualMachineEvent {
+			return VirtualMachineEvent{
+				Type:       eventType,
+				VMID:       id,
+				CloudState: curr.CloudState,
+				Message:    message,
+				Source:     VirtualMachineEventSourceReconcile,
+				Time:       now,
+			}
+		}
+
+		prev, existed := previous[id]
+		if !existed {
+			events = append(events, event(VirtualMachineEventCreated, ""))
+		}
+
+		if curr.ProvisioningState == "failed" && prev.ProvisioningState != "failed" {
+			events = append(events, event(VirtualMachineEventFailed, curr.ProvisioningError))
+		}
+		if curr.ProvisioningError != "" && curr.ProvisioningError != prev.ProvisioningError {
+			events = append(events, event(VirtualMachineEventProvisioningError, curr.ProvisioningError))
+		}
+
+		if curr.PowerState != prev.PowerState {
+			if eventType, ok := powerStateEvents[curr.PowerState]; ok {
+				events = append(events, event(eventType, ""))
+			}
+		}
+	}
+
+	for _, id := range sortedKeys(previous) {
+		if _, exists := current[id]; !exists {
+			events = append(events, VirtualMachineEvent{
+				Type:   VirtualMachineEventDeleted,
+				VMID:   id,
+				Source: VirtualMachineEventSourceReconcile,
+				Time:   now,
+			})
+		}
+	}
+
+	return events
+}
+
+// events for the power states a VM settles in, transitional states are not reported
+var powerStateEvents = map[string]VirtualMachineEventType{
+	"running":     VirtualMachineEventStarted,
+	"stopped":     VirtualMachineEventStopped,
+	"deallocated": VirtualMachineEventDeallocated,
+}
+
+func sortedKeys(observations map[string]vmObservation) []string {
+	keys := make([]string, 0, len(observations))
+	for key := range observations {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	return keys
+}
+
+// eventGridEvent is an event in the Event Grid schema
+type eventGridEvent struct {
+	ID        string          `json:"id"`
+	EventType string          `json:"eventType"`
+	Subject   string          `json:"subject"`
+	EventTime time.Time       `json:"eventTime"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// eventGridResourceData is the data of the Microsoft.Resources events of a resource group or subscription system topic
+type eventGridResourceData struct {
+	OperationName  string `json:"operationName"`
+	ResourceURI    string `json:"resourceUri"`
+	Status         string `json:"status"`
+	ResponseBody   string `json:"responseBody"`
+	ValidationCode string `json:"validationCode"`
+}
+
+const eventGridValidationEvent = "Microsoft.EventGrid.SubscriptionValidationEvent"
+
+// ServeHTTP receives Event Grid deliveries of a resource group or subscription system topic, in the Event Grid schema.
+// It answers the subscription validation handshake, and emits events for the watched VMs without waiting for
+// the next reconciliation. Events for VMs the watcher does not know are only emitted if the selector can match
+// them by name, since an event does not carry the VM's tags.
+func (w *VirtualMachineWatcher) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if w.options.EventGridSecret != "" &&
+		subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("code")), []byte(w.options.EventGridSecret)) != 1 {
+		rw.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxEventGridPayload))
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var deliveries []eventGridEvent
+	if err := json.Unmarshal(body, &deliveries); err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	for _, delivery := range deliveries {
+		if delivery.EventType != eventGridValidationEvent {
+			continue
+		}
+
+		var data eventGridResourceData
+		if err := json.Unmarshal(delivery.Data, &data); err != nil || data.ValidationCode == "" {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(rw).Encode(map[string]string{"validationResponse": data.ValidationCode})
+		return
+	}
+
+	w.handleEventGridEvents(deliveries)
+	rw.WriteHeader(http.StatusOK)
+}
+
+// handleEventGridEvents applies Event Grid events to the watched VMs and emits the resulting VM events
+func (w *VirtualMachineWatcher) handleEventGridEvents(deliveries []eventGridEvent) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	events := []VirtualMachineEvent{}
+	for _, delivery := range deliveries {
+		event, ok := w.applyEventGridEvent(delivery)
+		if ok {
+			events = append(events, event)
+		}
+	}
+
+	w.emit(events)
+}
+
+// applyEventGridEvent maps an Event Grid event to a VM event and updates the known state of the VM,
+// so the next reconciliation does not report the same transition. Must be called with the lock held.
+func (w *VirtualMachineWatcher) applyEventGridEvent(delivery eventGridEvent) (VirtualMachineEvent, bool) {
+	var data eventGridResourceData
+	if err := json.Unmarshal(delivery.Data, &data); err != nil {
+		return VirtualMachineEvent{}, false
+	}
+
+	resourceID := data.ResourceURI
+	if resourceID == "" {
+		resourceID = delivery.Subject
+	}
+	vmID, ok := virtualMachineNameFromID(resourceID)
+	if !ok || !strings.EqualFold(resourceGroupFromID(resourceID), w.vmm.Credentials.ResourceGroup) {
+		return VirtualMachineEvent{}, false
+	}
+
+	observation, known := w.known[vmID]
+	if !known && !w.selectsByName(vmID) {
+		return VirtualMachineEvent{}, false
+	}
+
+	eventType, ok := eventGridEventType(delivery.EventType, data.OperationName, known)
+	if !ok {
+		return VirtualMachineEvent{}, false
+	}
+
+	event := VirtualMachineEvent{
+		Type:   eventType,
+		VMID:   vmID,
+		Source: VirtualMachineEventSourceEventGrid,
+		Time:   delivery.EventTime,
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	switch eventType {
+	case VirtualMachineEventDeleted:
+		delete(w.known, vmID)
+		return event, true
+	case VirtualMachineEventFailed, VirtualMachineEventProvisioningError:
+		event.Message = data.ResponseBody
+		if event.Message == "" {
+			event.Message = data.Status
+		}
+	case VirtualMachineEventCreated:
+		observation = vmObservation{}
+	default:
+		for powerState, powerEvent := range powerStateEvents {
+			if powerEvent == eventType {
+				observation.PowerState = powerState
+			}
+		}
+		state := powerStateCloudStates[observation.PowerState]
+		observation.CloudState = &state
+		event.CloudState = observation.CloudState
+	}
+
+	// until a reconciliation sees the VM, record it so its creation is not reported twice
+	if w.baseline {
+		w.known[vmID] = observation
+	}
+
+	return event, true
+}
+
+// cloud states of VMs that settled in a power state
+var powerStateCloudStates = map[string]models.VirtualMachineCloudState{
+	"running":     models.VirtualMachineCloudStateRunning,
+	"stopped":     models.VirtualMachineCloudStateStopped,
+	"deallocated": models.VirtualMachineCloudStateStopped,
+}
+
+// selectsByName reports whether a VM the watcher has not seen is watched, using only its name
+func (w *VirtualMachineWatcher) selectsByName(vmID string) bool {
+	selector := w.options.Selector
+	if len(selector.IDs) > 0 {
+		return containsFold(selector.IDs, vmID)
+	}
+
+	return len(selector.Tags) == 0 && strings.HasPrefix(vmID, selector.Prefix)
+}
+
+// eventGridEventType maps a Microsoft.Resources event for a VM to a VM event type
+func eventGridEventType(eventType, operationName string, known bool) (VirtualMachineEventType, bool) {
+	operation := strings.ToLower(operationName)
+
+	switch eventType {
+	case "Microsoft.Resources.ResourceWriteSuccess":
+		// writes also update existing VMs, only the first one creates the VM
+		if operation == "microsoft.compute/virtualmachines/write" && !known {
+			return VirtualMachineEventCreated, true
+		}
+	case "Microsoft.Resources.ResourceDeleteSuccess":
+		if operation == "microsoft.compute/virtualmachines/delete" {
+			return VirtualMachineEventDeleted, true
+		}
+	case "Microsoft.Resources.ResourceActionSuccess":
+		switch operation {
+		case "microsoft.compute/virtualmachines/start/action", "microsoft.compute/virtualmachines/restart/action":
+			return VirtualMachineEventStarted, true
+		case "microsoft.compute/virtualmachines/poweroff/action":
+			return VirtualMachineEventStopped, true
+		case "microsoft.compute/virtualmachines/deallocate/action":
+			return VirtualMachineEventDeallocated, true
+		}
+	case "Microsoft.Resources.ResourceWriteFailure":
+		if operation == "microsoft.compute/virtualmachines/write" {
+			return VirtualMachineEventProvisioningError, true
+		}
+	case "Microsoft.Resources.ResourceActionFailure", "Microsoft.Resources.ResourceDeleteFailure":
+		if strings.HasPrefix(operation, "microsoft.compute/virtualmachines/") {
+			return VirtualMachineEventFailed, true
+		}
+	}
+
+	return "", false
+}
+
+// virtualMachineNameFromID returns the VM name of a VM resource ID. Child resources, e.g. extensions, are not VMs.
+func virtualMachineNameFromID(id string) (string, bool) {
+	parts := strings.Split(strings.Trim(id, "/"), "/")
+	n := len(parts)
+	if n < 3 || !strings.EqualFold(parts[n-3], "Microsoft.Compute") || !strings.EqualFold(parts[n-2], "virtualMachines") {
+		return "", false
+	}
+
+	return parts[n-1], true
+}
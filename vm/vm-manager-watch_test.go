@@ -0,0 +1,156 @@
+package vm
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	cloudyazure "github.com/appliedres/cloudy-azure"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffObservations(t *testing.T) {
+	now := time.Now()
+	previous := map[string]vmObservation{
+		"shvm-1": {PowerState: "running", ProvisioningState: "succeeded"},
+		"shvm-2": {PowerState: "running", ProvisioningState: "succeeded"},
+		"shvm-3": {PowerState: "deallocated", ProvisioningState: "succeeded"},
+	}
+	current := map[string]vmObservation{
+		"shvm-1": {PowerState: "running", ProvisioningState: "succeeded"},
+		"shvm-2": {PowerState: "deallocating", ProvisioningState: "updating"},
+		"shvm-4": {PowerState: "starting", ProvisioningState: "failed", ProvisioningError: "OSProvisioningTimedOut"},
+	}
+
+	events := diffObservations(previous, current, now)
+	types := []string{}
+	for _, event := range events {
+		types = append(types, event.VMID+":"+string(event.Type))
+	}
+	assert.Equal(t, []string{"shvm-4:created", "shvm-4:failed", "shvm-4:provisioning_error", "shvm-3:deleted"}, types)
+	assert.Equal(t, "OSProvisioningTimedOut", events[1].Message)
+	assert.Equal(t, VirtualMachineEventSourceReconcile, events[0].Source)
+
+	previous, current = current, map[string]vmObservation{
+		"shvm-1": {PowerState: "stopped", ProvisioningState: "succeeded"},
+		"shvm-2": {PowerState: "deallocated", ProvisioningState: "succeeded"},
+		"shvm-4": {PowerState: "running", ProvisioningState: "failed", ProvisioningError: "OSProvisioningTimedOut"},
+	}
+	types = []string{}
+	for _, event := range diffObservations(previous, current, now) {
+		types = append(types, event.VMID+":"+string(event.Type))
+	}
+	assert.Equal(t, []string{"shvm-1:stopped", "shvm-2:deallocated", "shvm-4:started"}, types)
+}
+
+func TestEventGridEventType(t *testing.T) {
+	eventType, ok := eventGridEventType("Microsoft.Resources.ResourceActionSuccess", "Microsoft.Compute/virtualMachines/deallocate/action", true)
+	assert.True(t, ok)
+	assert.Equal(t, VirtualMachineEventDeallocated, eventType)
+
+	eventType, ok = eventGridEventType("Microsoft.Resources.ResourceWriteSuccess", "Microsoft.Compute/virtualMachines/write", false)
+	assert.True(t, ok)
+	assert.Equal(t, VirtualMachineEventCreated, eventType)
+
+	_, ok = eventGridEventType("Microsoft.Resources.ResourceWriteSuccess", "Microsoft.Compute/virtualMachines/write", true)
+	assert.False(t, ok)
+
+	eventType, ok = eventGridEventType("Microsoft.Resources.ResourceActionFailure", "Microsoft.Compute/virtualMachines/start/action", true)
+	assert.True(t, ok)
+	assert.Equal(t, VirtualMachineEventFailed, eventType)
+
+	_, ok = eventGridEventType("Microsoft.Resources.ResourceActionSuccess", "Microsoft.Network/networkInterfaces/join/action", true)
+	assert.False(t, ok)
+}
+
+func TestVirtualMachineNameFromID(t *testing.T) {
+	name, ok := virtualMachineNameFromID("/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/shvm-1")
+	assert.True(t, ok)
+	assert.Equal(t, "shvm-1", name)
+
+	_, ok = virtualMachineNameFromID("/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/shvm-1/extensions/ext")
+	assert.False(t, ok)
+}
+
+func newTestWatcher(secret string) *VirtualMachineWatcher {
+	return &VirtualMachineWatcher{
+		vmm:      &AzureVirtualMachineManager{Credentials: &cloudyazure.AzureCredentials{ResourceGroup: "rg"}},
+		options:  WatchOptions{Selector: VirtualMachineSelector{Prefix: "shvm-"}, EventGridSecret: secret},
+		events:   make(chan VirtualMachineEvent, 10),
+		known:    map[string]vmObservation{"shvm-1": {PowerState: "running"}},
+		baseline: true,
+	}
+}
+
+func TestWatcherEventGridValidation(t *testing.T) {
+	w := newTestWatcher("")
+
+	body := `[{"id":"1","eventType":"Microsoft.EventGrid.SubscriptionValidationEvent","data":{"validationCode":"abc"}}]`
+	rec := httptest.NewRecorder()
+	w.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body)))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"validationResponse":"abc"}`, rec.Body.String())
+}
+
+func TestWatcherEventGridEvents(t *testing.T) {
+	w := newTestWatcher("s3cret")
+
+	body := `[
+		{"id":"1","eventType":"Microsoft.Resources.ResourceActionSuccess","subject":"/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/shvm-1",
+		 "eventTime":"2024-05-01T12:00:00Z","data":{"operationName":"Microsoft.Compute/virtualMachines/deallocate/action","resourceUri":"/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/shvm-1"}},
+		{"id":"2","eventType":"Microsoft.Resources.ResourceActionSuccess","subject":"/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/uvm-1",
+		 "data":{"operationName":"Microsoft.Compute/virtualMachines/start/action"}},
+		{"id":"3","eventType":"Microsoft.Resources.ResourceDeleteSuccess","subject":"/subscriptions/sub/resourceGroups/other/providers/Microsoft.Compute/virtualMachines/shvm-2",
+		 "data":{"operationName":"Microsoft.Compute/virtualMachines/delete"}}
+	]`
+
+	rec := httptest.NewRecorder()
+	w.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body)))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = httptest.NewRecorder()
+	w.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/events?code=s3cret", strings.NewReader(body)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	assert.Len(t, w.events, 1)
+	event := <-w.events
+	assert.Equal(t, VirtualMachineEventDeallocated, event.Type)
+	assert.Equal(t, "shvm-1", event.VMID)
+	assert.Equal(t, VirtualMachineEventSourceEventGrid, event.Source)
+	assert.Equal(t, "deallocated", w.known["shvm-1"].PowerState)
+
+	// the next reconciliation must not report the same transition
+	assert.Empty(t, diffObservations(w.known, map[string]vmObservation{"shvm-1": {PowerState: "deallocated"}}, time.Now()))
+}
+
+func TestWatcherEmitDoesNotBlock(t *testing.T) {
+	w := newTestWatcher("")
+	w.events = make(chan VirtualMachineEvent, 1)
+	w.options.Buffer = 2
+
+	w.emit([]VirtualMachineEvent{
+		{Type: VirtualMachineEventStarted, VMID: "shvm-1"},
+		{Type: VirtualMachineEventStopped, VMID: "shvm-1"},
+		{Type: VirtualMachineEventStarted, VMID: "shvm-2"},
+		{Type: VirtualMachineEventDeallocated, VMID: "shvm-1"},
+		{Type: VirtualMachineEventStarted, VMID: "shvm-3"},
+	})
+
+	// the held events are coalesced per VM, and the oldest is dropped once the overflow is full
+	assert.Equal(t, VirtualMachineEventStarted, (<-w.events).Type)
+	assert.Equal(t, []VirtualMachineEvent{
+		{Type: VirtualMachineEventDeallocated, VMID: "shvm-1"},
+		{Type: VirtualMachineEventStarted, VMID: "shvm-3"},
+	}, w.overflow)
+	assert.Equal(t, 2, w.Dropped())
+
+	// held events are delivered first on the next emit
+	w.emit(nil)
+	assert.Equal(t, "shvm-1", (<-w.events).VMID)
+	w.emit(nil)
+	assert.Equal(t, "shvm-3", (<-w.events).VMID)
+	assert.Empty(t, w.overflow)
+}
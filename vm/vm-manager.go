@@ -13,8 +13,12 @@ import (
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/authorization/armauthorization/v2"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/monitor/armmonitor"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork/v5"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resourcegraph/armresourcegraph"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
 
 	cloudyazure "github.com/appliedres/cloudy-azure"
 	"github.com/appliedres/cloudy/logging"
@@ -33,16 +37,32 @@ type AzureVirtualMachineManager struct {
 	Credentials *cloudyazure.AzureCredentials
 	Config      *VirtualMachineManagerConfig
 
-	vmClient     *armcompute.VirtualMachinesClient
-	nicClient    *armnetwork.InterfacesClient
-	diskClient   *armcompute.DisksClient
-	subnetClient *armnetwork.SubnetsClient
+	vmClient         *armcompute.VirtualMachinesClient
+	extensionsClient *armcompute.VirtualMachineExtensionsClient
+	nicClient        *armnetwork.InterfacesClient
+	diskClient       *armcompute.DisksClient
+	subnetClient     *armnetwork.SubnetsClient
+
+	publicIPClient       *armnetwork.PublicIPAddressesClient
+	securityRulesClient  *armnetwork.SecurityRulesClient
+	securityGroupsClient *armnetwork.SecurityGroupsClient
+	bastionClient        *armnetwork.ManagementClient
 
 	sizesClient *armcompute.ResourceSKUsClient
 	usageClient *armcompute.UsageClient
 
 	galleryClient *armcompute.SharedGalleryImageVersionsClient
 
+	skuCache *skuCache
+
+	metricsClient *armmonitor.MetricsClient
+
+	roleAssignmentsClient *armauthorization.RoleAssignmentsClient
+
+	resourceGraphClient *armresourcegraph.Client
+
+	transferClient *azblob.Client
+
 	LogBody bool
 }
 
@@ -113,6 +133,12 @@ func (vmm *AzureVirtualMachineManager) Configure(ctx context.Context) error {
 	}
 	vmm.vmClient = vmClient
 
+	extensionsClient, err := armcompute.NewVirtualMachineExtensionsClient(vmm.Credentials.SubscriptionID, credential, options)
+	if err != nil {
+		return err
+	}
+	vmm.extensionsClient = extensionsClient
+
 	nicClient, err := armnetwork.NewInterfacesClient(vmm.Credentials.SubscriptionID, VnetCredential, options)
 	if err != nil {
 		return err
@@ -131,11 +157,38 @@ func (vmm *AzureVirtualMachineManager) Configure(ctx context.Context) error {
 	}
 	vmm.subnetClient = subnetClient
 
+	publicIPClient, err := armnetwork.NewPublicIPAddressesClient(vmm.Credentials.SubscriptionID, credential, options)
+	if err != nil {
+		return err
+	}
+	vmm.publicIPClient = publicIPClient
+
+	securityRulesClient, err := armnetwork.NewSecurityRulesClient(vmm.Credentials.SubscriptionID, VnetCredential, options)
+	if err != nil {
+		return err
+	}
+	vmm.securityRulesClient = securityRulesClient
+
+	securityGroupsClient, err := armnetwork.NewSecurityGroupsClient(vmm.Credentials.SubscriptionID, VnetCredential, options)
+	if err != nil {
+		return err
+	}
+	vmm.securityGroupsClient = securityGroupsClient
+
+	bastionClient, err := armnetwork.NewManagementClient(vmm.Credentials.SubscriptionID, VnetCredential, options)
+	if err != nil {
+		return err
+	}
+	vmm.bastionClient = bastionClient
+
 	sizesClient, err := armcompute.NewResourceSKUsClient(vmm.Credentials.SubscriptionID, credential, options)
 	if err != nil {
 		return err
 	}
 	vmm.sizesClient = sizesClient
+	if vmm.skuCache == nil {
+		vmm.skuCache = &skuCache{}
+	}
 
 	galleryClient, err := armcompute.NewSharedGalleryImageVersionsClient(vmm.Credentials.SubscriptionID, credential, options)
 	if err != nil {
@@ -149,6 +202,34 @@ func (vmm *AzureVirtualMachineManager) Configure(ctx context.Context) error {
 	}
 	vmm.usageClient = usageClient
 
+	metricsClient, err := armmonitor.NewMetricsClient(vmm.Credentials.SubscriptionID, credential, options)
+	if err != nil {
+		return err
+	}
+	vmm.metricsClient = metricsClient
+
+	roleAssignmentsClient, err := armauthorization.NewRoleAssignmentsClient(vmm.Credentials.SubscriptionID, credential, options)
+	if err != nil {
+		return err
+	}
+	vmm.roleAssignmentsClient = roleAssignmentsClient
+
+	resourceGraphClient, err := armresourcegraph.NewClient(credential, options)
+	if err != nil {
+		return err
+	}
+	vmm.resourceGraphClient = resourceGraphClient
+
+	if vmm.Config.FileTransfer != nil {
+		transferClient, err := azblob.NewClient(transferServiceURL(vmm.Config.FileTransfer.StorageAccount), credential, &azblob.ClientOptions{
+			ClientOptions: options.ClientOptions,
+		})
+		if err != nil {
+			return err
+		}
+		vmm.transferClient = transferClient
+	}
+
 	return nil
 }
 
@@ -172,6 +253,27 @@ func (vmm *AzureVirtualMachineManager) StartVirtualMachine(ctx context.Context,
 	return nil
 }
 
+// RestartVirtualMachine restarts a running VM in place, keeping its allocation, e.g. for VMs with an ephemeral OS disk
+func (vmm *AzureVirtualMachineManager) RestartVirtualMachine(ctx context.Context, vmName string) error {
+	log := logging.GetLogger(ctx)
+	log.DebugContext(ctx, "VM Restart")
+	defer log.DebugContext(ctx, "VM Restart complete")
+
+	poller, err := vmm.vmClient.BeginRestart(ctx, vmm.Credentials.ResourceGroup, vmName, &armcompute.VirtualMachinesClientBeginRestartOptions{})
+	if err != nil {
+		return errors.Wrap(err, "VM Restart")
+	}
+
+	_, err = cloudyazure.PollWrapper(ctx, poller, "VM Restart")
+	if err != nil {
+		return errors.Wrap(err, "VM Restart")
+	}
+
+	log.InfoContext(ctx, "VM Restart complete")
+
+	return nil
+}
+
 func (vmm *AzureVirtualMachineManager) StopVirtualMachine(ctx context.Context, vmName string) error {
 	return vmm.deallocateVirtualMachine(ctx, vmName)
 }
@@ -247,9 +349,41 @@ func (vmm *AzureVirtualMachineManager) ExecuteRemoteShellScript(ctx context.Cont
 	return vmm.executeRemoteCommand(ctx, vmID, "RunShellScript", "Shell Script", script, timeout, pollInterval)
 }
 
+// ExecuteRemotePowershellWithOutput executes a PowerShell script on a remote Azure virtual machine and returns its output.
+// Unlike ExecuteRemotePowershell, the output is not scanned for errors; interpreting it is left to the caller.
+func (vmm *AzureVirtualMachineManager) ExecuteRemotePowershellWithOutput(ctx context.Context, vmID string, script *string, timeout, pollInterval time.Duration) (string, error) {
+	result, err := vmm.runRemoteCommand(ctx, vmID, "RunPowerShellScript", "PowerShell", script, timeout, pollInterval)
+	if err != nil {
+		return "", err
+	}
+
+	return collectCommandOutput(result), nil
+}
+
+// ExecuteRemoteShellScriptWithOutput executes a shell script on a remote Azure virtual machine and returns its output.
+// Unlike ExecuteRemoteShellScript, the output is not scanned for errors; interpreting it is left to the caller.
+func (vmm *AzureVirtualMachineManager) ExecuteRemoteShellScriptWithOutput(ctx context.Context, vmID string, script *string, timeout, pollInterval time.Duration) (string, error) {
+	result, err := vmm.runRemoteCommand(ctx, vmID, "RunShellScript", "Shell Script", script, timeout, pollInterval)
+	if err != nil {
+		return "", err
+	}
+
+	return collectCommandOutput(result), nil
+}
+
 // executeRemoteCommand encapsulates the common logic for executing a remote command.
 // The commandID and label (e.g., "PowerShell" or "Shell Script") differentiate the two types.
 func (vmm *AzureVirtualMachineManager) executeRemoteCommand(ctx context.Context, vmID, commandID, label string, script *string, timeout, pollInterval time.Duration) error {
+	result, err := vmm.runRemoteCommand(ctx, vmID, commandID, label, script, timeout, pollInterval)
+	if err != nil {
+		return err
+	}
+
+	return processCommandResult(ctx, result, label)
+}
+
+// runRemoteCommand starts a RunCommand on the VM and polls it until it completes, returning the raw result.
+func (vmm *AzureVirtualMachineManager) runRemoteCommand(ctx context.Context, vmID, commandID, label string, script *string, timeout, pollInterval time.Duration) (armcompute.RunCommandResult, error) {
 	log := logging.GetLogger(ctx)
 
 	log.DebugContext(ctx, fmt.Sprintf("Constructing RunCommandInput for %s execution", label))
@@ -265,16 +399,11 @@ func (vmm *AzureVirtualMachineManager) executeRemoteCommand(ctx context.Context,
 	poller, err := vmm.vmClient.BeginRunCommand(ctx, vmm.Credentials.ResourceGroup, vmID, runCommandInput, nil)
 	if err != nil {
 		log.ErrorContext(ctx, fmt.Sprintf("Failed to execute remote %s script", label), "error", err)
-		return logging.LogAndWrapErr(ctx, log, err, fmt.Sprintf("failed to execute remote %s script", label))
+		return armcompute.RunCommandResult{}, logging.LogAndWrapErr(ctx, log, err, fmt.Sprintf("failed to execute remote %s script", label))
 	}
 
 	log.DebugContext(ctx, fmt.Sprintf("%s command execution started successfully, polling for result", label))
-	result, err := pollCommandExecution(ctx, poller, timeout, pollInterval, label)
-	if err != nil {
-		return err
-	}
-
-	return processCommandResult(ctx, result, label)
+	return pollCommandExecution(ctx, poller, timeout, pollInterval, label)
 }
 
 // pollCommandExecution polls the status of a remote command execution until it completes or times out.
@@ -376,3 +505,19 @@ func processCommandResult(ctx context.Context, result armcompute.RunCommandResul
 	log.DebugContext(ctx, fmt.Sprintf("Run%s function completed successfully", label))
 	return nil
 }
+
+// collectCommandOutput joins all output messages of a RunCommand result into a single string.
+func collectCommandOutput(result armcompute.RunCommandResult) string {
+	var sb strings.Builder
+	for _, output := range result.Value {
+		if output == nil || output.Message == nil {
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(*output.Message)
+	}
+
+	return sb.String()
+}
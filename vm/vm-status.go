@@ -2,20 +2,18 @@ package vm
 
 import (
 	"context"
+	"fmt"
 	"sort"
 	"strings"
 
-	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
-	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
-	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
-	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
 	"github.com/appliedres/cloudy"
 	cloudyazure "github.com/appliedres/cloudy-azure"
 	"github.com/appliedres/cloudy-azure/keyvault"
+	"github.com/appliedres/cloudy/logging"
 	cloudyvm "github.com/appliedres/cloudy/vm"
-	"github.com/hashicorp/go-version"
+	"github.com/pkg/errors"
 )
 
 const AzureArmCompute = "azure-arm-compute"
@@ -29,15 +27,17 @@ type AzureVMControllerConfig struct {
 	SubscriptionID string
 	ResourceGroup  string
 
-	// ??
-	NetworkResourceGroup            string // From Environment Variable
-	SourceImageGalleryResourceGroup string
+	NetworkResourceGroup            string   // From Environment Variable
+	SourceImageGalleryResourceGroup string   // defaults to ResourceGroup
 	SourceImageGalleryName          string   // From Environment Variable
 	Vnet                            string   // From Environment Variable
 	AvailableSubnets                []string // From Environment Variable
-	NetworkSecurityGroupName        string   // From Environment Variable
-	NetworkSecurityGroupID          string   // From Environment Variable
-	// SaltCmd                         string   // From Environment Variable
+
+	// Deprecated: NICs are created without a network security group, the subnet's NSG applies
+	NetworkSecurityGroupName string
+	// Deprecated: NICs are created without a network security group, the subnet's NSG applies
+	NetworkSecurityGroupID string
+	// Deprecated: the controller no longer reads from a key vault
 	VaultURL string
 
 	DomainControllerOverride string
@@ -46,12 +46,18 @@ type AzureVMControllerConfig struct {
 	LogBody bool
 }
 
+// AzureVMController implements the legacy cloudy VMController on top of AzureVirtualMachineManager,
+// so that both share the same clients, credentials and VM create / delete code.
 type AzureVMController struct {
-	Vault  *keyvault.KeyVault
+	Config  *AzureVMControllerConfig
+	Manager *AzureVirtualMachineManager
+
+	// Deprecated: use Manager
 	Client *armcompute.VirtualMachinesClient
-	Usage  *armcompute.UsageClient
-	Config *AzureVMControllerConfig
-	cred   *azidentity.ClientSecretCredential
+	// Deprecated: use Manager
+	Usage *armcompute.UsageClient
+	// Deprecated: the controller no longer reads from a key vault, set only when VaultURL is configured
+	Vault *keyvault.KeyVault
 }
 
 type AzureVMControllerFactory struct{}
@@ -70,16 +76,15 @@ func (f *AzureVMControllerFactory) FromEnv(env *cloudy.Environment) (interface{}
 	cfg.AzureCredentials = cloudyazure.GetAzureCredentialsFromEnv(env)
 	cfg.SubscriptionID = env.Force("AZ_SUBSCRIPTION_ID")
 	cfg.ResourceGroup = env.Force("AZ_RESOURCE_GROUP")
-	cfg.SubscriptionID = env.Force("AZ_SUBSCRIPTION_ID")
 
 	// Not always necessary but needed for creation
 	cfg.NetworkResourceGroup = env.Force("AZ_NETWORK_RESOURCE_GROUP")
 	cfg.SourceImageGalleryResourceGroup = env.Default("AZ_SOURCE_IMAGE_GALLERY_RESOURCE_GROUP", cfg.ResourceGroup)
 	cfg.SourceImageGalleryName = env.Force("AZ_SOURCE_IMAGE_GALLERY_NAME")
 	cfg.Vnet = env.Force("AZ_VNET")
-	cfg.NetworkSecurityGroupName = env.Force("AZ_NETWORK_SECURITY_GROUP_NAME")
-	cfg.NetworkSecurityGroupID = env.Force("AZ_NETWORK_SECURITY_GROUP_ID")
-	cfg.VaultURL = env.Force("AZ_VAULT_URL")
+	cfg.NetworkSecurityGroupName = env.Get("AZ_NETWORK_SECURITY_GROUP_NAME")
+	cfg.NetworkSecurityGroupID = env.Get("AZ_NETWORK_SECURITY_GROUP_ID")
+	cfg.VaultURL = env.Get("AZ_VAULT_URL")
 
 	subnets := env.Force("SUBNETS") //SUBNET1,SUBNET2
 	cfg.AvailableSubnets = strings.Split(subnets, ",")
@@ -100,166 +105,284 @@ func (f *AzureVMControllerFactory) FromEnv(env *cloudy.Environment) (interface{}
 }
 
 func NewAzureVMController(ctx context.Context, config *AzureVMControllerConfig) (*AzureVMController, error) {
-	cred, err := cloudyazure.GetAzureClientSecretCredential(config.AzureCredentials)
+	credentials, managerConfig := toVirtualMachineManagerConfig(config)
+
+	vmm := &AzureVirtualMachineManager{
+		name:        AzureArmCompute,
+		Credentials: credentials,
+		Config:      managerConfig,
+		LogBody:     config.LogBody,
+	}
+	err := vmm.Configure(ctx)
 	if err != nil {
-		return nil, cloudy.Error(ctx, "Authentication failure: %+v", err)
+		return nil, errors.Wrap(err, "NewAzureVMController")
 	}
 
-	// client, err := NewVMClient(ctx, config)
-	// if err != nil {
-	// 	return nil, err
-	// }
-
-	// usage, err := NewUsageClient(ctx, config)
-	// if err != nil {
-	// 	return nil, err
-	// }
-
-	v, err := keyvault.NewKeyVault(ctx, config.VaultURL, config.AzureCredentials)
-	if err != nil {
-		return nil, err
+	var vault *keyvault.KeyVault
+	if config.VaultURL != "" {
+		vault, err = keyvault.NewKeyVault(ctx, config.VaultURL, config.AzureCredentials)
+		if err != nil {
+			return nil, errors.Wrap(err, "NewAzureVMController")
+		}
 	}
 
 	return &AzureVMController{
-		// Client: client,
-		// Usage:  usage,
-		Config: config,
-		cred:   cred,
-		Vault:  v,
+		Config:  config,
+		Manager: vmm,
+
+		Client: vmm.vmClient,
+		Usage:  vmm.usageClient,
+		Vault:  vault,
 	}, nil
 }
 
+// toVirtualMachineManagerConfig maps the legacy controller configuration to the VM manager's credentials and configuration
+func toVirtualMachineManagerConfig(config *AzureVMControllerConfig) (*cloudyazure.AzureCredentials, *VirtualMachineManagerConfig) {
+	credentials := config.AzureCredentials
+	credentials.SubscriptionID = config.SubscriptionID
+	credentials.ResourceGroup = config.ResourceGroup
+
+	managerConfig := &VirtualMachineManagerConfig{
+		SubnetIds:              config.AvailableSubnets,
+		VnetResourceGroup:      config.NetworkResourceGroup,
+		VnetId:                 config.Vnet,
+		SourceImageGalleryName: config.SourceImageGalleryName,
+	}
+
+	if strings.EqualFold(config.DomainControllerOverride, "True") {
+		managerConfig.DomainControllers = config.DomainControllers
+	}
+
+	return &credentials, managerConfig
+}
+
 func (vmc *AzureVMController) ListAll(ctx context.Context) ([]*cloudyvm.VirtualMachineStatus, error) {
-	// return VmList(ctx, vmc.Client, vmc.Config.ResourceGroup)
-	return nil, nil
+	return vmc.list(ctx, "")
 }
 
+// ListWithTag lists the VMs that have a tag. The tag is either a key, or a key=value pair to also match its value.
+// Tag keys are matched case-insensitively.
 func (vmc *AzureVMController) ListWithTag(ctx context.Context, tag string) ([]*cloudyvm.VirtualMachineStatus, error) {
-	// return VmList(ctx, vmc.Client, vmc.Config.ResourceGroup)
-	return nil, nil
-}
+	if tag == "" {
+		return nil, fmt.Errorf("ListWithTag: no tag given")
+	}
 
-func (vmc *AzureVMController) Status(ctx context.Context, vmName string) (*cloudyvm.VirtualMachineStatus, error) {
-	// return VmStatus(ctx, vmc.Client, vmName, vmc.Config.ResourceGroup)
-	return nil, nil
+	return vmc.list(ctx, tag)
 }
 
-func (vmc *AzureVMController) SetState(ctx context.Context, state cloudyvm.VirtualMachineAction, vmName string, wait bool) (*cloudyvm.VirtualMachineStatus, error) {
-	// return VmState(ctx, vmc.Client, state, vmName, vmc.Config.ResourceGroup, wait)
-	return nil, nil
-}
+// list returns the status of the VMs in the resource group, optionally filtered by a tag
+func (vmc *AzureVMController) list(ctx context.Context, tag string) ([]*cloudyvm.VirtualMachineStatus, error) {
+	azVMs, err := vmc.Manager.listVirtualMachinesWithState(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-func (vmc *AzureVMController) Start(ctx context.Context, vmName string, wait bool) error {
-	// return VmStart(ctx, vmc.Client, vmName, vmc.Config.ResourceGroup, wait)
-	return nil
+	var statuses []*cloudyvm.VirtualMachineStatus
+	for _, azVM := range azVMs {
+		if tag != "" && !matchesTag(azVM.Tags, tag) {
+			continue
+		}
+
+		statuses = append(statuses, toVirtualMachineStatus(azVM))
+	}
+
+	return statuses, nil
 }
 
-func (vmc *AzureVMController) Stop(ctx context.Context, vmName string, wait bool) error {
-	// return VmStop(ctx, vmc.Client, vmName, vmc.Config.ResourceGroup, wait)
-	return nil
+// matchesTag reports whether the tags contain a tag given as a key, or as a key=value pair
+func matchesTag(tags map[string]*string, tag string) bool {
+	key, value, hasValue := strings.Cut(tag, "=")
+
+	for k, v := range tags {
+		if !strings.EqualFold(k, key) {
+			continue
+		}
+
+		return !hasValue || (v != nil && *v == value)
+	}
+
+	return false
 }
 
-func (vmc *AzureVMController) Terminate(ctx context.Context, vmName string, wait bool) error {
-	// return VmTerminate(ctx, vmc.Client, vmName, vmc.Config.ResourceGroup, wait)
-	return nil
+// Status returns the status of a VM, or nil if it does not exist
+func (vmc *AzureVMController) Status(ctx context.Context, vmName string) (*cloudyvm.VirtualMachineStatus, error) {
+	log := logging.GetLogger(ctx)
+
+	resp, err := vmc.Manager.vmClient.Get(ctx, vmc.Manager.Credentials.ResourceGroup, vmName, &armcompute.VirtualMachinesClientGetOptions{
+		Expand: to.Ptr(armcompute.InstanceViewTypesInstanceView),
+	})
+	if err != nil {
+		if cloudyazure.Is404(err) {
+			log.DebugContext(ctx, fmt.Sprintf("VM Status, VM not found: [%s]", vmName))
+			return nil, nil
+		}
+
+		return nil, errors.Wrap(err, "VM Status")
+	}
+
+	return toVirtualMachineStatus(&resp.VirtualMachine), nil
 }
 
-func (vmc *AzureVMController) GetLimits(ctx context.Context) ([]*cloudyvm.VirtualMachineLimit, error) {
+// toVirtualMachineStatus maps an Azure VM to the legacy cloudy VM status
+func toVirtualMachineStatus(azVM *armcompute.VirtualMachine) *cloudyvm.VirtualMachineStatus {
+	status := &cloudyvm.VirtualMachineStatus{
+		Name:   stringValue(azVM.Name),
+		LongID: stringValue(azVM.ID),
+		Tags:   azVM.Tags,
+	}
 
-	pager := vmc.Usage.NewListPager(vmc.Config.Region, &armcompute.UsageClientListOptions{})
+	if user := tagValue(azVM.Tags, "User Principal Name"); user != "" {
+		status.User = user
+	} else {
+		status.User = tagValue(azVM.Tags, vmUserTagKey)
+	}
 
-	var rtn []*cloudyvm.VirtualMachineLimit
+	props := azVM.Properties
+	if props == nil {
+		return status
+	}
 
-	for pager.More() {
-		resp, err := pager.NextPage(ctx)
-		if err != nil {
-			return nil, cloudy.Error(ctx, "Error retrieving next page: %v", err)
-		}
+	status.ID = stringValue(props.VMID)
+	status.ProvisioningState = stringValue(props.ProvisioningState)
 
-		for _, u := range resp.Value {
-			lowerName := strings.ToLower(*u.Name.Value)
+	if props.HardwareProfile != nil && props.HardwareProfile.VMSize != nil {
+		status.Size = string(*props.HardwareProfile.VMSize)
+	}
 
-			// Eliminate non-VM values
-			if strings.Contains(lowerName, "family") && !strings.Contains(lowerName, "promo") {
-				rtn = append(rtn, &cloudyvm.VirtualMachineLimit{
-					Name:    *u.Name.Value,
-					Current: int(*u.CurrentValue),
-					Limit:   int(*u.Limit),
-				})
+	if props.StorageProfile != nil && props.StorageProfile.OSDisk != nil && props.StorageProfile.OSDisk.OSType != nil {
+		status.OperatingSystem = string(*props.StorageProfile.OSDisk.OSType)
+	}
+
+	if props.InstanceView != nil {
+		for _, s := range props.InstanceView.Statuses {
+			if s.Code == nil {
+				continue
+			}
+
+			if powerState, ok := strings.CutPrefix(*s.Code, "PowerState/"); ok {
+				status.PowerState = powerState
+			} else if strings.HasPrefix(*s.Code, "ProvisioningState/") && s.Time != nil {
+				status.ProvisioningTime = *s.Time
 			}
 		}
 	}
 
-	return rtn, nil
+	return status
 }
 
-func (vmc *AzureVMController) GetLatestImageVersion(ctx context.Context, imageName string) (string, error) {
-	c, err := armcompute.NewSharedGalleryImageVersionsClient(vmc.Config.SubscriptionID, vmc.cred, nil)
+// SetState starts, stops or terminates a VM and returns its status afterwards
+func (vmc *AzureVMController) SetState(ctx context.Context, state cloudyvm.VirtualMachineAction, vmName string, wait bool) (*cloudyvm.VirtualMachineStatus, error) {
+	var err error
+
+	switch state {
+	case cloudyvm.VirtualMachineStart:
+		err = vmc.Start(ctx, vmName, wait)
+	case cloudyvm.VirtualMachineStop:
+		err = vmc.Stop(ctx, vmName, wait)
+	case cloudyvm.VirtualMachineTerminate:
+		err = vmc.Terminate(ctx, vmName, wait)
+	default:
+		return nil, fmt.Errorf("invalid state requested: %s", state)
+	}
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	pager := c.NewListPager(vmc.Config.Region, vmc.Config.SourceImageGalleryName, imageName, &armcompute.SharedGalleryImageVersionsClientListOptions{})
 
-	var allVersions []*version.Version
+	return vmc.Status(ctx, vmName)
+}
 
-	for pager.More() {
-		resp, err := pager.NextPage(ctx)
-		if err != nil {
-			return "", err
-		}
-		for _, imageVersion := range resp.Value {
-			v, err := version.NewVersion(*imageVersion.Name)
-			if err != nil {
-				_ = cloudy.Error(ctx, "Skipping Invalid Version : %v, %v", *imageVersion.Name, err)
-				continue
-			}
-			allVersions = append(allVersions, v)
-		}
+// Start starts a VM. If wait is false, it returns once Azure has accepted the request.
+func (vmc *AzureVMController) Start(ctx context.Context, vmName string, wait bool) error {
+	if wait {
+		return vmc.Manager.StartVirtualMachine(ctx, vmName)
 	}
 
-	sort.Sort(version.Collection(allVersions))
+	_, err := vmc.Manager.vmClient.BeginStart(ctx, vmc.Manager.Credentials.ResourceGroup, vmName, nil)
+	return errors.Wrap(err, "VM Start")
+}
 
-	latest := allVersions[len(allVersions)-1]
+// Stop powers off a VM without deallocating it, so it continues to incur costs. Use Terminate to deallocate it.
+// If wait is false, it returns once Azure has accepted the request.
+func (vmc *AzureVMController) Stop(ctx context.Context, vmName string, wait bool) error {
+	if wait {
+		return vmc.Manager.powerOffVirtualMachine(ctx, vmName)
+	}
 
-	return latest.Original(), nil
+	_, err := vmc.Manager.vmClient.BeginPowerOff(ctx, vmc.Manager.Credentials.ResourceGroup, vmName, nil)
+	return errors.Wrap(err, "VM Stop")
 }
 
-func (vmc *AzureVMController) GetVMSizes(ctx context.Context) (map[string]*cloudyvm.VmSize, error) {
-	cloudy.Info(ctx, "AzureVMController.GetVMSizes")
+// Terminate deallocates a VM. A VM that does not exist is not an error.
+// If wait is false, it returns once Azure has accepted the request.
+func (vmc *AzureVMController) Terminate(ctx context.Context, vmName string, wait bool) error {
+	if wait {
+		return vmc.Manager.deallocateVirtualMachine(ctx, vmName)
+	}
 
-	client, err := armcompute.NewResourceSKUsClient(vmc.Config.SubscriptionID, vmc.cred, &arm.ClientOptions{
-		ClientOptions: policy.ClientOptions{
-			Cloud: cloud.AzureGovernment,
-		},
-	})
+	_, err := vmc.Manager.vmClient.BeginDeallocate(ctx, vmc.Manager.Credentials.ResourceGroup, vmName, nil)
+	if cloudyazure.Is404(err) {
+		return nil
+	}
+	return errors.Wrap(err, "VM Terminate")
+}
+
+// GetLimits returns the core usage and quota of each VM family in the region, sorted by family name
+func (vmc *AzureVMController) GetLimits(ctx context.Context) ([]*cloudyvm.VirtualMachineLimit, error) {
+	usage, err := vmc.Manager.GetVirtualMachineUsage(ctx)
 	if err != nil {
-		return nil, cloudy.Error(ctx, "AzureVMController.GetVMSizes could not create NewResourceSKUsClient, %v", err)
+		return nil, err
 	}
 
+	limits := make([]*cloudyvm.VirtualMachineLimit, 0, len(usage))
+	for _, family := range usage {
+		limits = append(limits, &cloudyvm.VirtualMachineLimit{
+			Name:    family.Name,
+			Current: int(family.Usage),
+			Limit:   int(family.Quota),
+		})
+	}
+
+	sort.Slice(limits, func(i, j int) bool {
+		return limits[i].Name < limits[j].Name
+	})
+
+	return limits, nil
+}
+
+// GetLatestImageVersion returns the highest version of an image in the configured source image gallery
+func (vmc *AzureVMController) GetLatestImageVersion(ctx context.Context, imageName string) (string, error) {
+	return vmc.Manager.GetLatestImageVersion(ctx, imageName)
+}
+
+// GetVMSizes returns the VM sizes available to the subscription in the region, keyed by size name
+func (vmc *AzureVMController) GetVMSizes(ctx context.Context) (map[string]*cloudyvm.VmSize, error) {
+	log := logging.GetLogger(ctx)
+
+	region := vmc.Manager.Credentials.Region
 	sizes := make(map[string]*cloudyvm.VmSize)
-	pager := client.NewListPager(&armcompute.ResourceSKUsClientListOptions{
-		Filter:                   to.Ptr("location eq " + vmc.Config.Region),
-		IncludeExtendedLocations: nil,
+	pager := vmc.Manager.sizesClient.NewListPager(&armcompute.ResourceSKUsClientListOptions{
+		Filter: to.Ptr("location eq '" + region + "'"),
 	})
 	for pager.More() {
 		resp, err := pager.NextPage(ctx)
 		if err != nil {
-			return sizes, cloudy.Error(ctx, "AzureVMController.GetVMSizes could not get NextPage, %v", err)
+			return sizes, errors.Wrap(err, "GetVMSizes")
 		}
 
 		for _, r := range resp.Value {
-			if strings.EqualFold("virtualMachines", *r.ResourceType) &&
-				!strings.Contains(*r.Size, "Promo") &&
-				IsInLocation(vmc.Config.Region, r.Locations) &&
-				IsAvailable(r.Restrictions) {
-
-				size := SizeFromResource(ctx, r)
-				sizes[size.Name] = size
+			if r.ResourceType == nil || !strings.EqualFold("virtualMachines", *r.ResourceType) ||
+				r.Size == nil || strings.Contains(*r.Size, "Promo") ||
+				!IsInLocation(region, r.Locations) ||
+				!IsAvailable(r.Restrictions) {
+				continue
 			}
+
+			size := SizeFromResource(ctx, r)
+			sizes[size.Name] = size
 		}
 	}
 
-	cloudy.Info(ctx, "AzureVMController.GetVMSizes %d sizes found", len(sizes))
+	log.DebugContext(ctx, fmt.Sprintf("GetVMSizes %d sizes found", len(sizes)))
 
 	return sizes, nil
 }
@@ -276,15 +399,12 @@ func IsInLocation(region string, locations []*string) bool {
 }
 
 func IsAvailable(restrictions []*armcompute.ResourceSKURestrictions) bool {
+	return !isSizeRestricted(restrictions)
+}
 
-	notAvailable := string(armcompute.ResourceSKURestrictionsReasonCodeNotAvailableForSubscription)
-
-	for _, restriction := range restrictions {
-
-		if strings.EqualFold(notAvailable, string(*restriction.ReasonCode)) {
-			return false
-		}
+func stringValue(p *string) string {
+	if p == nil {
+		return ""
 	}
-
-	return true
+	return *p
 }
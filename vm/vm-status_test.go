@@ -3,7 +3,10 @@ package vm
 import (
 	"fmt"
 	"testing"
+	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
 	"github.com/appliedres/cloudy"
 	cloudyazure "github.com/appliedres/cloudy-azure"
 	"github.com/appliedres/cloudy/testutil"
@@ -65,3 +68,81 @@ func TestAllVMStatus(t *testing.T) {
 	}
 
 }
+
+func TestToVirtualMachineStatus(t *testing.T) {
+	provisioned := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	azVM := &armcompute.VirtualMachine{
+		ID:   to.Ptr("/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/uvm-1"),
+		Name: to.Ptr("uvm-1"),
+		Tags: map[string]*string{"UserID": to.Ptr("user-1")},
+		Properties: &armcompute.VirtualMachineProperties{
+			VMID:              to.Ptr("1234"),
+			ProvisioningState: to.Ptr("Succeeded"),
+			HardwareProfile:   &armcompute.HardwareProfile{VMSize: to.Ptr(armcompute.VirtualMachineSizeTypesStandardD2SV3)},
+			StorageProfile: &armcompute.StorageProfile{
+				OSDisk: &armcompute.OSDisk{OSType: to.Ptr(armcompute.OperatingSystemTypesLinux)},
+			},
+			InstanceView: &armcompute.VirtualMachineInstanceView{
+				Statuses: []*armcompute.InstanceViewStatus{
+					{Code: to.Ptr("ProvisioningState/succeeded"), Time: &provisioned},
+					{Code: to.Ptr("PowerState/deallocated")},
+				},
+			},
+		},
+	}
+
+	status := toVirtualMachineStatus(azVM)
+	assert.Equal(t, "uvm-1", status.Name)
+	assert.Equal(t, "1234", status.ID)
+	assert.Equal(t, *azVM.ID, status.LongID)
+	assert.Equal(t, "user-1", status.User)
+	assert.Equal(t, "Standard_D2s_v3", status.Size)
+	assert.Equal(t, "Linux", status.OperatingSystem)
+	assert.Equal(t, "Succeeded", status.ProvisioningState)
+	assert.Equal(t, "deallocated", status.PowerState)
+	assert.Equal(t, provisioned, status.ProvisioningTime)
+
+	status = toVirtualMachineStatus(&armcompute.VirtualMachine{Name: to.Ptr("uvm-2")})
+	assert.Equal(t, "uvm-2", status.Name)
+	assert.Empty(t, status.PowerState)
+}
+
+func TestMatchesTag(t *testing.T) {
+	tags := map[string]*string{"Application": to.Ptr("VDI"), "Empty": nil}
+
+	assert.True(t, matchesTag(tags, "Application"))
+	assert.True(t, matchesTag(tags, "application=VDI"))
+	assert.True(t, matchesTag(tags, "Empty"))
+	assert.False(t, matchesTag(tags, "Application=vdi"))
+	assert.False(t, matchesTag(tags, "Empty=x"))
+	assert.False(t, matchesTag(tags, "Missing"))
+}
+
+func TestToVirtualMachineManagerConfig(t *testing.T) {
+	dc := "10.0.0.4"
+	config := &AzureVMControllerConfig{
+		AzureCredentials:         cloudyazure.AzureCredentials{TenantID: "tenant", Region: "usgovvirginia"},
+		SubscriptionID:           "sub",
+		ResourceGroup:            "rg",
+		NetworkResourceGroup:     "net-rg",
+		Vnet:                     "vnet",
+		AvailableSubnets:         []string{"a", "b"},
+		SourceImageGalleryName:   "gallery",
+		DomainControllerOverride: "true",
+		DomainControllers:        []*string{&dc},
+	}
+
+	credentials, managerConfig := toVirtualMachineManagerConfig(config)
+	assert.Equal(t, "tenant", credentials.TenantID)
+	assert.Equal(t, "sub", credentials.SubscriptionID)
+	assert.Equal(t, "rg", credentials.ResourceGroup)
+	assert.Equal(t, "net-rg", managerConfig.VnetResourceGroup)
+	assert.Equal(t, "vnet", managerConfig.VnetId)
+	assert.Equal(t, []string{"a", "b"}, managerConfig.SubnetIds)
+	assert.Equal(t, "gallery", managerConfig.SourceImageGalleryName)
+	assert.Equal(t, []*string{&dc}, managerConfig.DomainControllers)
+
+	config.DomainControllerOverride = "False"
+	_, managerConfig = toVirtualMachineManagerConfig(config)
+	assert.Empty(t, managerConfig.DomainControllers)
+}
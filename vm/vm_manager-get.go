@@ -48,6 +48,30 @@ func (vmm *AzureVirtualMachineManager) GetVirtualMachine(ctx context.Context, vm
 	return vm, nil
 }
 
+// Queries Azure for the details of a single VM along with a detailed status built from its instance view.
+// The detailed status includes every status code, the VM agent and extension statuses, and the last provisioning error.
+// Returns nil for both if the VM does not exist.
+func (vmm *AzureVirtualMachineManager) GetVirtualMachineWithStatus(ctx context.Context, vmName string) (*models.VirtualMachine, *VirtualMachineDetailedStatus, error) {
+	log := logging.GetLogger(ctx)
+
+	resp, err := vmm.vmClient.Get(ctx, vmm.Credentials.ResourceGroup, vmName, &armcompute.VirtualMachinesClientGetOptions{
+		Expand: to.Ptr(armcompute.InstanceViewTypesInstanceView),
+	})
+	if err != nil {
+		if cloudyazure.Is404(err) {
+			log.DebugContext(ctx, fmt.Sprintf("Azure vmm.GetWithStatus VM not found: [%s]", vmName))
+			return nil, nil, nil
+		}
+
+		return nil, nil, errors.Wrap(err, "Azure vmm.GetWithStatus Error")
+	}
+
+	vm := ToCloudyVirtualMachine(ctx, &resp.VirtualMachine)
+	status := toDetailedStatus(ctx, &resp.VirtualMachine)
+
+	return vm, status, nil
+}
+
 // Queries Azure for the details of all User VMs.
 //
 //	If includeState is true, this will also retrieve the state of the VMs (running, stopped, etc.)
@@ -72,6 +96,22 @@ func (vmm *AzureVirtualMachineManager) getAllVirtualMachinesWithPrefix(ctx conte
 		log.WarnContext(ctx, "Querying VMs without a filter. This could return critical infrastructure VMs")
 	}
 
+	if vmm.Config.UseResourceGraph {
+		// callers act on these VMs by name in the manager's resource group, so only query that
+		// instead of every configured Resource Graph subscription
+		vms, err := vmm.queryResourceGraph(ctx, VirtualMachineQuery{
+			Prefix:         filterPrefix,
+			ResourceGroups: []string{vmm.Credentials.ResourceGroup},
+			Subscriptions:  []string{vmm.Credentials.SubscriptionID},
+			IncludeState:   includeState,
+		})
+		if err == nil {
+			return vms, nil
+		}
+
+		log.WarnContext(ctx, "Resource Graph query failed, falling back to the compute API", "err", err)
+	}
+
 	vmList := []models.VirtualMachine{}
 
 	statusOnlyString := strconv.FormatBool(includeState)